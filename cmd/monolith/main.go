@@ -42,6 +42,10 @@ func run() error {
 	root.AddCommand(cli.NewCmdInfo())
 	root.AddCommand(cli.NewCmdMigrate())
 	root.AddCommand(cli.NewCmdServer(version, gitCommit))
+	root.AddCommand(cli.NewCmdUsers())
+	root.AddCommand(cli.NewCmdOAuth())
+	root.AddCommand(cli.NewCmdAudit())
+	root.AddCommand(cli.NewCmdHealthcheck())
 
 	ctx := context.WithValue(context.Background(), cli.AppKey("app"), cliApp)
 	if err := root.ExecuteContext(ctx); err != nil {
@@ -0,0 +1,36 @@
+package app
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+)
+
+// adminRoutes returns the routes of the admin listener. They are for
+// operators and monitoring only and have no authentication, so are served
+// on a separate port bound to localhost rather than alongside the public
+// API.
+func (a *App) adminRoutes() *http.ServeMux {
+	mux := http.NewServeMux()
+	if a.metrics != nil {
+		mux.Handle("GET /metrics", a.metrics)
+	}
+
+	// profiling and runtime variables
+	mux.HandleFunc("GET /debug/pprof/", pprof.Index)
+	mux.HandleFunc("GET /debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("GET /debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("GET /debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("POST /debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("GET /debug/pprof/trace", pprof.Trace)
+	mux.Handle("GET /debug/vars", expvar.Handler())
+
+	// runtime controls
+	admin := newRouteGroup(mux, a.handler.JSONHeader)
+	admin.HandleFunc("GET /admin/log-level", a.handler.GetLogLevel())
+	admin.HandleFunc("PUT /admin/log-level", a.handler.SetLogLevel())
+	admin.HandleFunc("GET /admin/maintenance", a.handler.GetMaintenance())
+	admin.HandleFunc("PUT /admin/maintenance", a.handler.SetMaintenance())
+
+	return mux
+}
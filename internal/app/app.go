@@ -2,13 +2,14 @@ package app
 
 import (
 	"context"
+	"net"
 	"net/http"
-	"os"
-	"os/signal"
-	"syscall"
+	"time"
 
 	"github.com/andyfusniak/monolith/internal/env"
 	"github.com/andyfusniak/monolith/internal/handler"
+	"github.com/andyfusniak/monolith/internal/lifecycle"
+	"github.com/andyfusniak/monolith/internal/metrics"
 
 	log "github.com/sirupsen/logrus"
 
@@ -18,8 +19,12 @@ import (
 type App struct {
 	svc     *service.Service
 	cfg     env.AppConfig
-	router  *http.ServeMux
+	tenant  env.TenantConfig
+	router  http.Handler
+	admin   http.Handler
 	handler *handler.Handler
+	metrics *metrics.Registry
+	limits  map[string]handler.RateLimitRule
 }
 
 // Option is a function that configures an App.
@@ -37,8 +42,35 @@ func New(cfg env.AppConfig, opts ...Option) (*App, error) {
 	// application handlers
 	app.handler = handler.New(app.svc)
 
-	// routing
-	app.router = app.v1Routes()
+	// routing; middleware common to every route, outermost first
+	mux := app.v1Routes()
+	warnUnmatchedRateLimits(mux, app.limits)
+	chain := NewChain(
+		app.handler.RequestID,
+		app.handler.RealIP(cfg.TrustedProxies),
+		app.handler.Trace(routePattern(mux)),
+		app.handler.AccessLog(routePattern(mux)),
+	)
+	if app.metrics != nil {
+		hist := app.metrics.NewHistogram("monolith_http_request_duration_seconds",
+			"Time taken to serve HTTP requests by method, route pattern and status.",
+			metrics.DefBuckets, "method", "route", "status")
+		chain = chain.Append(app.handler.Metrics(routePattern(mux), hist))
+	}
+	chain = chain.Append(app.handler.Recover, app.handler.JSONHeader, app.handler.Maintenance)
+	if app.svc.IsMultiTenant() {
+		chain = chain.Append(app.handler.Tenant(app.tenant.Domain, app.tenant.Header))
+	}
+
+	// health probes are served outside the chain, so they need no tenant
+	// and are not access logged
+	root := http.NewServeMux()
+	probes := NewChain(app.handler.Recover, app.handler.JSONHeader)
+	root.Handle("GET /healthz", probes.ThenFunc(app.handler.Healthz()))
+	root.Handle("GET /readyz", probes.ThenFunc(app.handler.Readyz()))
+	root.Handle("/", chain.Then(mux))
+	app.router = root
+	app.admin = NewChain(app.handler.Recover).Then(app.adminRoutes())
 
 	return app, nil
 }
@@ -50,50 +82,70 @@ func WithService(svc *service.Service) Option {
 	}
 }
 
-// Start the app server.
-func (a *App) Start(ctx context.Context) error {
-	// HTTP Service
-	srv := http.Server{
-		Addr:    "0.0.0.0:" + a.cfg.Port,
-		Handler: a.router,
+// WithTenants sets how requests are mapped to tenants when the service
+// has a database per tenant.
+func WithTenants(cfg env.TenantConfig) Option {
+	return func(a *App) {
+		a.tenant = cfg
 	}
+}
 
-	// signals
-	idleConnsClosed := make(chan struct{})
-	go func() {
-		sigint := make(chan os.Signal, 1)
-
-		// interrupt signal sent from terminal
-		signal.Notify(sigint, os.Interrupt)
-		// sigterm signal sent from kubernetes
-		signal.Notify(sigint, syscall.SIGTERM)
-
-		switch sig := <-sigint; sig {
-		case syscall.SIGINT:
-			log.Info("[main] received signal SIGINT")
-		case syscall.SIGTERM:
-			log.Info("[main] received signal SIGTERM")
-		default:
-			log.Info("[main] received unexpected signal", "signal", sig)
-		}
-
-		log.Info("[main] gracefully shutting down the server...")
+// WithMetrics sets the registry that HTTP request metrics are recorded in
+// and that is served at /metrics on the admin listener.
+func WithMetrics(reg *metrics.Registry) Option {
+	return func(a *App) {
+		a.metrics = reg
+	}
+}
 
-		// We received an interrupt signal, shut down.
-		if err := srv.Shutdown(ctx); err != nil {
-			// Error from closing listeners, or context timeout:
-			log.Infof("[main] HTTP server Shutdown: %+v", err)
+// WithRateLimits sets the rate limits of routes, keyed by route pattern
+// with "*" for routes without a rule of their own.
+func WithRateLimits(rules []env.RateLimitRule) Option {
+	return func(a *App) {
+		a.limits = make(map[string]handler.RateLimitRule, len(rules))
+		for _, r := range rules {
+			a.limits[r.Pattern] = handler.RateLimitRule{
+				RateLimit: service.RateLimit{Limit: r.Limit, Period: r.Period, Burst: r.Burst},
+				Key:       r.Key,
+			}
 		}
-		log.Info("[main] HTTP server shutdown complete")
-		close(idleConnsClosed)
-	}()
-
-	log.Infof("[main] server listening on HTTP port %s", a.cfg.Port)
-	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
-		log.Errorf("[main] error: %+v", err)
-		return err
 	}
-	<-idleConnsClosed
+}
+
+// Register adds the app's components to m: the admin server, then the
+// public server, then readiness. They stop in reverse, so on shutdown
+// readiness fails first, and after ShutdownDelay for load balancers to
+// notice, the public server stops accepting requests and waits for those
+// in flight.
+func (a *App) Register(m *lifecycle.Manager) {
+	// admin HTTP service, only reachable from the host by default
+	if a.cfg.AdminPort != "" {
+		m.Add(lifecycle.HTTPServer(m, "admin server", &http.Server{
+			Addr:    net.JoinHostPort(a.cfg.AdminHost, a.cfg.AdminPort),
+			Handler: a.admin,
+		}))
+	}
 
-	return nil
+	// HTTP Service
+	m.Add(lifecycle.HTTPServer(m, "server", &http.Server{
+		Addr:    "0.0.0.0:" + a.cfg.Port,
+		Handler: a.router,
+	}))
+
+	m.Add(lifecycle.Component{
+		Name: "readiness",
+		Stop: func(ctx context.Context) error {
+			a.svc.SetDraining(true)
+			if a.cfg.ShutdownDelay <= 0 {
+				return nil
+			}
+			log.Infof("[main] not ready; waiting %s before closing listeners", a.cfg.ShutdownDelay)
+			select {
+			case <-time.After(a.cfg.ShutdownDelay):
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+	})
 }
@@ -0,0 +1,73 @@
+package app
+
+import (
+	"net/http"
+)
+
+// Middleware wraps an http.Handler with behaviour that runs before and
+// after it.
+type Middleware func(http.Handler) http.Handler
+
+// Chain is an ordered list of middleware. The first middleware is the
+// outermost, so it sees the request first and the response last.
+type Chain []Middleware
+
+// NewChain returns a Chain of the given middleware.
+func NewChain(m ...Middleware) Chain {
+	return append(Chain(nil), m...)
+}
+
+// Append returns a new Chain with m added after the middleware of c. c
+// is not modified, so a chain can be extended in several ways.
+func (c Chain) Append(m ...Middleware) Chain {
+	n := make(Chain, 0, len(c)+len(m))
+	return append(append(n, c...), m...)
+}
+
+// Then returns h wrapped in the middleware of c.
+func (c Chain) Then(h http.Handler) http.Handler {
+	for i := len(c) - 1; i >= 0; i-- {
+		h = c[i](h)
+	}
+	return h
+}
+
+// ThenFunc returns fn wrapped in the middleware of c.
+func (c Chain) ThenFunc(fn http.HandlerFunc) http.Handler {
+	return c.Then(fn)
+}
+
+// routeGroup registers routes on a mux wrapped in a common Chain.
+type routeGroup struct {
+	mux   *http.ServeMux
+	chain Chain
+}
+
+func newRouteGroup(mux *http.ServeMux, m ...Middleware) routeGroup {
+	return routeGroup{mux: mux, chain: NewChain(m...)}
+}
+
+// With returns a group on the same mux whose routes are additionally
+// wrapped in m.
+func (g routeGroup) With(m ...Middleware) routeGroup {
+	return routeGroup{mux: g.mux, chain: g.chain.Append(m...)}
+}
+
+// Handle registers h for pattern wrapped in the group's middleware.
+func (g routeGroup) Handle(pattern string, h http.Handler) {
+	g.mux.Handle(pattern, g.chain.Then(h))
+}
+
+// HandleFunc registers fn for pattern wrapped in the group's middleware.
+func (g routeGroup) HandleFunc(pattern string, fn http.HandlerFunc) {
+	g.Handle(pattern, fn)
+}
+
+// routePattern returns a function reporting the pattern of the route in
+// mux that serves a request, for logging.
+func routePattern(mux *http.ServeMux) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		_, pattern := mux.Handler(r)
+		return pattern
+	}
+}
@@ -0,0 +1,84 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+// tagMiddleware records name in order before and after calling next.
+func tagMiddleware(order *[]string, name string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			*order = append(*order, name)
+			next.ServeHTTP(w, r)
+			*order = append(*order, "/"+name)
+		})
+	}
+}
+
+func TestChain(t *testing.T) {
+	var order []string
+	base := NewChain(tagMiddleware(&order, "a"), tagMiddleware(&order, "b"))
+	withC := base.Append(tagMiddleware(&order, "c"))
+	withD := base.Append(tagMiddleware(&order, "d"))
+
+	tests := []struct {
+		name  string
+		chain Chain
+		want  []string
+	}{
+		{name: "empty", chain: NewChain(), want: []string{"h"}},
+		{name: "base", chain: base, want: []string{"a", "b", "h", "/b", "/a"}},
+		{name: "appended", chain: withC, want: []string{"a", "b", "c", "h", "/c", "/b", "/a"}},
+		// appending to base again must not change withC
+		{name: "appended separately", chain: withD, want: []string{"a", "b", "d", "h", "/d", "/b", "/a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order = nil
+			tt.chain.ThenFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, "h")
+			}).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if !slices.Equal(order, tt.want) {
+				t.Errorf("order = %v, want %v", order, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouteGroup(t *testing.T) {
+	var order []string
+	mux := http.NewServeMux()
+	public := newRouteGroup(mux, tagMiddleware(&order, "public"))
+	private := public.With(tagMiddleware(&order, "auth"))
+
+	public.HandleFunc("GET /open", func(w http.ResponseWriter, r *http.Request) {})
+	private.HandleFunc("GET /closed", func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		path        string
+		want        []string
+		wantPattern string
+	}{
+		{path: "/open", want: []string{"public", "/public"}, wantPattern: "GET /open"},
+		{path: "/closed", want: []string{"public", "auth", "/auth", "/public"}, wantPattern: "GET /closed"},
+	}
+	pattern := routePattern(mux)
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			order = nil
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			mux.ServeHTTP(httptest.NewRecorder(), req)
+
+			if !slices.Equal(order, tt.want) {
+				t.Errorf("order = %v, want %v", order, tt.want)
+			}
+			if got := pattern(req); got != tt.wantPattern {
+				t.Errorf("routePattern = %q, want %q", got, tt.wantPattern)
+			}
+		})
+	}
+}
@@ -2,18 +2,126 @@ package app
 
 import (
 	"net/http"
+	"strings"
+
+	"github.com/andyfusniak/monolith/internal/handler"
+	log "github.com/sirupsen/logrus"
 )
 
 func (a *App) v1Routes() *http.ServeMux {
 	mux := http.NewServeMux()
-	// mux.Use(a.handler.JSONHeader)
+
+	// authenticated routes are rate limited after authentication, so
+	// limits can apply per user or API key
+	public := newRouteGroup(mux)
+	authed := public.With(a.handler.RequireAuth)
+	if len(a.limits) > 0 {
+		limit := a.handler.RateLimit(routePattern(mux), a.limits)
+		public = newRouteGroup(mux, limit)
+		authed = newRouteGroup(mux, a.handler.RequireAuth, limit)
+	}
+
+	// routes creating resources whose responses hold no secrets may be
+	// retried with an Idempotency-Key
+	idempotent := public.With(a.handler.Idempotency)
+	authedIdempotent := authed.With(a.handler.Idempotency)
+
+	// oauth2 / openid connect authorization server
+	public.HandleFunc("GET /.well-known/openid-configuration", a.handler.OpenIDConfiguration())
+	public.HandleFunc("GET /oauth2/jwks", a.handler.JWKS())
+	public.HandleFunc("GET /oauth2/authorize", a.handler.Authorize())
+	public.HandleFunc("POST /oauth2/token", a.handler.Token())
+	public.HandleFunc("GET /oauth2/userinfo", a.handler.UserInfo())
 
 	// auth
-	mux.HandleFunc("POST /v1/auth/signin", a.handler.SignIn())
+	public.HandleFunc("POST /v1/auth/signin", a.handler.SignIn())
+	public.HandleFunc("POST /v1/auth/signin/mfa", a.handler.SignInMFA())
+	public.HandleFunc("POST /v1/auth/token/refresh", a.handler.RefreshToken())
+	public.HandleFunc("POST /v1/auth/magic-link", a.handler.RequestMagicLink())
+	public.HandleFunc("GET /v1/auth/magic-link/{token}", a.handler.RedeemMagicLink())
+
+	// oidc
+	public.HandleFunc("GET /v1/auth/oidc/{provider}", a.handler.OIDCLogin())
+	public.HandleFunc("GET /v1/auth/oidc/{provider}/callback", a.handler.OIDCCallback())
+
+	// webauthn
+	authed.HandleFunc("POST /v1/auth/webauthn/register/begin", a.handler.WebAuthnRegisterBegin())
+	authed.HandleFunc("POST /v1/auth/webauthn/register/finish", a.handler.WebAuthnRegisterFinish())
+	public.HandleFunc("POST /v1/auth/webauthn/login/begin", a.handler.WebAuthnLoginBegin())
+	public.HandleFunc("POST /v1/auth/webauthn/login/finish", a.handler.WebAuthnLoginFinish())
 
 	// user
-	mux.HandleFunc("POST /v1/users", a.handler.CreateUser())
-	mux.HandleFunc("GET /v1/users/{user_id}", a.handler.GetUser())
+	idempotent.HandleFunc("POST /v1/users", a.handler.CreateUser())
+	public.HandleFunc("GET /v1/users/{user_id}", a.handler.GetUser())
+
+	authed.HandleFunc("DELETE /v1/users/{user_id}", a.handler.EraseUser())
+	authed.HandleFunc("PUT /v1/users/{user_id}/password", a.handler.ChangePassword())
+	authed.HandleFunc("GET /v1/users/{user_id}/identities", a.handler.ListUserIdentities())
+
+	// data export
+	authedIdempotent.HandleFunc("POST /v1/users/{user_id}/export", a.handler.RequestUserExport())
+	authed.HandleFunc("GET /v1/users/{user_id}/exports/{export_id}", a.handler.GetUserExport())
+	authed.HandleFunc("GET /v1/users/{user_id}/exports/{export_id}/archive", a.handler.DownloadUserExport())
+
+	// api keys
+	authed.HandleFunc("POST /v1/users/{user_id}/api-keys", a.handler.CreateAPIKey())
+	authed.HandleFunc("GET /v1/users/{user_id}/api-keys", a.handler.ListAPIKeys())
+	authed.HandleFunc("DELETE /v1/users/{user_id}/api-keys/{api_key_id}", a.handler.DeleteAPIKey())
+
+	// admin
+	authed.HandleFunc("POST /v1/admin/impersonate/{user_id}", a.handler.Impersonate())
+	public.HandleFunc("DELETE /v1/admin/impersonate", a.handler.StopImpersonation())
+	authed.HandleFunc("GET /v1/admin/audit", a.handler.ListAuditEvents())
+
+	// organizations
+	authedIdempotent.HandleFunc("POST /v1/orgs", a.handler.CreateOrganization())
+	authed.HandleFunc("GET /v1/orgs", a.handler.ListOrganizations())
+	authed.HandleFunc("GET /v1/orgs/{org_id}", a.handler.GetOrganization())
+	authed.HandleFunc("DELETE /v1/orgs/{org_id}", a.handler.DeleteOrganization())
+	authed.HandleFunc("GET /v1/orgs/{org_id}/members", a.handler.ListOrgMembers())
+	authed.HandleFunc("PUT /v1/orgs/{org_id}/members/{user_id}", a.handler.UpdateOrgMember())
+	authed.HandleFunc("DELETE /v1/orgs/{org_id}/members/{user_id}", a.handler.RemoveOrgMember())
+	authedIdempotent.HandleFunc("POST /v1/orgs/{org_id}/invitations", a.handler.CreateOrgInvitation())
+	authed.HandleFunc("GET /v1/orgs/{org_id}/invitations", a.handler.ListOrgInvitations())
+	authed.HandleFunc("DELETE /v1/orgs/{org_id}/invitations/{invitation_id}", a.handler.RevokeOrgInvitation())
+
+	public.HandleFunc("GET /v1/invitations/{token}", a.handler.GetInvitation())
+	authed.HandleFunc("POST /v1/invitations/{token}/accept", a.handler.AcceptInvitation())
+	public.HandleFunc("POST /v1/invitations/{token}/decline", a.handler.DeclineInvitation())
+
+	// mfa
+	authed.HandleFunc("POST /v1/users/{user_id}/mfa/totp", a.handler.EnrollTOTP())
+	authed.HandleFunc("POST /v1/users/{user_id}/mfa/totp/confirm", a.handler.ConfirmTOTP())
 
 	return mux
 }
+
+// warnUnmatchedRateLimits logs the rate limit rules whose pattern is not
+// that of a route in mux, such as those with typos, which would never
+// apply.
+func warnUnmatchedRateLimits(mux *http.ServeMux, rules map[string]handler.RateLimitRule) {
+	for pattern := range rules {
+		if pattern == "*" {
+			continue
+		}
+		method, path, ok := strings.Cut(pattern, " ")
+		if !ok {
+			method, path = http.MethodGet, pattern
+		}
+
+		// a request the pattern matches, with wildcards replaced
+		segs := strings.Split(path, "/")
+		for i, seg := range segs {
+			if strings.HasPrefix(seg, "{") {
+				segs[i] = "x"
+			}
+		}
+		r, err := http.NewRequest(method, strings.Join(segs, "/"), nil)
+		if err == nil {
+			if _, p := mux.Handler(r); p == pattern {
+				continue
+			}
+		}
+		log.Warnf("[main] RATE_LIMITS rule %q matches no route", pattern)
+	}
+}
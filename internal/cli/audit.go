@@ -0,0 +1,50 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/andyfusniak/monolith/service"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// NewCmdAudit audit sub command.
+func NewCmdAudit() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:               "audit",
+		Short:             "audit log administration",
+		PersistentPreRun:  openService,
+		PersistentPostRun: closeService,
+	}
+
+	cmd.AddCommand(NewCmdAuditVerify())
+	return cmd
+}
+
+// NewCmdAuditVerify checks the audit log hash chain, exiting non-zero if
+// any event has been altered or removed.
+func NewCmdAuditVerify() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "verify",
+		Short: "verify the audit log hash chain",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			app := ctx.Value(AppKey("app")).(*App)
+
+			v, err := app.svc.VerifyAuditLog(ctx)
+			if err != nil {
+				var chainErr *service.AuditChainError
+				if errors.As(err, &chainErr) {
+					return fmt.Errorf("audit log verification failed: %v", chainErr)
+				}
+				return err
+			}
+
+			fmt.Fprintf(app.stdout, "events:   %d\n", v.Events)
+			fmt.Fprintf(app.stdout, "unsealed: %d\n", v.Unsealed)
+			fmt.Fprintf(app.stdout, "head:     %s\n", v.Head)
+			return nil
+		},
+	}
+	return cmd
+}
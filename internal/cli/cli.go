@@ -6,8 +6,11 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"time"
 
+	"github.com/andyfusniak/monolith/internal/env"
 	"github.com/andyfusniak/monolith/internal/store/sqlite3"
+	"github.com/andyfusniak/monolith/service"
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/spf13/cobra"
 )
@@ -19,6 +22,7 @@ type App struct {
 	gitCommit string
 	db        *sql.DB
 	mg        *migrate.Migrate
+	svc       *service.Service
 	stdout    io.Writer
 	stderr    io.Writer
 }
@@ -126,6 +130,58 @@ func NewCmdInfo() *cobra.Command {
 	return cmd
 }
 
+// openService is used as the PersistentPreRun of commands that use the
+// service. It reads the environment, opens the database and sets app.db
+// and app.svc, exiting on failure.
+func openService(cmd *cobra.Command, args []string) {
+	ctx := cmd.Context()
+	app := ctx.Value(AppKey("app")).(*App)
+
+	cfg, err := env.EnvToConfig()
+	if err != nil {
+		fmt.Fprintf(app.stderr, "%+v\n", err)
+		os.Exit(1)
+	}
+	if cfg.IsFatalErr() {
+		for _, e := range cfg.Errors() {
+			fmt.Fprintf(app.stderr, "%s\n", e)
+		}
+		os.Exit(1)
+	}
+
+	// with a database per tenant DB_FILEPATH selects the tenant database
+	if cfg.DBFilepath == "" {
+		fmt.Fprint(app.stderr, "DB_FILEPATH not set - set it to the database file of the tenant\n")
+		os.Exit(1)
+	}
+
+	db, err := sqlite3.OpenDB(cfg.DBFilepath)
+	if err != nil {
+		fmt.Fprint(app.stderr, "failed to open sqlite3 database file - check DB_FILEPATH\n")
+		os.Exit(1)
+	}
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
+	db.SetConnMaxLifetime(5 * time.Minute)
+
+	svc, err := newService(cfg, sqlite3.NewStore(db, db))
+	if err != nil {
+		fmt.Fprintf(app.stderr, "%+v\n", err)
+		os.Exit(1)
+	}
+
+	app.db = db
+	app.svc = svc
+}
+
+// closeService is used as the PersistentPostRun of commands that use
+// openService.
+func closeService(cmd *cobra.Command, args []string) {
+	ctx := cmd.Context()
+	app := ctx.Value(AppKey("app")).(*App)
+	app.db.Close()
+}
+
 // Version returns the cli application version.
 func (a *App) Version() string {
 	db, err := sql.Open(sqlite3.DriverName, ":memory:")
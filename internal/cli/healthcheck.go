@@ -0,0 +1,60 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// NewCmdHealthcheck probes the readiness or liveness endpoint of a running
+// server, exiting non-zero if it is not healthy. It lets container images
+// without curl define a HEALTHCHECK.
+func NewCmdHealthcheck() *cobra.Command {
+	var (
+		live    bool
+		url     string
+		timeout time.Duration
+	)
+	cmd := &cobra.Command{
+		Use:   "healthcheck",
+		Short: "probe the /readyz or /healthz endpoint of a running server",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			app := ctx.Value(AppKey("app")).(*App)
+
+			if url == "" {
+				port := os.Getenv("PORT")
+				if port == "" {
+					port = "8080"
+				}
+				path := "/readyz"
+				if live {
+					path = "/healthz"
+				}
+				url = "http://127.0.0.1:" + port + path
+			}
+
+			client := &http.Client{Timeout: timeout}
+			resp, err := client.Get(url)
+			if err != nil {
+				return fmt.Errorf("healthcheck failed: %v", err)
+			}
+			defer resp.Body.Close()
+
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+			fmt.Fprintf(app.stdout, "%s", body)
+			if resp.StatusCode != http.StatusOK {
+				return fmt.Errorf("healthcheck failed: %s returned %s", url, resp.Status)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&live, "live", false, "probe /healthz instead of /readyz")
+	cmd.Flags().StringVar(&url, "url", "", "URL to probe (default http://127.0.0.1:$PORT/readyz)")
+	cmd.Flags().DurationVar(&timeout, "timeout", 5*time.Second, "time to wait for a response")
+	return cmd
+}
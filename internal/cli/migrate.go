@@ -1,6 +1,7 @@
 package cli
 
 import (
+	"database/sql"
 	"fmt"
 	"net/http"
 	"os"
@@ -14,6 +15,7 @@ import (
 	driversqlite3 "github.com/golang-migrate/migrate/v4/database/sqlite3"
 
 	"github.com/golang-migrate/migrate/v4/source/httpfs"
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 )
 
@@ -26,6 +28,11 @@ func NewCmdMigrate() *cobra.Command {
 			ctx := cmd.Context()
 			app := ctx.Value(AppKey("app")).(*App)
 
+			// tenant databases are opened one at a time by the command
+			if all, _ := cmd.Flags().GetBool("all-tenants"); all {
+				return
+			}
+
 			dbfile := os.Getenv("DB_FILEPATH")
 			if dbfile == "" {
 				fmt.Fprint(app.stderr, "DB_FILEPATH not set\n")
@@ -38,28 +45,12 @@ func NewCmdMigrate() *cobra.Command {
 				os.Exit(1)
 			}
 
-			db.SetMaxOpenConns(1)
-			db.SetMaxIdleConns(1)
-			db.SetConnMaxLifetime(5 * time.Minute)
-
-			driver, err := driversqlite3.WithInstance(db, &driversqlite3.Config{NoTxWrap: true})
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "failed with instance %+v\n", err)
-				os.Exit(1)
-			}
-
-			source, err := httpfs.New(http.FS(schema.Migrations), "migrations")
+			mg, err := newMigrate(db)
 			if err != nil {
 				fmt.Fprintf(app.stderr, "%+v\n", err)
 				os.Exit(1)
 			}
 
-			mg, err := migrate.NewWithInstance("https", source, "sqlite3", driver)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "failed to get new migrate instance %+v\n", err)
-				os.Exit(1)
-			}
-
 			app.db = db
 			app.mg = mg
 		},
@@ -70,8 +61,32 @@ func NewCmdMigrate() *cobra.Command {
 	return cmd
 }
 
+// newMigrate returns a migrate instance applying schema.Migrations to db.
+func newMigrate(db *sql.DB) (*migrate.Migrate, error) {
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
+	db.SetConnMaxLifetime(5 * time.Minute)
+
+	driver, err := driversqlite3.WithInstance(db, &driversqlite3.Config{NoTxWrap: true})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed with instance")
+	}
+
+	source, err := httpfs.New(http.FS(schema.Migrations), "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	mg, err := migrate.NewWithInstance("https", source, "sqlite3", driver)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get new migrate instance")
+	}
+	return mg, nil
+}
+
 // NewCmdMigrateUp migrate up brings up the database schema.
 func NewCmdMigrateUp() *cobra.Command {
+	var allTenants bool
 	cmd := &cobra.Command{
 		Use:   "up",
 		Short: "apply all or N up migrations",
@@ -79,6 +94,10 @@ func NewCmdMigrateUp() *cobra.Command {
 			ctx := cmd.Context()
 			app := ctx.Value(AppKey("app")).(*App)
 
+			if allTenants {
+				return migrateUpAllTenants(app)
+			}
+
 			if err := app.mg.Up(); err != nil {
 				fmt.Fprintf(os.Stderr, "migrate up failed: %+v\n", err)
 			}
@@ -88,9 +107,59 @@ func NewCmdMigrateUp() *cobra.Command {
 			return nil
 		},
 	}
+	cmd.Flags().BoolVar(&allTenants, "all-tenants", false,
+		"migrate every tenant database in TENANT_DB_DIR")
 	return cmd
 }
 
+// migrateUpAllTenants applies the up migrations to each tenant database in
+// turn. A failing tenant does not stop the others; an error is returned at
+// the end if any failed.
+func migrateUpAllTenants(app *App) error {
+	dir := os.Getenv("TENANT_DB_DIR")
+	if dir == "" {
+		return fmt.Errorf("TENANT_DB_DIR not set")
+	}
+
+	tenants, err := sqlite3.ListTenants(dir)
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	for _, id := range tenants {
+		if err := migrateUpTenant(dir, id); err != nil {
+			failed++
+			fmt.Fprintf(app.stderr, "tenant %s: migrate up failed: %v\n", id, err)
+			continue
+		}
+		fmt.Fprintf(app.stdout, "tenant %s: up to date\n", id)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("migrate up failed for %d of %d tenants", failed, len(tenants))
+	}
+	fmt.Fprintf(app.stdout, "migrated %d tenants\n", len(tenants))
+	return nil
+}
+
+func migrateUpTenant(dir, tenantID string) error {
+	db, err := sqlite3.OpenDB(sqlite3.TenantDBPath(dir, tenantID))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	mg, err := newMigrate(db)
+	if err != nil {
+		return err
+	}
+	if err := mg.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return err
+	}
+	return nil
+}
+
 // NewCmdMigrateDown migrate down brings down the database schema.
 func NewCmdMigrateDown() *cobra.Command {
 	cmd := &cobra.Command{
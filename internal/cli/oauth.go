@@ -0,0 +1,151 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/andyfusniak/monolith/service"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// NewCmdOAuth oauth sub command.
+func NewCmdOAuth() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:               "oauth",
+		Short:             "OAuth2 / OpenID Connect authorization server administration",
+		PersistentPreRun:  openService,
+		PersistentPostRun: closeService,
+	}
+
+	cmd.AddCommand(NewCmdOAuthClients())
+	cmd.AddCommand(NewCmdOAuthRotateKey())
+	return cmd
+}
+
+// NewCmdOAuthClients oauth clients sub command.
+func NewCmdOAuthClients() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "clients",
+		Short: "manage registered OAuth clients",
+	}
+
+	cmd.AddCommand(NewCmdOAuthClientsAdd())
+	cmd.AddCommand(NewCmdOAuthClientsList())
+	cmd.AddCommand(NewCmdOAuthClientsRemove())
+	return cmd
+}
+
+// NewCmdOAuthClientsAdd registers a new OAuth client. The client secret is
+// printed once and cannot be retrieved later.
+func NewCmdOAuthClientsAdd() *cobra.Command {
+	var (
+		name         string
+		redirectURIs []string
+		public       bool
+	)
+	cmd := &cobra.Command{
+		Use:   "add",
+		Short: "register a new OAuth client",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			app := ctx.Value(AppKey("app")).(*App)
+
+			client, secret, err := app.svc.CreateOAuthClient(ctx, name, redirectURIs, public)
+			if err != nil {
+				if errors.Is(err, service.ErrOAuthRedirectURIInvalid) {
+					return fmt.Errorf("%v; use https, or http on a loopback address", err)
+				}
+				return err
+			}
+
+			fmt.Fprintf(app.stdout, "client_id:     %s\n", client.ID)
+			if secret != "" {
+				fmt.Fprintf(app.stdout, "client_secret: %s\n", secret)
+				fmt.Fprint(app.stdout, "store the client secret now; it cannot be shown again\n")
+			} else {
+				fmt.Fprint(app.stdout, "public client; use PKCE (S256) in place of a client secret\n")
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&name, "name", "", "client display name")
+	cmd.Flags().StringArrayVar(&redirectURIs, "redirect-uri", nil, "allowed redirect URI (repeatable)")
+	cmd.Flags().BoolVar(&public, "public", false, "public client without a secret, e.g. a SPA or native app")
+	_ = cmd.MarkFlagRequired("name")
+	_ = cmd.MarkFlagRequired("redirect-uri")
+	return cmd
+}
+
+// NewCmdOAuthClientsList lists registered OAuth clients.
+func NewCmdOAuthClientsList() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "list registered OAuth clients",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			app := ctx.Value(AppKey("app")).(*App)
+
+			clients, err := app.svc.ListOAuthClients(ctx)
+			if err != nil {
+				return err
+			}
+
+			for _, c := range clients {
+				typ := "confidential"
+				if c.Public {
+					typ = "public"
+				}
+				fmt.Fprintf(app.stdout, "%s  %-12s  %s  %s\n",
+					c.ID, typ, c.Name, strings.Join(c.RedirectURIs, " "))
+			}
+			return nil
+		},
+	}
+	return cmd
+}
+
+// NewCmdOAuthClientsRemove removes an OAuth client along with its
+// outstanding authorization codes and refresh tokens.
+func NewCmdOAuthClientsRemove() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "remove <client_id>",
+		Short: "remove an OAuth client and revoke its refresh tokens",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			app := ctx.Value(AppKey("app")).(*App)
+
+			if err := app.svc.DeleteOAuthClient(ctx, args[0]); err != nil {
+				if errors.Is(err, service.ErrOAuthClientNotFound) {
+					return fmt.Errorf("client %s not found", args[0])
+				}
+				return err
+			}
+			fmt.Fprintf(app.stdout, "removed client %s\n", args[0])
+			return nil
+		},
+	}
+	return cmd
+}
+
+// NewCmdOAuthRotateKey creates a new signing key. The previous key stays
+// published in the JWKS until tokens signed with it have expired.
+func NewCmdOAuthRotateKey() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rotate-key",
+		Short: "create a new token signing key",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			app := ctx.Value(AppKey("app")).(*App)
+
+			kid, err := app.svc.RotateOAuthSigningKey(ctx)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(app.stdout, "new signing key kid=%s\n", kid)
+			return nil
+		},
+	}
+	return cmd
+}
@@ -2,22 +2,41 @@ package cli
 
 import (
 	"context"
+	"database/sql"
+	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
 	"runtime"
+	"syscall"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/andyfusniak/monolith/internal/app"
 	"github.com/andyfusniak/monolith/internal/env"
+	"github.com/andyfusniak/monolith/internal/lifecycle"
+	"github.com/andyfusniak/monolith/internal/logging"
+	"github.com/andyfusniak/monolith/internal/mail"
+	"github.com/andyfusniak/monolith/internal/metrics"
+	"github.com/andyfusniak/monolith/internal/oidc"
+	"github.com/andyfusniak/monolith/internal/store"
 	"github.com/andyfusniak/monolith/internal/store/sqlite3"
+	"github.com/andyfusniak/monolith/internal/store/sqlite3/schema"
+	"github.com/andyfusniak/monolith/internal/tracing"
+	"github.com/andyfusniak/monolith/internal/webauthn"
 	"github.com/andyfusniak/monolith/service"
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 )
 
 const (
 	defaultMaxOpenConns int = 120
 	defaultMaxIdleConns int = 20
+
+	// expiryInterval is how often expired challenges, magic links, OIDC
+	// authorization requests and idempotency keys are deleted.
+	expiryInterval = time.Hour
 )
 
 // NewCmdServer creates a new server command. This command starts the web service.
@@ -48,66 +67,324 @@ func NewCmdServer(version, gitcommit string) *cobra.Command {
 			}
 
 			// set up logging
+			closeLog, err := initLogging(cfg.App.LogLevel, cfg.Log)
+			if err != nil {
+				return err
+			}
 			defer func() {
 				log.Infof("[main] goodbye from monolith version %s (%s)", version, gitcommit)
+				closeLog()
 			}()
-			initLogging(cfg.App.LogLevel)
 			log.Infof("[main] hello from monolith version %s (%s) %s for %s %s",
 				version, gitcommit, runtime.Version(), runtime.GOOS, runtime.GOARCH)
 
+			// components are started in order and stopped in reverse
+			lc := lifecycle.New(cfg.App.ShutdownTimeout)
+
+			// tracing
+			tracer, err := initTracing(cfg.Trace)
+			if err != nil {
+				return err
+			}
+			lc.Add(tracer)
+
+			// metrics served on the admin listener
+			reg := metrics.NewRegistry()
+			reg.Register(metrics.Runtime())
+
 			// database connection
 			// one read-only with high concurrency
 			// one read-write for non-concurrent queries
-			rw, err := sqlite3.OpenDB(cfg.DBFilepath)
+			// or both per tenant, opened on demand
+			var repo store.Repository
+			if cfg.Tenant.DBDir != "" {
+				pools := sqlite3.NewTenantPools(cfg.Tenant.DBDir, cfg.Tenant.MaxOpen)
+				lc.Add(lifecycle.Component{
+					Name: "database",
+					Stop: func(ctx context.Context) error {
+						pools.Close()
+						return nil
+					},
+				})
+				repo = sqlite3.NewTenantStore(pools)
+				log.Infof("[main] database per tenant in %s", cfg.Tenant.DBDir)
+			} else {
+				rw, err := sqlite3.OpenDB(cfg.DBFilepath)
+				if err != nil {
+					return err
+				}
+				rw.SetMaxOpenConns(1)
+				rw.SetMaxIdleConns(1)
+				rw.SetConnMaxIdleTime(5 * time.Minute)
+
+				ro, err := sqlite3.OpenDB(cfg.DBFilepath)
+				if err != nil {
+					return err
+				}
+				ro.SetMaxOpenConns(defaultMaxOpenConns)
+				ro.SetMaxIdleConns(defaultMaxIdleConns)
+				ro.SetConnMaxIdleTime(5 * time.Minute)
+
+				lc.Add(lifecycle.Component{
+					Name: "database",
+					Stop: func(ctx context.Context) error {
+						roErr, rwErr := ro.Close(), rw.Close()
+						if roErr != nil {
+							return roErr
+						}
+						return rwErr
+					},
+				})
+
+				repo = sqlite3.NewStore(ro, rw)
+				reg.Register(metrics.DBStats(map[string]*sql.DB{"ro": ro, "rw": rw}))
+				reg.Register(metrics.FileSize("monolith_sqlite_wal_size_bytes",
+					"Size of the SQLite write-ahead log file.", cfg.DBFilepath+"-wal"))
+			}
+
+			// service
+			schemaVersion, err := schema.LatestVersion()
 			if err != nil {
 				return err
 			}
-			defer rw.Close()
-			rw.SetMaxOpenConns(1)
-			rw.SetMaxIdleConns(1)
-			rw.SetConnMaxIdleTime(5 * time.Minute)
-
-			ro, err := sqlite3.OpenDB(cfg.DBFilepath)
+			opts := []service.Option{
+				service.WithMetrics(reg),
+				service.WithSchemaVersion(schemaVersion),
+			}
+			if cfg.RateLimit.Store == "sqlite" {
+				opts = append(opts, service.WithRateLimitStore(repo))
+			}
+			if len(cfg.RateLimit.Rules) > 0 {
+				log.Infof("[main] rate limiting %d route patterns using the %s store",
+					len(cfg.RateLimit.Rules), cfg.RateLimit.Store)
+			}
+			svc, err := newService(cfg, repo, opts...)
 			if err != nil {
 				return err
 			}
-			defer ro.Close()
-			ro.SetMaxOpenConns(defaultMaxOpenConns)
-			ro.SetMaxIdleConns(defaultMaxIdleConns)
-			ro.SetConnMaxIdleTime(5 * time.Minute)
 
-			// store and service
-			store := sqlite3.NewStore(ro, rw)
-			svc := service.New(service.WithRepository(store))
+			// user exports are built in the background; builds in
+			// progress finish before the database is closed, and those
+			// lost when a previous process stopped are marked failed
+			startedAt := time.Now()
+			lc.Add(lifecycle.Component{
+				Name: "user exports",
+				Start: func(ctx context.Context) error {
+					n, err := svc.FailStaleUserExports(ctx, startedAt)
+					if n > 0 {
+						log.Warnf("[main] marked %d stale user exports failed", n)
+					}
+					return err
+				},
+				Stop: svc.StopUserExports,
+			})
+
+			// unauthenticated requests leave challenges behind and
+			// responses to requests with an Idempotency-Key are replayed
+			// for a day, so expired rows are deleted periodically
+			lc.Add(lifecycle.Periodic("expired row cleanup", expiryInterval,
+				func(ctx context.Context) error {
+					n, err := svc.DeleteExpired(ctx)
+					if n > 0 {
+						log.Infof("[main] deleted %d expired rows", n)
+					}
+					return err
+				}))
 
 			// HTTP application server
-			app, err := app.New(cfg.App, app.WithService(svc))
+			app, err := app.New(cfg.App, app.WithService(svc), app.WithTenants(cfg.Tenant),
+				app.WithMetrics(reg), app.WithRateLimits(cfg.RateLimit.Rules))
 			if err != nil {
 				return err
 			}
-			if err := app.Start(context.Background()); err != nil {
-				return err
-			}
+			app.Register(lc)
 
-			return nil
+			ctx, stop := shutdownContext()
+			defer stop()
+			return lc.Run(ctx)
 		},
 	}
 
 	return cmd
 }
 
-func initLogging(logLevel string) {
-	// Output logs with colour
-	log.SetFormatter(&log.TextFormatter{
-		ForceColors: true,
-	})
+// newService creates a service backed by repo using the password policy,
+// hashing parameters, relying party, mailer and identity providers from
+// cfg, followed by opts.
+func newService(cfg *env.Config, repo store.Repository, opts ...service.Option) (*service.Service, error) {
+	policy := service.DefaultPasswordPolicy()
+	policy.MinRunes = cfg.Password.MinLength
+	policy.MaxRunes = cfg.Password.MaxLength
+	if cfg.Password.PwnedPasswordsPath != "" {
+		pwned, err := service.NewPwnedPasswords(cfg.Password.PwnedPasswordsPath)
+		if err != nil {
+			return nil, err
+		}
+		policy.Breaches = pwned
+	}
 
-	// Output to stdout instead of the default stderr
-	log.SetOutput(os.Stdout)
+	params := service.DefaultHashParams()
+	params.Memory = cfg.Password.HashMemory
+	params.Iterations = cfg.Password.HashIterations
+	params.Parallelism = cfg.Password.HashParallelism
+
+	rp, err := webauthn.NewConfig(cfg.App.BaseURL, "monolith")
+	if err != nil {
+		return nil, err
+	}
+
+	var mailer mail.Mailer = mail.LogMailer{}
+	if cfg.Mail.SMTPAddr != "" {
+		mailer = mail.NewSMTPMailer(cfg.Mail.SMTPAddr, cfg.Mail.SMTPUsername,
+			cfg.Mail.SMTPPassword, cfg.Mail.From)
+	}
+
+	// calls to identity providers are traced
+	client := &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: tracing.Transport(nil),
+	}
+	providers := make([]*oidc.Provider, 0, len(cfg.OIDC))
+	for _, p := range cfg.OIDC {
+		providers = append(providers, oidc.NewProvider(oidc.Config{
+			Name:         p.Name,
+			Issuer:       p.Issuer,
+			ClientID:     p.ClientID,
+			ClientSecret: p.ClientSecret,
+			Scopes:       p.Scopes,
+			AuthURL:      p.AuthURL,
+			TokenURL:     p.TokenURL,
+			UserInfoURL:  p.UserInfoURL,
+			TrustEmail:   p.TrustEmail,
+		}, client))
+	}
+
+	return service.New(append([]service.Option{
+		service.WithRepository(repo),
+		service.WithPasswordPolicy(policy),
+		service.WithHashParams(params),
+		service.WithWebAuthn(rp),
+		service.WithMailer(mailer),
+		service.WithBaseURL(cfg.App.BaseURL),
+		service.WithTenantDomain(cfg.Tenant.Domain),
+		service.WithOIDCProviders(providers...),
+		service.WithAuditHashKey([]byte(cfg.Audit.HashKey)),
+	}, opts...)...), nil
+}
+
+// initLogging configures the standard logger and routes log/slog to it.
+// The returned function closes the log file, if any.
+func initLogging(logLevel string, cfg env.LogConfig) (func(), error) {
+	// Output to stdout instead of the default stderr, or to a rotated file
+	closeLog := func() {}
+	if cfg.File != "" {
+		f, err := logging.OpenRotatingFile(cfg.File, cfg.MaxSize, cfg.MaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		log.SetOutput(f)
+		closeLog = func() {
+			log.SetOutput(os.Stdout)
+			f.Close()
+		}
+	} else {
+		log.SetOutput(os.Stdout)
+	}
+
+	switch cfg.Format {
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	case "logfmt":
+		log.SetFormatter(&log.TextFormatter{
+			DisableColors: true,
+			FullTimestamp: true,
+		})
+	default:
+		// Output logs with colour unless writing to a file
+		log.SetFormatter(&log.TextFormatter{
+			ForceColors: cfg.File == "",
+		})
+	}
+
+	// Add request IDs and principals to entries logged with a request context
+	log.AddHook(service.LogHook{})
 
 	// Log debug level severity or above.
 	logrusLevel := logLevelToLogrusLevel(logLevel)
 	log.SetLevel(logrusLevel)
+
+	// Libraries using log/slog write to the same output with the same fields
+	slog.SetDefault(slog.New(logging.NewSlogHandler(log.StandardLogger())))
+
+	return closeLog, nil
+}
+
+// initTracing sets the default tracer from cfg, returning a component
+// that exports queued spans and stops the tracer.
+func initTracing(cfg env.TraceConfig) (lifecycle.Component, error) {
+	c := lifecycle.Component{Name: "tracing"}
+
+	var exporter tracing.Exporter
+	closeFile := func() error { return nil }
+	switch cfg.Exporter {
+	case "":
+		return c, nil
+	case "otlp":
+		exporter = tracing.NewOTLPExporter(cfg.OTLPEndpoint, cfg.OTLPHeaders, "monolith")
+	case "stdout":
+		exporter = tracing.NewWriterExporter(os.Stdout, "monolith")
+	case "file":
+		f, err := os.OpenFile(cfg.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+		if err != nil {
+			return c, errors.Wrapf(err, "[main] open trace file %s failed", cfg.File)
+		}
+		exporter = tracing.NewWriterExporter(f, "monolith")
+		closeFile = f.Close
+	}
+
+	tracer := tracing.NewTracer(exporter, cfg.SampleRatio)
+	tracing.SetDefault(tracer)
+	log.Infof("[main] tracing with %s exporter sampling %g of traces", cfg.Exporter, cfg.SampleRatio)
+
+	c.Stop = func(ctx context.Context) error {
+		tracing.SetDefault(nil)
+		if err := tracer.Shutdown(ctx); err != nil {
+			closeFile()
+			return err
+		}
+		return closeFile()
+	}
+	return c, nil
+}
+
+// shutdownContext returns a context that is cancelled when the process is
+// sent SIGINT or SIGTERM. A second signal exits immediately, for when
+// shutdown is stuck.
+func shutdownContext() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 2)
+
+	// interrupt signal sent from terminal, sigterm from kubernetes
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig, ok := <-sigs
+		if !ok {
+			return
+		}
+		log.Infof("[main] received signal %s; shutting down", sig)
+		cancel()
+
+		if sig, ok := <-sigs; ok {
+			log.Errorf("[main] received signal %s during shutdown; exiting", sig)
+			os.Exit(1)
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(sigs)
+		close(sigs)
+		cancel()
+	}
 }
 
 func logLevelToLogrusLevel(v string) log.Level {
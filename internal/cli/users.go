@@ -0,0 +1,213 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/andyfusniak/monolith/service"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// NewCmdUsers users sub command.
+func NewCmdUsers() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:               "users",
+		Short:             "user account administration",
+		PersistentPreRun:  openService,
+		PersistentPostRun: closeService,
+	}
+
+	cmd.AddCommand(NewCmdUsersHashReport())
+	cmd.AddCommand(NewCmdUsersUnlock())
+	cmd.AddCommand(NewCmdUsersGrantRole())
+	cmd.AddCommand(NewCmdUsersRevokeRole())
+	cmd.AddCommand(NewCmdUsersExport())
+	cmd.AddCommand(NewCmdUsersErase())
+	return cmd
+}
+
+// NewCmdUsersHashReport reports how many users have password hashes
+// created with outdated argon2id parameters.
+func NewCmdUsersHashReport() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "hash-report",
+		Short: "report users with password hashes using outdated argon2id parameters",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			app := ctx.Value(AppKey("app")).(*App)
+
+			report, err := app.svc.PasswordHashReport(ctx)
+			if err != nil {
+				return err
+			}
+
+			p := app.svc.HashParams()
+			fmt.Fprintf(app.stdout, "configured params: m=%d,t=%d,p=%d\n",
+				p.Memory, p.Iterations, p.Parallelism)
+			fmt.Fprintf(app.stdout, "users:             %d\n", report.Total)
+			fmt.Fprintf(app.stdout, "outdated:          %d\n", report.Outdated)
+
+			keys := make([]string, 0, len(report.ByParams))
+			for k := range report.ByParams {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				fmt.Fprintf(app.stdout, "  %-24s %d\n", k, report.ByParams[k])
+			}
+
+			return nil
+		},
+	}
+	return cmd
+}
+
+// NewCmdUsersUnlock clears failed sign in attempts and any lockout for a
+// user or client IP address.
+func NewCmdUsersUnlock() *cobra.Command {
+	var ip string
+	cmd := &cobra.Command{
+		Use:   "unlock [user_id]",
+		Short: "clear sign in lockout for a user or client IP address",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			app := ctx.Value(AppKey("app")).(*App)
+
+			if len(args) == 0 && ip == "" {
+				return fmt.Errorf("either a user_id argument or --ip is required")
+			}
+
+			if len(args) == 1 {
+				if err := app.svc.UnlockUser(ctx, args[0]); err != nil {
+					return err
+				}
+				fmt.Fprintf(app.stdout, "unlocked user %s\n", args[0])
+			}
+			if ip != "" {
+				if err := app.svc.UnlockIP(ctx, ip); err != nil {
+					return err
+				}
+				fmt.Fprintf(app.stdout, "unlocked ip %s\n", ip)
+			}
+
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&ip, "ip", "", "client IP address to unlock")
+	return cmd
+}
+
+// NewCmdUsersGrantRole grants a staff role to a user.
+func NewCmdUsersGrantRole() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "grant-role <user_id> <role>",
+		Short: "grant a staff role (admin) to a user",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			app := ctx.Value(AppKey("app")).(*App)
+
+			if err := app.svc.GrantRole(ctx, args[0], args[1]); err != nil {
+				return roleCmdError(err, args)
+			}
+			fmt.Fprintf(app.stdout, "granted %s to user %s\n", args[1], args[0])
+			return nil
+		},
+	}
+	return cmd
+}
+
+// NewCmdUsersRevokeRole revokes a staff role from a user.
+func NewCmdUsersRevokeRole() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "revoke-role <user_id> <role>",
+		Short: "revoke a staff role from a user",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			app := ctx.Value(AppKey("app")).(*App)
+
+			if err := app.svc.RevokeRole(ctx, args[0], args[1]); err != nil {
+				return roleCmdError(err, args)
+			}
+			fmt.Fprintf(app.stdout, "revoked %s from user %s\n", args[1], args[0])
+			return nil
+		},
+	}
+	return cmd
+}
+
+// NewCmdUsersExport writes an archive of all data held about a user to
+// standard output.
+func NewCmdUsersExport() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "export <user_id>",
+		Short: "write a JSON archive of a user's data to standard output",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			app := ctx.Value(AppKey("app")).(*App)
+
+			archive, err := app.svc.ExportUserData(ctx, args[0])
+			if err != nil {
+				if errors.Is(err, service.ErrUserNotFound) {
+					return fmt.Errorf("user %s not found", args[0])
+				}
+				return err
+			}
+
+			enc := json.NewEncoder(app.stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(archive)
+		},
+	}
+	return cmd
+}
+
+// NewCmdUsersErase deletes a user and all their data.
+func NewCmdUsersErase() *cobra.Command {
+	var yes bool
+	cmd := &cobra.Command{
+		Use:   "erase <user_id>",
+		Short: "permanently delete a user and all their data",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			app := ctx.Value(AppKey("app")).(*App)
+
+			if !yes {
+				return fmt.Errorf("erasing a user cannot be undone; pass --yes to confirm")
+			}
+
+			if err := app.svc.EraseUser(ctx, args[0]); err != nil {
+				switch {
+				case errors.Is(err, service.ErrUserNotFound):
+					return fmt.Errorf("user %s not found", args[0])
+				case errors.Is(err, service.ErrUserSoleOwner):
+					return fmt.Errorf("user %s is the only owner of an organization with other members; "+
+						"transfer ownership first", args[0])
+				}
+				return err
+			}
+			fmt.Fprintf(app.stdout, "erased user %s\n", args[0])
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&yes, "yes", false, "confirm the erasure")
+	return cmd
+}
+
+func roleCmdError(err error, args []string) error {
+	switch {
+	case errors.Is(err, service.ErrRoleInvalid):
+		return fmt.Errorf("role %q is not valid; the only role is %s", args[1], service.RoleAdmin)
+	case errors.Is(err, service.ErrUserNotFound):
+		return fmt.Errorf("user %s not found", args[0])
+	case errors.Is(err, service.ErrRoleNotHeld):
+		return fmt.Errorf("user %s does not have the %s role", args[0], args[1])
+	}
+	return err
+}
@@ -2,8 +2,15 @@ package env
 
 import (
 	"fmt"
+	"math"
+	"net/netip"
 	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/andyfusniak/monolith/service"
 	"github.com/pkg/errors"
 )
 
@@ -11,19 +18,122 @@ import (
 type Config struct {
 	DBFilepath string
 	App        AppConfig
+	Log        LogConfig
+	Trace      TraceConfig
+	Password   PasswordConfig
+	Mail       MailConfig
+	Audit      AuditConfig
+	OIDC       []OIDCProviderConfig
+	Tenant     TenantConfig
+	RateLimit  RateLimitConfig
 	errors     []string
 	warnings   []string
 	errFatal   bool
 }
 
 type AppConfig struct {
-	Port      string
-	LogLevel  string
-	CWD       string
-	BaseURL   string
-	IsDevMode bool
+	Port           string
+	AdminPort      string
+	AdminHost      string
+	LogLevel       string
+	CWD            string
+	BaseURL        string
+	IsDevMode      bool
+	TrustedProxies []netip.Prefix
+
+	ShutdownTimeout time.Duration
+	ShutdownDelay   time.Duration
+}
+
+// LogConfig log output configuration. If File is empty logs are written
+// to stdout.
+type LogConfig struct {
+	Format     string
+	File       string
+	MaxSize    int64
+	MaxBackups int
+}
+
+// TraceConfig distributed tracing configuration. If Exporter is empty
+// tracing is disabled.
+type TraceConfig struct {
+	Exporter     string
+	OTLPEndpoint string
+	OTLPHeaders  map[string]string
+	File         string
+	SampleRatio  float64
+}
+
+// PasswordConfig password policy and hashing configuration.
+type PasswordConfig struct {
+	MinLength          int
+	MaxLength          int
+	PwnedPasswordsPath string
+	HashMemory         uint32
+	HashIterations     uint32
+	HashParallelism    uint8
+}
+
+// MailConfig outgoing email configuration. If SMTPAddr is empty email is
+// written to the log instead of being sent.
+type MailConfig struct {
+	SMTPAddr     string
+	SMTPUsername string
+	SMTPPassword string
+	From         string
+}
+
+// AuditConfig audit log configuration. If HashKey is empty email
+// addresses of failed sign ins are not recorded.
+type AuditConfig struct {
+	HashKey string
+}
+
+// OIDCProviderConfig an external OAuth2 or OpenID Connect provider users
+// can sign in with.
+type OIDCProviderConfig struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	AuthURL      string
+	TokenURL     string
+	UserInfoURL  string
+	TrustEmail   bool
+}
+
+// TenantConfig database-per-tenant configuration. If DBDir is empty the
+// service uses the single database at DBFilepath.
+type TenantConfig struct {
+	DBDir   string
+	Domain  string
+	Header  string
+	MaxOpen int
 }
 
+// RateLimitConfig per-route rate limits. Store is memory or sqlite.
+type RateLimitConfig struct {
+	Store string
+	Rules []RateLimitRule
+}
+
+// RateLimitRule limits the requests each client may make to the routes
+// registered with Pattern, or to routes without a rule of their own if
+// Pattern is "*". Clients have a bucket of Burst tokens refilled at Limit
+// per Period, and are identified by Key: ip, user or api_key.
+type RateLimitRule struct {
+	Pattern string
+	Limit   int
+	Period  time.Duration
+	Burst   int
+	Key     string
+}
+
+// defaultRateLimits limits the unauthenticated endpoints that hash
+// passwords, create accounts or store challenges.
+const defaultRateLimits = "POST /v1/users=10/1h; POST /v1/auth/signin=30/1m,burst=10; POST /v1/auth/signin/mfa=30/1m,burst=10; POST /v1/auth/webauthn/login/begin=30/1m,burst=10"
+
 // HasWarnings returns true if there are any warnings.
 func (c *Config) HasWarnings() bool {
 	return len(c.warnings) > 0
@@ -70,6 +180,39 @@ func EnvToConfig() (*Config, error) {
 	}
 	cfg.App.Port = port
 
+	// ADMIN_PORT (optional) port of the admin listener serving metrics,
+	// profiling and runtime controls. Set empty to disable it.
+	adminPort, found := os.LookupEnv("ADMIN_PORT")
+	if !found {
+		adminPort = "9090"
+	}
+	if adminPort != "" && adminPort == port {
+		cfg.errors = append(cfg.errors, "ADMIN_PORT must differ from PORT")
+		cfg.errFatal = true
+	}
+	cfg.App.AdminPort = adminPort
+
+	// ADMIN_HOST (optional) address the admin listener is bound to. The
+	// admin listener has no authentication so it is localhost by default.
+	adminHost := os.Getenv("ADMIN_HOST")
+	if adminHost == "" {
+		adminHost = "127.0.0.1"
+	}
+	if addr, err := netip.ParseAddr(adminHost); err != nil || !addr.IsLoopback() {
+		cfg.warnings = append(cfg.warnings, fmt.Sprintf(
+			"ADMIN_HOST %s is not a loopback address; the admin listener has no authentication", adminHost))
+	}
+	cfg.App.AdminHost = adminHost
+
+	// BASE_URL (optional) public URL of the service. Used as the WebAuthn
+	// relying party origin.
+	baseURL := os.Getenv("BASE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:" + port
+		cfg.warnings = append(cfg.warnings, fmt.Sprintf("BASE_URL not set; defaulting to %s", baseURL))
+	}
+	cfg.App.BaseURL = strings.TrimRight(baseURL, "/")
+
 	// LOG_LEVEL (optional)
 	logLevel := os.Getenv("LOG_LEVEL")
 	if logLevel == "" {
@@ -81,17 +224,360 @@ func EnvToConfig() (*Config, error) {
 	}
 	cfg.App.LogLevel = logLevel
 
+	// LOG_FORMAT (optional) text, logfmt or json.
+	logFormat := os.Getenv("LOG_FORMAT")
+	if logFormat == "" {
+		logFormat = "text"
+	}
+	if !isValidLogFormat(logFormat) {
+		cfg.errors = append(cfg.errors, fmt.Sprintf("LOG_FORMAT %s is not valid", logFormat))
+		cfg.errFatal = true
+	}
+	cfg.Log.Format = logFormat
+
+	// LOG_FILE (optional) file to write logs to instead of stdout. It is
+	// rotated when it reaches LOG_FILE_MAX_SIZE (MiB, at most 1 TiB),
+	// keeping LOG_FILE_MAX_BACKUPS old files.
+	cfg.Log.File = os.Getenv("LOG_FILE")
+	cfg.Log.MaxSize = int64(cfg.intRangeEnv("LOG_FILE_MAX_SIZE", 100, 1, 1<<20)) << 20
+	cfg.Log.MaxBackups = cfg.intEnv("LOG_FILE_MAX_BACKUPS", 5, 0)
+
+	// TRACE_EXPORTER (optional) otlp, stdout or file. Tracing is disabled
+	// if it is not set.
+	cfg.Trace.Exporter = os.Getenv("TRACE_EXPORTER")
+	switch cfg.Trace.Exporter {
+	case "", "otlp", "stdout", "file":
+	default:
+		cfg.errors = append(cfg.errors, fmt.Sprintf("TRACE_EXPORTER %s is not valid", cfg.Trace.Exporter))
+		cfg.errFatal = true
+	}
+
+	// TRACE_OTLP_ENDPOINT and TRACE_OTLP_HEADERS (optional) URL spans are
+	// posted to by the otlp exporter, and comma separated name=value
+	// headers sent with them.
+	cfg.Trace.OTLPEndpoint = os.Getenv("TRACE_OTLP_ENDPOINT")
+	if cfg.Trace.OTLPEndpoint == "" {
+		cfg.Trace.OTLPEndpoint = "http://localhost:4318/v1/traces"
+	}
+	cfg.Trace.OTLPHeaders = make(map[string]string)
+	for _, v := range strings.Split(os.Getenv("TRACE_OTLP_HEADERS"), ",") {
+		if strings.TrimSpace(v) == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(v, "=")
+		if !ok || strings.TrimSpace(name) == "" {
+			cfg.errors = append(cfg.errors, "TRACE_OTLP_HEADERS must be comma separated name=value pairs")
+			cfg.errFatal = true
+			break
+		}
+		cfg.Trace.OTLPHeaders[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+
+	// TRACE_FILE (required for the file exporter) file spans are appended
+	// to as JSON lines.
+	cfg.Trace.File = os.Getenv("TRACE_FILE")
+	if cfg.Trace.Exporter == "file" && cfg.Trace.File == "" {
+		cfg.errors = append(cfg.errors, "TRACE_FILE must be set when TRACE_EXPORTER is file")
+		cfg.errFatal = true
+	}
+
+	// TRACE_SAMPLE_RATIO (optional) fraction of new traces recorded.
+	cfg.Trace.SampleRatio = 1
+	if v := os.Getenv("TRACE_SAMPLE_RATIO"); v != "" {
+		ratio, err := strconv.ParseFloat(v, 64)
+		if err != nil || ratio < 0 || ratio > 1 {
+			cfg.errors = append(cfg.errors, fmt.Sprintf("TRACE_SAMPLE_RATIO %s is not valid", v))
+			cfg.errFatal = true
+		} else {
+			cfg.Trace.SampleRatio = ratio
+		}
+	}
+
+	// SHUTDOWN_TIMEOUT (optional) time allowed for the server to stop once
+	// signalled, including SHUTDOWN_DELAY (optional), the time readiness
+	// reports failure before the listeners close.
+	cfg.App.ShutdownTimeout = cfg.durationEnv("SHUTDOWN_TIMEOUT", 30*time.Second)
+	cfg.App.ShutdownDelay = cfg.durationEnv("SHUTDOWN_DELAY", 0)
+	if cfg.App.ShutdownDelay >= cfg.App.ShutdownTimeout {
+		cfg.errors = append(cfg.errors, "SHUTDOWN_DELAY must be less than SHUTDOWN_TIMEOUT")
+		cfg.errFatal = true
+	}
+
+	// TRUSTED_PROXIES (optional) comma separated IP addresses or CIDR
+	// ranges of reverse proxies whose X-Forwarded-For header is trusted to
+	// give the client IP address.
+	for _, v := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		p, err := parsePrefix(v)
+		if err != nil {
+			cfg.errors = append(cfg.errors, fmt.Sprintf("TRUSTED_PROXIES entry %q is not valid", v))
+			cfg.errFatal = true
+			continue
+		}
+		cfg.App.TrustedProxies = append(cfg.App.TrustedProxies, p)
+	}
+
+	// RATE_LIMITS (optional) semicolon separated rules of the form
+	// <route pattern>=<limit>/<period>[,burst=<n>][,key=ip|user|api_key].
+	// Set empty to disable rate limiting. RATE_LIMIT_STORE (optional) is
+	// memory, or sqlite to keep limits across restarts.
+	rules, found := os.LookupEnv("RATE_LIMITS")
+	if !found {
+		rules = defaultRateLimits
+	}
+	seen := make(map[string]bool)
+	for _, v := range strings.Split(rules, ";") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		rule, err := parseRateLimitRule(v)
+		if err != nil {
+			cfg.errors = append(cfg.errors, fmt.Sprintf("RATE_LIMITS rule %q is not valid: %v", v, err))
+			cfg.errFatal = true
+			continue
+		}
+		if seen[rule.Pattern] {
+			cfg.errors = append(cfg.errors, fmt.Sprintf("RATE_LIMITS has more than one rule for %q", rule.Pattern))
+			cfg.errFatal = true
+			continue
+		}
+		seen[rule.Pattern] = true
+		cfg.RateLimit.Rules = append(cfg.RateLimit.Rules, rule)
+	}
+	cfg.RateLimit.Store = os.Getenv("RATE_LIMIT_STORE")
+	if cfg.RateLimit.Store == "" {
+		cfg.RateLimit.Store = "memory"
+	}
+	if cfg.RateLimit.Store != "memory" && cfg.RateLimit.Store != "sqlite" {
+		cfg.errors = append(cfg.errors, fmt.Sprintf("RATE_LIMIT_STORE %s is not valid; must be memory or sqlite", cfg.RateLimit.Store))
+		cfg.errFatal = true
+	}
+
+	// TENANT_DB_DIR (optional) directory of per-tenant database files. When
+	// set each request is served from the database of its tenant, resolved
+	// from the subdomain of TENANT_DOMAIN or the TENANT_HEADER header, and
+	// DB_FILEPATH is only used by administration commands.
+	cfg.Tenant.DBDir = os.Getenv("TENANT_DB_DIR")
+	cfg.Tenant.Domain = strings.ToLower(strings.Trim(os.Getenv("TENANT_DOMAIN"), "."))
+	header, found := os.LookupEnv("TENANT_HEADER")
+	if !found {
+		header = "X-Tenant-ID"
+	}
+	cfg.Tenant.Header = header
+	cfg.Tenant.MaxOpen = cfg.intEnv("TENANT_MAX_OPEN", 100, 1)
+	// links sent by email and OpenID Connect callbacks are followed by
+	// browsers, which cannot send a header, so they use the subdomain
+	if cfg.Tenant.DBDir != "" && cfg.Tenant.Domain == "" {
+		cfg.errors = append(cfg.errors, "TENANT_DOMAIN must be set when TENANT_DB_DIR is set")
+		cfg.errFatal = true
+	}
+
 	// DBFilepath full path to the sqlite3 database file.
 	dbfilepath, found := os.LookupEnv("DB_FILEPATH")
-	if !found {
+	if !found && cfg.Tenant.DBDir == "" {
 		cfg.errors = append(cfg.errors, "DB_FILEPATH environment variable not set")
 		cfg.errFatal = true
 	}
 	cfg.DBFilepath = dbfilepath
 
+	// PASSWORD_MIN_LENGTH and PASSWORD_MAX_LENGTH (optional) default to
+	// the service password policy.
+	policy := service.DefaultPasswordPolicy()
+	cfg.Password.MinLength = cfg.intEnv("PASSWORD_MIN_LENGTH", policy.MinRunes, 1)
+	cfg.Password.MaxLength = cfg.intEnv("PASSWORD_MAX_LENGTH", policy.MaxRunes, cfg.Password.MinLength)
+
+	// ARGON2ID_MEMORY (KiB), ARGON2ID_ITERATIONS and ARGON2ID_PARALLELISM
+	// (optional) password hashing parameters. Memory is at most 4 GiB.
+	hash := service.DefaultHashParams()
+	cfg.Password.HashMemory = uint32(cfg.intRangeEnv("ARGON2ID_MEMORY", int(hash.Memory), 8*1024, 4*1024*1024))
+	cfg.Password.HashIterations = uint32(cfg.intRangeEnv("ARGON2ID_ITERATIONS", int(hash.Iterations), 1, 100))
+	cfg.Password.HashParallelism = uint8(cfg.intRangeEnv("ARGON2ID_PARALLELISM",
+		min(runtime.NumCPU(), math.MaxUint8), 1, math.MaxUint8))
+
+	// PWNED_PASSWORDS_PATH (optional) local copy of the Have I Been Pwned
+	// SHA-1 password hashes.
+	cfg.Password.PwnedPasswordsPath = os.Getenv("PWNED_PASSWORDS_PATH")
+
+	// SMTP_ADDR, SMTP_USERNAME, SMTP_PASSWORD and MAIL_FROM (optional)
+	// outgoing email.
+	cfg.Mail.SMTPAddr = os.Getenv("SMTP_ADDR")
+	cfg.Mail.SMTPUsername = os.Getenv("SMTP_USERNAME")
+	cfg.Mail.SMTPPassword = os.Getenv("SMTP_PASSWORD")
+	cfg.Mail.From = os.Getenv("MAIL_FROM")
+	if cfg.Mail.SMTPAddr == "" {
+		cfg.warnings = append(cfg.warnings, "SMTP_ADDR not set; email will be written to the log")
+	} else if cfg.Mail.From == "" {
+		cfg.errors = append(cfg.errors, "MAIL_FROM must be set when SMTP_ADDR is set")
+		cfg.errFatal = true
+	}
+
+	// AUDIT_HASH_KEY (optional) secret used to hash the email addresses of
+	// failed sign ins recorded in the audit log. At least 32 bytes.
+	cfg.Audit.HashKey = os.Getenv("AUDIT_HASH_KEY")
+	if cfg.Audit.HashKey == "" {
+		cfg.warnings = append(cfg.warnings, "AUDIT_HASH_KEY not set; failed sign ins will be audited without the email")
+	} else if len(cfg.Audit.HashKey) < 32 {
+		cfg.errors = append(cfg.errors, "AUDIT_HASH_KEY must be at least 32 bytes")
+		cfg.errFatal = true
+	}
+
+	// OIDC_PROVIDERS (optional) comma separated provider names. Each
+	// provider is configured using OIDC_<NAME>_* variables.
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		cfg.OIDC = append(cfg.OIDC, cfg.oidcProviderEnv(name))
+	}
+
 	return &cfg, nil
 }
 
+// oidcProviderEnv reads the OIDC_<NAME>_* variables for the named provider.
+func (c *Config) oidcProviderEnv(name string) OIDCProviderConfig {
+	prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+	p := OIDCProviderConfig{
+		Name:         strings.ToLower(name),
+		Issuer:       os.Getenv(prefix + "ISSUER"),
+		ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+		ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+		Scopes:       strings.Fields(os.Getenv(prefix + "SCOPES")),
+		AuthURL:      os.Getenv(prefix + "AUTH_URL"),
+		TokenURL:     os.Getenv(prefix + "TOKEN_URL"),
+		UserInfoURL:  os.Getenv(prefix + "USERINFO_URL"),
+		TrustEmail:   os.Getenv(prefix+"TRUST_EMAIL") == "true",
+	}
+
+	for _, r := range p.Name {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+			c.errors = append(c.errors, fmt.Sprintf("OIDC_PROVIDERS name %q is not valid", name))
+			c.errFatal = true
+			return p
+		}
+	}
+	if p.ClientID == "" {
+		c.errors = append(c.errors, fmt.Sprintf("%sCLIENT_ID not set", prefix))
+		c.errFatal = true
+	}
+	if p.Issuer == "" && (p.AuthURL == "" || p.TokenURL == "" || p.UserInfoURL == "") {
+		c.errors = append(c.errors, fmt.Sprintf(
+			"%sISSUER or all of %sAUTH_URL, %sTOKEN_URL and %sUSERINFO_URL must be set",
+			prefix, prefix, prefix, prefix))
+		c.errFatal = true
+	}
+	return p
+}
+
+// intEnv reads an optional integer environment variable returning def if
+// it is not set. Values that are not integers or are less than min are
+// recorded as fatal errors.
+func (c *Config) intEnv(name string, def, min int) int {
+	return c.intRangeEnv(name, def, min, math.MaxInt)
+}
+
+// intRangeEnv is like intEnv but values greater than max are also recorded
+// as fatal errors.
+func (c *Config) intRangeEnv(name string, def, min, max int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < min || n > max {
+		c.errors = append(c.errors, fmt.Sprintf("%s %s is not valid", name, v))
+		c.errFatal = true
+		return def
+	}
+	return n
+}
+
+// durationEnv reads an optional duration environment variable, such as
+// 30s, returning def if it is not set. Values that are not durations or
+// are negative are recorded as fatal errors.
+func (c *Config) durationEnv(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		c.errors = append(c.errors, fmt.Sprintf("%s %s is not valid", name, v))
+		c.errFatal = true
+		return def
+	}
+	return d
+}
+
+// parseRateLimitRule parses a RATE_LIMITS rule such as
+// "POST /v1/users=10/1h,burst=5,key=ip". The period may omit its count,
+// as in 10/m. Burst defaults to the limit and key to ip.
+func parseRateLimitRule(v string) (RateLimitRule, error) {
+	pattern, spec, ok := strings.Cut(v, "=")
+	if !ok {
+		return RateLimitRule{}, errors.New("missing =")
+	}
+	rule := RateLimitRule{Pattern: strings.TrimSpace(pattern), Key: "ip"}
+	if rule.Pattern == "" {
+		return RateLimitRule{}, errors.New("missing route pattern")
+	}
+
+	opts := strings.Split(spec, ",")
+	limit, period, ok := strings.Cut(strings.TrimSpace(opts[0]), "/")
+	if !ok {
+		return RateLimitRule{}, errors.New("limit must be of the form <limit>/<period>")
+	}
+	n, err := strconv.Atoi(limit)
+	if err != nil || n < 1 {
+		return RateLimitRule{}, errors.Errorf("limit %s must be a positive integer", limit)
+	}
+	rule.Limit, rule.Burst = n, n
+	if period != "" && (period[0] < '0' || period[0] > '9') {
+		period = "1" + period
+	}
+	rule.Period, err = time.ParseDuration(period)
+	if err != nil || rule.Period <= 0 {
+		return RateLimitRule{}, errors.Errorf("period %s must be a positive duration", period)
+	}
+
+	for _, opt := range opts[1:] {
+		name, value, _ := strings.Cut(strings.TrimSpace(opt), "=")
+		switch name {
+		case "burst":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return RateLimitRule{}, errors.Errorf("burst %s must be a positive integer", value)
+			}
+			rule.Burst = n
+		case "key":
+			if value != "ip" && value != "user" && value != "api_key" {
+				return RateLimitRule{}, errors.Errorf("key %s must be ip, user or api_key", value)
+			}
+			rule.Key = value
+		default:
+			return RateLimitRule{}, errors.Errorf("unknown option %q", name)
+		}
+	}
+	return rule, nil
+}
+
+// parsePrefix parses a CIDR range or a single IP address.
+func parsePrefix(v string) (netip.Prefix, error) {
+	if strings.Contains(v, "/") {
+		p, err := netip.ParsePrefix(v)
+		return p.Masked(), err
+	}
+	addr, err := netip.ParseAddr(v)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
 func cwd() (string, error) {
 	d, err := os.Getwd()
 	if err != nil {
@@ -108,3 +594,12 @@ func isValidLogLevel(v string) bool {
 		return false
 	}
 }
+
+func isValidLogFormat(v string) bool {
+	switch v {
+	case "text", "logfmt", "json":
+		return true
+	default:
+		return false
+	}
+}
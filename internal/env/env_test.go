@@ -0,0 +1,101 @@
+package env
+
+import "testing"
+
+func TestArgon2idEnv(t *testing.T) {
+	tests := []struct {
+		name            string
+		memory          string
+		iterations      string
+		parallelism     string
+		wantFatal       bool
+		wantMemory      uint32
+		wantIterations  uint32
+		wantParallelism uint8
+	}{
+		{name: "set", memory: "131072", iterations: "3", parallelism: "4", wantMemory: 131072, wantIterations: 3, wantParallelism: 4},
+		{name: "maximums", memory: "4194304", iterations: "100", parallelism: "255", wantMemory: 4194304, wantIterations: 100, wantParallelism: 255},
+		{name: "minimums", memory: "8192", iterations: "1", parallelism: "1", wantMemory: 8192, wantIterations: 1, wantParallelism: 1},
+		{name: "memory too small", memory: "4096", iterations: "1", parallelism: "1", wantFatal: true},
+		{name: "memory too large", memory: "4194305", iterations: "1", parallelism: "1", wantFatal: true},
+		{name: "memory overflows uint32", memory: "4294967296", iterations: "1", parallelism: "1", wantFatal: true},
+		{name: "iterations zero", memory: "65536", iterations: "0", parallelism: "1", wantFatal: true},
+		{name: "iterations too large", memory: "65536", iterations: "101", parallelism: "1", wantFatal: true},
+		{name: "parallelism zero", memory: "65536", iterations: "1", parallelism: "0", wantFatal: true},
+		{name: "parallelism overflows uint8", memory: "65536", iterations: "1", parallelism: "256", wantFatal: true},
+		{name: "not a number", memory: "64MiB", iterations: "1", parallelism: "1", wantFatal: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DB_FILEPATH", "test.db")
+			t.Setenv("ARGON2ID_MEMORY", tt.memory)
+			t.Setenv("ARGON2ID_ITERATIONS", tt.iterations)
+			t.Setenv("ARGON2ID_PARALLELISM", tt.parallelism)
+
+			cfg, err := EnvToConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.IsFatalErr() != tt.wantFatal {
+				t.Fatalf("IsFatalErr() = %t, want %t; errors %v", cfg.IsFatalErr(), tt.wantFatal, cfg.Errors())
+			}
+			if tt.wantFatal {
+				return
+			}
+			p := cfg.Password
+			if p.HashMemory != tt.wantMemory || p.HashIterations != tt.wantIterations || p.HashParallelism != tt.wantParallelism {
+				t.Errorf("hash params = m=%d,t=%d,p=%d, want m=%d,t=%d,p=%d",
+					p.HashMemory, p.HashIterations, p.HashParallelism,
+					tt.wantMemory, tt.wantIterations, tt.wantParallelism)
+			}
+		})
+	}
+}
+
+func TestArgon2idEnvDefaults(t *testing.T) {
+	t.Setenv("DB_FILEPATH", "test.db")
+	t.Setenv("ARGON2ID_MEMORY", "")
+	t.Setenv("ARGON2ID_ITERATIONS", "")
+	t.Setenv("ARGON2ID_PARALLELISM", "")
+
+	cfg, err := EnvToConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.IsFatalErr() {
+		t.Fatalf("errors %v", cfg.Errors())
+	}
+	if p := cfg.Password; p.HashMemory != 64*1024 || p.HashIterations != 1 || p.HashParallelism < 1 {
+		t.Errorf("default hash params = m=%d,t=%d,p=%d, want m=65536,t=1,p>=1", p.HashMemory, p.HashIterations, p.HashParallelism)
+	}
+}
+
+func TestTenantDomainRequired(t *testing.T) {
+	tests := []struct {
+		name      string
+		dbDir     string
+		domain    string
+		wantFatal bool
+	}{
+		{name: "single database", dbDir: "", domain: ""},
+		{name: "tenant mode with domain", dbDir: "/var/lib/monolith/tenants", domain: "example.com"},
+		{name: "tenant mode without domain", dbDir: "/var/lib/monolith/tenants", domain: "", wantFatal: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DB_FILEPATH", "test.db")
+			t.Setenv("TENANT_DB_DIR", tt.dbDir)
+			t.Setenv("TENANT_DOMAIN", tt.domain)
+
+			cfg, err := EnvToConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.IsFatalErr() != tt.wantFatal {
+				t.Errorf("IsFatalErr() = %t, want %t; errors %v", cfg.IsFatalErr(), tt.wantFatal, cfg.Errors())
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/andyfusniak/monolith/service"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	errCodeAdminRequired     = "admin/admin-required"
+	errCodeAdminUserNotFound = "admin/user-not-found"
+	errCodeImpersonateSelf   = "admin/impersonate-self"
+	errCodeImpersonateAdmin  = "admin/impersonate-admin"
+	errCodeNotImpersonating  = "admin/not-impersonating"
+)
+
+type impersonationResponse struct {
+	Session service.Session `json:"session"`
+	User    service.User    `json:"user"`
+}
+
+// Impersonate starts an impersonation session for an admin, replacing the
+// admin's session cookie. While it lasts the admin sees the API as the
+// user does but cannot make changes.
+func (h *Handler) Impersonate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		cl := log.WithContext(ctx)
+
+		userID := r.PathValue("user_id")
+		if !isValidUserID(userID) {
+			clientError(w, http.StatusBadRequest, errCodeBadRequest,
+				"user_id path parameter must be a valid user id") // 400
+			return
+		}
+
+		session, err := h.svc.Impersonate(ctx, userID)
+		if err != nil {
+			switch {
+			case errors.Is(err, service.ErrNotAdmin):
+				cl.Warnf("[app] impersonate user_id=%s refused for non-admin", userID)
+				clientError(w, http.StatusForbidden, errCodeAdminRequired,
+					"the admin role is required") // 403
+			case errors.Is(err, service.ErrUserNotFound):
+				clientError(w, http.StatusNotFound, errCodeAdminUserNotFound,
+					"user not found") // 404
+			case errors.Is(err, service.ErrImpersonateSelf):
+				clientError(w, http.StatusUnprocessableEntity, errCodeImpersonateSelf,
+					"you cannot impersonate yourself") // 422
+			case errors.Is(err, service.ErrImpersonateAdmin):
+				clientError(w, http.StatusForbidden, errCodeImpersonateAdmin,
+					"admins cannot be impersonated") // 403
+			default:
+				cl.Errorf("[app] svc.Impersonate(ctx, userID=%q) unexpected error: %+v", userID, err)
+				w.WriteHeader(http.StatusInternalServerError) // 500
+			}
+			return
+		}
+
+		user, err := h.svc.GetUser(ctx, userID)
+		if err != nil {
+			cl.Errorf("[app] svc.GetUser(ctx, userID=%q) unexpected error: %+v", userID, err)
+			w.WriteHeader(http.StatusInternalServerError) // 500
+			return
+		}
+
+		// successful response
+		setSessionCookie(w, &session)
+		w.Header().Set("Cache-Control", "no-store")
+		response := containerResponse{Data: impersonationResponse{Session: session, User: user}}
+		h.respond(ctx, w, r, response, http.StatusCreated) // 201
+	}
+}
+
+// StopImpersonation ends the impersonation session in the session cookie.
+// It is not wrapped in RequireAuth as impersonation sessions are
+// read-only.
+func (h *Handler) StopImpersonation() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		cl := log.WithContext(ctx)
+
+		session, err := h.sessionFromCookie(r)
+		if err != nil {
+			if errors.Is(err, service.ErrSessionNotFound) {
+				clientError(w, http.StatusUnauthorized, errCodeUnauthenticated,
+					"authentication required") // 401
+				return
+			}
+			cl.Errorf("[app] svc.AuthenticateSession(ctx, token=*****) unexpected error: %+v", err)
+			w.WriteHeader(http.StatusInternalServerError) // 500
+			return
+		}
+
+		if err := h.svc.StopImpersonation(ctx, session); err != nil {
+			if errors.Is(err, service.ErrNotImpersonating) {
+				clientError(w, http.StatusConflict, errCodeNotImpersonating,
+					"the session is not an impersonation session") // 409
+				return
+			}
+			cl.Errorf("[app] svc.StopImpersonation(ctx, sessionID=%q) unexpected error: %+v", session.ID, err)
+			w.WriteHeader(http.StatusInternalServerError) // 500
+			return
+		}
+
+		clearSessionCookie(w)
+		w.WriteHeader(http.StatusNoContent) // 204
+	}
+}
+
+// ListAuditEvents returns audit log events, newest first. Events may be
+// filtered by the action, actor_id, subject_id, since and until (RFC 3339)
+// query parameters. Pages are requested with limit and before, the
+// event_id of the last event of the previous page.
+func (h *Handler) ListAuditEvents() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		cl := log.WithContext(ctx)
+
+		filter, err := auditFilterFromQuery(r)
+		if err != nil {
+			clientError(w, http.StatusBadRequest, errCodeBadRequest, err.Error()) // 400
+			return
+		}
+
+		events, err := h.svc.ListAuditEvents(ctx, filter)
+		if err != nil {
+			switch {
+			case errors.Is(err, service.ErrNotAdmin):
+				cl.Warn("[app] list audit events refused for non-admin")
+				clientError(w, http.StatusForbidden, errCodeAdminRequired,
+					"the admin role is required") // 403
+			case errors.Is(err, service.ErrAuditFilterInvalid):
+				clientError(w, http.StatusBadRequest, errCodeBadRequest,
+					"limit must be between 1 and 500 and before must not be negative") // 400
+			default:
+				cl.Errorf("[app] svc.ListAuditEvents(ctx, filter) unexpected error: %+v", err)
+				w.WriteHeader(http.StatusInternalServerError) // 500
+			}
+			return
+		}
+
+		// successful response
+		h.respond(ctx, w, r, containerResponse{Data: events}, http.StatusOK) // 200
+	}
+}
+
+func auditFilterFromQuery(r *http.Request) (service.AuditFilter, error) {
+	q := r.URL.Query()
+	filter := service.AuditFilter{
+		Action:    q.Get("action"),
+		ActorID:   q.Get("actor_id"),
+		SubjectID: q.Get("subject_id"),
+	}
+
+	for name, dst := range map[string]**time.Time{"since": &filter.Since, "until": &filter.Until} {
+		v := q.Get(name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return service.AuditFilter{}, errors.Errorf("%s query parameter must be an RFC 3339 timestamp", name)
+		}
+		*dst = &t
+	}
+
+	if v := q.Get("before"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return service.AuditFilter{}, errors.New("before query parameter must be an event_id")
+		}
+		filter.BeforeID = n
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return service.AuditFilter{}, errors.New("limit query parameter must be an integer")
+		}
+		filter.Limit = n
+	}
+	return filter, nil
+}
@@ -0,0 +1,171 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/andyfusniak/monolith/service"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	errCodeAPIKeyNotFound      = "api-keys/not-found"
+	errCodeAPIKeyNameInvalid   = "api-keys/name-invalid"
+	errCodeAPIKeyScopeInvalid  = "api-keys/scope-invalid"
+	errCodeAPIKeyExpiryInvalid = "api-keys/expiry-invalid"
+	errCodeAPIKeyLimitReached  = "api-keys/limit-reached"
+)
+
+type createAPIKeyRequest struct {
+	Name      *string    `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// authorizeAPIKeyAdmin checks the principal may manage the API keys of
+// the user in the user_id path parameter. API keys cannot be used to
+// manage API keys. On failure the error response is written and false
+// returned.
+func authorizeAPIKeyAdmin(w http.ResponseWriter, r *http.Request, userID string) bool {
+	cl := log.WithContext(r.Context())
+
+	if !isValidUserID(userID) {
+		cl.Warnf("[app] path parameter /users/%s invalid", userID)
+		clientError(w, http.StatusUnprocessableEntity, errCodeUserIDInvalid,
+			"user_id url path parameter is not a valid user id") // 422
+		return false
+	}
+	if !isSelf(r, userID) {
+		cl.Warnf("[app] api keys: forbidden for user_id=%s", userID)
+		clientError(w, http.StatusForbidden, errCodeForbidden,
+			"you may only manage your own api keys") // 403
+		return false
+	}
+	if p, _ := service.PrincipalFromContext(r.Context()); p.APIKeyID != "" {
+		cl.Warnf("[app] api keys: api_key_id=%s attempted to manage api keys", p.APIKeyID)
+		clientError(w, http.StatusForbidden, errCodeForbidden,
+			"api keys must be managed using a signed in session") // 403
+		return false
+	}
+	return true
+}
+
+// CreateAPIKey mints a personal API key for the authenticated user. The key
+// is only included in this response.
+func (h *Handler) CreateAPIKey() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		cl := log.WithContext(ctx)
+
+		userID := r.PathValue("user_id")
+		if !authorizeAPIKeyAdmin(w, r, userID) {
+			return
+		}
+
+		// request body
+		req := createAPIKeyRequest{}
+		if err := h.decode(w, r, &req); err != nil {
+			cl.Warn("[app] createAPIKeyRequest body decode failed", err)
+			clientError(w, http.StatusBadRequest, errCodeBadRequest, err.Error()) // 400
+			return
+		}
+		message, ok := validateCreateAPIKeyRequest(&req)
+		if !ok {
+			cl.Warnf("[app] CreateAPIKey: validation failed %q", message)
+			clientError(w, http.StatusBadRequest, errCodeBadRequest, message) // 400
+			return
+		}
+
+		apiKey, err := h.svc.CreateAPIKey(ctx, userID, *req.Name, req.Scopes, req.ExpiresAt)
+		if err != nil {
+			switch {
+			case errors.Is(err, service.ErrAPIKeyNameInvalid):
+				clientError(w, http.StatusUnprocessableEntity, errCodeAPIKeyNameInvalid,
+					"name must be between 1 and 100 characters") // 422
+			case errors.Is(err, service.ErrAPIKeyScopeInvalid):
+				clientError(w, http.StatusUnprocessableEntity, errCodeAPIKeyScopeInvalid,
+					`scopes must contain only "read" and "write"`) // 422
+			case errors.Is(err, service.ErrAPIKeyExpiryInvalid):
+				clientError(w, http.StatusUnprocessableEntity, errCodeAPIKeyExpiryInvalid,
+					"expires_at must be in the future") // 422
+			case errors.Is(err, service.ErrAPIKeyLimitReached):
+				clientError(w, http.StatusConflict, errCodeAPIKeyLimitReached,
+					"api key limit reached; delete an unused key first") // 409
+			default:
+				cl.Errorf("[app] svc.CreateAPIKey(ctx, userID=%q) unexpected error: %+v", userID, err)
+				w.WriteHeader(http.StatusInternalServerError) // 500
+			}
+			return
+		}
+
+		// successful response
+		response := containerResponse{Data: apiKey}
+		h.respond(ctx, w, r, response, http.StatusCreated) // 201
+		cl.Infof("[app] created api_key_id=%s for user_id=%s", apiKey.ID, userID)
+	}
+}
+
+func validateCreateAPIKeyRequest(req *createAPIKeyRequest) (string, bool) {
+	if req.Name == nil {
+		return "name attribute not set", false
+	}
+	if req.Scopes == nil {
+		return "scopes attribute not set", false
+	}
+	return "", true
+}
+
+// ListAPIKeys returns the authenticated user's API keys. Keys themselves
+// are never returned, only their prefix.
+func (h *Handler) ListAPIKeys() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		cl := log.WithContext(ctx)
+
+		userID := r.PathValue("user_id")
+		if !authorizeAPIKeyAdmin(w, r, userID) {
+			return
+		}
+
+		apiKeys, err := h.svc.ListAPIKeys(ctx, userID)
+		if err != nil {
+			cl.Errorf("[app] svc.ListAPIKeys(ctx, userID=%q) unexpected error: %+v", userID, err)
+			w.WriteHeader(http.StatusInternalServerError) // 500
+			return
+		}
+
+		// successful response
+		response := containerResponse{Data: apiKeys}
+		h.respond(ctx, w, r, response, http.StatusOK) // 200
+	}
+}
+
+// DeleteAPIKey revokes one of the authenticated user's API keys.
+func (h *Handler) DeleteAPIKey() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		cl := log.WithContext(ctx)
+
+		userID := r.PathValue("user_id")
+		if !authorizeAPIKeyAdmin(w, r, userID) {
+			return
+		}
+
+		apiKeyID := r.PathValue("api_key_id")
+		if err := h.svc.DeleteAPIKey(ctx, userID, apiKeyID); err != nil {
+			if errors.Is(err, service.ErrAPIKeyNotFound) {
+				clientError(w, http.StatusNotFound, errCodeAPIKeyNotFound,
+					"api key not found") // 404
+				return
+			}
+			cl.Errorf("[app] svc.DeleteAPIKey(ctx, userID=%q, apiKeyID=%q) unexpected error: %+v",
+				userID, apiKeyID, err)
+			w.WriteHeader(http.StatusInternalServerError) // 500
+			return
+		}
+
+		cl.Infof("[app] deleted api_key_id=%s for user_id=%s", apiKeyID, userID)
+		w.WriteHeader(http.StatusNoContent) // 204
+	}
+}
@@ -0,0 +1,202 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/andyfusniak/monolith/service"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	sessionCookieName = "session"
+
+	errCodeMFATokenInvalid = "auth/mfa-token-invalid"
+	errCodeMFACodeInvalid  = "auth/mfa-code-invalid"
+
+	errCodeRefreshTokenInvalid = "auth/refresh-token-invalid"
+)
+
+func setSessionCookie(w http.ResponseWriter, session *service.Session) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    session.Token,
+		Path:     "/",
+		Expires:  time.Time(session.ExpiresAt),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// isSelf returns true if the authenticated principal is the user with the
+// given userID.
+func isSelf(r *http.Request, userID string) bool {
+	p, ok := service.PrincipalFromContext(r.Context())
+	return ok && p.UserID == userID
+}
+
+// signInGrant returns the credentials requested by the optional tokens
+// attribute of a sign in request.
+func signInGrant(tokens *bool) service.SignInGrant {
+	if tokens != nil && *tokens {
+		return service.GrantTokens
+	}
+	return service.GrantSession
+}
+
+type signInTokensResponse struct {
+	User service.User `json:"user"`
+	service.TokenPair
+}
+
+// respondSignedIn completes a sign in by setting the session cookie or,
+// if tokens were requested, returning them in the response body.
+func (h *Handler) respondSignedIn(ctx context.Context, w http.ResponseWriter, r *http.Request, result service.SignInResult) {
+	if result.Tokens != nil {
+		w.Header().Set("Cache-Control", "no-store")
+		response := containerResponse{Data: signInTokensResponse{
+			User:      result.User,
+			TokenPair: *result.Tokens,
+		}}
+		h.respond(ctx, w, r, response, http.StatusCreated) // 201
+		return
+	}
+
+	setSessionCookie(w, result.Session)
+	response := containerResponse{Data: result.User}
+	h.respond(ctx, w, r, response, http.StatusCreated) // 201
+}
+
+type signInMFARequest struct {
+	MFAToken     *string `json:"mfa_token"`
+	Code         *string `json:"code"`
+	RecoveryCode *string `json:"recovery_code"`
+	Tokens       *bool   `json:"tokens"`
+}
+
+// SignInMFA completes a two-step sign in using a TOTP code or a recovery
+// code.
+func (h *Handler) SignInMFA() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		cl := log.WithContext(ctx)
+
+		// request body
+		req := signInMFARequest{}
+		if err := h.decode(w, r, &req); err != nil {
+			cl.Warn("[app] signInMFARequest body decode failed", err)
+			clientError(w, http.StatusBadRequest, errCodeBadRequest, err.Error()) // 400
+			return
+		}
+		message, ok := validateSignInMFARequest(&req)
+		if !ok {
+			cl.Warnf("[app] SignInMFA: validation failed %q", message)
+			clientError(w, http.StatusBadRequest, errCodeBadRequest, message) // 400
+			return
+		}
+
+		var code, recoveryCode string
+		if req.Code != nil {
+			code = *req.Code
+		}
+		if req.RecoveryCode != nil {
+			recoveryCode = *req.RecoveryCode
+		}
+
+		result, err := h.svc.VerifyMFAChallenge(ctx, *req.MFAToken, code, recoveryCode, signInGrant(req.Tokens))
+		if err != nil {
+			if errors.Is(err, service.ErrMFAChallengeInvalid) {
+				cl.Infof("[app] mfa challenge invalid or expired")
+				clientError(w, http.StatusUnauthorized, errCodeMFATokenInvalid,
+					"mfa_token is invalid or has expired; sign in again") // 401
+				return
+			}
+			if errors.Is(err, service.ErrMFACodeInvalid) {
+				cl.Infof("[app] mfa code invalid")
+				clientError(w, http.StatusUnauthorized, errCodeMFACodeInvalid,
+					"code is not valid") // 401
+				return
+			}
+
+			cl.Errorf("[app] svc.VerifyMFAChallenge(ctx, token=*****) unexpected error: %+v", err)
+			w.WriteHeader(http.StatusInternalServerError) // 500
+			return
+		}
+
+		// successful response
+		cl.Infof("[app] successful mfa signin for user user_id=%s email=%s",
+			result.User.ID, result.User.Email)
+		h.respondSignedIn(ctx, w, r, result)
+	}
+}
+
+func validateSignInMFARequest(req *signInMFARequest) (string, bool) {
+	if req.MFAToken == nil || *req.MFAToken == "" {
+		return "mfa_token attribute not set", false
+	}
+	if (req.Code == nil) == (req.RecoveryCode == nil) {
+		return "exactly one of code or recovery_code must be set", false
+	}
+	return "", true
+}
+
+type refreshTokenRequest struct {
+	RefreshToken *string `json:"refresh_token"`
+}
+
+// RefreshToken exchanges a refresh token for a new access token and
+// refresh token. The old refresh token can no longer be used.
+func (h *Handler) RefreshToken() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		cl := log.WithContext(ctx)
+
+		// request body
+		req := refreshTokenRequest{}
+		if err := h.decode(w, r, &req); err != nil {
+			cl.Warn("[app] refreshTokenRequest body decode failed", err)
+			clientError(w, http.StatusBadRequest, errCodeBadRequest, err.Error()) // 400
+			return
+		}
+		if req.RefreshToken == nil || *req.RefreshToken == "" {
+			cl.Warn("[app] RefreshToken: validation failed refresh_token attribute not set")
+			clientError(w, http.StatusBadRequest, errCodeBadRequest,
+				"refresh_token attribute not set") // 400
+			return
+		}
+
+		tokens, err := h.svc.RefreshTokens(ctx, *req.RefreshToken)
+		if err != nil {
+			if errors.Is(err, service.ErrRefreshTokenInvalid) || errors.Is(err, service.ErrRefreshTokenReused) {
+				cl.Infof("[app] refresh token rejected: %v", err)
+				clientError(w, http.StatusUnauthorized, errCodeRefreshTokenInvalid,
+					"refresh_token is invalid or has expired; sign in again") // 401
+				return
+			}
+
+			cl.Errorf("[app] svc.RefreshTokens(ctx, token=*****) unexpected error: %+v", err)
+			w.WriteHeader(http.StatusInternalServerError) // 500
+			return
+		}
+
+		// successful response
+		w.Header().Set("Cache-Control", "no-store")
+		response := containerResponse{Data: tokens}
+		h.respond(ctx, w, r, response, http.StatusOK) // 200
+	}
+}
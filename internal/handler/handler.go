@@ -3,15 +3,42 @@ package handler
 import (
 	"context"
 	"encoding/json"
+	"net"
 	"net/http"
 
 	"github.com/andyfusniak/monolith/service"
 	log "github.com/sirupsen/logrus"
 )
 
+const (
+	requestIDHeader = "X-Request-ID"
+	maxRequestIDLen = 64
+)
+
 const (
 	// General
-	errCodeBadRequest = "errors/bad-request"
+	errCodeBadRequest  = "errors/bad-request"
+	errCodeInternal    = "errors/internal"
+	errCodeMaintenance = "errors/maintenance"
+	errCodeRateLimited = "errors/rate-limited"
+
+	// Auth
+	errCodeAccountLocked     = "auth/account-locked"
+	errCodeTooManyAttempts   = "auth/too-many-attempts"
+	errCodeUnauthenticated   = "auth/unauthenticated"
+	errCodeForbidden         = "auth/forbidden"
+	errCodeInsufficientScope = "auth/insufficient-scope"
+
+	errCodeImpersonationReadOnly = "auth/impersonation-read-only"
+
+	// Tenants
+	errCodeTenantRequired = "tenants/required"
+	errCodeTenantNotFound = "tenants/not-found"
+
+	// Idempotency
+	errCodeIdempotencyKeyInvalid    = "idempotency/invalid-key"
+	errCodeIdempotencyKeyMismatch   = "idempotency/key-mismatch"
+	errCodeIdempotencyKeyInProgress = "idempotency/in-progress"
 )
 
 type Handler struct {
@@ -65,3 +92,61 @@ func clientError(w http.ResponseWriter, statusCode int, code string, message str
 		message,
 	})
 }
+
+// 5xx (Server Error): The server failed to fulfill an apparently valid
+// request
+func serverError(w http.ResponseWriter, message string) {
+	w.WriteHeader(http.StatusInternalServerError)
+	_ = json.NewEncoder(w).Encode(apiErrorResponse{
+		http.StatusInternalServerError,
+		errCodeInternal,
+		message,
+	})
+}
+
+// statusWriter records the status code and number of body bytes written
+// to a ResponseWriter.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// clientIP returns the IP address of the client making the request, as
+// found by the RealIP middleware.
+func clientIP(r *http.Request) string {
+	if ip := service.ClientIPFromContext(r.Context()); ip != "" {
+		return ip
+	}
+	return remoteIP(r)
+}
+
+// remoteIP returns the IP address of the peer connected to the server.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
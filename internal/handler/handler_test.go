@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io/fs"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/alexedwards/argon2id"
+	"github.com/andyfusniak/monolith/internal/store"
+	"github.com/andyfusniak/monolith/internal/store/sqlite3"
+	"github.com/andyfusniak/monolith/internal/store/sqlite3/schema"
+	"github.com/andyfusniak/monolith/service"
+)
+
+// newTestHandler returns a Handler for a service using a new migrated
+// SQLite database in a temporary directory.
+func newTestHandler(t *testing.T, opts ...service.Option) (*Handler, *service.Service) {
+	t.Helper()
+
+	db := openTestDB(t, filepath.Join(t.TempDir(), "test.db"))
+	return newTestHandlerWithRepo(t, sqlite3.NewStore(db, db), opts...)
+}
+
+// newTestHandlerWithRepo returns a Handler for a service using repo.
+func newTestHandlerWithRepo(t *testing.T, repo store.Repository, opts ...service.Option) (*Handler, *service.Service) {
+	t.Helper()
+
+	opts = append([]service.Option{
+		service.WithRepository(repo),
+		service.WithHashParams(argon2id.Params{
+			Memory: 8 * 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32,
+		}),
+	}, opts...)
+	svc := service.New(opts...)
+	return New(svc), svc
+}
+
+// openTestDB opens the SQLite database at path, applying all migrations.
+// It is closed when the test completes.
+func openTestDB(t *testing.T, path string) *sql.DB {
+	t.Helper()
+
+	db, err := sqlite3.OpenDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	db.SetMaxOpenConns(1)
+
+	migrations, err := fs.Glob(schema.Migrations, "migrations/*.up.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(migrations)
+	for _, name := range migrations {
+		b, err := fs.ReadFile(schema.Migrations, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(string(b)); err != nil {
+			t.Fatalf("migration %s: %v", name, err)
+		}
+	}
+	return db
+}
+
+// newTestUser creates a user with email.
+func newTestUser(t *testing.T, svc *service.Service, email string) service.User {
+	t.Helper()
+	u, err := svc.CreateUser(context.Background(), email, "correct horse battery staple")
+	if err != nil {
+		t.Fatalf("CreateUser(%q): %v", email, err)
+	}
+	return u
+}
+
+// decodeError decodes the apiErrorResponse body of a recorded response.
+func decodeError(t *testing.T, rec *httptest.ResponseRecorder) apiErrorResponse {
+	t.Helper()
+	var resp apiErrorResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode error response %q: %v", rec.Body.String(), err)
+	}
+	return resp
+}
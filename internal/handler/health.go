@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/andyfusniak/monolith/service"
+	log "github.com/sirupsen/logrus"
+)
+
+// readinessTimeout bounds the readiness checks so a blocked database
+// fails the probe rather than hanging it.
+const readinessTimeout = 2 * time.Second
+
+type healthResponse struct {
+	Status string `json:"status"`
+}
+
+// Healthz reports that the process is alive and serving HTTP. It checks
+// nothing else, so a liveness probe does not restart the process because
+// a dependency is down.
+func (h *Handler) Healthz() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		h.respond(r.Context(), w, r, healthResponse{Status: "ok"}, http.StatusOK) // 200
+	}
+}
+
+// Readyz reports whether the service can serve requests, with the result
+// of each check. It responds 503 if any check fails so load balancers
+// stop routing to it.
+func (h *Handler) Readyz() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+		defer cancel()
+
+		readiness := h.svc.Readiness(ctx)
+		status := http.StatusOK // 200
+		if !readiness.Ready() {
+			fields := log.Fields{}
+			for name, c := range readiness.Checks {
+				if c.Status == service.HealthFail {
+					fields[name] = c.Error
+				}
+			}
+			log.WithContext(ctx).WithFields(fields).Warn("[app] not ready")
+			status = http.StatusServiceUnavailable // 503
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		h.respond(ctx, w, r, readiness, status)
+	}
+}
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/andyfusniak/monolith/internal/store/sqlite3"
+	"github.com/andyfusniak/monolith/internal/store/sqlite3/schema"
+	"github.com/andyfusniak/monolith/service"
+)
+
+// setSchemaVersion records version in the schema_migrations table the
+// way the migrate command does.
+func setSchemaVersion(t *testing.T, db *sql.DB, version uint, dirty bool) {
+	t.Helper()
+	if _, err := db.Exec(`create table if not exists schema_migrations (version uint64, dirty bool)`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`delete from schema_migrations`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`insert into schema_migrations (version, dirty) values (?, ?)`, version, dirty); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	latest, err := schema.LatestVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		version    uint
+		dirty      bool
+		draining   bool
+		wantStatus int
+		wantFailed string
+	}{
+		{name: "ready", version: latest, wantStatus: http.StatusOK},
+		{name: "draining", version: latest, draining: true, wantStatus: http.StatusServiceUnavailable, wantFailed: "draining"},
+		{name: "schema behind", version: latest - 1, wantStatus: http.StatusServiceUnavailable, wantFailed: "migrations"},
+		{name: "schema dirty", version: latest, dirty: true, wantStatus: http.StatusServiceUnavailable, wantFailed: "migrations"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := openTestDB(t, filepath.Join(t.TempDir(), "test.db"))
+			setSchemaVersion(t, db, tt.version, tt.dirty)
+			h, svc := newTestHandlerWithRepo(t, sqlite3.NewStore(db, db), service.WithSchemaVersion(latest))
+			svc.SetDraining(tt.draining)
+
+			rec := httptest.NewRecorder()
+			h.Readyz().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d; body %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if cc := rec.Header().Get("Cache-Control"); cc != "no-store" {
+				t.Errorf("Cache-Control = %q, want no-store", cc)
+			}
+			var body service.Readiness
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantFailed == "" {
+				if body.Status != service.HealthOK {
+					t.Errorf("body status = %s, want %s", body.Status, service.HealthOK)
+				}
+				return
+			}
+			if body.Status != service.HealthFail || body.Checks[tt.wantFailed].Status != service.HealthFail {
+				t.Errorf("body = %+v, want check %s to fail", body, tt.wantFailed)
+			}
+		})
+	}
+}
+
+func TestReadyzNotMigrated(t *testing.T) {
+	// no schema_migrations table, as if migrate up had never run
+	h, _ := newTestHandler(t, service.WithSchemaVersion(1))
+
+	rec := httptest.NewRecorder()
+	h.Readyz().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+}
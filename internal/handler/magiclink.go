@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/andyfusniak/monolith/service"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	errCodeMagicLinkInvalid = "auth/magic-link-invalid"
+)
+
+type magicLinkRequest struct {
+	Email *string `json:"email"`
+}
+
+// RequestMagicLink emails a single-use sign in link. The response is the
+// same whether or not an account exists for the email.
+func (h *Handler) RequestMagicLink() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		cl := log.WithContext(ctx)
+
+		// request body
+		req := magicLinkRequest{}
+		if err := h.decode(w, r, &req); err != nil {
+			cl.Warn("[app] magicLinkRequest body decode failed", err)
+			clientError(w, http.StatusBadRequest, errCodeBadRequest, err.Error()) // 400
+			return
+		}
+		if req.Email == nil || *req.Email == "" {
+			cl.Warnf("[app] RequestMagicLink: validation failed %q", "email attribute not set")
+			clientError(w, http.StatusBadRequest, errCodeBadRequest, "email attribute not set") // 400
+			return
+		}
+
+		if err := h.svc.RequestMagicLink(ctx, *req.Email, clientIP(r)); err != nil {
+			var throttled *service.SignInThrottledError
+			if errors.As(err, &throttled) {
+				retryAfter := int(math.Ceil(throttled.RetryAfter.Seconds()))
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+				cl.Warnf("[app] magic link refused for email=%s ip=%s", *req.Email, clientIP(r))
+				clientError(w, http.StatusTooManyRequests, errCodeTooManyAttempts,
+					"too many sign in links requested; try again later") // 429
+				return
+			}
+
+			cl.Errorf("[app] svc.RequestMagicLink(ctx, email=%s) unexpected error: %+v", *req.Email, err)
+			w.WriteHeader(http.StatusInternalServerError) // 500
+			return
+		}
+
+		// successful response
+		w.WriteHeader(http.StatusAccepted) // 202
+	}
+}
+
+// RedeemMagicLink signs in using the token from a magic link.
+func (h *Handler) RedeemMagicLink() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		cl := log.WithContext(ctx)
+
+		result, err := h.svc.RedeemMagicLink(ctx, r.PathValue("token"))
+		if err != nil {
+			if errors.Is(err, service.ErrMagicLinkInvalid) {
+				cl.Infof("[app] magic link invalid, used or expired")
+				clientError(w, http.StatusUnauthorized, errCodeMagicLinkInvalid,
+					"link is invalid or has expired; request a new one") // 401
+				return
+			}
+
+			cl.Errorf("[app] svc.RedeemMagicLink(ctx, token=*****) unexpected error: %+v", err)
+			w.WriteHeader(http.StatusInternalServerError) // 500
+			return
+		}
+
+		// second factor required
+		if result.Challenge != nil {
+			response := containerResponse{Data: result.Challenge}
+			cl.Infof("[app] magic link signin for user user_id=%s requires mfa",
+				result.User.ID)
+			h.respond(ctx, w, r, response, http.StatusOK) // 200
+			return
+		}
+
+		// successful response
+		setSessionCookie(w, result.Session)
+		response := containerResponse{Data: result.User}
+		cl.Infof("[app] successful magic link signin for user user_id=%s email=%s",
+			result.User.ID, result.User.Email)
+		h.respond(ctx, w, r, response, http.StatusCreated) // 201
+	}
+}
@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andyfusniak/monolith/internal/mail"
+	"github.com/andyfusniak/monolith/internal/store/sqlite3"
+	"github.com/andyfusniak/monolith/service"
+)
+
+// chanMailer delivers sent messages to a channel.
+type chanMailer chan mail.Message
+
+func (m chanMailer) Send(ctx context.Context, msg mail.Message) error {
+	m <- msg
+	return nil
+}
+
+func TestRedeemMagicLinkTenantMode(t *testing.T) {
+	dir := t.TempDir()
+	openTestDB(t, filepath.Join(dir, "acme.db")).Close()
+	pools := sqlite3.NewTenantPools(dir, 10)
+	t.Cleanup(pools.Close)
+
+	mailer := make(chanMailer, 1)
+	h, svc := newTestHandlerWithRepo(t, sqlite3.NewTenantStore(pools),
+		service.WithMailer(mailer),
+		service.WithBaseURL("https://example.com:8443"),
+		service.WithTenantDomain("example.com"),
+	)
+
+	tctx, release, err := svc.EnterTenant(context.Background(), "acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = svc.CreateUser(tctx, "alice@example.com", "correct horse battery staple")
+	release()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/auth/magic-link", h.RequestMagicLink())
+	mux.HandleFunc("GET /v1/auth/magic-link/{token}", h.RedeemMagicLink())
+	handler := h.Tenant("example.com", "X-Tenant-ID")(mux)
+
+	// requested using the header, followed by a browser using the link
+	req := httptest.NewRequest(http.MethodPost, "https://example.com:8443/v1/auth/magic-link",
+		strings.NewReader(`{"email":"alice@example.com"}`))
+	req.Header.Set("X-Tenant-ID", "acme")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("request status = %d, want %d; body %s", rec.Code, http.StatusAccepted, rec.Body)
+	}
+
+	var msg mail.Message
+	select {
+	case msg = <-mailer:
+	case <-time.After(5 * time.Second):
+		t.Fatal("magic link email not sent")
+	}
+	link := regexp.MustCompile(`https://\S+`).FindString(msg.Body)
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Host != "acme.example.com:8443" || !strings.HasPrefix(u.Path, "/v1/auth/magic-link/") {
+		t.Fatalf("link = %s, want it on the tenant subdomain", link)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, link, nil))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("redeem status = %d, want %d; body %s", rec.Code, http.StatusCreated, rec.Body)
+	}
+	if !strings.Contains(rec.Header().Get("Set-Cookie"), sessionCookieName+"=") {
+		t.Errorf("Set-Cookie = %q, want a session cookie", rec.Header().Get("Set-Cookie"))
+	}
+}
@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/andyfusniak/monolith/service"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	errCodeTOTPAlreadyEnabled = "mfa/totp-already-enabled"
+	errCodeTOTPNotEnrolled    = "mfa/totp-not-enrolled"
+	errCodeTOTPCodeInvalid    = "mfa/totp-code-invalid"
+)
+
+// EnrollTOTP begins TOTP enrollment for the authenticated user, returning
+// the secret and an otpauth URI for authenticator apps.
+func (h *Handler) EnrollTOTP() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		cl := log.WithContext(ctx)
+
+		userID := r.PathValue("user_id")
+		if !isValidUserID(userID) {
+			cl.Warnf("[app] path parameter /users/%s invalid", userID)
+			clientError(w, http.StatusUnprocessableEntity, errCodeUserIDInvalid,
+				"user_id url path parameter is not a valid user id") // 422
+			return
+		}
+		if !isSelf(r, userID) {
+			cl.Warnf("[app] EnrollTOTP: forbidden for user_id=%s", userID)
+			clientError(w, http.StatusForbidden, errCodeForbidden,
+				"you may only enroll your own account") // 403
+			return
+		}
+
+		enrollment, err := h.svc.EnrollTOTP(ctx, userID)
+		if err != nil {
+			if errors.Is(err, service.ErrTOTPAlreadyEnabled) {
+				clientError(w, http.StatusConflict, errCodeTOTPAlreadyEnabled,
+					"totp is already enabled for this account") // 409
+				return
+			}
+
+			cl.Errorf("[app] svc.EnrollTOTP(ctx, userID=%q) unexpected error: %+v", userID, err)
+			w.WriteHeader(http.StatusInternalServerError) // 500
+			return
+		}
+
+		// successful response
+		response := containerResponse{Data: enrollment}
+		h.respond(ctx, w, r, response, http.StatusCreated) // 201
+		cl.Infof("[app] totp enrollment started for user_id=%s", userID)
+	}
+}
+
+type confirmTOTPRequest struct {
+	Code *string `json:"code"`
+}
+
+type confirmTOTPResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// ConfirmTOTP completes TOTP enrollment for the authenticated user and
+// returns single-use recovery codes.
+func (h *Handler) ConfirmTOTP() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		cl := log.WithContext(ctx)
+
+		userID := r.PathValue("user_id")
+		if !isValidUserID(userID) {
+			cl.Warnf("[app] path parameter /users/%s invalid", userID)
+			clientError(w, http.StatusUnprocessableEntity, errCodeUserIDInvalid,
+				"user_id url path parameter is not a valid user id") // 422
+			return
+		}
+		if !isSelf(r, userID) {
+			cl.Warnf("[app] ConfirmTOTP: forbidden for user_id=%s", userID)
+			clientError(w, http.StatusForbidden, errCodeForbidden,
+				"you may only enroll your own account") // 403
+			return
+		}
+
+		// request body
+		req := confirmTOTPRequest{}
+		if err := h.decode(w, r, &req); err != nil {
+			cl.Warn("[app] confirmTOTPRequest body decode failed", err)
+			clientError(w, http.StatusBadRequest, errCodeBadRequest, err.Error()) // 400
+			return
+		}
+		if req.Code == nil {
+			clientError(w, http.StatusBadRequest, errCodeBadRequest, "code attribute not set") // 400
+			return
+		}
+
+		codes, err := h.svc.ConfirmTOTP(ctx, userID, *req.Code)
+		if err != nil {
+			switch {
+			case errors.Is(err, service.ErrTOTPNotEnrolled):
+				clientError(w, http.StatusConflict, errCodeTOTPNotEnrolled,
+					"totp enrollment has not been started") // 409
+				return
+			case errors.Is(err, service.ErrTOTPAlreadyEnabled):
+				clientError(w, http.StatusConflict, errCodeTOTPAlreadyEnabled,
+					"totp is already enabled for this account") // 409
+				return
+			case errors.Is(err, service.ErrMFACodeInvalid):
+				clientError(w, http.StatusUnprocessableEntity, errCodeTOTPCodeInvalid,
+					"code is not valid") // 422
+				return
+			}
+
+			cl.Errorf("[app] svc.ConfirmTOTP(ctx, userID=%q) unexpected error: %+v", userID, err)
+			w.WriteHeader(http.StatusInternalServerError) // 500
+			return
+		}
+
+		// successful response
+		response := containerResponse{Data: confirmTOTPResponse{RecoveryCodes: codes}}
+		h.respond(ctx, w, r, response, http.StatusOK) // 200
+		cl.Infof("[app] totp enabled for user_id=%s", userID)
+	}
+}
@@ -1,10 +1,752 @@
 package handler
 
-import "net/http"
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/andyfusniak/base58"
+	"github.com/andyfusniak/monolith/internal/metrics"
+	"github.com/andyfusniak/monolith/internal/tracing"
+	"github.com/andyfusniak/monolith/service"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// JSONHeader sets the Content-Type of responses to application/json.
+// Handlers that respond with another type set their own.
 func (h *Handler) JSONHeader(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		next.ServeHTTP(w, r)
 	})
 }
+
+// RequestID identifies each request. The X-Request-ID request header is
+// used if it is set by a proxy in front of the service and is well
+// formed, otherwise a new ID is generated. The ID is returned in the
+// X-Request-ID response header and added to the request context.
+func (h *Handler) RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(requestIDHeader)
+		if !isValidRequestID(requestID) {
+			var err error
+			requestID, err = base58.RandString(22)
+			if err != nil {
+				log.WithContext(r.Context()).Errorf("[app] failed to generate request id: %+v", err)
+				requestID = ""
+			}
+		}
+
+		if requestID != "" {
+			w.Header().Set(requestIDHeader, requestID)
+			r = r.WithContext(service.ContextWithRequestID(r.Context(), requestID))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isValidRequestID reports whether an incoming request ID is safe to
+// repeat in logs and response headers.
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == ':') {
+			return false
+		}
+	}
+	return true
+}
+
+// accessLogKey is the context key of the *accessLogEntry of a request.
+type accessLogKey struct{}
+
+// accessLogEntry holds what inner handlers learn about a request for the
+// access log line written once it completes.
+type accessLogEntry struct {
+	principal service.Principal
+}
+
+// setAccessLogPrincipal records the authenticated principal of the request
+// for the access log.
+func setAccessLogPrincipal(ctx context.Context, p service.Principal) {
+	if e, ok := ctx.Value(accessLogKey{}).(*accessLogEntry); ok {
+		e.principal = p
+	}
+}
+
+// AccessLog writes a log line for each request once it completes, with
+// the method, matched route pattern, status, response bytes, latency,
+// authenticated user and request ID. Requests using an API key also
+// record the key, and impersonated requests the impersonating admin, as
+// other log lines for the request do. route returns the pattern of the
+// route that served r, or "" if none matched.
+func (h *Handler) AccessLog(route func(r *http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			entry := &accessLogEntry{}
+			ctx := context.WithValue(r.Context(), accessLogKey{}, entry)
+			sw := &statusWriter{ResponseWriter: w}
+
+			defer func() {
+				status := sw.status
+				if status == 0 {
+					status = http.StatusOK
+				}
+				fields := log.Fields{
+					"method":      r.Method,
+					"route":       route(r),
+					"path":        r.URL.Path,
+					"status":      status,
+					"bytes":       sw.bytes,
+					"duration_ms": float64(time.Since(start).Microseconds()) / 1000,
+					"ip":          clientIP(r),
+				}
+				if p := entry.principal; p.UserID != "" {
+					fields["user_id"] = p.UserID
+					if p.APIKeyID != "" {
+						fields["api_key_id"] = p.APIKeyID
+					}
+					if p.ImpersonatorID != "" {
+						fields["impersonator_id"] = p.ImpersonatorID
+						fields["impersonated_user_id"] = p.UserID
+					}
+				}
+				log.WithContext(ctx).WithFields(fields).Infof("[app] %s %s %d", r.Method, r.URL.Path, status)
+			}()
+
+			next.ServeHTTP(sw, r.WithContext(ctx))
+		})
+	}
+}
+
+// Metrics records the duration of each request in hist, labelled by
+// method, route pattern and status. Requests that match no route share an
+// empty route label and unknown methods are recorded as OTHER, so
+// scanners cannot create unbounded series.
+func (h *Handler) Metrics(route func(r *http.Request) string, hist *metrics.Histogram) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			sw := &statusWriter{ResponseWriter: w}
+
+			defer func() {
+				status := sw.status
+				if status == 0 {
+					status = http.StatusOK
+				}
+				hist.Observe(time.Since(start).Seconds(), normalizeMethod(r.Method), route(r), strconv.Itoa(status))
+			}()
+
+			next.ServeHTTP(sw, r)
+		})
+	}
+}
+
+func normalizeMethod(m string) string {
+	switch m {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return m
+	default:
+		return "OTHER"
+	}
+}
+
+// Trace records a server span for each request, continuing the trace of
+// the caller if the request has a valid traceparent header. Spans are
+// named after the matched route pattern so requests for different IDs
+// group together.
+func (h *Handler) Trace(route func(r *http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !tracing.Enabled() {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			pattern := route(r)
+			name := pattern
+			if name == "" {
+				name = normalizeMethod(r.Method)
+			}
+			ctx, span := tracing.StartKind(tracing.Extract(r.Context(), r.Header), name,
+				tracing.SpanKindServer,
+				tracing.String("http.request.method", normalizeMethod(r.Method)),
+				tracing.String("http.route", pattern),
+				tracing.String("url.path", r.URL.Path),
+				tracing.String("client.address", clientIP(r)),
+			)
+			defer span.End()
+			sw := &statusWriter{ResponseWriter: w}
+
+			defer func() {
+				status := sw.status
+				if status == 0 {
+					status = http.StatusOK
+				}
+				span.SetAttributes(tracing.Int("http.response.status_code", status))
+				if status >= 500 {
+					span.SetError(http.StatusText(status))
+				}
+			}()
+
+			next.ServeHTTP(sw, r.WithContext(ctx))
+		})
+	}
+}
+
+// Recover turns a panic in a handler into a JSON 500 response, logging
+// the panic and stack trace. If the handler had already started its
+// response the connection is closed instead.
+func (h *Handler) Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+
+			log.WithContext(r.Context()).Errorf("[app] panic serving %s %s: %v\n%s",
+				r.Method, r.URL.Path, v, debug.Stack())
+			if sw.status != 0 {
+				panic(http.ErrAbortHandler)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			serverError(w, "internal server error") // 500
+		}()
+		next.ServeHTTP(sw, r)
+	})
+}
+
+// RealIP adds the client IP address to the request context, where it is
+// read by clientIP and recorded in the audit log.
+//
+// When the request comes from one of the trusted proxies the client is
+// the rightmost address in X-Forwarded-For (or X-Real-IP) that is not
+// itself a trusted proxy. Forwarding headers from other peers are
+// ignored, so clients cannot spoof their address.
+func (h *Handler) RealIP(trusted []netip.Prefix) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := realIP(r, trusted)
+			next.ServeHTTP(w, r.WithContext(service.ContextWithClientIP(r.Context(), ip)))
+		})
+	}
+}
+
+func realIP(r *http.Request, trusted []netip.Prefix) string {
+	peer := remoteIP(r)
+	addr, err := netip.ParseAddr(peer)
+	if err != nil || !isTrustedProxy(addr, trusted) {
+		return peer
+	}
+
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	if len(hops) == 0 {
+		hops = []string{r.Header.Get("X-Real-IP")}
+	}
+
+	ip := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		ip = hop.Unmap().String()
+		if !isTrustedProxy(hop, trusted) {
+			break
+		}
+	}
+	return ip
+}
+
+func isTrustedProxy(addr netip.Addr, trusted []netip.Prefix) bool {
+	addr = addr.Unmap()
+	for _, p := range trusted {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// maintenanceRetryAfter is the Retry-After, in seconds, of responses sent
+// in maintenance mode.
+const maintenanceRetryAfter = "120"
+
+// Maintenance responds 503 to every request while the service is in
+// maintenance mode.
+func (h *Handler) Maintenance(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.svc.InMaintenance() {
+			w.Header().Set("Retry-After", maintenanceRetryAfter)
+			clientError(w, http.StatusServiceUnavailable, errCodeMaintenance,
+				"the service is down for maintenance; try again later") // 503
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Rate limit client keys, identifying the client a RateLimitRule counts
+// requests of.
+const (
+	RateLimitKeyIP     = "ip"
+	RateLimitKeyUser   = "user"
+	RateLimitKeyAPIKey = "api_key"
+)
+
+// RateLimitRule limits the requests each client may make to a route.
+// Clients are identified by Key, one of the RateLimitKey constants.
+type RateLimitRule struct {
+	service.RateLimit
+	Key string
+}
+
+// RateLimit limits the requests each client may make to each route using
+// the rule in rules for the route pattern, or for "*" if it has none.
+// Every limited response has RateLimit-Limit, RateLimit-Remaining,
+// RateLimit-Reset and RateLimit-Policy headers, and requests over the
+// limit are refused with 429 and a Retry-After header.
+//
+// Clients are identified by IP address, or by user or API key once
+// authenticated, so it must follow RequireAuth for those keys to apply.
+// If the limit cannot be checked the request is allowed.
+func (h *Handler) RateLimit(route func(r *http.Request) string, rules map[string]RateLimitRule) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			pattern := route(r)
+			rule, ok := rules[pattern]
+			if !ok {
+				pattern = "*"
+				rule, ok = rules[pattern]
+			}
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx := r.Context()
+			key := pattern + " " + rateLimitClient(r, rule.Key)
+			status, err := h.svc.TakeRateLimitToken(ctx, key, rule.RateLimit)
+			if err != nil {
+				log.WithContext(ctx).Errorf("[app] svc.TakeRateLimitToken(ctx, key=%q) unexpected error: %+v", key, err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			hdr := w.Header()
+			hdr.Set("RateLimit-Limit", strconv.Itoa(status.Limit))
+			hdr.Set("RateLimit-Remaining", strconv.Itoa(status.Remaining))
+			hdr.Set("RateLimit-Reset", ceilSeconds(status.Reset))
+			hdr.Set("RateLimit-Policy", strconv.Itoa(status.Limit)+";w="+ceilSeconds(status.Window))
+			if !status.Allowed {
+				hdr.Set("Retry-After", ceilSeconds(status.RetryAfter))
+				clientError(w, http.StatusTooManyRequests, errCodeRateLimited,
+					"too many requests; try again later") // 429
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rateLimitClient identifies the client of r for a rule with the given
+// key. Unauthenticated clients are identified by IP address, and clients
+// authenticated without an API key by user.
+func rateLimitClient(r *http.Request, key string) string {
+	p, ok := service.PrincipalFromContext(r.Context())
+	switch {
+	case !ok || key == RateLimitKeyIP:
+		return "ip:" + clientIP(r)
+	case key == RateLimitKeyAPIKey && p.APIKeyID != "":
+		return "api_key:" + p.APIKeyID
+	default:
+		return "user:" + p.UserID
+	}
+}
+
+// ceilSeconds formats d as a whole number of seconds, rounded up.
+func ceilSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+
+	// maxIdempotencyKeyLen is the longest Idempotency-Key accepted.
+	maxIdempotencyKeyLen = 255
+
+	// maxIdempotentBodyBytes is the largest request body accepted with an
+	// Idempotency-Key, as the body is read in full to fingerprint it.
+	maxIdempotentBodyBytes = 1 << 20
+)
+
+// idempotentHeaders are the response headers replayed with the stored
+// response to a request with an Idempotency-Key.
+var idempotentHeaders = []string{"Content-Type", "Location"}
+
+// Idempotency makes POST requests with an Idempotency-Key header safe to
+// retry. The response to the first request with a key is stored and
+// replayed, with an Idempotent-Replayed header, to repeats of it for 24
+// hours. A key reused for a request with a different method, path or
+// body is rejected with 422, and a repeat made while the first request is
+// in progress with 409. Server errors are not stored, so the request can
+// be retried with the same key.
+//
+// Keys are scoped to the authenticated user, so it must follow
+// RequireAuth on routes requiring authentication. Requests without the
+// header are served as normal.
+func (h *Handler) Idempotency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if r.Method != http.MethodPost || key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := r.Context()
+		cl := log.WithContext(ctx)
+
+		if !isValidIdempotencyKey(key) {
+			clientError(w, http.StatusBadRequest, errCodeIdempotencyKeyInvalid,
+				"Idempotency-Key must be 1 to 255 printable ASCII characters") // 400
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBodyBytes))
+		if err != nil {
+			var mbe *http.MaxBytesError
+			if errors.As(err, &mbe) {
+				clientError(w, http.StatusRequestEntityTooLarge, errCodeBadRequest,
+					"request body too large") // 413
+				return
+			}
+			cl.Warnf("[app] read request body failed: %v", err)
+			clientError(w, http.StatusBadRequest, errCodeBadRequest,
+				"failed to read request body") // 400
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		var scope string
+		if p, ok := service.PrincipalFromContext(ctx); ok {
+			scope = p.UserID
+		}
+		sum := sha256.New()
+		sum.Write([]byte(r.Method + " " + r.URL.RequestURI() + "\n"))
+		sum.Write(body)
+		fingerprint := hex.EncodeToString(sum.Sum(nil))
+
+		stored, err := h.svc.BeginIdempotentRequest(ctx, scope, key, fingerprint)
+		if err != nil {
+			switch {
+			case errors.Is(err, service.ErrIdempotencyKeyMismatch):
+				clientError(w, http.StatusUnprocessableEntity, errCodeIdempotencyKeyMismatch,
+					"Idempotency-Key was used for a different request") // 422
+			case errors.Is(err, service.ErrIdempotencyKeyInProgress):
+				w.Header().Set("Retry-After", "1")
+				clientError(w, http.StatusConflict, errCodeIdempotencyKeyInProgress,
+					"a request with this Idempotency-Key is in progress") // 409
+			default:
+				cl.Errorf("[app] svc.BeginIdempotentRequest(ctx, scope=%q, key=%q) unexpected error: %+v",
+					scope, key, err)
+				serverError(w, "internal server error") // 500
+			}
+			return
+		}
+		if stored != nil {
+			for k, v := range stored.Headers {
+				w.Header().Set(k, v)
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(stored.StatusCode)
+			_, _ = w.Write(stored.Body)
+			return
+		}
+
+		// the response is stored even if the client has gone away, as the
+		// request has been handled
+		dctx := context.WithoutCancel(ctx)
+		rw := &recordWriter{statusWriter: statusWriter{ResponseWriter: w}}
+		completed := false
+		defer func() {
+			if completed {
+				return
+			}
+			// the handler panicked or failed
+			if err := h.svc.AbandonIdempotentRequest(dctx, scope, key); err != nil {
+				cl.Errorf("[app] svc.AbandonIdempotentRequest(ctx, scope=%q, key=%q) unexpected error: %+v",
+					scope, key, err)
+			}
+		}()
+
+		next.ServeHTTP(rw, r)
+
+		status := rw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		if status >= 500 {
+			return
+		}
+		resp := service.IdempotentResponse{
+			StatusCode: status,
+			Headers:    make(map[string]string),
+			Body:       rw.body.Bytes(),
+		}
+		for _, k := range idempotentHeaders {
+			if v := w.Header().Get(k); v != "" {
+				resp.Headers[k] = v
+			}
+		}
+		if err := h.svc.CompleteIdempotentRequest(dctx, scope, key, resp); err != nil {
+			cl.Errorf("[app] svc.CompleteIdempotentRequest(ctx, scope=%q, key=%q) unexpected error: %+v",
+				scope, key, err)
+			return
+		}
+		completed = true
+	})
+}
+
+// isValidIdempotencyKey reports whether an Idempotency-Key is safe to
+// store and log.
+func isValidIdempotencyKey(key string) bool {
+	if len(key) > maxIdempotencyKeyLen {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x20 || key[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// recordWriter keeps a copy of the response body written through it.
+type recordWriter struct {
+	statusWriter
+	body bytes.Buffer
+}
+
+func (w *recordWriter) Write(b []byte) (int, error) {
+	n, err := w.statusWriter.Write(b)
+	w.body.Write(b[:n])
+	return n, err
+}
+
+// Tenant serves each request from the database of its tenant. The tenant
+// is the subdomain of the request host under domain or, if the host is not
+// a subdomain, the value of the header. Either may be empty to disable
+// that method.
+func (h *Handler) Tenant(domain, header string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			cl := log.WithContext(ctx)
+
+			tenantID := tenantFromHost(r.Host, domain)
+			if tenantID == "" && header != "" {
+				tenantID = strings.ToLower(strings.TrimSpace(r.Header.Get(header)))
+			}
+			if tenantID == "" {
+				clientError(w, http.StatusBadRequest, errCodeTenantRequired,
+					"request does not identify a tenant") // 400
+				return
+			}
+
+			tctx, release, err := h.svc.EnterTenant(ctx, tenantID)
+			if err != nil {
+				if errors.Is(err, service.ErrTenantNotFound) {
+					cl.Warnf("[app] request for unknown tenant=%q", tenantID)
+					clientError(w, http.StatusNotFound, errCodeTenantNotFound,
+						"tenant not found") // 404
+					return
+				}
+				cl.Errorf("[app] svc.EnterTenant(ctx, tenantID=%q) unexpected error: %+v", tenantID, err)
+				w.WriteHeader(http.StatusInternalServerError) // 500
+				return
+			}
+			defer release()
+
+			next.ServeHTTP(w, r.WithContext(tctx))
+		})
+	}
+}
+
+// tenantFromHost returns the leftmost label of host if host is a direct
+// subdomain of domain, otherwise "".
+func tenantFromHost(host, domain string) string {
+	if domain == "" {
+		return ""
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	label, ok := strings.CutSuffix(strings.ToLower(host), "."+domain)
+	if !ok || strings.Contains(label, ".") {
+		return ""
+	}
+	return label
+}
+
+// RequireAuth rejects requests without a valid session cookie, access
+// token or API key. Access tokens and API keys are sent as
+// "Authorization: Bearer <token>"; API keys without the write scope and
+// impersonation sessions may only be used with safe methods. On success
+// the service.Principal is added to the request context.
+func (h *Handler) RequireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		cl := log.WithContext(ctx)
+
+		if authz := r.Header.Get("Authorization"); authz != "" {
+			p, ok := h.authenticateBearer(w, r, authz)
+			if !ok {
+				return
+			}
+			if !p.HasScope(requiredScope(r.Method)) {
+				cl.Warnf("[app] api_key_id=%s lacks scope %s for %s %s",
+					p.APIKeyID, requiredScope(r.Method), r.Method, r.URL.Path)
+				clientError(w, http.StatusForbidden, errCodeInsufficientScope,
+					"api key does not have the "+requiredScope(r.Method)+" scope") // 403
+				return
+			}
+			setAccessLogPrincipal(ctx, p)
+			next.ServeHTTP(w, r.WithContext(service.ContextWithPrincipal(ctx, p)))
+			return
+		}
+
+		c, err := r.Cookie(sessionCookieName)
+		if err != nil || strings.TrimSpace(c.Value) == "" {
+			clientError(w, http.StatusUnauthorized, errCodeUnauthenticated,
+				"authentication required") // 401
+			return
+		}
+
+		session, err := h.svc.AuthenticateSession(ctx, c.Value)
+		if err != nil {
+			if errors.Is(err, service.ErrSessionNotFound) {
+				clientError(w, http.StatusUnauthorized, errCodeUnauthenticated,
+					"session invalid or expired") // 401
+				return
+			}
+			cl.Errorf("[app] svc.AuthenticateSession(ctx, token=*****) unexpected error: %+v", err)
+			w.WriteHeader(http.StatusInternalServerError) // 500
+			return
+		}
+
+		p := service.Principal{
+			UserID:         session.UserID,
+			SessionID:      session.ID,
+			ImpersonatorID: session.ImpersonatorID,
+		}
+		setAccessLogPrincipal(ctx, p)
+		ctx = service.ContextWithPrincipal(ctx, p)
+
+		// impersonation is for seeing what the user sees, not acting as them
+		if session.ImpersonatorID != "" && requiredScope(r.Method) != service.APIScopeRead {
+			log.WithContext(ctx).Warnf("[app] impersonation session blocked %s %s", r.Method, r.URL.Path)
+			clientError(w, http.StatusForbidden, errCodeImpersonationReadOnly,
+				"impersonation sessions are read-only") // 403
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// authenticateBearer returns the principal for a bearer access token or
+// API key in the Authorization header value authz. API keys are told apart
+// by their prefix. On failure the error response is written and false
+// returned.
+func (h *Handler) authenticateBearer(w http.ResponseWriter, r *http.Request, authz string) (service.Principal, bool) {
+	ctx := r.Context()
+	cl := log.WithContext(ctx)
+
+	token, ok := strings.CutPrefix(authz, "Bearer ")
+	token = strings.TrimSpace(token)
+	if !ok || token == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+		clientError(w, http.StatusUnauthorized, errCodeUnauthenticated,
+			"Authorization header must use the Bearer scheme") // 401
+		return service.Principal{}, false
+	}
+
+	if !strings.HasPrefix(token, service.APIKeyPrefix) {
+		p, err := h.svc.AuthenticateAccessToken(ctx, token)
+		if err != nil {
+			if errors.Is(err, service.ErrAccessTokenInvalid) {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="api", error="invalid_token"`)
+				clientError(w, http.StatusUnauthorized, errCodeUnauthenticated,
+					"access token invalid or expired") // 401
+				return service.Principal{}, false
+			}
+			cl.Errorf("[app] svc.AuthenticateAccessToken(ctx, token=*****) unexpected error: %+v", err)
+			w.WriteHeader(http.StatusInternalServerError) // 500
+			return service.Principal{}, false
+		}
+		return p, true
+	}
+
+	apiKey, err := h.svc.AuthenticateAPIKey(ctx, token)
+	if err != nil {
+		if errors.Is(err, service.ErrAPIKeyInvalid) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api", error="invalid_token"`)
+			clientError(w, http.StatusUnauthorized, errCodeUnauthenticated,
+				"api key invalid or expired") // 401
+			return service.Principal{}, false
+		}
+		cl.Errorf("[app] svc.AuthenticateAPIKey(ctx, key=*****) unexpected error: %+v", err)
+		w.WriteHeader(http.StatusInternalServerError) // 500
+		return service.Principal{}, false
+	}
+
+	return service.Principal{
+		UserID:   apiKey.UserID,
+		APIKeyID: apiKey.ID,
+		Scopes:   apiKey.Scopes,
+	}, true
+}
+
+// requiredScope returns the API key scope needed for a request method.
+func requiredScope(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return service.APIScopeRead
+	default:
+		return service.APIScopeWrite
+	}
+}
+
+// sessionFromCookie returns the session for the request session cookie.
+// If there is no cookie or the session is invalid or expired
+// service.ErrSessionNotFound is returned.
+func (h *Handler) sessionFromCookie(r *http.Request) (service.Session, error) {
+	c, err := r.Cookie(sessionCookieName)
+	if err != nil || strings.TrimSpace(c.Value) == "" {
+		return service.Session{}, service.ErrSessionNotFound
+	}
+	return h.svc.AuthenticateSession(r.Context(), c.Value)
+}
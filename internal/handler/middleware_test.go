@@ -0,0 +1,639 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andyfusniak/monolith/internal/store"
+	"github.com/andyfusniak/monolith/internal/store/memory"
+	"github.com/andyfusniak/monolith/internal/store/sqlite3"
+	"github.com/andyfusniak/monolith/internal/tracing"
+	"github.com/andyfusniak/monolith/service"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestRequireAuthAPIKeyScope(t *testing.T) {
+	h, svc := newTestHandler(t)
+	user := newTestUser(t, svc, "alice@example.com")
+
+	readKey, err := svc.CreateAPIKey(context.Background(), user.ID, "read", []string{service.APIScopeRead}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeKey, err := svc.CreateAPIKey(context.Background(), user.ID, "write", []string{service.APIScopeWrite}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var principal service.Principal
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, _ = service.PrincipalFromContext(r.Context())
+		w.WriteHeader(http.StatusNoContent)
+	})
+	handler := h.RequireAuth(next)
+
+	tests := []struct {
+		name       string
+		method     string
+		authz      string
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "read key GET", method: http.MethodGet, authz: "Bearer " + readKey.Key, wantStatus: http.StatusNoContent},
+		{name: "read key HEAD", method: http.MethodHead, authz: "Bearer " + readKey.Key, wantStatus: http.StatusNoContent},
+		{name: "read key POST", method: http.MethodPost, authz: "Bearer " + readKey.Key,
+			wantStatus: http.StatusForbidden, wantCode: errCodeInsufficientScope},
+		{name: "read key DELETE", method: http.MethodDelete, authz: "Bearer " + readKey.Key,
+			wantStatus: http.StatusForbidden, wantCode: errCodeInsufficientScope},
+		{name: "write key POST", method: http.MethodPost, authz: "Bearer " + writeKey.Key, wantStatus: http.StatusNoContent},
+		{name: "unknown key", method: http.MethodGet, authz: "Bearer " + service.APIKeyPrefix + "unknown",
+			wantStatus: http.StatusUnauthorized, wantCode: errCodeUnauthenticated},
+		{name: "basic scheme", method: http.MethodGet, authz: "Basic " + readKey.Key,
+			wantStatus: http.StatusUnauthorized, wantCode: errCodeUnauthenticated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principal = service.Principal{}
+			req := httptest.NewRequest(tt.method, "/v1/users/me", nil)
+			req.Header.Set("Authorization", tt.authz)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d; body %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantCode != "" {
+				if resp := decodeError(t, rec); resp.Code != tt.wantCode {
+					t.Errorf("code = %s, want %s", resp.Code, tt.wantCode)
+				}
+				return
+			}
+			if principal.UserID != user.ID || principal.APIKeyID == "" {
+				t.Errorf("principal = %+v, want user %s with an api key", principal, user.ID)
+			}
+		})
+	}
+}
+
+func TestRealIP(t *testing.T) {
+	trusted := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("fd00::/8"),
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        []string
+		xRealIP    string
+		want       string
+	}{
+		{name: "direct client", remoteAddr: "203.0.113.7:4000", want: "203.0.113.7"},
+		{name: "spoofed X-Forwarded-For from untrusted peer", remoteAddr: "203.0.113.7:4000",
+			xff: []string{"198.51.100.1"}, want: "203.0.113.7"},
+		{name: "spoofed X-Real-IP from untrusted peer", remoteAddr: "203.0.113.7:4000",
+			xRealIP: "198.51.100.1", want: "203.0.113.7"},
+		{name: "trusted proxy", remoteAddr: "10.0.0.1:4000", xff: []string{"203.0.113.7"}, want: "203.0.113.7"},
+		{name: "client prepends a spoofed hop", remoteAddr: "10.0.0.1:4000",
+			xff: []string{"198.51.100.1, 203.0.113.7"}, want: "203.0.113.7"},
+		{name: "chain of trusted proxies", remoteAddr: "10.0.0.1:4000",
+			xff: []string{"198.51.100.1, 203.0.113.7, 10.0.0.3", "10.0.0.2"}, want: "203.0.113.7"},
+		{name: "only trusted hops", remoteAddr: "10.0.0.1:4000", xff: []string{"10.0.0.3, 10.0.0.2"}, want: "10.0.0.3"},
+		{name: "invalid hop stops the walk", remoteAddr: "10.0.0.1:4000",
+			xff: []string{"203.0.113.7, not-an-ip"}, want: "10.0.0.1"},
+		{name: "trusted proxy with X-Real-IP", remoteAddr: "10.0.0.1:4000", xRealIP: "203.0.113.7", want: "203.0.113.7"},
+		{name: "trusted proxy without headers", remoteAddr: "10.0.0.1:4000", want: "10.0.0.1"},
+		{name: "IPv6 trusted proxy", remoteAddr: "[fd00::1]:4000", xff: []string{"2001:db8::7"}, want: "2001:db8::7"},
+		{name: "IPv4-mapped hop", remoteAddr: "10.0.0.1:4000", xff: []string{"::ffff:203.0.113.7"}, want: "203.0.113.7"},
+	}
+
+	h := &Handler{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = clientIP(r)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.xff {
+				req.Header.Add("X-Forwarded-For", v)
+			}
+			if tt.xRealIP != "" {
+				req.Header.Set("X-Real-IP", tt.xRealIP)
+			}
+			h.RealIP(trusted)(next).ServeHTTP(httptest.NewRecorder(), req)
+
+			if got != tt.want {
+				t.Errorf("client IP = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecover(t *testing.T) {
+	h := &Handler{}
+
+	t.Run("panic before writing", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		h.Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic("boom")
+		})).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if rec.Code != http.StatusInternalServerError {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %q, want application/json", ct)
+		}
+		if resp := decodeError(t, rec); resp.Status != http.StatusInternalServerError || resp.Code != errCodeInternal {
+			t.Errorf("body = %+v, want status 500 and code %s", resp, errCodeInternal)
+		}
+	})
+
+	t.Run("panic after writing", func(t *testing.T) {
+		defer func() {
+			if v := recover(); v != http.ErrAbortHandler {
+				t.Errorf("recovered %v, want http.ErrAbortHandler", v)
+			}
+		}()
+		rec := httptest.NewRecorder()
+		h.Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+			panic("boom")
+		})).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		t.Error("Recover did not abort the response")
+	})
+
+	t.Run("abort handler", func(t *testing.T) {
+		defer func() {
+			if v := recover(); v != http.ErrAbortHandler {
+				t.Errorf("recovered %v, want http.ErrAbortHandler", v)
+			}
+		}()
+		h.Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic(http.ErrAbortHandler)
+		})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	})
+}
+
+// captureLog sends the standard logger output to the returned buffer as
+// JSON until the test completes.
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	l := log.StandardLogger()
+	out, formatter := l.Out, l.Formatter
+	t.Cleanup(func() {
+		l.SetOutput(out)
+		l.SetFormatter(formatter)
+	})
+
+	var buf bytes.Buffer
+	l.SetOutput(&buf)
+	l.SetFormatter(&log.JSONFormatter{})
+	return &buf
+}
+
+// accessLogLine returns the fields of the access log line in buf.
+func accessLogLine(t *testing.T, buf *bytes.Buffer) map[string]any {
+	t.Helper()
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var fields map[string]any
+		if err := json.Unmarshal([]byte(line), &fields); err != nil {
+			t.Fatalf("log line %q: %v", line, err)
+		}
+		if _, ok := fields["route"]; ok {
+			return fields
+		}
+	}
+	t.Fatalf("no access log line in %s", buf)
+	return nil
+}
+
+func TestAccessLogPrincipal(t *testing.T) {
+	ctx := context.Background()
+	h, svc := newTestHandler(t)
+	admin := newTestUser(t, svc, "admin@example.com")
+	user := newTestUser(t, svc, "alice@example.com")
+	if err := svc.GrantRole(ctx, admin.ID, service.RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	session, err := svc.Impersonate(service.ContextWithPrincipal(ctx, service.Principal{UserID: admin.ID}), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	own, err := svc.CreateSession(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := svc.CreateAPIKey(ctx, user.ID, "ci", []string{service.APIScopeRead}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	route := func(r *http.Request) string { return "GET /v1/users/me" }
+	handler := h.AccessLog(route)(h.RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	tests := []struct {
+		name       string
+		cookie     string
+		authz      string
+		wantFields map[string]any
+		wantAbsent []string
+	}{
+		{
+			name:       "impersonation session",
+			cookie:     session.Token,
+			wantFields: map[string]any{"user_id": user.ID, "impersonator_id": admin.ID, "impersonated_user_id": user.ID},
+			wantAbsent: []string{"api_key_id"},
+		},
+		{
+			name:       "own session",
+			cookie:     own.Token,
+			wantFields: map[string]any{"user_id": user.ID},
+			wantAbsent: []string{"impersonator_id", "impersonated_user_id", "api_key_id"},
+		},
+		{
+			name:       "api key",
+			authz:      "Bearer " + key.Key,
+			wantFields: map[string]any{"user_id": user.ID, "api_key_id": key.ID},
+			wantAbsent: []string{"impersonator_id", "impersonated_user_id"},
+		},
+		{
+			name:       "unauthenticated",
+			wantFields: map[string]any{"status": float64(http.StatusUnauthorized)},
+			wantAbsent: []string{"user_id", "impersonator_id", "api_key_id"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := captureLog(t)
+			req := httptest.NewRequest(http.MethodGet, "/v1/users/me", nil)
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: tt.cookie})
+			}
+			if tt.authz != "" {
+				req.Header.Set("Authorization", tt.authz)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			fields := accessLogLine(t, buf)
+			for k, want := range tt.wantFields {
+				if fields[k] != want {
+					t.Errorf("%s = %v, want %v", k, fields[k], want)
+				}
+			}
+			for _, k := range tt.wantAbsent {
+				if v, ok := fields[k]; ok {
+					t.Errorf("%s = %v, want it absent", k, v)
+				}
+			}
+		})
+	}
+}
+
+// spanRecorder keeps exported spans.
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []tracing.SpanData
+}
+
+func (e *spanRecorder) Export(_ context.Context, spans []tracing.SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func TestTraceContinuesCaller(t *testing.T) {
+	exp := &spanRecorder{}
+	tracer := tracing.NewTracer(exp, 0)
+	tracing.SetDefault(tracer)
+	t.Cleanup(func() { tracing.SetDefault(nil) })
+
+	const (
+		traceID  = "4bf92f3577b34da6a3ce929d0e0e4736"
+		parentID = "00f067aa0ba902b7"
+	)
+	var inner tracing.SpanContext
+	h := &Handler{}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inner = tracing.SpanContextFromContext(r.Context())
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	route := func(r *http.Request) string { return "GET /users/{id}" }
+
+	req := httptest.NewRequest(http.MethodGet, "/users/123", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-"+parentID+"-01")
+	h.Trace(route)(next).ServeHTTP(httptest.NewRecorder(), req)
+
+	if inner.TraceID.String() != traceID || inner.SpanID.String() == parentID || !inner.Sampled {
+		t.Fatalf("handler span context = %+v, want a sampled child in trace %s", inner, traceID)
+	}
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(exp.spans) != 1 {
+		t.Fatalf("exported %d spans, want 1", len(exp.spans))
+	}
+	span := exp.spans[0]
+	if span.Name != "GET /users/{id}" || span.Kind != tracing.SpanKindServer || span.ParentSpanID.String() != parentID {
+		t.Errorf("span = %+v, want server span GET /users/{id} with parent %s", span, parentID)
+	}
+	if !span.Error {
+		t.Error("span for a 502 response was not marked as an error")
+	}
+}
+
+// rateLimitStores creates each of the rate limit stores a service may
+// use, empty.
+var rateLimitStores = map[string]func(t *testing.T) store.RateLimitsRepository{
+	"memory": func(t *testing.T) store.RateLimitsRepository {
+		return memory.NewRateLimits()
+	},
+	"sqlite": func(t *testing.T) store.RateLimitsRepository {
+		db := openTestDB(t, filepath.Join(t.TempDir(), "ratelimits.db"))
+		return sqlite3.NewStore(db, db)
+	},
+}
+
+func TestRateLimit(t *testing.T) {
+	const route = "POST /v1/things"
+	// a bucket of 2 refilled every 50ms
+	limit := service.RateLimit{Limit: 20, Period: time.Second, Burst: 2}
+
+	for name, newStore := range rateLimitStores {
+		t.Run(name, func(t *testing.T) {
+			h, _ := newTestHandler(t, service.WithRateLimitStore(newStore(t)))
+			handler := h.RateLimit(func(r *http.Request) string { return route }, map[string]RateLimitRule{
+				route: {RateLimit: limit, Key: RateLimitKeyIP},
+			})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			do := func() *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodPost, "/v1/things", nil)
+				req.RemoteAddr = "192.0.2.1:1234"
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+				return rec
+			}
+
+			for i, wantRemaining := range []string{"1", "0"} {
+				rec := do()
+				if rec.Code != http.StatusNoContent {
+					t.Fatalf("request %d status = %d, want %d", i+1, rec.Code, http.StatusNoContent)
+				}
+				if got := rec.Header().Get("RateLimit-Remaining"); got != wantRemaining {
+					t.Errorf("request %d RateLimit-Remaining = %s, want %s", i+1, got, wantRemaining)
+				}
+			}
+
+			rec := do()
+			if rec.Code != http.StatusTooManyRequests {
+				t.Fatalf("status over the limit = %d, want %d", rec.Code, http.StatusTooManyRequests)
+			}
+			hdr := rec.Header()
+			if got := hdr.Get("Retry-After"); got != "1" {
+				t.Errorf("Retry-After = %q, want 1", got)
+			}
+			// the limit and the policy both describe a bucket of 2 that
+			// refills in 100ms
+			if got := hdr.Get("RateLimit-Limit"); got != "2" {
+				t.Errorf("RateLimit-Limit = %q, want 2", got)
+			}
+			if got := hdr.Get("RateLimit-Policy"); got != "2;w=1" {
+				t.Errorf("RateLimit-Policy = %q, want 2;w=1", got)
+			}
+			if resp := decodeError(t, rec); resp.Code != errCodeRateLimited {
+				t.Errorf("code = %s, want %s", resp.Code, errCodeRateLimited)
+			}
+
+			time.Sleep(60 * time.Millisecond)
+			if rec := do(); rec.Code != http.StatusNoContent {
+				t.Errorf("status after a refill = %d, want %d", rec.Code, http.StatusNoContent)
+			}
+		})
+	}
+}
+
+func TestRateLimitKeys(t *testing.T) {
+	const route = "GET /v1/things"
+	// a bucket of 1 refilled once an hour, so only the first request of
+	// each client is allowed
+	limit := service.RateLimit{Limit: 1, Period: time.Hour, Burst: 1}
+
+	alice := service.Principal{UserID: "alice"}
+	aliceKey1 := service.Principal{UserID: "alice", APIKeyID: "key1"}
+	aliceKey2 := service.Principal{UserID: "alice", APIKeyID: "key2"}
+	bob := service.Principal{UserID: "bob"}
+
+	type request struct {
+		ip        string
+		principal *service.Principal
+	}
+	tests := []struct {
+		name    string
+		key     string
+		first   request
+		second  request
+		limited bool
+	}{
+		{name: "ip same address", key: RateLimitKeyIP,
+			first: request{ip: "192.0.2.1"}, second: request{ip: "192.0.2.1"}, limited: true},
+		{name: "ip other address", key: RateLimitKeyIP,
+			first: request{ip: "192.0.2.1"}, second: request{ip: "192.0.2.2"}},
+		{name: "ip ignores user", key: RateLimitKeyIP,
+			first: request{ip: "192.0.2.1", principal: &alice}, second: request{ip: "192.0.2.1", principal: &bob}, limited: true},
+		{name: "user other address", key: RateLimitKeyUser,
+			first: request{ip: "192.0.2.1", principal: &alice}, second: request{ip: "192.0.2.2", principal: &alice}, limited: true},
+		{name: "user other user", key: RateLimitKeyUser,
+			first: request{ip: "192.0.2.1", principal: &alice}, second: request{ip: "192.0.2.1", principal: &bob}},
+		{name: "user shares keys", key: RateLimitKeyUser,
+			first: request{ip: "192.0.2.1", principal: &aliceKey1}, second: request{ip: "192.0.2.1", principal: &aliceKey2}, limited: true},
+		{name: "user anonymous by address", key: RateLimitKeyUser,
+			first: request{ip: "192.0.2.1"}, second: request{ip: "192.0.2.2"}},
+		{name: "api key same key", key: RateLimitKeyAPIKey,
+			first: request{ip: "192.0.2.1", principal: &aliceKey1}, second: request{ip: "192.0.2.2", principal: &aliceKey1}, limited: true},
+		{name: "api key other key", key: RateLimitKeyAPIKey,
+			first: request{ip: "192.0.2.1", principal: &aliceKey1}, second: request{ip: "192.0.2.1", principal: &aliceKey2}},
+		{name: "api key user without key", key: RateLimitKeyAPIKey,
+			first: request{ip: "192.0.2.1", principal: &alice}, second: request{ip: "192.0.2.2", principal: &alice}, limited: true},
+		{name: "api key not shared with user", key: RateLimitKeyAPIKey,
+			first: request{ip: "192.0.2.1", principal: &alice}, second: request{ip: "192.0.2.1", principal: &aliceKey1}},
+	}
+
+	for name, newStore := range rateLimitStores {
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				h, _ := newTestHandler(t, service.WithRateLimitStore(newStore(t)))
+				handler := h.RateLimit(func(r *http.Request) string { return route }, map[string]RateLimitRule{
+					route: {RateLimit: limit, Key: tt.key},
+				})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusNoContent)
+				}))
+				do := func(r request) int {
+					req := httptest.NewRequest(http.MethodGet, "/v1/things", nil)
+					req.RemoteAddr = r.ip + ":1234"
+					if r.principal != nil {
+						req = req.WithContext(service.ContextWithPrincipal(req.Context(), *r.principal))
+					}
+					rec := httptest.NewRecorder()
+					handler.ServeHTTP(rec, req)
+					return rec.Code
+				}
+
+				if got := do(tt.first); got != http.StatusNoContent {
+					t.Fatalf("first status = %d, want %d", got, http.StatusNoContent)
+				}
+				want := http.StatusNoContent
+				if tt.limited {
+					want = http.StatusTooManyRequests
+				}
+				if got := do(tt.second); got != want {
+					t.Errorf("second status = %d, want %d", got, want)
+				}
+			})
+		}
+	}
+}
+
+func TestIdempotency(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	var calls int
+	status := http.StatusCreated
+	handler := h.Idempotency(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", "/v1/things/1")
+		w.Header().Set("X-Call", strconv.Itoa(calls))
+		w.WriteHeader(status)
+		fmt.Fprintf(w, `{"call":%d,"body":%q}`, calls, body)
+	}))
+	post := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/things", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set(idempotencyKeyHeader, key)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := post("key1", `{"name":"a"}`)
+	if first.Code != http.StatusCreated || first.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("first response = %d %v, want 201 not replayed", first.Code, first.Header())
+	}
+
+	t.Run("replay", func(t *testing.T) {
+		rec := post("key1", `{"name":"a"}`)
+		if calls != 1 {
+			t.Fatalf("handler called %d times, want 1", calls)
+		}
+		if rec.Code != http.StatusCreated || rec.Body.String() != first.Body.String() {
+			t.Errorf("replayed response = %d %s, want %d %s", rec.Code, rec.Body, first.Code, first.Body)
+		}
+		hdr := rec.Header()
+		if hdr.Get("Idempotent-Replayed") != "true" || hdr.Get("Location") != "/v1/things/1" ||
+			hdr.Get("Content-Type") != "application/json" {
+			t.Errorf("replayed headers = %v, want Idempotent-Replayed, Location and Content-Type", hdr)
+		}
+		if hdr.Get("X-Call") != "" {
+			t.Errorf("replayed X-Call = %q, want headers outside idempotentHeaders dropped", hdr.Get("X-Call"))
+		}
+	})
+
+	t.Run("mismatched body", func(t *testing.T) {
+		rec := post("key1", `{"name":"b"}`)
+		if rec.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusUnprocessableEntity)
+		}
+		if resp := decodeError(t, rec); resp.Code != errCodeIdempotencyKeyMismatch {
+			t.Errorf("code = %s, want %s", resp.Code, errCodeIdempotencyKeyMismatch)
+		}
+		if calls != 1 {
+			t.Errorf("handler called %d times, want 1", calls)
+		}
+	})
+
+	t.Run("without a key", func(t *testing.T) {
+		before := calls
+		post("", `{"name":"a"}`)
+		post("", `{"name":"a"}`)
+		if calls != before+2 {
+			t.Errorf("handler called %d times, want 2", calls-before)
+		}
+	})
+
+	t.Run("invalid key", func(t *testing.T) {
+		rec := post("key\x00", `{}`)
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+		}
+		if resp := decodeError(t, rec); resp.Code != errCodeIdempotencyKeyInvalid {
+			t.Errorf("code = %s, want %s", resp.Code, errCodeIdempotencyKeyInvalid)
+		}
+	})
+
+	t.Run("server error not stored", func(t *testing.T) {
+		status = http.StatusInternalServerError
+		before := calls
+		post("key2", `{}`)
+		status = http.StatusCreated
+		rec := post("key2", `{}`)
+		if calls != before+2 || rec.Code != http.StatusCreated || rec.Header().Get("Idempotent-Replayed") != "" {
+			t.Errorf("retry after a 500 = %d after %d calls, want 201 handled again", rec.Code, calls-before)
+		}
+	})
+}
+
+func TestIdempotencyInProgress(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := h.Idempotency(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusCreated)
+	}))
+	post := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/things", strings.NewReader(`{}`))
+		req.Header.Set(idempotencyKeyHeader, "key1")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- post() }()
+	<-started
+
+	rec := post()
+	if rec.Code != http.StatusConflict || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("repeat in progress = %d %v, want 409 with Retry-After", rec.Code, rec.Header())
+	}
+	close(release)
+	if first := <-done; first.Code != http.StatusCreated {
+		t.Fatalf("first status = %d, want %d", first.Code, http.StatusCreated)
+	}
+	if rec := post(); rec.Code != http.StatusCreated || rec.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("repeat after completion = %d %v, want a replayed 201", rec.Code, rec.Header())
+	}
+}
@@ -0,0 +1,279 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/andyfusniak/monolith/service"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	maxOAuthFormBytes = 64 << 10
+
+	errCodeOAuthClientInvalid      = "oauth/client-invalid"
+	errCodeOAuthRedirectURIInvalid = "oauth/redirect-uri-invalid"
+)
+
+// oauthErrorResponse is the error format of RFC 6749 section 5.2, used by
+// the authorization server endpoints in place of apiErrorResponse.
+type oauthErrorResponse struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+// writeJSON writes v without the containerResponse wrapper, as required by
+// the OAuth and OpenID Connect specifications.
+func writeJSON(w http.ResponseWriter, v any, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func oauthClientError(w http.ResponseWriter, status int, e *service.OAuthError) {
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, oauthErrorResponse{Error: e.Code, Description: e.Description}, status)
+}
+
+// OpenIDConfiguration serves the OpenID Connect discovery document.
+func (h *Handler) OpenIDConfiguration() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		writeJSON(w, h.svc.OpenIDConfiguration(), http.StatusOK) // 200
+	}
+}
+
+// JWKS serves the public keys used to sign ID and access tokens.
+func (h *Handler) JWKS() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		cl := log.WithContext(ctx)
+
+		set, err := h.svc.OAuthJWKS(ctx)
+		if err != nil {
+			cl.Errorf("[app] svc.OAuthJWKS(ctx) unexpected error: %+v", err)
+			w.WriteHeader(http.StatusInternalServerError) // 500
+			return
+		}
+
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		writeJSON(w, set, http.StatusOK) // 200
+	}
+}
+
+// Authorize handles authorization requests from OAuth clients. The user
+// must already be signed in. Clients are first party so no consent is
+// asked for; a code is issued and the user agent redirected back to the
+// client.
+func (h *Handler) Authorize() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		cl := log.WithContext(ctx)
+
+		q := r.URL.Query()
+		req := service.AuthorizeRequest{
+			ResponseType:        q.Get("response_type"),
+			ClientID:            q.Get("client_id"),
+			RedirectURI:         q.Get("redirect_uri"),
+			Scope:               q.Get("scope"),
+			State:               q.Get("state"),
+			Nonce:               q.Get("nonce"),
+			CodeChallenge:       q.Get("code_challenge"),
+			CodeChallengeMethod: q.Get("code_challenge_method"),
+		}
+
+		// errors about the client or redirect URI must not redirect
+		_, err := h.svc.ValidateAuthorizeRequest(ctx, &req)
+		if err != nil {
+			var oerr *service.OAuthError
+			switch {
+			case errors.Is(err, service.ErrOAuthClientNotFound):
+				cl.Warnf("[app] authorize with unknown client_id=%s", req.ClientID)
+				clientError(w, http.StatusBadRequest, errCodeOAuthClientInvalid,
+					"client_id is not a registered client") // 400
+			case errors.Is(err, service.ErrOAuthRedirectURIInvalid):
+				cl.Warnf("[app] authorize with unregistered redirect_uri=%q for client_id=%s",
+					req.RedirectURI, req.ClientID)
+				clientError(w, http.StatusBadRequest, errCodeOAuthRedirectURIInvalid,
+					"redirect_uri is not registered for the client") // 400
+			case errors.As(err, &oerr):
+				redirectOAuthError(w, r, req, oerr)
+			default:
+				cl.Errorf("[app] svc.ValidateAuthorizeRequest(ctx, clientID=%q) unexpected error: %+v",
+					req.ClientID, err)
+				w.WriteHeader(http.StatusInternalServerError) // 500
+			}
+			return
+		}
+
+		session, err := h.sessionFromCookie(r)
+		if err != nil {
+			if !errors.Is(err, service.ErrSessionNotFound) {
+				cl.Errorf("[app] svc.AuthenticateSession(ctx, token=*****) unexpected error: %+v", err)
+				w.WriteHeader(http.StatusInternalServerError) // 500
+				return
+			}
+			if q.Get("prompt") == "none" {
+				redirectOAuthError(w, r, req, &service.OAuthError{
+					Code:        service.OAuthErrLoginRequired,
+					Description: "the user is not signed in",
+				})
+				return
+			}
+			clientError(w, http.StatusUnauthorized, errCodeUnauthenticated,
+				"sign in before authorizing the application") // 401
+			return
+		}
+
+		if session.ImpersonatorID != "" {
+			cl.Warnf("[app] authorize client_id=%s refused for impersonation session_id=%s",
+				req.ClientID, session.ID)
+			clientError(w, http.StatusForbidden, errCodeImpersonationReadOnly,
+				"applications cannot be authorized during impersonation") // 403
+			return
+		}
+
+		code, err := h.svc.Authorize(ctx, session.UserID, req)
+		if err != nil {
+			cl.Errorf("[app] svc.Authorize(ctx, userID=%q, clientID=%q) unexpected error: %+v",
+				session.UserID, req.ClientID, err)
+			w.WriteHeader(http.StatusInternalServerError) // 500
+			return
+		}
+
+		cl.Infof("[app] authorized client_id=%s for user_id=%s", req.ClientID, session.UserID)
+		v := url.Values{}
+		v.Set("code", code)
+		if req.State != "" {
+			v.Set("state", req.State)
+		}
+		http.Redirect(w, r, appendQuery(req.RedirectURI, v), http.StatusFound) // 302
+	}
+}
+
+// redirectOAuthError returns an error to the client at its redirect URI
+// (RFC 6749 section 4.1.2.1).
+func redirectOAuthError(w http.ResponseWriter, r *http.Request, req service.AuthorizeRequest, e *service.OAuthError) {
+	v := url.Values{}
+	v.Set("error", e.Code)
+	v.Set("error_description", e.Description)
+	if req.State != "" {
+		v.Set("state", req.State)
+	}
+	http.Redirect(w, r, appendQuery(req.RedirectURI, v), http.StatusFound) // 302
+}
+
+func appendQuery(u string, v url.Values) string {
+	if strings.Contains(u, "?") {
+		return u + "&" + v.Encode()
+	}
+	return u + "?" + v.Encode()
+}
+
+// Token issues tokens for the authorization_code and refresh_token grants.
+// Clients authenticate using HTTP Basic or client_secret_post; public
+// clients send only client_id.
+func (h *Handler) Token() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		cl := log.WithContext(ctx)
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxOAuthFormBytes)
+		if err := r.ParseForm(); err != nil {
+			oauthClientError(w, http.StatusBadRequest, &service.OAuthError{
+				Code:        service.OAuthErrInvalidRequest,
+				Description: "request body must be application/x-www-form-urlencoded",
+			}) // 400
+			return
+		}
+
+		// client authentication (RFC 6749 section 2.3.1)
+		clientID, secret, basic := r.BasicAuth()
+		if basic {
+			clientID, _ = url.QueryUnescape(clientID)
+			secret, _ = url.QueryUnescape(secret)
+		} else {
+			clientID = r.PostForm.Get("client_id")
+			secret = r.PostForm.Get("client_secret")
+		}
+
+		client, err := h.svc.AuthenticateOAuthClient(ctx, clientID, secret)
+		if err == nil {
+			var resp service.OAuthTokenResponse
+			switch grant := r.PostForm.Get("grant_type"); grant {
+			case "authorization_code":
+				resp, err = h.svc.ExchangeOAuthCode(ctx, client, r.PostForm.Get("code"),
+					r.PostForm.Get("redirect_uri"), r.PostForm.Get("code_verifier"))
+			case "refresh_token":
+				resp, err = h.svc.RefreshOAuthToken(ctx, client, r.PostForm.Get("refresh_token"),
+					r.PostForm.Get("scope"))
+			default:
+				err = &service.OAuthError{
+					Code:        service.OAuthErrUnsupportedGrantType,
+					Description: "grant_type must be authorization_code or refresh_token",
+				}
+			}
+			if err == nil {
+				w.Header().Set("Cache-Control", "no-store")
+				w.Header().Set("Pragma", "no-cache")
+				writeJSON(w, resp, http.StatusOK) // 200
+				cl.Infof("[app] issued oauth tokens to client_id=%s", client.ID)
+				return
+			}
+		}
+
+		var oerr *service.OAuthError
+		if !errors.As(err, &oerr) {
+			cl.Errorf("[app] oauth token request for client_id=%q unexpected error: %+v", clientID, err)
+			w.WriteHeader(http.StatusInternalServerError) // 500
+			return
+		}
+
+		cl.Infof("[app] oauth token request for client_id=%q failed: %v", clientID, oerr)
+		if oerr.Code == service.OAuthErrInvalidClient {
+			if basic {
+				w.Header().Set("WWW-Authenticate", `Basic realm="oauth2"`)
+			}
+			oauthClientError(w, http.StatusUnauthorized, oerr) // 401
+			return
+		}
+		oauthClientError(w, http.StatusBadRequest, oerr) // 400
+	}
+}
+
+// UserInfo returns claims about the user identified by a bearer access
+// token.
+func (h *Handler) UserInfo() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		cl := log.WithContext(ctx)
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="oauth2"`)
+			w.WriteHeader(http.StatusUnauthorized) // 401
+			return
+		}
+
+		info, err := h.svc.UserInfo(ctx, token)
+		if err != nil {
+			var oerr *service.OAuthError
+			if errors.As(err, &oerr) {
+				w.Header().Set("WWW-Authenticate",
+					`Bearer realm="oauth2", error="`+oerr.Code+`", error_description="`+oerr.Description+`"`)
+				oauthClientError(w, http.StatusUnauthorized, oerr) // 401
+				return
+			}
+
+			cl.Errorf("[app] svc.UserInfo(ctx, token=*****) unexpected error: %+v", err)
+			w.WriteHeader(http.StatusInternalServerError) // 500
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, info, http.StatusOK) // 200
+	}
+}
@@ -0,0 +1,168 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/andyfusniak/monolith/service"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	oidcStateCookieName = "oidc_state"
+
+	errCodeOIDCProviderNotFound = "auth/oidc-provider-not-found"
+	errCodeOIDCStateInvalid     = "auth/oidc-state-invalid"
+	errCodeOIDCFailed           = "auth/oidc-failed"
+	errCodeOIDCEmailMissing     = "auth/oidc-email-missing"
+)
+
+// OIDCLogin redirects the user agent to the provider to sign in. The state
+// is also set in a short lived cookie so the callback can only be completed
+// by the same browser.
+func (h *Handler) OIDCLogin() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		cl := log.WithContext(ctx)
+
+		provider := r.PathValue("provider")
+		authURL, state, err := h.svc.BeginOIDCLogin(ctx, provider)
+		if err != nil {
+			if errors.Is(err, service.ErrOIDCProviderNotFound) {
+				clientError(w, http.StatusNotFound, errCodeOIDCProviderNotFound,
+					"identity provider not found") // 404
+				return
+			}
+
+			cl.Errorf("[app] svc.BeginOIDCLogin(ctx, provider=%q) unexpected error: %+v", provider, err)
+			w.WriteHeader(http.StatusInternalServerError) // 500
+			return
+		}
+
+		// SameSite Lax so the cookie is sent on the top level redirect
+		// back from the provider
+		http.SetCookie(w, &http.Cookie{
+			Name:     oidcStateCookieName,
+			Value:    state,
+			Path:     "/v1/auth/oidc/",
+			MaxAge:   600,
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(w, r, authURL, http.StatusFound) // 302
+	}
+}
+
+// OIDCCallback completes sign in when the provider redirects back with an
+// authorization code.
+func (h *Handler) OIDCCallback() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		cl := log.WithContext(ctx)
+
+		provider := r.PathValue("provider")
+		q := r.URL.Query()
+
+		// the state cookie is single use
+		http.SetCookie(w, &http.Cookie{
+			Name:     oidcStateCookieName,
+			Path:     "/v1/auth/oidc/",
+			MaxAge:   -1,
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteLaxMode,
+		})
+
+		if e := q.Get("error"); e != "" {
+			cl.Infof("[app] oidc provider=%s returned error=%s description=%q",
+				provider, e, q.Get("error_description"))
+			clientError(w, http.StatusUnauthorized, errCodeOIDCFailed,
+				"sign in with the identity provider failed") // 401
+			return
+		}
+
+		state, code := q.Get("state"), q.Get("code")
+		c, err := r.Cookie(oidcStateCookieName)
+		if err != nil || state == "" || code == "" ||
+			subtle.ConstantTimeCompare([]byte(c.Value), []byte(state)) != 1 {
+			cl.Warnf("[app] oidc callback state mismatch for provider=%s", provider)
+			clientError(w, http.StatusUnauthorized, errCodeOIDCStateInvalid,
+				"sign in request is invalid or has expired; try again") // 401
+			return
+		}
+
+		result, err := h.svc.FinishOIDCLogin(ctx, provider, state, code)
+		if err != nil {
+			switch {
+			case errors.Is(err, service.ErrOIDCProviderNotFound):
+				clientError(w, http.StatusNotFound, errCodeOIDCProviderNotFound,
+					"identity provider not found") // 404
+			case errors.Is(err, service.ErrOIDCStateInvalid):
+				clientError(w, http.StatusUnauthorized, errCodeOIDCStateInvalid,
+					"sign in request is invalid or has expired; try again") // 401
+			case errors.Is(err, service.ErrOIDCLoginFailed):
+				clientError(w, http.StatusUnauthorized, errCodeOIDCFailed,
+					"sign in with the identity provider failed") // 401
+			case errors.Is(err, service.ErrOIDCEmailMissing):
+				clientError(w, http.StatusUnprocessableEntity, errCodeOIDCEmailMissing,
+					"the identity provider did not share a verified email address") // 422
+			default:
+				cl.Errorf("[app] svc.FinishOIDCLogin(ctx, provider=%q) unexpected error: %+v", provider, err)
+				w.WriteHeader(http.StatusInternalServerError) // 500
+			}
+			return
+		}
+
+		// second factor required
+		if result.Challenge != nil {
+			response := containerResponse{Data: result.Challenge}
+			cl.Infof("[app] oidc signin for user user_id=%s requires mfa",
+				result.User.ID)
+			h.respond(ctx, w, r, response, http.StatusOK) // 200
+			return
+		}
+
+		// successful response
+		setSessionCookie(w, result.Session)
+		response := containerResponse{Data: result.User}
+		cl.Infof("[app] successful oidc signin with provider=%s for user user_id=%s email=%s",
+			provider, result.User.ID, result.User.Email)
+		h.respond(ctx, w, r, response, http.StatusCreated) // 201
+	}
+}
+
+// ListUserIdentities returns the external identities linked to the
+// authenticated user.
+func (h *Handler) ListUserIdentities() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		cl := log.WithContext(ctx)
+
+		userID := r.PathValue("user_id")
+		if !isValidUserID(userID) {
+			cl.Warnf("[app] path parameter /users/%s invalid", userID)
+			clientError(w, http.StatusUnprocessableEntity, errCodeUserIDInvalid,
+				"user_id url path parameter is not a valid user id") // 422
+			return
+		}
+		if !isSelf(r, userID) {
+			cl.Warnf("[app] ListUserIdentities: forbidden for user_id=%s", userID)
+			clientError(w, http.StatusForbidden, errCodeForbidden,
+				"you may only view your own account") // 403
+			return
+		}
+
+		identities, err := h.svc.ListUserIdentities(ctx, userID)
+		if err != nil {
+			cl.Errorf("[app] svc.ListUserIdentities(ctx, userID=%q) unexpected error: %+v", userID, err)
+			w.WriteHeader(http.StatusInternalServerError) // 500
+			return
+		}
+
+		// successful response
+		response := containerResponse{Data: identities}
+		h.respond(ctx, w, r, response, http.StatusOK) // 200
+	}
+}
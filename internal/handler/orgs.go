@@ -0,0 +1,369 @@
+package handler
+
+import (
+	"net/http"
+	"net/mail"
+
+	"github.com/andyfusniak/monolith/service"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	errCodeOrgNotFound                = "orgs/not-found"
+	errCodeOrgForbidden               = "orgs/forbidden"
+	errCodeOrgNameInvalid             = "orgs/name-invalid"
+	errCodeOrgRoleInvalid             = "orgs/role-invalid"
+	errCodeOrgMemberNotFound          = "orgs/member-not-found"
+	errCodeOrgLastOwner               = "orgs/last-owner"
+	errCodeOrgAlreadyMember           = "orgs/already-member"
+	errCodeOrgInvitationNotFound      = "orgs/invitation-not-found"
+	errCodeOrgInvitationInvalid       = "orgs/invitation-invalid"
+	errCodeOrgInvitationEmailMismatch = "orgs/invitation-email-mismatch"
+)
+
+// orgError writes the response for an error returned by an organization
+// service method. op names the method for the log.
+func orgError(w http.ResponseWriter, r *http.Request, op string, err error) {
+	cl := log.WithContext(r.Context())
+
+	switch {
+	case errors.Is(err, service.ErrOrgNotFound):
+		clientError(w, http.StatusNotFound, errCodeOrgNotFound,
+			"organization not found") // 404
+	case errors.Is(err, service.ErrOrgForbidden):
+		cl.Warnf("[app] %s: forbidden for org_id=%s", op, r.PathValue("org_id"))
+		clientError(w, http.StatusForbidden, errCodeOrgForbidden,
+			"your role in the organization does not permit this action") // 403
+	case errors.Is(err, service.ErrOrgNameInvalid):
+		clientError(w, http.StatusUnprocessableEntity, errCodeOrgNameInvalid,
+			"name must be between 1 and 100 characters") // 422
+	case errors.Is(err, service.ErrOrgRoleInvalid):
+		clientError(w, http.StatusUnprocessableEntity, errCodeOrgRoleInvalid,
+			`role must be one of "owner", "admin" or "member"`) // 422
+	case errors.Is(err, service.ErrOrgMemberNotFound):
+		clientError(w, http.StatusNotFound, errCodeOrgMemberNotFound,
+			"user is not a member of the organization") // 404
+	case errors.Is(err, service.ErrOrgLastOwner):
+		clientError(w, http.StatusConflict, errCodeOrgLastOwner,
+			"an organization must have at least one owner") // 409
+	case errors.Is(err, service.ErrOrgAlreadyMember):
+		clientError(w, http.StatusConflict, errCodeOrgAlreadyMember,
+			"user is already a member of the organization") // 409
+	case errors.Is(err, service.ErrOrgInvitationNotFound):
+		clientError(w, http.StatusNotFound, errCodeOrgInvitationNotFound,
+			"invitation not found") // 404
+	case errors.Is(err, service.ErrOrgInvitationInvalid):
+		clientError(w, http.StatusNotFound, errCodeOrgInvitationInvalid,
+			"invitation is invalid or has expired") // 404
+	case errors.Is(err, service.ErrOrgInvitationEmailMismatch):
+		clientError(w, http.StatusForbidden, errCodeOrgInvitationEmailMismatch,
+			"invitation was sent to a different email address") // 403
+	default:
+		cl.Errorf("[app] svc.%s unexpected error: %+v", op, err)
+		w.WriteHeader(http.StatusInternalServerError) // 500
+	}
+}
+
+type createOrganizationRequest struct {
+	Name *string `json:"name"`
+}
+
+// CreateOrganization creates an organization owned by the authenticated
+// user.
+func (h *Handler) CreateOrganization() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		cl := log.WithContext(ctx)
+
+		// request body
+		req := createOrganizationRequest{}
+		if err := h.decode(w, r, &req); err != nil {
+			cl.Warn("[app] createOrganizationRequest body decode failed", err)
+			clientError(w, http.StatusBadRequest, errCodeBadRequest, err.Error()) // 400
+			return
+		}
+		if req.Name == nil {
+			cl.Warn("[app] CreateOrganization: validation failed name attribute not set")
+			clientError(w, http.StatusBadRequest, errCodeBadRequest, "name attribute not set") // 400
+			return
+		}
+
+		org, err := h.svc.CreateOrganization(ctx, *req.Name)
+		if err != nil {
+			orgError(w, r, "CreateOrganization", err)
+			return
+		}
+
+		// successful response
+		response := containerResponse{Data: org}
+		h.respond(ctx, w, r, response, http.StatusCreated) // 201
+		cl.Infof("[app] created org_id=%s", org.ID)
+	}
+}
+
+// ListOrganizations returns the organizations the authenticated user is a
+// member of.
+func (h *Handler) ListOrganizations() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		orgs, err := h.svc.ListOrganizations(ctx)
+		if err != nil {
+			orgError(w, r, "ListOrganizations", err)
+			return
+		}
+
+		// successful response
+		response := containerResponse{Data: orgs}
+		h.respond(ctx, w, r, response, http.StatusOK) // 200
+	}
+}
+
+// GetOrganization returns an organization the authenticated user is a
+// member of.
+func (h *Handler) GetOrganization() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		org, err := h.svc.GetOrganization(ctx, r.PathValue("org_id"))
+		if err != nil {
+			orgError(w, r, "GetOrganization", err)
+			return
+		}
+
+		// successful response
+		response := containerResponse{Data: org}
+		h.respond(ctx, w, r, response, http.StatusOK) // 200
+	}
+}
+
+// DeleteOrganization deletes an organization. Owners only.
+func (h *Handler) DeleteOrganization() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		cl := log.WithContext(ctx)
+
+		orgID := r.PathValue("org_id")
+		if err := h.svc.DeleteOrganization(ctx, orgID); err != nil {
+			orgError(w, r, "DeleteOrganization", err)
+			return
+		}
+
+		cl.Infof("[app] deleted org_id=%s", orgID)
+		w.WriteHeader(http.StatusNoContent) // 204
+	}
+}
+
+// ListOrgMembers returns the members of an organization.
+func (h *Handler) ListOrgMembers() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		members, err := h.svc.ListOrgMembers(ctx, r.PathValue("org_id"))
+		if err != nil {
+			orgError(w, r, "ListOrgMembers", err)
+			return
+		}
+
+		// successful response
+		response := containerResponse{Data: members}
+		h.respond(ctx, w, r, response, http.StatusOK) // 200
+	}
+}
+
+type updateOrgMemberRequest struct {
+	Role *string `json:"role"`
+}
+
+// UpdateOrgMember changes the role of a member of an organization.
+func (h *Handler) UpdateOrgMember() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		cl := log.WithContext(ctx)
+
+		// request body
+		req := updateOrgMemberRequest{}
+		if err := h.decode(w, r, &req); err != nil {
+			cl.Warn("[app] updateOrgMemberRequest body decode failed", err)
+			clientError(w, http.StatusBadRequest, errCodeBadRequest, err.Error()) // 400
+			return
+		}
+		if req.Role == nil {
+			cl.Warn("[app] UpdateOrgMember: validation failed role attribute not set")
+			clientError(w, http.StatusBadRequest, errCodeBadRequest, "role attribute not set") // 400
+			return
+		}
+
+		orgID, userID := r.PathValue("org_id"), r.PathValue("user_id")
+		member, err := h.svc.UpdateOrgMemberRole(ctx, orgID, userID, *req.Role)
+		if err != nil {
+			orgError(w, r, "UpdateOrgMemberRole", err)
+			return
+		}
+
+		// successful response
+		response := containerResponse{Data: member}
+		h.respond(ctx, w, r, response, http.StatusOK) // 200
+		cl.Infof("[app] set role=%s for user_id=%s in org_id=%s", member.Role, userID, orgID)
+	}
+}
+
+// RemoveOrgMember removes a member from an organization. Members may
+// remove themselves to leave.
+func (h *Handler) RemoveOrgMember() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		cl := log.WithContext(ctx)
+
+		orgID, userID := r.PathValue("org_id"), r.PathValue("user_id")
+		if err := h.svc.RemoveOrgMember(ctx, orgID, userID); err != nil {
+			orgError(w, r, "RemoveOrgMember", err)
+			return
+		}
+
+		cl.Infof("[app] removed user_id=%s from org_id=%s", userID, orgID)
+		w.WriteHeader(http.StatusNoContent) // 204
+	}
+}
+
+type createOrgInvitationRequest struct {
+	Email *string `json:"email"`
+	Role  *string `json:"role"`
+}
+
+// CreateOrgInvitation invites an email address to join an organization.
+func (h *Handler) CreateOrgInvitation() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		cl := log.WithContext(ctx)
+
+		// request body
+		req := createOrgInvitationRequest{}
+		if err := h.decode(w, r, &req); err != nil {
+			cl.Warn("[app] createOrgInvitationRequest body decode failed", err)
+			clientError(w, http.StatusBadRequest, errCodeBadRequest, err.Error()) // 400
+			return
+		}
+		message, ok := validateCreateOrgInvitationRequest(&req)
+		if !ok {
+			cl.Warnf("[app] CreateOrgInvitation: validation failed %q", message)
+			clientError(w, http.StatusBadRequest, errCodeBadRequest, message) // 400
+			return
+		}
+
+		orgID := r.PathValue("org_id")
+		invitation, err := h.svc.InviteToOrg(ctx, orgID, *req.Email, *req.Role)
+		if err != nil {
+			orgError(w, r, "InviteToOrg", err)
+			return
+		}
+
+		// successful response
+		response := containerResponse{Data: invitation}
+		h.respond(ctx, w, r, response, http.StatusCreated) // 201
+		cl.Infof("[app] created invitation_id=%s for org_id=%s", invitation.ID, orgID)
+	}
+}
+
+func validateCreateOrgInvitationRequest(req *createOrgInvitationRequest) (string, bool) {
+	if req.Email == nil {
+		return "email attribute not set", false
+	}
+	if _, err := mail.ParseAddress(*req.Email); err != nil {
+		return "email attribute must be a valid email address", false
+	}
+	if req.Role == nil {
+		return "role attribute not set", false
+	}
+	return "", true
+}
+
+// ListOrgInvitations returns the pending invitations of an organization.
+func (h *Handler) ListOrgInvitations() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		invitations, err := h.svc.ListOrgInvitations(ctx, r.PathValue("org_id"))
+		if err != nil {
+			orgError(w, r, "ListOrgInvitations", err)
+			return
+		}
+
+		// successful response
+		response := containerResponse{Data: invitations}
+		h.respond(ctx, w, r, response, http.StatusOK) // 200
+	}
+}
+
+// RevokeOrgInvitation deletes a pending invitation.
+func (h *Handler) RevokeOrgInvitation() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		cl := log.WithContext(ctx)
+
+		orgID, invitationID := r.PathValue("org_id"), r.PathValue("invitation_id")
+		if err := h.svc.RevokeOrgInvitation(ctx, orgID, invitationID); err != nil {
+			orgError(w, r, "RevokeOrgInvitation", err)
+			return
+		}
+
+		cl.Infof("[app] revoked invitation_id=%s for org_id=%s", invitationID, orgID)
+		w.WriteHeader(http.StatusNoContent) // 204
+	}
+}
+
+// GetInvitation returns the invitation for the token in an invitation
+// email so it can be shown before it is accepted or declined.
+func (h *Handler) GetInvitation() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		invitation, err := h.svc.GetOrgInvitation(ctx, r.PathValue("token"))
+		if err != nil {
+			orgError(w, r, "GetOrgInvitation", err)
+			return
+		}
+
+		// successful response
+		response := containerResponse{Data: invitation}
+		h.respond(ctx, w, r, response, http.StatusOK) // 200
+	}
+}
+
+// AcceptInvitation adds the authenticated user to the organization of an
+// invitation.
+func (h *Handler) AcceptInvitation() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		cl := log.WithContext(ctx)
+
+		org, err := h.svc.AcceptOrgInvitation(ctx, r.PathValue("token"))
+		if err != nil {
+			orgError(w, r, "AcceptOrgInvitation", err)
+			return
+		}
+
+		// successful response
+		response := containerResponse{Data: org}
+		h.respond(ctx, w, r, response, http.StatusOK) // 200
+		cl.Infof("[app] invitation accepted for org_id=%s", org.ID)
+	}
+}
+
+// DeclineInvitation deletes an invitation. Only the token is required so
+// invitees without an account can decline.
+func (h *Handler) DeclineInvitation() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		cl := log.WithContext(ctx)
+
+		if err := h.svc.DeclineOrgInvitation(ctx, r.PathValue("token")); err != nil {
+			orgError(w, r, "DeclineOrgInvitation", err)
+			return
+		}
+
+		cl.Info("[app] invitation declined")
+		w.WriteHeader(http.StatusNoContent) // 204
+	}
+}
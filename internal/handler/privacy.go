@@ -0,0 +1,183 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/andyfusniak/monolith/service"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	errCodeUserNotFound        = "users/not-found"
+	errCodeUserSoleOwner       = "users/sole-owner"
+	errCodeUserExportNotFound  = "users/export-not-found"
+	errCodeUserExportNotReady  = "users/export-not-ready"
+	errCodeUserExportIDInvalid = "users/export-id-invalid"
+	errCodeUserExportsStopped  = "users/exports-stopped"
+)
+
+// RequestUserExport starts building an archive of the authenticated
+// user's data.
+func (h *Handler) RequestUserExport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		cl := log.WithContext(ctx)
+
+		userID := r.PathValue("user_id")
+		if !isSelf(r, userID) {
+			clientError(w, http.StatusForbidden, errCodeForbidden,
+				"you may only export your own data") // 403
+			return
+		}
+
+		export, err := h.svc.RequestUserExport(ctx, userID)
+		if err != nil {
+			if errors.Is(err, service.ErrUserNotFound) {
+				clientError(w, http.StatusNotFound, errCodeUserNotFound, "user not found") // 404
+				return
+			}
+			if errors.Is(err, service.ErrUserExportsStopped) {
+				clientError(w, http.StatusServiceUnavailable, errCodeUserExportsStopped,
+					"the server is shutting down; try again shortly") // 503
+				return
+			}
+			cl.Errorf("[app] svc.RequestUserExport(ctx, userID=%q) unexpected error: %+v", userID, err)
+			w.WriteHeader(http.StatusInternalServerError) // 500
+			return
+		}
+
+		// successful response
+		cl.Infof("[app] export_id=%s requested for user_id=%s", export.ID, userID)
+		w.Header().Set("Location", fmt.Sprintf("/v1/users/%s/exports/%s", userID, export.ID))
+		h.respond(ctx, w, r, containerResponse{Data: export}, http.StatusAccepted) // 202
+	}
+}
+
+// GetUserExport returns the status of one of the authenticated user's
+// exports.
+func (h *Handler) GetUserExport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		cl := log.WithContext(ctx)
+
+		userID, exportID, ok := userExportPath(w, r)
+		if !ok {
+			return
+		}
+
+		export, err := h.svc.GetUserExport(ctx, userID, exportID)
+		if err != nil {
+			if errors.Is(err, service.ErrUserExportNotFound) {
+				clientError(w, http.StatusNotFound, errCodeUserExportNotFound, "export not found") // 404
+				return
+			}
+			cl.Errorf("[app] svc.GetUserExport(ctx, userID=%q, exportID=%q) unexpected error: %+v",
+				userID, exportID, err)
+			w.WriteHeader(http.StatusInternalServerError) // 500
+			return
+		}
+
+		// successful response
+		h.respond(ctx, w, r, containerResponse{Data: export}, http.StatusOK) // 200
+	}
+}
+
+// DownloadUserExport returns the JSON archive of a ready export as an
+// attachment.
+func (h *Handler) DownloadUserExport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		cl := log.WithContext(ctx)
+
+		userID, exportID, ok := userExportPath(w, r)
+		if !ok {
+			return
+		}
+
+		archive, err := h.svc.UserExportArchive(ctx, userID, exportID)
+		if err != nil {
+			switch {
+			case errors.Is(err, service.ErrUserExportNotFound):
+				clientError(w, http.StatusNotFound, errCodeUserExportNotFound, "export not found") // 404
+			case errors.Is(err, service.ErrUserExportNotReady):
+				clientError(w, http.StatusConflict, errCodeUserExportNotReady,
+					"export is not ready; check its status") // 409
+			default:
+				cl.Errorf("[app] svc.UserExportArchive(ctx, userID=%q, exportID=%q) unexpected error: %+v",
+					userID, exportID, err)
+				w.WriteHeader(http.StatusInternalServerError) // 500
+			}
+			return
+		}
+
+		// successful response
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition",
+			fmt.Sprintf(`attachment; filename="monolith-export-%s.json"`, exportID))
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK) // 200
+		if _, err := w.Write(archive); err != nil {
+			cl.Warnf("[app] write export_id=%s failed: %v", exportID, err)
+		}
+	}
+}
+
+// userExportPath returns the user_id and export_id path values, writing an
+// error response if the caller may not access them.
+func userExportPath(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	userID := r.PathValue("user_id")
+	if !isSelf(r, userID) {
+		clientError(w, http.StatusForbidden, errCodeForbidden,
+			"you may only access your own exports") // 403
+		return "", "", false
+	}
+	exportID := r.PathValue("export_id")
+	if !isValidUserID(exportID) { // same form as user ids
+		clientError(w, http.StatusBadRequest, errCodeUserExportIDInvalid,
+			"export_id path parameter must be a valid export id") // 400
+		return "", "", false
+	}
+	return userID, exportID, true
+}
+
+// EraseUser deletes a user and all their data. Users may erase their own
+// account; admins may erase any account.
+func (h *Handler) EraseUser() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		cl := log.WithContext(ctx)
+
+		userID := r.PathValue("user_id")
+		if !isValidUserID(userID) {
+			clientError(w, http.StatusBadRequest, errCodeUserIDInvalid,
+				"user_id path parameter must be a valid user id") // 400
+			return
+		}
+
+		if err := h.svc.EraseUser(ctx, userID); err != nil {
+			switch {
+			case errors.Is(err, service.ErrNotAdmin):
+				cl.Warnf("[app] erase user_id=%s refused", userID)
+				clientError(w, http.StatusForbidden, errCodeForbidden,
+					"you may only erase your own account, signed in with a session") // 403
+			case errors.Is(err, service.ErrUserNotFound):
+				clientError(w, http.StatusNotFound, errCodeUserNotFound, "user not found") // 404
+			case errors.Is(err, service.ErrUserSoleOwner):
+				clientError(w, http.StatusConflict, errCodeUserSoleOwner,
+					"transfer ownership of your organizations with other members first") // 409
+			default:
+				cl.Errorf("[app] svc.EraseUser(ctx, userID=%q) unexpected error: %+v", userID, err)
+				w.WriteHeader(http.StatusInternalServerError) // 500
+			}
+			return
+		}
+
+		cl.Infof("[app] erased user_id=%s", userID)
+		if isSelf(r, userID) {
+			clearSessionCookie(w)
+		}
+		w.WriteHeader(http.StatusNoContent) // 204
+	}
+}
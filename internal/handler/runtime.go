@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type logLevelRequest struct {
+	Level *string `json:"level"`
+}
+
+type logLevelResponse struct {
+	Level string `json:"level"`
+}
+
+type maintenanceRequest struct {
+	Enabled *bool `json:"enabled"`
+}
+
+type maintenanceResponse struct {
+	Enabled bool `json:"enabled"`
+}
+
+// GetLogLevel returns the level of the standard logger.
+func (h *Handler) GetLogLevel() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.respond(r.Context(), w, r, logLevelResponse{
+			Level: log.GetLevel().String(),
+		}, http.StatusOK) // 200
+	}
+}
+
+// SetLogLevel changes the level of the standard logger until the process
+// restarts, for example to turn on debug logging while investigating a
+// problem.
+func (h *Handler) SetLogLevel() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		cl := log.WithContext(ctx)
+
+		req := logLevelRequest{}
+		if err := h.decode(w, r, &req); err != nil {
+			clientError(w, http.StatusBadRequest, errCodeBadRequest, err.Error()) // 400
+			return
+		}
+		if req.Level == nil {
+			clientError(w, http.StatusBadRequest, errCodeBadRequest,
+				"level attribute must be set") // 400
+			return
+		}
+		level, err := log.ParseLevel(*req.Level)
+		if err != nil {
+			clientError(w, http.StatusBadRequest, errCodeBadRequest,
+				"level must be one of panic, fatal, error, warn, info, debug or trace") // 400
+			return
+		}
+
+		// log at warn so the change is recorded at any level
+		cl.Warnf("[app] log level changed from %s to %s", log.GetLevel(), level)
+		log.SetLevel(level)
+
+		h.respond(ctx, w, r, logLevelResponse{Level: level.String()}, http.StatusOK) // 200
+	}
+}
+
+// GetMaintenance reports whether maintenance mode is on.
+func (h *Handler) GetMaintenance() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.respond(r.Context(), w, r, maintenanceResponse{
+			Enabled: h.svc.InMaintenance(),
+		}, http.StatusOK) // 200
+	}
+}
+
+// SetMaintenance turns maintenance mode on or off.
+func (h *Handler) SetMaintenance() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		cl := log.WithContext(ctx)
+
+		req := maintenanceRequest{}
+		if err := h.decode(w, r, &req); err != nil {
+			clientError(w, http.StatusBadRequest, errCodeBadRequest, err.Error()) // 400
+			return
+		}
+		if req.Enabled == nil {
+			clientError(w, http.StatusBadRequest, errCodeBadRequest,
+				"enabled attribute must be set") // 400
+			return
+		}
+
+		h.svc.SetMaintenance(*req.Enabled)
+		if *req.Enabled {
+			cl.Warn("[app] maintenance mode on; the public API responds 503")
+		} else {
+			cl.Warn("[app] maintenance mode off")
+		}
+
+		h.respond(ctx, w, r, maintenanceResponse{Enabled: *req.Enabled}, http.StatusOK) // 200
+	}
+}
@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestSetLogLevel(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantLevel  log.Level
+	}{
+		{name: "debug", body: `{"level":"debug"}`, wantStatus: http.StatusOK, wantLevel: log.DebugLevel},
+		{name: "upper case", body: `{"level":"WARN"}`, wantStatus: http.StatusOK, wantLevel: log.WarnLevel},
+		{name: "warning", body: `{"level":"warning"}`, wantStatus: http.StatusOK, wantLevel: log.WarnLevel},
+		{name: "unknown level", body: `{"level":"verbose"}`, wantStatus: http.StatusBadRequest, wantLevel: log.InfoLevel},
+		{name: "empty level", body: `{"level":""}`, wantStatus: http.StatusBadRequest, wantLevel: log.InfoLevel},
+		{name: "missing level", body: `{}`, wantStatus: http.StatusBadRequest, wantLevel: log.InfoLevel},
+		{name: "null level", body: `{"level":null}`, wantStatus: http.StatusBadRequest, wantLevel: log.InfoLevel},
+		{name: "level not a string", body: `{"level":5}`, wantStatus: http.StatusBadRequest, wantLevel: log.InfoLevel},
+		{name: "unknown field", body: `{"level":"debug","persist":true}`, wantStatus: http.StatusBadRequest, wantLevel: log.InfoLevel},
+		{name: "not json", body: `debug`, wantStatus: http.StatusBadRequest, wantLevel: log.InfoLevel},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prev := log.GetLevel()
+			t.Cleanup(func() { log.SetLevel(prev) })
+			log.SetLevel(log.InfoLevel)
+
+			h := &Handler{}
+			rec := httptest.NewRecorder()
+			h.SetLogLevel().ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/admin/log-level", strings.NewReader(tt.body)))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d; body %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if got := log.GetLevel(); got != tt.wantLevel {
+				t.Errorf("level = %s, want %s", got, tt.wantLevel)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if resp := decodeError(t, rec); resp.Code != errCodeBadRequest {
+					t.Errorf("code = %s, want %s", resp.Code, errCodeBadRequest)
+				}
+				return
+			}
+			var resp logLevelResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Level != tt.wantLevel.String() {
+				t.Errorf("response level = %s, want %s", resp.Level, tt.wantLevel)
+			}
+		})
+	}
+}
+
+func TestMaintenance(t *testing.T) {
+	h, _ := newTestHandler(t)
+	public := h.Maintenance(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	get := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		public.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/users/me", nil))
+		return rec
+	}
+	set := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.SetMaintenance().ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/admin/maintenance", strings.NewReader(body)))
+		return rec
+	}
+	enabled := func() bool {
+		rec := httptest.NewRecorder()
+		h.GetMaintenance().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/maintenance", nil))
+		var resp maintenanceResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp.Enabled
+	}
+
+	if rec := get(); rec.Code != http.StatusNoContent {
+		t.Fatalf("status before maintenance = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+
+	if rec := set(`{"enabled":true}`); rec.Code != http.StatusOK {
+		t.Fatalf("enable status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if !enabled() {
+		t.Error("GetMaintenance reports maintenance off after enabling it")
+	}
+	rec := get()
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status in maintenance = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	if ra := rec.Header().Get("Retry-After"); ra != maintenanceRetryAfter {
+		t.Errorf("Retry-After = %q, want %s", ra, maintenanceRetryAfter)
+	}
+	if resp := decodeError(t, rec); resp.Status != http.StatusServiceUnavailable || resp.Code != errCodeMaintenance {
+		t.Errorf("body = %+v, want status 503 and code %s", resp, errCodeMaintenance)
+	}
+
+	for _, body := range []string{`{}`, `{"enabled":"no"}`, `{"enabled":false,"until":"later"}`} {
+		if rec := set(body); rec.Code != http.StatusBadRequest {
+			t.Errorf("PUT %s status = %d, want %d", body, rec.Code, http.StatusBadRequest)
+		}
+	}
+	if !enabled() {
+		t.Error("a rejected request turned maintenance off")
+	}
+
+	if rec := set(`{"enabled":false}`); rec.Code != http.StatusOK {
+		t.Fatalf("disable status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec := get(); rec.Code != http.StatusNoContent {
+		t.Errorf("status after maintenance = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+}
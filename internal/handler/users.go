@@ -2,9 +2,11 @@ package handler
 
 import (
 	"fmt"
+	"math"
 	"net/http"
 	"net/mail"
 	"regexp"
+	"strconv"
 
 	"github.com/pkg/errors"
 
@@ -13,7 +15,12 @@ import (
 )
 
 const (
-	errCodeUserIDInvalid = "users/user-id-invalid"
+	errCodeUserIDInvalid         = "users/user-id-invalid"
+	errCodeUserPasswordTooShort  = "users/password-too-short"
+	errCodeUserPasswordTooLong   = "users/password-too-long"
+	errCodeUserPasswordTooCommon = "users/password-too-common"
+	errCodeUserPasswordBreached  = "users/password-breached"
+	errCodeUserPasswordIncorrect = "users/password-incorrect"
 )
 
 type createUserRequest struct {
@@ -44,6 +51,12 @@ func (h *Handler) CreateUser() http.HandlerFunc {
 		fmt.Printf("%#v\n", h)
 		user, err := h.svc.CreateUser(ctx, *req.Email, *req.Password)
 		if err != nil {
+			if code, message, ok := h.passwordPolicyError(err); ok {
+				cl.Infof("[app] CreateUser: password rejected for email=%s: %v", *req.Email, err)
+				clientError(w, http.StatusUnprocessableEntity, code, message) // 422
+				return
+			}
+
 			cl.Errorf("[app] svc.CreateUser(ctx, req.Email=%q, req.Password=%q) unexpected error: %+v",
 				*req.Email, "*****", err)
 			w.WriteHeader(http.StatusInternalServerError) // 500
@@ -76,6 +89,83 @@ func validateCreateUserRequest(req *createUserRequest) (string, bool) {
 	return "", true
 }
 
+type changePasswordRequest struct {
+	CurrentPassword *string `json:"current_password"`
+	NewPassword     *string `json:"new_password"`
+}
+
+// ChangePassword replaces the password of the authenticated user.
+func (h *Handler) ChangePassword() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		cl := log.WithContext(ctx)
+
+		userID := r.PathValue("user_id")
+		if !isSelf(r, userID) {
+			clientError(w, http.StatusForbidden, errCodeForbidden,
+				"you may only change your own password") // 403
+			return
+		}
+
+		// request body
+		req := changePasswordRequest{}
+		if err := h.decode(w, r, &req); err != nil {
+			cl.Warn("[app] changePasswordRequest body decode failed", err)
+			clientError(w, http.StatusBadRequest, errCodeBadRequest, err.Error()) // 400
+			return
+		}
+		if req.CurrentPassword == nil || req.NewPassword == nil {
+			cl.Warn("[app] ChangePassword: validation failed current_password or new_password attribute not set")
+			clientError(w, http.StatusBadRequest, errCodeBadRequest,
+				"current_password and new_password attributes must be set") // 400
+			return
+		}
+
+		if err := h.svc.ChangePassword(ctx, userID, *req.CurrentPassword, *req.NewPassword); err != nil {
+			if errors.Is(err, service.ErrUserWrongPassword) {
+				cl.Infof("[app] ChangePassword: current password incorrect for user_id=%s", userID)
+				clientError(w, http.StatusForbidden, errCodeUserPasswordIncorrect,
+					"current password is incorrect") // 403
+				return
+			}
+			if code, message, ok := h.passwordPolicyError(err); ok {
+				cl.Infof("[app] ChangePassword: password rejected for user_id=%s: %v", userID, err)
+				clientError(w, http.StatusUnprocessableEntity, code, message) // 422
+				return
+			}
+
+			cl.Errorf("[app] svc.ChangePassword(ctx, userID=%q) unexpected error: %+v", userID, err)
+			w.WriteHeader(http.StatusInternalServerError) // 500
+			return
+		}
+
+		cl.Infof("[app] changed password for user_id=%s", userID)
+		w.WriteHeader(http.StatusNoContent) // 204
+	}
+}
+
+// passwordPolicyError maps password policy errors returned by the service
+// to an API error code and message.
+func (h *Handler) passwordPolicyError(err error) (code, message string, ok bool) {
+	policy := h.svc.PasswordPolicy()
+	switch {
+	case errors.Is(err, service.ErrUserPasswordTooShort):
+		return errCodeUserPasswordTooShort,
+			fmt.Sprintf("password must be at least %d characters", policy.MinRunes), true
+	case errors.Is(err, service.ErrUserPasswordTooLong):
+		return errCodeUserPasswordTooLong,
+			fmt.Sprintf("password must be at most %d characters", policy.MaxRunes), true
+	case errors.Is(err, service.ErrUserPasswordTooCommon):
+		return errCodeUserPasswordTooCommon,
+			"password is too common", true
+	case errors.Is(err, service.ErrUserPasswordBreached):
+		return errCodeUserPasswordBreached,
+			"password has appeared in a data breach and cannot be used", true
+	default:
+		return "", "", false
+	}
+}
+
 func (h *Handler) GetUser() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
@@ -116,6 +206,7 @@ func (h *Handler) GetUser() http.HandlerFunc {
 type signInRequest struct {
 	Email    *string `json:"email"`
 	Password *string `json:"password"`
+	Tokens   *bool   `json:"tokens"`
 }
 
 func (h *Handler) SignIn() http.HandlerFunc {
@@ -138,24 +229,48 @@ func (h *Handler) SignIn() http.HandlerFunc {
 		}
 
 		// signin user
-		user, err := h.svc.VerifyUserPassword(ctx, *req.Email, *req.Password)
+		result, err := h.svc.SignInWithPassword(ctx, *req.Email, *req.Password, clientIP(r), signInGrant(req.Tokens))
 		if err != nil {
+			var throttled *service.SignInThrottledError
+			if errors.As(err, &throttled) {
+				retryAfter := int(math.Ceil(throttled.RetryAfter.Seconds()))
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+				if errors.Is(err, service.ErrSignInAccountLocked) {
+					cl.Warnf("[app] signin refused for locked account email=%s", *req.Email)
+					clientError(w, http.StatusLocked, errCodeAccountLocked,
+						"too many failed sign in attempts; try again later") // 423
+					return
+				}
+				cl.Warnf("[app] signin refused for client ip=%s", clientIP(r))
+				clientError(w, http.StatusTooManyRequests, errCodeTooManyAttempts,
+					"too many failed sign in attempts; try again later") // 429
+				return
+			}
+
 			if errors.Is(err, service.ErrUserNotFound) || errors.Is(err, service.ErrUserWrongPassword) {
 				cl.Infof("[app] verify user password failed for user email=%s", *req.Email)
 				w.WriteHeader(http.StatusUnauthorized) // 401
 				return
 			}
 
-			cl.Errorf("[app] svc.VerifyUserPassword(ctx, req.Email=%s, req.Password=*****) unexpected error: %+v", *req.Email, err)
+			cl.Errorf("[app] svc.SignInWithPassword(ctx, req.Email=%s, req.Password=*****) unexpected error: %+v", *req.Email, err)
 			w.WriteHeader(http.StatusInternalServerError) // 500
 			return
 		}
 
+		// second factor required
+		if result.Challenge != nil {
+			response := containerResponse{Data: result.Challenge}
+			cl.Infof("[app] signin for user user_id=%s requires mfa",
+				result.User.ID)
+			h.respond(ctx, w, r, response, http.StatusOK) // 200
+			return
+		}
+
 		// successful response
-		response := containerResponse{Data: user}
 		cl.Infof("[app] successful signin for user user_id=%s email=%s",
-			user.ID, user.Email)
-		h.respond(ctx, w, r, response, http.StatusCreated) // 201
+			result.User.ID, result.User.Email)
+		h.respondSignedIn(ctx, w, r, result)
 	}
 }
 
@@ -0,0 +1,166 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/andyfusniak/monolith/internal/webauthn"
+	"github.com/andyfusniak/monolith/service"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	errCodeWebAuthnNotConfigured    = "webauthn/not-configured"
+	errCodeWebAuthnChallengeInvalid = "webauthn/challenge-invalid"
+	errCodeWebAuthnFailed           = "webauthn/verification-failed"
+	errCodeWebAuthnCredentialExists = "webauthn/credential-exists"
+)
+
+type publicKeyResponse struct {
+	PublicKey any `json:"publicKey"`
+}
+
+// webauthnError writes the client error for err returning true, or false
+// if err is not a known WebAuthn error.
+func webauthnError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, service.ErrWebAuthnNotConfigured):
+		clientError(w, http.StatusNotImplemented, errCodeWebAuthnNotConfigured,
+			"webauthn is not configured") // 501
+	case errors.Is(err, service.ErrWebAuthnChallengeInvalid):
+		clientError(w, http.StatusUnauthorized, errCodeWebAuthnChallengeInvalid,
+			"challenge is invalid or has expired; begin the ceremony again") // 401
+	case errors.Is(err, service.ErrWebAuthnVerificationFailed):
+		clientError(w, http.StatusUnauthorized, errCodeWebAuthnFailed,
+			"credential could not be verified") // 401
+	case errors.Is(err, service.ErrWebAuthnCredentialExists):
+		clientError(w, http.StatusConflict, errCodeWebAuthnCredentialExists,
+			"credential is already registered") // 409
+	default:
+		return false
+	}
+	return true
+}
+
+// WebAuthnRegisterBegin returns credential creation options for the
+// authenticated user.
+func (h *Handler) WebAuthnRegisterBegin() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		cl := log.WithContext(ctx)
+
+		p, _ := service.PrincipalFromContext(ctx)
+		opts, err := h.svc.BeginWebAuthnRegistration(ctx, p.UserID)
+		if err != nil {
+			if webauthnError(w, err) {
+				return
+			}
+			cl.Errorf("[app] svc.BeginWebAuthnRegistration(ctx, userID=%q) unexpected error: %+v", p.UserID, err)
+			w.WriteHeader(http.StatusInternalServerError) // 500
+			return
+		}
+
+		// successful response
+		response := containerResponse{Data: publicKeyResponse{PublicKey: opts}}
+		h.respond(ctx, w, r, response, http.StatusOK) // 200
+	}
+}
+
+// WebAuthnRegisterFinish verifies the authenticator attestation response
+// and stores the new credential for the authenticated user.
+func (h *Handler) WebAuthnRegisterFinish() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		cl := log.WithContext(ctx)
+
+		// request body
+		req := webauthn.AttestationResponse{}
+		if err := h.decode(w, r, &req); err != nil {
+			cl.Warn("[app] webauthn attestation response body decode failed", err)
+			clientError(w, http.StatusBadRequest, errCodeBadRequest, err.Error()) // 400
+			return
+		}
+
+		p, _ := service.PrincipalFromContext(ctx)
+		cred, err := h.svc.FinishWebAuthnRegistration(ctx, p.UserID, req)
+		if err != nil {
+			if webauthnError(w, err) {
+				cl.Infof("[app] webauthn registration failed for user_id=%s: %v", p.UserID, err)
+				return
+			}
+			cl.Errorf("[app] svc.FinishWebAuthnRegistration(ctx, userID=%q) unexpected error: %+v", p.UserID, err)
+			w.WriteHeader(http.StatusInternalServerError) // 500
+			return
+		}
+
+		// successful response
+		response := containerResponse{Data: cred}
+		h.respond(ctx, w, r, response, http.StatusCreated) // 201
+		cl.Infof("[app] webauthn credential %s registered for user_id=%s", cred.ID, p.UserID)
+	}
+}
+
+// WebAuthnLoginBegin returns credential request options.
+func (h *Handler) WebAuthnLoginBegin() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		cl := log.WithContext(ctx)
+
+		opts, err := h.svc.BeginWebAuthnLogin(ctx)
+		if err != nil {
+			if webauthnError(w, err) {
+				return
+			}
+			cl.Errorf("[app] svc.BeginWebAuthnLogin(ctx) unexpected error: %+v", err)
+			w.WriteHeader(http.StatusInternalServerError) // 500
+			return
+		}
+
+		// successful response
+		response := containerResponse{Data: publicKeyResponse{PublicKey: opts}}
+		h.respond(ctx, w, r, response, http.StatusOK) // 200
+	}
+}
+
+// WebAuthnLoginFinish verifies the authenticator assertion response and
+// signs the user in.
+func (h *Handler) WebAuthnLoginFinish() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		cl := log.WithContext(ctx)
+
+		// request body
+		req := webauthn.AssertionResponse{}
+		if err := h.decode(w, r, &req); err != nil {
+			cl.Warn("[app] webauthn assertion response body decode failed", err)
+			clientError(w, http.StatusBadRequest, errCodeBadRequest, err.Error()) // 400
+			return
+		}
+
+		result, err := h.svc.FinishWebAuthnLogin(ctx, req)
+		if err != nil {
+			if webauthnError(w, err) {
+				cl.Infof("[app] webauthn login failed: %v", err)
+				return
+			}
+			cl.Errorf("[app] svc.FinishWebAuthnLogin(ctx) unexpected error: %+v", err)
+			w.WriteHeader(http.StatusInternalServerError) // 500
+			return
+		}
+
+		// second factor required
+		if result.Challenge != nil {
+			response := containerResponse{Data: result.Challenge}
+			cl.Infof("[app] webauthn signin for user user_id=%s requires mfa", result.User.ID)
+			h.respond(ctx, w, r, response, http.StatusOK) // 200
+			return
+		}
+
+		// successful response
+		setSessionCookie(w, result.Session)
+		response := containerResponse{Data: result.User}
+		cl.Infof("[app] successful webauthn signin for user user_id=%s email=%s",
+			result.User.ID, result.User.Email)
+		h.respond(ctx, w, r, response, http.StatusCreated) // 201
+	}
+}
@@ -0,0 +1,87 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"math/big"
+
+	"github.com/pkg/errors"
+)
+
+// JWK is a public JSON Web Key (RFC 7517). Only RSA and P-256 EC keys are
+// supported.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// EC
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// Key returns the key with the given key ID.
+func (s JWKS) Key(kid string) (JWK, bool) {
+	for _, k := range s.Keys {
+		if k.Kid == kid {
+			return k, true
+		}
+	}
+	return JWK{}, false
+}
+
+// PublicKey decodes the key parameters.
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := Encoding.DecodeString(k.N)
+		if err != nil {
+			return nil, errors.Wrap(err, "jwt: jwk n invalid")
+		}
+		e, err := Encoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("jwt: jwk e invalid")
+		}
+		exp := 0
+		for _, b := range e {
+			exp = exp<<8 | int(b)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exp}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, errors.Errorf("jwt: jwk curve %q not supported", k.Crv)
+		}
+		x, err := Encoding.DecodeString(k.X)
+		if err != nil {
+			return nil, errors.Wrap(err, "jwt: jwk x invalid")
+		}
+		y, err := Encoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, errors.Wrap(err, "jwt: jwk y invalid")
+		}
+		pub := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("jwt: jwk point not on curve")
+		}
+		return pub, nil
+	default:
+		return nil, errors.Errorf("jwt: jwk key type %q not supported", k.Kty)
+	}
+}
@@ -0,0 +1,93 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"math/big"
+
+	"github.com/pkg/errors"
+)
+
+// Sign returns a compact serialized token for claims signed with key. The
+// key must be an *rsa.PrivateKey for RS256 or a P-256 *ecdsa.PrivateKey
+// for ES256, matching h.Alg.
+func Sign(h Header, key crypto.Signer, claims any) (string, error) {
+	hb, err := json.Marshal(h)
+	if err != nil {
+		return "", errors.Wrap(err, "jwt: encode header failed")
+	}
+	cb, err := json.Marshal(claims)
+	if err != nil {
+		return "", errors.Wrap(err, "jwt: encode claims failed")
+	}
+
+	signed := Encoding.EncodeToString(hb) + "." + Encoding.EncodeToString(cb)
+	digest := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	switch h.Alg {
+	case RS256:
+		k, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return "", ErrAlgorithmUnsupported
+		}
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:]); err != nil {
+			return "", errors.Wrap(err, "jwt: rsa sign failed")
+		}
+	case ES256:
+		k, ok := key.(*ecdsa.PrivateKey)
+		if !ok || k.Curve != elliptic.P256() {
+			return "", ErrAlgorithmUnsupported
+		}
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			return "", errors.Wrap(err, "jwt: ecdsa sign failed")
+		}
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	default:
+		return "", ErrAlgorithmUnsupported
+	}
+
+	return signed + "." + Encoding.EncodeToString(sig), nil
+}
+
+// NewJWK returns the public JWK for pub.
+func NewJWK(kid, alg string, pub crypto.PublicKey) (JWK, error) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA",
+			Kid: kid,
+			Use: "sig",
+			Alg: alg,
+			N:   Encoding.EncodeToString(k.N.Bytes()),
+			E:   Encoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() {
+			return JWK{}, ErrAlgorithmUnsupported
+		}
+		x := make([]byte, 32)
+		y := make([]byte, 32)
+		k.X.FillBytes(x)
+		k.Y.FillBytes(y)
+		return JWK{
+			Kty: "EC",
+			Kid: kid,
+			Use: "sig",
+			Alg: alg,
+			Crv: "P-256",
+			X:   Encoding.EncodeToString(x),
+			Y:   Encoding.EncodeToString(y),
+		}, nil
+	default:
+		return JWK{}, ErrAlgorithmUnsupported
+	}
+}
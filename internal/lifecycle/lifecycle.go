@@ -0,0 +1,197 @@
+// Package lifecycle starts the components of the server in order and stops
+// them in reverse order within a deadline.
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Component is a part of the server with a lifetime managed by a Manager.
+// Either function may be nil.
+type Component struct {
+	Name string
+
+	// Start starts the component, returning once it is running. Work that
+	// continues in the background reports a failure using Manager.Fail.
+	Start func(ctx context.Context) error
+
+	// Stop stops the component, returning once it has stopped or ctx is
+	// done.
+	Stop func(ctx context.Context) error
+}
+
+// Manager runs a list of components.
+type Manager struct {
+	components []Component
+	timeout    time.Duration
+	failed     chan error
+}
+
+// New returns a Manager that allows timeout for all components to stop.
+func New(timeout time.Duration) *Manager {
+	return &Manager{
+		timeout: timeout,
+		failed:  make(chan error, 1),
+	}
+}
+
+// Add appends c to the components. Components are started in the order
+// they are added, so a component may depend on those added before it,
+// and stopped in reverse.
+func (m *Manager) Add(c Component) {
+	m.components = append(m.components, c)
+}
+
+// Fail reports that a running component has failed, stopping the
+// Manager. Only the first failure is reported.
+func (m *Manager) Fail(err error) {
+	select {
+	case m.failed <- err:
+	default:
+	}
+}
+
+// Run starts each component in turn, waits until ctx is done or a
+// component fails, then stops the started components in reverse order.
+// If a component fails to start the components before it are stopped.
+//
+// Every started component is given the chance to stop even if one fails
+// to or the deadline passes. The error returned joins the failure that
+// stopped the Manager, if any, with every component that failed to stop.
+func (m *Manager) Run(ctx context.Context) error {
+	var runErr error
+	started := 0
+	for _, c := range m.components {
+		if c.Start != nil {
+			if err := c.Start(ctx); err != nil {
+				runErr = errors.Wrapf(err, "[lifecycle] start %s failed", c.Name)
+				log.Errorf("%v", runErr)
+				break
+			}
+		}
+		log.Debugf("[lifecycle] started %s", c.Name)
+		started++
+	}
+
+	if runErr == nil {
+		select {
+		case <-ctx.Done():
+			log.Infof("[lifecycle] stopping %d components within %s", started, m.timeout)
+		case err := <-m.failed:
+			runErr = errors.Wrap(err, "[lifecycle] component failed")
+			log.Errorf("%v; stopping", runErr)
+		}
+	}
+
+	stopErr := m.stop(started)
+	if runErr != nil && stopErr != nil {
+		return fmt.Errorf("%w; %w", runErr, stopErr)
+	}
+	if runErr != nil {
+		return runErr
+	}
+	return stopErr
+}
+
+// stop stops the first n components in reverse order.
+func (m *Manager) stop(n int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	var failed []string
+	for i := n - 1; i >= 0; i-- {
+		c := m.components[i]
+		if c.Stop == nil {
+			continue
+		}
+		start := time.Now()
+		if err := c.Stop(ctx); err != nil {
+			log.Errorf("[lifecycle] stop %s failed after %s: %v", c.Name, time.Since(start).Round(time.Millisecond), err)
+			failed = append(failed, c.Name)
+			continue
+		}
+		log.Infof("[lifecycle] stopped %s in %s", c.Name, time.Since(start).Round(time.Millisecond))
+	}
+
+	if len(failed) > 0 {
+		return errors.Errorf("[lifecycle] %d components failed to stop: %v", len(failed), failed)
+	}
+	return nil
+}
+
+// HTTPServer returns a component that serves srv. The listener is opened
+// by Start, so a port in use fails the start; Stop waits for requests in
+// flight to finish, closing their connections if the deadline passes.
+func HTTPServer(m *Manager, name string, srv *http.Server) Component {
+	return Component{
+		Name: name,
+		Start: func(ctx context.Context) error {
+			ln, err := net.Listen("tcp", srv.Addr)
+			if err != nil {
+				return err
+			}
+			log.Infof("[main] %s listening on %s", name, ln.Addr())
+			go func() {
+				if err := srv.Serve(ln); err != http.ErrServerClosed {
+					m.Fail(errors.Wrapf(err, "%s", name))
+				}
+			}()
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			if err := srv.Shutdown(ctx); err != nil {
+				srv.Close()
+				return err
+			}
+			return nil
+		},
+	}
+}
+
+// Periodic returns a component that calls fn every interval, logging its
+// errors, until it is stopped. Stop cancels a call in progress and waits
+// for it to return.
+func Periodic(name string, interval time.Duration, fn func(ctx context.Context) error) Component {
+	var cancel context.CancelFunc
+	done := make(chan struct{})
+
+	return Component{
+		Name: name,
+		Start: func(ctx context.Context) error {
+			ctx, cancel = context.WithCancel(context.WithoutCancel(ctx))
+			go func() {
+				defer close(done)
+
+				ticker := time.NewTicker(interval)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-ctx.Done():
+						return
+					case <-ticker.C:
+						if err := fn(ctx); err != nil && ctx.Err() == nil {
+							log.Errorf("[lifecycle] %s failed: %+v", name, err)
+						}
+					}
+				}
+			}()
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			cancel()
+			select {
+			case <-done:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+	}
+}
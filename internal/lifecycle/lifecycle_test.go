@@ -0,0 +1,208 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// recorder records the order components start and stop in.
+type recorder struct {
+	events []string
+}
+
+// component returns a component named name that records its start and
+// stop, returning startErr and stopErr.
+func (r *recorder) component(name string, startErr, stopErr error) Component {
+	return Component{
+		Name: name,
+		Start: func(ctx context.Context) error {
+			r.events = append(r.events, "start "+name)
+			return startErr
+		},
+		Stop: func(ctx context.Context) error {
+			r.events = append(r.events, "stop "+name)
+			return stopErr
+		},
+	}
+}
+
+// cancelled returns a context that is already done, so Run stops as soon
+// as every component has started.
+func cancelled() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}
+
+func TestRunStopsInReverseOrder(t *testing.T) {
+	r := &recorder{}
+	m := New(time.Second)
+	m.Add(r.component("database", nil, nil))
+	m.Add(Component{Name: "no functions"})
+	m.Add(r.component("server", nil, nil))
+	m.Add(r.component("readiness", nil, nil))
+
+	if err := m.Run(cancelled()); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	want := []string{
+		"start database", "start server", "start readiness",
+		"stop readiness", "stop server", "stop database",
+	}
+	if !reflect.DeepEqual(r.events, want) {
+		t.Errorf("events = %q, want %q", r.events, want)
+	}
+}
+
+func TestRunStartFailureStopsStarted(t *testing.T) {
+	r := &recorder{}
+	m := New(time.Second)
+	m.Add(r.component("database", nil, nil))
+	m.Add(r.component("cache", nil, nil))
+	m.Add(r.component("server", errors.New("address already in use"), nil))
+	m.Add(r.component("readiness", nil, nil))
+
+	// the context is never done; a failed start must not wait for it
+	err := m.Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "start server failed") {
+		t.Fatalf("Run error = %v, want the start failure", err)
+	}
+
+	want := []string{
+		"start database", "start cache", "start server",
+		"stop cache", "stop database",
+	}
+	if !reflect.DeepEqual(r.events, want) {
+		t.Errorf("events = %q, want %q", r.events, want)
+	}
+}
+
+func TestRunStopFailure(t *testing.T) {
+	r := &recorder{}
+	m := New(time.Second)
+	m.Add(r.component("database", nil, nil))
+	m.Add(r.component("server", nil, errors.New("close failed")))
+	m.Add(r.component("readiness", nil, nil))
+
+	err := m.Run(cancelled())
+	if err == nil || !strings.Contains(err.Error(), "1 components failed to stop: [server]") {
+		t.Fatalf("Run error = %v, want the stop failure", err)
+	}
+
+	// the components after the failure are still stopped
+	want := []string{
+		"start database", "start server", "start readiness",
+		"stop readiness", "stop server", "stop database",
+	}
+	if !reflect.DeepEqual(r.events, want) {
+		t.Errorf("events = %q, want %q", r.events, want)
+	}
+}
+
+func TestRunJoinsStartAndStopFailures(t *testing.T) {
+	r := &recorder{}
+	m := New(time.Second)
+	m.Add(r.component("database", nil, errors.New("close failed")))
+	m.Add(r.component("server", errors.New("address already in use"), nil))
+
+	err := m.Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "start server failed") ||
+		!strings.Contains(err.Error(), "failed to stop: [database]") {
+		t.Fatalf("Run error = %v, want both failures", err)
+	}
+}
+
+func TestRunComponentFails(t *testing.T) {
+	r := &recorder{}
+	m := New(time.Second)
+	m.Add(r.component("database", nil, nil))
+	failure := errors.New("serve failed")
+	m.Add(Component{
+		Name: "server",
+		Start: func(ctx context.Context) error {
+			go m.Fail(failure)
+			return nil
+		},
+	})
+
+	err := m.Run(context.Background())
+	if !errors.Is(err, failure) {
+		t.Fatalf("Run error = %v, want %v", err, failure)
+	}
+	if want := []string{"start database", "stop database"}; !reflect.DeepEqual(r.events, want) {
+		t.Errorf("events = %q, want %q", r.events, want)
+	}
+}
+
+func TestRunStopDeadline(t *testing.T) {
+	r := &recorder{}
+	m := New(20 * time.Millisecond)
+	m.Add(r.component("database", nil, nil))
+	m.Add(Component{
+		Name: "server",
+		Stop: func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	})
+
+	err := m.Run(cancelled())
+	if err == nil || !strings.Contains(err.Error(), "[server]") {
+		t.Fatalf("Run error = %v, want the server to fail to stop", err)
+	}
+	// the database is given the chance to stop after the deadline passed
+	if want := []string{"start database", "stop database"}; !reflect.DeepEqual(r.events, want) {
+		t.Errorf("events = %q, want %q", r.events, want)
+	}
+}
+
+func TestHTTPServerPortInUse(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	m := New(time.Second)
+	m.Add(HTTPServer(m, "server", &http.Server{Addr: ln.Addr().String()}))
+	if err := m.Run(context.Background()); err == nil {
+		t.Fatal("Run with the port in use returned no error")
+	}
+}
+
+func TestPeriodic(t *testing.T) {
+	var calls atomic.Int32
+	c := Periodic("cleanup", time.Millisecond, func(ctx context.Context) error {
+		calls.Add(1)
+		return nil
+	})
+
+	ctx := context.Background()
+	if err := c.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for calls.Load() < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if err := c.Stop(ctx); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+
+	n := calls.Load()
+	if n < 2 {
+		t.Fatalf("fn called %d times, want at least 2", n)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if got := calls.Load(); got != n {
+		t.Errorf("fn called %d times after Stop", got-n)
+	}
+}
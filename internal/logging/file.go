@@ -0,0 +1,121 @@
+// Package logging configures where and how logs are written.
+package logging
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// RotatingFile is a log file that is rotated once it reaches a maximum
+// size. On rotation path is renamed to path.1, path.1 to path.2 and so on,
+// keeping at most maxBackups old files.
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// OpenRotatingFile opens path for appending, creating it if necessary.
+// maxSize is in bytes.
+func OpenRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	rf := &RotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *RotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return errors.Wrapf(err, "[logging] open log file %s failed", rf.path)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return errors.Wrapf(err, "[logging] stat log file %s failed", rf.path)
+	}
+	rf.f = f
+	rf.size = fi.Size()
+	return nil
+}
+
+// Write appends p to the file, first rotating it if p would take it over
+// the maximum size. A single write larger than the maximum size is
+// written to an empty file. If rotation fails the error is printed to
+// stderr, p is appended to the current file and rotation is retried on
+// the next write.
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.f == nil {
+		return 0, os.ErrClosed
+	}
+	if rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+	}
+
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// rotate moves the files aside and opens a new one. The current file
+// stays open, following any rename, until the new one is open, so a
+// failure leaves rf writing to it and the next rotation starts from
+// wherever this one stopped.
+func (rf *RotatingFile) rotate() error {
+	if rf.maxBackups > 0 {
+		for i := rf.maxBackups - 1; i >= 1; i-- {
+			from := fmt.Sprintf("%s.%d", rf.path, i)
+			if _, err := os.Stat(from); err == nil {
+				if err := os.Rename(from, fmt.Sprintf("%s.%d", rf.path, i+1)); err != nil {
+					return errors.Wrapf(err, "[logging] rename %s failed", from)
+				}
+			}
+		}
+		if _, err := os.Stat(rf.path); err == nil {
+			if err := os.Rename(rf.path, rf.path+".1"); err != nil {
+				return errors.Wrapf(err, "[logging] rename %s failed", rf.path)
+			}
+		}
+	} else if err := os.Remove(rf.path); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "[logging] remove %s failed", rf.path)
+	}
+
+	old := rf.f
+	if err := rf.open(); err != nil {
+		return err
+	}
+	if err := old.Close(); err != nil {
+		return errors.Wrapf(err, "[logging] close rotated log file %s failed", rf.path)
+	}
+	return nil
+}
+
+// Close closes the file.
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.f == nil {
+		return nil
+	}
+	err := rf.f.Close()
+	rf.f = nil
+	return err
+}
@@ -0,0 +1,79 @@
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestRotatingFileRotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	rf, err := OpenRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+
+	for _, line := range []string{"first\n", "second\n", "third\n"} {
+		if _, err := rf.Write([]byte(line)); err != nil {
+			t.Fatalf("Write(%q): %v", line, err)
+		}
+	}
+
+	for name, want := range map[string]string{
+		path:        "third\n",
+		path + ".1": "second\n",
+		path + ".2": "first\n",
+	} {
+		if got := readFile(t, name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestRotatingFileRotateFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	rf, err := OpenRotatingFile(path, 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+
+	// a non-empty directory in the way of the backup makes the rename fail
+	if err := os.MkdirAll(filepath.Join(path+".1", "x"), 0750); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := rf.Write([]byte("first\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rf.Write([]byte("second\n")); err != nil {
+		t.Fatalf("Write during failed rotation: %v", err)
+	}
+	if got := readFile(t, path); got != "first\nsecond\n" {
+		t.Fatalf("%s after failed rotation = %q, want both lines", path, got)
+	}
+
+	// rotation is retried once the problem is fixed
+	if err := os.RemoveAll(path + ".1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rf.Write([]byte("third\n")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, path); got != "third\n" {
+		t.Errorf("%s = %q, want %q", path, got, "third\n")
+	}
+	if got := readFile(t, path+".1"); got != "first\nsecond\n" {
+		t.Errorf("%s.1 = %q, want %q", path, got, "first\nsecond\n")
+	}
+}
@@ -0,0 +1,113 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// SlogHandler is a slog.Handler that writes records to a logrus logger,
+// so code using log/slog shares the logger's output, format, level and
+// hooks. Records are logged with their context, so hooks that read the
+// context add the same fields as they do for log.WithContext. Attributes
+// become fields; groups prefix the keys of the attributes within them,
+// separated by a dot.
+type SlogHandler struct {
+	logger *log.Logger
+	fields log.Fields
+	group  string
+}
+
+// NewSlogHandler returns a slog.Handler writing to logger.
+func NewSlogHandler(logger *log.Logger) *SlogHandler {
+	return &SlogHandler{
+		logger: logger,
+		fields: log.Fields{},
+	}
+}
+
+// Enabled reports whether logger logs at level.
+func (h *SlogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return h.logger.IsLevelEnabled(logrusLevel(level))
+}
+
+// Handle logs r.
+func (h *SlogHandler) Handle(ctx context.Context, r slog.Record) error {
+	fields := make(log.Fields, len(h.fields)+r.NumAttrs())
+	for k, v := range h.fields {
+		fields[k] = v
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		addAttr(fields, h.group, a)
+		return true
+	})
+
+	e := h.logger.WithContext(ctx).WithFields(fields)
+	e.Time = r.Time
+	e.Log(logrusLevel(r.Level), r.Message)
+	return nil
+}
+
+// WithAttrs returns a handler that adds attrs to every record.
+func (h *SlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	fields := make(log.Fields, len(h.fields)+len(attrs))
+	for k, v := range h.fields {
+		fields[k] = v
+	}
+	for _, a := range attrs {
+		addAttr(fields, h.group, a)
+	}
+	return &SlogHandler{logger: h.logger, fields: fields, group: h.group}
+}
+
+// WithGroup returns a handler that prefixes the keys of later attributes
+// with name.
+func (h *SlogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &SlogHandler{logger: h.logger, fields: h.fields, group: joinKey(h.group, name)}
+}
+
+func addAttr(fields log.Fields, group string, a slog.Attr) {
+	v := a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if v.Kind() == slog.KindGroup {
+		prefix := group
+		if a.Key != "" {
+			prefix = joinKey(group, a.Key)
+		}
+		for _, ga := range v.Group() {
+			addAttr(fields, prefix, ga)
+		}
+		return
+	}
+	fields[joinKey(group, a.Key)] = v.Any()
+}
+
+func joinKey(group, key string) string {
+	if group == "" {
+		return key
+	}
+	return group + "." + key
+}
+
+// logrusLevel maps a slog level to the logrus level with the same
+// meaning. Levels between the named slog levels round down.
+func logrusLevel(level slog.Level) log.Level {
+	switch {
+	case level >= slog.LevelError:
+		return log.ErrorLevel
+	case level >= slog.LevelWarn:
+		return log.WarnLevel
+	case level >= slog.LevelInfo:
+		return log.InfoLevel
+	case level >= slog.LevelDebug:
+		return log.DebugLevel
+	default:
+		return log.TraceLevel
+	}
+}
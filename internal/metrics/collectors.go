@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"database/sql"
+	"os"
+	"runtime"
+	"sort"
+	"time"
+)
+
+// DBStats returns a Collector of the connection pool statistics of dbs,
+// labelled by pool name.
+func DBStats(dbs map[string]*sql.DB) Collector {
+	names := make([]string, 0, len(dbs))
+	for name := range dbs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return CollectorFunc(func(w *Writer) {
+		stats := make([]sql.DBStats, len(names))
+		for i, name := range names {
+			stats[i] = dbs[name].Stats()
+		}
+		family := func(name, help, typ string, v func(s sql.DBStats) float64) {
+			w.Family(name, help, typ)
+			for i, s := range stats {
+				w.Sample(name, v(s), Label{"pool", names[i]})
+			}
+		}
+
+		family("monolith_db_max_open_connections", "Maximum number of open connections to the database.", "gauge",
+			func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) })
+		family("monolith_db_open_connections", "Number of established connections, in use and idle.", "gauge",
+			func(s sql.DBStats) float64 { return float64(s.OpenConnections) })
+		family("monolith_db_in_use_connections", "Number of connections in use.", "gauge",
+			func(s sql.DBStats) float64 { return float64(s.InUse) })
+		family("monolith_db_idle_connections", "Number of idle connections.", "gauge",
+			func(s sql.DBStats) float64 { return float64(s.Idle) })
+		family("monolith_db_wait_count_total", "Total number of connections waited for.", "counter",
+			func(s sql.DBStats) float64 { return float64(s.WaitCount) })
+		family("monolith_db_wait_duration_seconds_total", "Total time blocked waiting for a new connection.", "counter",
+			func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() })
+		family("monolith_db_max_idle_closed_total", "Total number of connections closed due to SetMaxIdleConns.", "counter",
+			func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) })
+		family("monolith_db_max_idle_time_closed_total", "Total number of connections closed due to SetConnMaxIdleTime.", "counter",
+			func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) })
+		family("monolith_db_max_lifetime_closed_total", "Total number of connections closed due to SetConnMaxLifetime.", "counter",
+			func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) })
+	})
+}
+
+// FileSize returns a Collector of a gauge of the size of the file at
+// path in bytes. The gauge is zero if the file does not exist.
+func FileSize(name, help, path string) Collector {
+	return CollectorFunc(func(w *Writer) {
+		var size int64
+		if fi, err := os.Stat(path); err == nil {
+			size = fi.Size()
+		}
+		w.Family(name, help, "gauge")
+		w.Sample(name, float64(size))
+	})
+}
+
+// Runtime returns a Collector of Go runtime and process metrics, named
+// as they are by the official Prometheus client so existing dashboards
+// work.
+func Runtime() Collector {
+	start := float64(time.Now().Unix())
+
+	return CollectorFunc(func(w *Writer) {
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+
+		gauge := func(name, help string, v float64) {
+			w.Family(name, help, "gauge")
+			w.Sample(name, v)
+		}
+		counter := func(name, help string, v float64) {
+			w.Family(name, help, "counter")
+			w.Sample(name, v)
+		}
+
+		w.Family("go_info", "Information about the Go environment.", "gauge")
+		w.Sample("go_info", 1, Label{"version", runtime.Version()})
+		gauge("go_goroutines", "Number of goroutines that currently exist.", float64(runtime.NumGoroutine()))
+		gauge("go_memstats_alloc_bytes", "Number of bytes allocated and still in use.", float64(ms.Alloc))
+		counter("go_memstats_alloc_bytes_total", "Total number of bytes allocated, even if freed.", float64(ms.TotalAlloc))
+		gauge("go_memstats_sys_bytes", "Number of bytes obtained from system.", float64(ms.Sys))
+		counter("go_memstats_mallocs_total", "Total number of mallocs.", float64(ms.Mallocs))
+		counter("go_memstats_frees_total", "Total number of frees.", float64(ms.Frees))
+		gauge("go_memstats_heap_alloc_bytes", "Number of heap bytes allocated and still in use.", float64(ms.HeapAlloc))
+		gauge("go_memstats_heap_inuse_bytes", "Number of heap bytes that are in use.", float64(ms.HeapInuse))
+		gauge("go_memstats_heap_idle_bytes", "Number of heap bytes waiting to be used.", float64(ms.HeapIdle))
+		gauge("go_memstats_heap_objects", "Number of allocated objects.", float64(ms.HeapObjects))
+		gauge("go_memstats_stack_inuse_bytes", "Number of bytes in use by the stack allocator.", float64(ms.StackInuse))
+		gauge("go_memstats_next_gc_bytes", "Number of heap bytes when next garbage collection will take place.", float64(ms.NextGC))
+		counter("go_gc_cycles_total", "Number of completed GC cycles.", float64(ms.NumGC))
+		counter("go_gc_pause_seconds_total", "Total time spent in GC stop-the-world pauses.", float64(ms.PauseTotalNs)/1e9)
+		gauge("process_start_time_seconds", "Start time of the process since unix epoch in seconds.", start)
+	})
+}
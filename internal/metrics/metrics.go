@@ -0,0 +1,233 @@
+// Package metrics collects application metrics and writes them in the
+// Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ContentType is the media type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Collector writes one or more metric families.
+type Collector interface {
+	Collect(w *Writer)
+}
+
+// CollectorFunc adapts a function to a Collector.
+type CollectorFunc func(w *Writer)
+
+// Collect calls f(w).
+func (f CollectorFunc) Collect(w *Writer) {
+	f(w)
+}
+
+// Registry is a set of collectors written together when scraped.
+type Registry struct {
+	mu         sync.Mutex
+	collectors []Collector
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds c to the registry. Collectors are written in the order
+// they are registered.
+func (r *Registry) Register(c Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// NewHistogram creates a Histogram and registers it.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := NewHistogram(name, help, buckets, labels...)
+	r.Register(h)
+	return h
+}
+
+// WriteText writes every registered metric to out.
+func (r *Registry) WriteText(out io.Writer) error {
+	r.mu.Lock()
+	collectors := append([]Collector(nil), r.collectors...)
+	r.mu.Unlock()
+
+	w := &Writer{bw: bufio.NewWriter(out)}
+	for _, c := range collectors {
+		c.Collect(w)
+	}
+	return w.bw.Flush()
+}
+
+// ServeHTTP writes the registry for a Prometheus scrape.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	if err := r.WriteText(w); err != nil {
+		log.WithContext(req.Context()).Warnf("[metrics] write metrics failed: %v", err)
+	}
+}
+
+// Label is a metric label name and value.
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Writer writes metric families in the text exposition format. Each
+// family is started with Family followed by its samples.
+type Writer struct {
+	bw *bufio.Writer
+}
+
+// Family starts a metric family of type typ, one of counter, gauge or
+// histogram.
+func (w *Writer) Family(name, help, typ string) {
+	w.bw.WriteString("# HELP ")
+	w.bw.WriteString(name)
+	w.bw.WriteByte(' ')
+	w.bw.WriteString(helpReplacer.Replace(help))
+	w.bw.WriteString("\n# TYPE ")
+	w.bw.WriteString(name)
+	w.bw.WriteByte(' ')
+	w.bw.WriteString(typ)
+	w.bw.WriteByte('\n')
+}
+
+// Sample writes a single sample.
+func (w *Writer) Sample(name string, v float64, labels ...Label) {
+	w.bw.WriteString(name)
+	if len(labels) > 0 {
+		w.bw.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				w.bw.WriteByte(',')
+			}
+			w.bw.WriteString(l.Name)
+			w.bw.WriteString(`="`)
+			w.bw.WriteString(labelReplacer.Replace(l.Value))
+			w.bw.WriteByte('"')
+		}
+		w.bw.WriteByte('}')
+	}
+	w.bw.WriteByte(' ')
+	w.bw.WriteString(formatFloat(v))
+	w.bw.WriteByte('\n')
+}
+
+var (
+	helpReplacer  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// DefBuckets are histogram buckets in seconds suited to request latencies.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Histogram counts observations in cumulative buckets, partitioned by a
+// fixed set of labels. A nil Histogram discards observations, so optional
+// instrumentation needs no checks.
+type Histogram struct {
+	name    string
+	help    string
+	buckets []float64
+	labels  []string
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	values []string
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram returns a Histogram with the given upper bucket bounds,
+// which must be sorted, and label names.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return &Histogram{
+		name:    name,
+		help:    help,
+		buckets: buckets,
+		labels:  labels,
+		series:  make(map[string]*histogramSeries),
+	}
+}
+
+// Observe records v for the series with the given label values, which
+// must match the label names of the histogram in number and order.
+func (h *Histogram) Observe(v float64, values ...string) {
+	if h == nil {
+		return
+	}
+	key := strings.Join(values, "\xff")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{
+			values: append([]string(nil), values...),
+			counts: make([]uint64, len(h.buckets)),
+		}
+		h.series[key] = s
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.sum += v
+	s.count++
+}
+
+// Collect writes the histogram with its series sorted by label values.
+func (h *Histogram) Collect(w *Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	keys := make([]string, 0, len(h.series))
+	for k := range h.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	w.Family(h.name, h.help, "histogram")
+	for _, k := range keys {
+		s := h.series[k]
+		labels := make([]Label, len(h.labels), len(h.labels)+1)
+		for i, name := range h.labels {
+			labels[i] = Label{Name: name, Value: s.values[i]}
+		}
+
+		var cumulative uint64
+		for i, le := range h.buckets {
+			cumulative += s.counts[i]
+			w.Sample(h.name+"_bucket", float64(cumulative), append(labels, Label{"le", formatFloat(le)})...)
+		}
+		w.Sample(h.name+"_bucket", float64(s.count), append(labels, Label{"le", "+Inf"})...)
+		w.Sample(h.name+"_sum", s.sum, labels...)
+		w.Sample(h.name+"_count", float64(s.count), labels...)
+	}
+}
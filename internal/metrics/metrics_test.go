@@ -0,0 +1,137 @@
+package metrics
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriterEscaping(t *testing.T) {
+	r := NewRegistry()
+	r.Register(CollectorFunc(func(w *Writer) {
+		w.Family("test_gauge", "Help with a \\ backslash\nand a newline.", "gauge")
+		w.Sample("test_gauge", 1.5, Label{"path", `C:\dir "quoted"` + "\nnext"}, Label{"pool", "rw"})
+		w.Sample("test_gauge", 0)
+	}))
+
+	var b strings.Builder
+	if err := r.WriteText(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP test_gauge Help with a \\ backslash\nand a newline.
+# TYPE test_gauge gauge
+test_gauge{path="C:\\dir \"quoted\"\nnext",pool="rw"} 1.5
+test_gauge 0
+`
+	if b.String() != want {
+		t.Errorf("WriteText =\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestFormatFloat(t *testing.T) {
+	tests := []struct {
+		v    float64
+		want string
+	}{
+		{v: 0, want: "0"},
+		{v: 42, want: "42"},
+		{v: 0.005, want: "0.005"},
+		{v: 1e21, want: "1e+21"},
+		{v: -2.5, want: "-2.5"},
+		{v: math.Inf(1), want: "+Inf"},
+		{v: math.Inf(-1), want: "-Inf"},
+		{v: math.NaN(), want: "NaN"},
+	}
+	for _, tt := range tests {
+		if got := formatFloat(tt.v); got != tt.want {
+			t.Errorf("formatFloat(%v) = %s, want %s", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	r := NewRegistry()
+	h := r.NewHistogram("http_request_duration_seconds", "Request latency.", []float64{0.1, 0.5, 1}, "method", "status")
+
+	// on a bucket bound counts in that bucket
+	for _, v := range []float64{0.05, 0.1, 0.3, 2} {
+		h.Observe(v, "GET", "200")
+	}
+	h.Observe(0.7, "POST", "201")
+
+	var b strings.Builder
+	if err := r.WriteText(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP http_request_duration_seconds Request latency.
+# TYPE http_request_duration_seconds histogram
+http_request_duration_seconds_bucket{method="GET",status="200",le="0.1"} 2
+http_request_duration_seconds_bucket{method="GET",status="200",le="0.5"} 3
+http_request_duration_seconds_bucket{method="GET",status="200",le="1"} 3
+http_request_duration_seconds_bucket{method="GET",status="200",le="+Inf"} 4
+http_request_duration_seconds_sum{method="GET",status="200"} 2.45
+http_request_duration_seconds_count{method="GET",status="200"} 4
+http_request_duration_seconds_bucket{method="POST",status="201",le="0.1"} 0
+http_request_duration_seconds_bucket{method="POST",status="201",le="0.5"} 0
+http_request_duration_seconds_bucket{method="POST",status="201",le="1"} 1
+http_request_duration_seconds_bucket{method="POST",status="201",le="+Inf"} 1
+http_request_duration_seconds_sum{method="POST",status="201"} 0.7
+http_request_duration_seconds_count{method="POST",status="201"} 1
+`
+	if b.String() != want {
+		t.Errorf("WriteText =\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestHistogramNil(t *testing.T) {
+	var h *Histogram
+	h.Observe(1, "GET") // must not panic
+}
+
+func TestRegistryServeHTTP(t *testing.T) {
+	r := NewRegistry()
+	r.Register(FileSize("test_file_bytes", "Size of a missing file.", "/nonexistent/file"))
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if ct := rec.Header().Get("Content-Type"); ct != ContentType {
+		t.Errorf("Content-Type = %q, want %q", ct, ContentType)
+	}
+	want := "# HELP test_file_bytes Size of a missing file.\n# TYPE test_file_bytes gauge\ntest_file_bytes 0\n"
+	if rec.Body.String() != want {
+		t.Errorf("body = %q, want %q", rec.Body.String(), want)
+	}
+}
+
+func TestRuntimeCollector(t *testing.T) {
+	r := NewRegistry()
+	r.Register(Runtime())
+
+	var b strings.Builder
+	if err := r.WriteText(&b); err != nil {
+		t.Fatal(err)
+	}
+	// every sample belongs to the family declared before it
+	var family string
+	for _, line := range strings.Split(strings.TrimSpace(b.String()), "\n") {
+		if name, ok := strings.CutPrefix(line, "# TYPE "); ok {
+			family = strings.Fields(name)[0]
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		name := strings.FieldsFunc(line, func(r rune) bool { return r == '{' || r == ' ' })[0]
+		if name != family {
+			t.Errorf("sample %q follows family %s", line, family)
+		}
+	}
+	for _, name := range []string{"go_goroutines", "go_memstats_alloc_bytes", "process_start_time_seconds"} {
+		if !strings.Contains(b.String(), "\n"+name+" ") {
+			t.Errorf("no %s sample", name)
+		}
+	}
+}
@@ -0,0 +1,465 @@
+// Package oidc implements the client side of the OAuth 2.0 authorization
+// code flow with PKCE (RFC 7636) and OpenID Connect ID token validation.
+//
+// Providers that publish an OpenID Connect discovery document only need an
+// issuer. Plain OAuth 2.0 providers without ID tokens, such as GitHub, are
+// supported by configuring the endpoints explicitly; the user is then
+// identified using the userinfo endpoint.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andyfusniak/monolith/internal/jwt"
+	"github.com/pkg/errors"
+)
+
+const (
+	maxResponseBytes = 1 << 20
+	clockLeeway      = time.Minute
+
+	// minimum time between JWKS fetches triggered by an unknown key ID
+	jwksRefreshInterval = time.Minute
+)
+
+var (
+	ErrIDTokenInvalid = errors.New("oidc: id token invalid")
+)
+
+// Config describes a provider. Either Issuer must be set, or AuthURL,
+// TokenURL and UserInfoURL.
+type Config struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+
+	// Endpoints override those in the discovery document.
+	AuthURL     string
+	TokenURL    string
+	UserInfoURL string
+
+	// TrustEmail treats email addresses from the provider as verified even
+	// when the email_verified claim is absent. Only set this for providers
+	// known to return verified addresses only.
+	TrustEmail bool
+}
+
+// Metadata is the subset of the discovery document (OpenID Connect
+// Discovery 1.0 section 3) used by the client.
+type Metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserInfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider is an OAuth 2.0 or OpenID Connect provider. It is safe for
+// concurrent use. The discovery document and keys are fetched on first use
+// and cached.
+type Provider struct {
+	cfg    Config
+	client *http.Client
+
+	mu          sync.Mutex
+	meta        *Metadata
+	jwks        jwt.JWKS
+	jwksFetched time.Time
+}
+
+// NewProvider returns a new provider. If client is nil a client with a 10
+// second timeout is used.
+func NewProvider(cfg Config, client *http.Client) *Provider {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	}
+	return &Provider{
+		cfg:    cfg,
+		client: client,
+	}
+}
+
+// Name returns the provider name.
+func (p *Provider) Name() string {
+	return p.cfg.Name
+}
+
+// Metadata returns the provider endpoints, fetching the discovery document
+// if an issuer is configured.
+func (p *Provider) Metadata(ctx context.Context) (Metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.meta != nil {
+		return *p.meta, nil
+	}
+
+	m := Metadata{}
+	if p.cfg.Issuer != "" {
+		u := strings.TrimRight(p.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+		if err := p.getJSON(ctx, u, "", &m); err != nil {
+			return Metadata{}, err
+		}
+		if m.Issuer != p.cfg.Issuer {
+			return Metadata{}, errors.Errorf("oidc: discovery issuer %q does not match %q", m.Issuer, p.cfg.Issuer)
+		}
+	}
+	if p.cfg.AuthURL != "" {
+		m.AuthorizationEndpoint = p.cfg.AuthURL
+	}
+	if p.cfg.TokenURL != "" {
+		m.TokenEndpoint = p.cfg.TokenURL
+	}
+	if p.cfg.UserInfoURL != "" {
+		m.UserInfoEndpoint = p.cfg.UserInfoURL
+	}
+	if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" {
+		return Metadata{}, errors.Errorf("oidc: provider %q has no authorization or token endpoint", p.cfg.Name)
+	}
+
+	p.meta = &m
+	return m, nil
+}
+
+// NewVerifier returns a random PKCE code verifier.
+func NewVerifier() (string, error) {
+	return randomString(32)
+}
+
+// NewState returns a random value suitable for the state or nonce
+// parameters.
+func NewState() (string, error) {
+	return randomString(32)
+}
+
+// CodeChallenge returns the S256 code challenge for verifier.
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return jwt.Encoding.EncodeToString(sum[:])
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "oidc: failed to read random bytes")
+	}
+	return jwt.Encoding.EncodeToString(b), nil
+}
+
+// AuthCodeURL returns the URL to redirect the user to in order to sign in
+// with the provider.
+func (p *Provider) AuthCodeURL(ctx context.Context, redirectURI, state, nonce, verifier string) (string, error) {
+	m, err := p.Metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.cfg.ClientID)
+	v.Set("redirect_uri", redirectURI)
+	v.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", CodeChallenge(verifier))
+	v.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(m.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return m.AuthorizationEndpoint + sep + v.Encode(), nil
+}
+
+// TokenResponse is a successful token endpoint response.
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	IDToken      string `json:"id_token"`
+}
+
+// tokenError is an error response from the token endpoint (RFC 6749
+// section 5.2).
+type tokenError struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+// Exchange redeems an authorization code at the token endpoint.
+func (p *Provider) Exchange(ctx context.Context, code, redirectURI, verifier string) (TokenResponse, error) {
+	m, err := p.Metadata(ctx)
+	if err != nil {
+		return TokenResponse{}, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURI)
+	form.Set("code_verifier", verifier)
+	form.Set("client_id", p.cfg.ClientID)
+	if p.cfg.ClientSecret != "" {
+		form.Set("client_secret", p.cfg.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return TokenResponse{}, errors.Wrap(err, "oidc: new token request failed")
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return TokenResponse{}, errors.Wrap(err, "oidc: token request failed")
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return TokenResponse{}, errors.Wrap(err, "oidc: read token response failed")
+	}
+
+	// some providers report errors with a 200 status
+	var te tokenError
+	if err := json.Unmarshal(body, &te); err == nil && te.Error != "" {
+		return TokenResponse{}, errors.Errorf("oidc: token endpoint error %s: %s", te.Error, te.Description)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return TokenResponse{}, errors.Errorf("oidc: token endpoint returned status %d", resp.StatusCode)
+	}
+
+	var tr TokenResponse
+	if err := json.Unmarshal(body, &tr); err != nil {
+		return TokenResponse{}, errors.Wrap(err, "oidc: decode token response failed")
+	}
+	if tr.AccessToken == "" {
+		return TokenResponse{}, errors.New("oidc: token response has no access_token")
+	}
+	return tr, nil
+}
+
+// Identity is the user as asserted by the provider.
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+// Identity returns the user identity for a token response. If the response
+// includes an ID token it is validated against nonce; otherwise the
+// userinfo endpoint is called with the access token.
+func (p *Provider) Identity(ctx context.Context, tr TokenResponse, nonce string) (Identity, error) {
+	var id Identity
+	if tr.IDToken != "" {
+		claims, err := p.VerifyIDToken(ctx, tr.IDToken, nonce)
+		if err != nil {
+			return Identity{}, err
+		}
+		id = Identity{
+			Subject:       claims.Subject,
+			Email:         claims.Email,
+			EmailVerified: bool(claims.EmailVerified),
+		}
+	} else {
+		var err error
+		if id, err = p.UserInfo(ctx, tr.AccessToken); err != nil {
+			return Identity{}, err
+		}
+	}
+
+	if id.Subject == "" {
+		return Identity{}, errors.New("oidc: provider did not return a subject")
+	}
+	if p.cfg.TrustEmail && id.Email != "" {
+		id.EmailVerified = true
+	}
+	return id, nil
+}
+
+// IDTokenClaims are the claims of an ID token used by the client.
+type IDTokenClaims struct {
+	jwt.Claims
+	AuthorizedParty string   `json:"azp,omitempty"`
+	Nonce           string   `json:"nonce,omitempty"`
+	Email           string   `json:"email,omitempty"`
+	EmailVerified   flexBool `json:"email_verified,omitempty"`
+}
+
+// VerifyIDToken validates an ID token as described in OpenID Connect Core
+// 1.0 section 3.1.3.7.
+func (p *Provider) VerifyIDToken(ctx context.Context, raw, nonce string) (IDTokenClaims, error) {
+	m, err := p.Metadata(ctx)
+	if err != nil {
+		return IDTokenClaims{}, err
+	}
+	if m.JWKSURI == "" {
+		return IDTokenClaims{}, errors.Errorf("oidc: provider %q has no jwks_uri", p.cfg.Name)
+	}
+
+	tok, err := jwt.Parse(raw)
+	if err != nil {
+		return IDTokenClaims{}, errors.Wrap(ErrIDTokenInvalid, err.Error())
+	}
+	key, err := p.key(ctx, m.JWKSURI, tok.Header.Kid)
+	if err != nil {
+		return IDTokenClaims{}, err
+	}
+	if key.Alg != "" && key.Alg != tok.Header.Alg {
+		return IDTokenClaims{}, errors.Wrap(ErrIDTokenInvalid, "alg does not match key")
+	}
+	pub, err := key.PublicKey()
+	if err != nil {
+		return IDTokenClaims{}, err
+	}
+	if err := tok.Verify(pub); err != nil {
+		return IDTokenClaims{}, errors.Wrap(ErrIDTokenInvalid, err.Error())
+	}
+
+	var c IDTokenClaims
+	if err := tok.DecodeClaims(&c); err != nil {
+		return IDTokenClaims{}, errors.Wrap(ErrIDTokenInvalid, err.Error())
+	}
+	if err := c.Validate(time.Now(), m.Issuer, p.cfg.ClientID, clockLeeway); err != nil {
+		return IDTokenClaims{}, errors.Wrap(ErrIDTokenInvalid, err.Error())
+	}
+	if len(c.Audience) > 1 && c.AuthorizedParty != p.cfg.ClientID {
+		return IDTokenClaims{}, errors.Wrap(ErrIDTokenInvalid, "azp does not match client_id")
+	}
+	if c.Nonce != nonce {
+		return IDTokenClaims{}, errors.Wrap(ErrIDTokenInvalid, "nonce mismatch")
+	}
+	return c, nil
+}
+
+// key returns the signing key with the given key ID, refetching the key
+// set if the key is unknown so provider key rotation is picked up.
+func (p *Provider) key(ctx context.Context, jwksURI, kid string) (jwt.JWK, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if k, ok := p.findKey(kid); ok {
+		return k, nil
+	}
+	if time.Since(p.jwksFetched) < jwksRefreshInterval {
+		return jwt.JWK{}, errors.Wrapf(ErrIDTokenInvalid, "unknown key id %q", kid)
+	}
+
+	var set jwt.JWKS
+	if err := p.getJSON(ctx, jwksURI, "", &set); err != nil {
+		return jwt.JWK{}, err
+	}
+	p.jwks = set
+	p.jwksFetched = time.Now()
+
+	if k, ok := p.findKey(kid); ok {
+		return k, nil
+	}
+	return jwt.JWK{}, errors.Wrapf(ErrIDTokenInvalid, "unknown key id %q", kid)
+}
+
+// findKey looks up a signing key. If the token has no key ID the set must
+// hold exactly one signing key.
+func (p *Provider) findKey(kid string) (jwt.JWK, bool) {
+	if kid != "" {
+		return p.jwks.Key(kid)
+	}
+	var found []jwt.JWK
+	for _, k := range p.jwks.Keys {
+		if k.Use == "" || k.Use == "sig" {
+			found = append(found, k)
+		}
+	}
+	if len(found) != 1 {
+		return jwt.JWK{}, false
+	}
+	return found[0], true
+}
+
+// UserInfo fetches the user from the userinfo endpoint. As well as the
+// standard sub claim, a numeric id (as returned by GitHub) is accepted as
+// the subject.
+func (p *Provider) UserInfo(ctx context.Context, accessToken string) (Identity, error) {
+	m, err := p.Metadata(ctx)
+	if err != nil {
+		return Identity{}, err
+	}
+	if m.UserInfoEndpoint == "" {
+		return Identity{}, errors.Errorf("oidc: provider %q has no userinfo endpoint", p.cfg.Name)
+	}
+
+	var v struct {
+		Sub           string      `json:"sub"`
+		ID            json.Number `json:"id"`
+		Email         string      `json:"email"`
+		EmailVerified flexBool    `json:"email_verified"`
+	}
+	if err := p.getJSON(ctx, m.UserInfoEndpoint, accessToken, &v); err != nil {
+		return Identity{}, err
+	}
+
+	id := Identity{
+		Subject:       v.Sub,
+		Email:         v.Email,
+		EmailVerified: bool(v.EmailVerified),
+	}
+	if id.Subject == "" {
+		id.Subject = v.ID.String()
+	}
+	return id, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, u, bearer string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return errors.Wrapf(err, "oidc: new request for %s failed", u)
+	}
+	req.Header.Set("Accept", "application/json")
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "oidc: get %s failed", u)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("oidc: get %s returned status %d", u, resp.StatusCode)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseBytes)).Decode(v); err != nil {
+		return errors.Wrapf(err, "oidc: decode %s failed", u)
+	}
+	return nil
+}
+
+// flexBool accepts both JSON booleans and the strings "true" and "false",
+// as some providers encode email_verified as a string.
+type flexBool bool
+
+func (b *flexBool) UnmarshalJSON(data []byte) error {
+	switch string(data) {
+	case `true`, `"true"`:
+		*b = true
+	case `false`, `"false"`, `null`:
+		*b = false
+	default:
+		return fmt.Errorf("oidc: invalid boolean %s", data)
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+package oidc_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/andyfusniak/monolith/internal/jwt"
+	"github.com/andyfusniak/monolith/internal/oidc"
+	"github.com/andyfusniak/monolith/internal/oidc/oidctest"
+)
+
+const (
+	testClientID    = "monolith"
+	testRedirectURI = "https://example.com/v1/auth/oidc/test/callback"
+)
+
+func newProvider(t *testing.T) (*oidctest.Provider, *oidc.Provider) {
+	t.Helper()
+	stub := oidctest.NewProvider(t, testClientID)
+	p := oidc.NewProvider(oidc.Config{
+		Name:     "test",
+		Issuer:   stub.Issuer(),
+		ClientID: testClientID,
+	}, stub.Client())
+	return stub, p
+}
+
+func TestMetadata(t *testing.T) {
+	stub, p := newProvider(t)
+
+	m, err := p.Metadata(context.Background())
+	if err != nil {
+		t.Fatalf("Metadata: %v", err)
+	}
+	if m.Issuer != stub.Issuer() || m.TokenEndpoint != stub.URL+"/token" || m.JWKSURI != stub.URL+"/jwks" {
+		t.Errorf("Metadata = %+v", m)
+	}
+}
+
+func TestMetadataIssuerMismatch(t *testing.T) {
+	stub := oidctest.NewProvider(t, testClientID)
+	p := oidc.NewProvider(oidc.Config{
+		Name:     "test",
+		Issuer:   stub.Issuer() + "/other",
+		ClientID: testClientID,
+	}, stub.Client())
+
+	if _, err := p.Metadata(context.Background()); err == nil {
+		t.Fatal("Metadata succeeded, want issuer mismatch error")
+	}
+}
+
+// authorize runs the flow up to the callback, returning the code and the
+// verifier and nonce the client keeps.
+func authorize(t *testing.T, stub *oidctest.Provider, p *oidc.Provider, id oidctest.Identity) (code, verifier, nonce string) {
+	t.Helper()
+	ctx := context.Background()
+
+	state, err := oidc.NewState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce, err = oidc.NewState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier, err = oidc.NewVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authURL, err := p.AuthCodeURL(ctx, testRedirectURI, state, nonce, verifier)
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := u.Query().Get("code_challenge"); got != oidc.CodeChallenge(verifier) {
+		t.Fatalf("code_challenge = %q, want %q", got, oidc.CodeChallenge(verifier))
+	}
+
+	code, gotState, err := stub.Authorize(authURL, id)
+	if err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+	if gotState != state {
+		t.Fatalf("state = %q, want %q", gotState, state)
+	}
+	return code, verifier, nonce
+}
+
+func TestExchangeAndIdentity(t *testing.T) {
+	ctx := context.Background()
+	stub, p := newProvider(t)
+	want := oidctest.Identity{Subject: "1234", Email: "alice@example.com", EmailVerified: true}
+	code, verifier, nonce := authorize(t, stub, p, want)
+
+	tr, err := p.Exchange(ctx, code, testRedirectURI, verifier)
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if tr.IDToken == "" {
+		t.Fatal("token response has no id_token")
+	}
+	id, err := p.Identity(ctx, tr, nonce)
+	if err != nil {
+		t.Fatalf("Identity: %v", err)
+	}
+	if id != (oidc.Identity{Subject: want.Subject, Email: want.Email, EmailVerified: true}) {
+		t.Errorf("Identity = %+v, want %+v", id, want)
+	}
+
+	// codes can be redeemed once
+	if _, err := p.Exchange(ctx, code, testRedirectURI, verifier); err == nil {
+		t.Error("second Exchange succeeded, want error")
+	}
+}
+
+func TestExchangeRejected(t *testing.T) {
+	tests := []struct {
+		name        string
+		redirectURI string
+		verifier    func(verifier string) string
+	}{
+		{
+			name:        "wrong code verifier",
+			redirectURI: testRedirectURI,
+			verifier:    func(string) string { return "not-the-verifier" },
+		},
+		{
+			name:        "wrong redirect uri",
+			redirectURI: "https://evil.example/callback",
+			verifier:    func(v string) string { return v },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub, p := newProvider(t)
+			code, verifier, _ := authorize(t, stub, p, oidctest.Identity{Subject: "1234"})
+			if _, err := p.Exchange(context.Background(), code, tt.redirectURI, tt.verifier(verifier)); err == nil {
+				t.Fatal("Exchange succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestIdentityFromUserInfo(t *testing.T) {
+	ctx := context.Background()
+	stub, p := newProvider(t)
+	code, verifier, nonce := authorize(t, stub, p, oidctest.Identity{Subject: "1234", Email: "alice@example.com"})
+
+	tr, err := p.Exchange(ctx, code, testRedirectURI, verifier)
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	tr.IDToken = "" // as returned by plain OAuth 2.0 providers
+	id, err := p.Identity(ctx, tr, nonce)
+	if err != nil {
+		t.Fatalf("Identity: %v", err)
+	}
+	if id.Subject != "1234" || id.Email != "alice@example.com" || id.EmailVerified {
+		t.Errorf("Identity = %+v", id)
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	now := time.Now()
+	valid := func(stub *oidctest.Provider) oidctest.IDTokenClaims {
+		return oidctest.IDTokenClaims{
+			Claims: jwt.Claims{
+				Issuer:    stub.Issuer(),
+				Subject:   "1234",
+				Audience:  jwt.Audience{testClientID},
+				ExpiresAt: now.Add(time.Minute).Unix(),
+				IssuedAt:  now.Unix(),
+			},
+			Nonce: "nonce",
+		}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(c *oidctest.IDTokenClaims)
+		ok     bool
+	}{
+		{name: "valid", modify: func(c *oidctest.IDTokenClaims) {}, ok: true},
+		{name: "wrong issuer", modify: func(c *oidctest.IDTokenClaims) { c.Issuer = "https://evil.example" }},
+		{name: "wrong audience", modify: func(c *oidctest.IDTokenClaims) { c.Audience = jwt.Audience{"other"} }},
+		{name: "expired", modify: func(c *oidctest.IDTokenClaims) { c.ExpiresAt = now.Add(-time.Hour).Unix() }},
+		{name: "no expiry", modify: func(c *oidctest.IDTokenClaims) { c.ExpiresAt = 0 }},
+		{name: "not yet valid", modify: func(c *oidctest.IDTokenClaims) { c.NotBefore = now.Add(time.Hour).Unix() }},
+		{name: "wrong nonce", modify: func(c *oidctest.IDTokenClaims) { c.Nonce = "other" }},
+		{name: "multiple audiences without azp", modify: func(c *oidctest.IDTokenClaims) {
+			c.Audience = jwt.Audience{testClientID, "other"}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub, p := newProvider(t)
+			claims := valid(stub)
+			tt.modify(&claims)
+			raw, err := stub.SignIDToken(claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = p.VerifyIDToken(context.Background(), raw, "nonce")
+			if tt.ok && err != nil {
+				t.Fatalf("VerifyIDToken: %v", err)
+			}
+			if !tt.ok && !errors.Is(err, oidc.ErrIDTokenInvalid) {
+				t.Fatalf("VerifyIDToken error = %v, want %v", err, oidc.ErrIDTokenInvalid)
+			}
+		})
+	}
+}
+
+func TestVerifyIDTokenWrongKey(t *testing.T) {
+	stub, p := newProvider(t)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := jwt.Sign(jwt.Header{Alg: jwt.ES256, Kid: "test-key"}, key, oidctest.IDTokenClaims{
+		Claims: jwt.Claims{
+			Issuer:    stub.Issuer(),
+			Subject:   "1234",
+			Audience:  jwt.Audience{testClientID},
+			ExpiresAt: time.Now().Add(time.Minute).Unix(),
+		},
+		Nonce: "nonce",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.VerifyIDToken(context.Background(), raw, "nonce"); !errors.Is(err, oidc.ErrIDTokenInvalid) {
+		t.Fatalf("VerifyIDToken error = %v, want %v", err, oidc.ErrIDTokenInvalid)
+	}
+}
@@ -0,0 +1,235 @@
+// Package oidctest provides a stub OpenID Connect provider served with
+// httptest for testing clients.
+package oidctest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andyfusniak/monolith/internal/jwt"
+	"github.com/andyfusniak/monolith/internal/oidc"
+	"github.com/pkg/errors"
+)
+
+const keyID = "test-key"
+
+// Identity is the user a Provider signs in.
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+// IDTokenClaims are the claims of the ID tokens issued by a Provider.
+type IDTokenClaims struct {
+	jwt.Claims
+	Nonce         string `json:"nonce,omitempty"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified bool   `json:"email_verified"`
+}
+
+// authorization is an issued authorization code.
+type authorization struct {
+	clientID      string
+	redirectURI   string
+	codeChallenge string
+	nonce         string
+	identity      Identity
+}
+
+// Provider is a stub provider publishing a discovery document, a key set
+// and token and userinfo endpoints. Users are signed in by calling
+// Authorize with the URL the client redirects them to.
+type Provider struct {
+	*httptest.Server
+	ClientID string
+
+	key *ecdsa.PrivateKey
+
+	mu     sync.Mutex
+	codes  map[string]authorization
+	tokens map[string]Identity // by access token
+}
+
+// NewProvider starts a provider accepting clientID. It is closed when the
+// test ends.
+func NewProvider(t testing.TB, clientID string) *Provider {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Provider{
+		ClientID: clientID,
+		key:      key,
+		codes:    make(map[string]authorization),
+		tokens:   make(map[string]Identity),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("GET /jwks", p.jwks)
+	mux.HandleFunc("POST /token", p.token)
+	mux.HandleFunc("GET /userinfo", p.userinfo)
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+// Issuer returns the issuer identifier of the provider.
+func (p *Provider) Issuer() string {
+	return p.URL
+}
+
+// Authorize signs id in at authURL, as built by the client, returning the
+// authorization code and state to pass to the client callback.
+func (p *Provider) Authorize(authURL string, id Identity) (code, state string, err error) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return "", "", err
+	}
+	q := u.Query()
+	if q.Get("response_type") != "code" || q.Get("code_challenge_method") != "S256" {
+		return "", "", errors.Errorf("oidctest: unsupported authorization request %s", u.RawQuery)
+	}
+	if q.Get("client_id") != p.ClientID {
+		return "", "", errors.Errorf("oidctest: unknown client_id %q", q.Get("client_id"))
+	}
+
+	code, err = oidc.NewState()
+	if err != nil {
+		return "", "", err
+	}
+	p.mu.Lock()
+	p.codes[code] = authorization{
+		clientID:      q.Get("client_id"),
+		redirectURI:   q.Get("redirect_uri"),
+		codeChallenge: q.Get("code_challenge"),
+		nonce:         q.Get("nonce"),
+		identity:      id,
+	}
+	p.mu.Unlock()
+	return code, q.Get("state"), nil
+}
+
+// SignIDToken signs claims with the provider key.
+func (p *Provider) SignIDToken(claims any) (string, error) {
+	return jwt.Sign(jwt.Header{Alg: jwt.ES256, Kid: keyID, Typ: "JWT"}, p.key, claims)
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, oidc.Metadata{
+		Issuer:                p.URL,
+		AuthorizationEndpoint: p.URL + "/authorize",
+		TokenEndpoint:         p.URL + "/token",
+		UserInfoEndpoint:      p.URL + "/userinfo",
+		JWKSURI:               p.URL + "/jwks",
+	})
+}
+
+func (p *Provider) jwks(w http.ResponseWriter, r *http.Request) {
+	k, err := jwt.NewJWK(keyID, jwt.ES256, &p.key.PublicKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, jwt.JWKS{Keys: []jwt.JWK{k}})
+}
+
+// token redeems an authorization code once, checking the client,
+// redirect URI and PKCE code verifier (RFC 7636 section 4.6).
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, "invalid_request")
+		return
+	}
+	p.mu.Lock()
+	a, ok := p.codes[r.PostForm.Get("code")]
+	delete(p.codes, r.PostForm.Get("code"))
+	p.mu.Unlock()
+
+	switch {
+	case r.PostForm.Get("grant_type") != "authorization_code":
+		tokenError(w, "unsupported_grant_type")
+		return
+	case !ok,
+		r.PostForm.Get("client_id") != a.clientID,
+		r.PostForm.Get("redirect_uri") != a.redirectURI,
+		oidc.CodeChallenge(r.PostForm.Get("code_verifier")) != a.codeChallenge:
+		tokenError(w, "invalid_grant")
+		return
+	}
+
+	now := time.Now()
+	idToken, err := p.SignIDToken(IDTokenClaims{
+		Claims: jwt.Claims{
+			Issuer:    p.URL,
+			Subject:   a.identity.Subject,
+			Audience:  jwt.Audience{p.ClientID},
+			ExpiresAt: now.Add(5 * time.Minute).Unix(),
+			IssuedAt:  now.Unix(),
+		},
+		Nonce:         a.nonce,
+		Email:         a.identity.Email,
+		EmailVerified: a.identity.EmailVerified,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	accessToken, err := oidc.NewState()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	p.mu.Lock()
+	p.tokens[accessToken] = a.identity
+	p.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, oidc.TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   300,
+		IDToken:     idToken,
+	})
+}
+
+func (p *Provider) userinfo(w http.ResponseWriter, r *http.Request) {
+	const prefix = "Bearer "
+	auth := r.Header.Get("Authorization")
+	if len(auth) < len(prefix) || auth[:len(prefix)] != prefix {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	p.mu.Lock()
+	id, ok := p.tokens[auth[len(prefix):]]
+	p.mu.Unlock()
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"sub":            id.Subject,
+		"email":          id.Email,
+		"email_verified": id.EmailVerified,
+	})
+}
+
+func tokenError(w http.ResponseWriter, code string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,75 @@
+// Package memory implements store operations in memory, for state that
+// need not survive a restart or be shared between processes.
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/andyfusniak/monolith/internal/store"
+)
+
+// RateLimits implements store.RateLimitsRepository in memory.
+type RateLimits struct {
+	mu      sync.Mutex
+	buckets map[string]*store.RateLimitBucket
+}
+
+// NewRateLimits returns an empty set of token buckets.
+func NewRateLimits() *RateLimits {
+	return &RateLimits{
+		buckets: make(map[string]*store.RateLimitBucket),
+	}
+}
+
+// TakeRateLimitToken refills the token bucket for params.Key for the time
+// since it was last updated and takes a token if it holds one. A bucket
+// left without a token is not updated, and is returned with Taken false.
+func (m *RateLimits) TakeRateLimitToken(_ context.Context, params store.TakeRateLimitToken) (store.RateLimitBucket, error) {
+	now := time.Now().UTC()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, ok := m.buckets[params.Key]
+	if !ok {
+		b = &store.RateLimitBucket{
+			Key:       params.Key,
+			Tokens:    float64(params.Burst),
+			UpdatedAt: store.Datetime(now),
+		}
+		m.buckets[params.Key] = b
+	}
+
+	elapsed := max(now.Sub(time.Time(b.UpdatedAt)).Seconds(), 0)
+	tokens := min(float64(params.Burst), b.Tokens+elapsed*params.Rate)
+	if tokens < 1 {
+		r := *b
+		r.Tokens = tokens
+		return r, nil
+	}
+
+	b.Tokens = tokens - 1
+	b.UpdatedAt = store.Datetime(now)
+	b.ExpiresAt = params.ExpiresAt
+	r := *b
+	r.Taken = true
+	return r, nil
+}
+
+// DeleteExpiredRateLimitBuckets deletes buckets that expired before now,
+// returning the number deleted.
+func (m *RateLimits) DeleteExpiredRateLimitBuckets(_ context.Context, now store.Datetime) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var n int64
+	for key, b := range m.buckets {
+		if time.Time(b.ExpiresAt).Before(time.Time(now)) {
+			delete(m.buckets, key)
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,80 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/andyfusniak/monolith/internal/store"
+)
+
+func TestTakeRateLimitToken(t *testing.T) {
+	ctx := context.Background()
+	m := NewRateLimits()
+
+	// a bucket of 2 refilled every 50ms
+	take := func(key string) store.RateLimitBucket {
+		t.Helper()
+		b, err := m.TakeRateLimitToken(ctx, store.TakeRateLimitToken{
+			Key:       key,
+			Rate:      20,
+			Burst:     2,
+			ExpiresAt: store.Datetime(time.Now().UTC().Add(100 * time.Millisecond)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	if b := take("a"); !b.Taken || b.Tokens < 0.99 || b.Tokens > 1.1 {
+		t.Fatalf("first take = %+v, want taken leaving 1", b)
+	}
+	if b := take("a"); !b.Taken || b.Tokens > 0.1 {
+		t.Fatalf("second take = %+v, want taken leaving 0", b)
+	}
+	empty := take("a")
+	if empty.Taken || empty.Tokens >= 1 {
+		t.Fatalf("third take = %+v, want refused", empty)
+	}
+	if b := take("b"); !b.Taken {
+		t.Errorf("take from another key = %+v, want taken", b)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if b := take("a"); !b.Taken {
+		t.Errorf("take after a refill = %+v, want taken", b)
+	}
+}
+
+func TestDeleteExpiredRateLimitBuckets(t *testing.T) {
+	ctx := context.Background()
+	m := NewRateLimits()
+
+	now := time.Now().UTC()
+	for key, expires := range map[string]time.Time{
+		"expired": now.Add(-time.Second),
+		"current": now.Add(time.Hour),
+	} {
+		if _, err := m.TakeRateLimitToken(ctx, store.TakeRateLimitToken{
+			Key: key, Rate: 1, Burst: 1, ExpiresAt: store.Datetime(expires),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := m.DeleteExpiredRateLimitBuckets(ctx, store.Datetime(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("deleted %d buckets, want 1", n)
+	}
+	// the deleted bucket is full again; the other is still empty
+	if b, _ := m.TakeRateLimitToken(ctx, store.TakeRateLimitToken{Key: "expired", Rate: 1, Burst: 1}); !b.Taken {
+		t.Errorf("take from a deleted bucket = %+v, want taken", b)
+	}
+	if b, _ := m.TakeRateLimitToken(ctx, store.TakeRateLimitToken{Key: "current", Rate: 1e-6, Burst: 1}); b.Taken {
+		t.Errorf("take from a kept bucket = %+v, want refused", b)
+	}
+}
@@ -0,0 +1,168 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/andyfusniak/monolith/internal/store"
+	"github.com/pkg/errors"
+)
+
+// InsertAPIKey adds a new row to the api_keys table.
+func (q *Queries) InsertAPIKey(ctx context.Context, params store.AddAPIKey) (store.APIKey, error) {
+	const query = `
+insert into api_keys
+  (api_key_id, user_id, name, prefix, token_hash, scopes, expires_at, created_at)
+values
+  (:api_key_id, :user_id, :name, :prefix, :token_hash, :scopes, :expires_at, :created_at)
+returning
+  api_key_id, user_id, name, prefix, token_hash, scopes,
+  expires_at, last_used_at, created_at
+`
+	r := store.APIKey{}
+	now := store.Datetime(time.Now().UTC())
+	if err := q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("api_key_id", params.APIKeyID),  // :api_key_id
+		sql.Named("user_id", params.UserID),       // :user_id
+		sql.Named("name", params.Name),            // :name
+		sql.Named("prefix", params.Prefix),        // :prefix
+		sql.Named("token_hash", params.TokenHash), // :token_hash
+		sql.Named("scopes", params.Scopes),        // :scopes
+		sql.Named("expires_at", params.ExpiresAt), // :expires_at
+		sql.Named("created_at", &now),             // :created_at
+	).Scan(
+		&r.APIKeyID,   // 0 api_key_id
+		&r.UserID,     // 1 user_id
+		&r.Name,       // 2 name
+		&r.Prefix,     // 3 prefix
+		&r.TokenHash,  // 4 token_hash
+		&r.Scopes,     // 5 scopes
+		&r.ExpiresAt,  // 6 expires_at
+		&r.LastUsedAt, // 7 last_used_at
+		&r.CreatedAt,  // 8 created_at
+	); err != nil {
+		return store.APIKey{}, errors.Wrapf(err,
+			"[sqlite3:apikeys] query row scan failed query=%q", query)
+	}
+
+	return r, nil
+}
+
+// GetAPIKeyByTokenHash gets an api_keys row by token hash.
+func (q *Queries) GetAPIKeyByTokenHash(ctx context.Context, tokenHash string) (store.APIKey, error) {
+	const query = `
+select
+  api_key_id, user_id, name, prefix, token_hash, scopes,
+  expires_at, last_used_at, created_at
+from api_keys
+where token_hash = :token_hash
+`
+	r := store.APIKey{}
+	if err := q.readonly.QueryRowContext(ctx, query,
+		sql.Named("token_hash", tokenHash), // :token_hash
+	).Scan(
+		&r.APIKeyID,   // 0 api_key_id
+		&r.UserID,     // 1 user_id
+		&r.Name,       // 2 name
+		&r.Prefix,     // 3 prefix
+		&r.TokenHash,  // 4 token_hash
+		&r.Scopes,     // 5 scopes
+		&r.ExpiresAt,  // 6 expires_at
+		&r.LastUsedAt, // 7 last_used_at
+		&r.CreatedAt,  // 8 created_at
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return store.APIKey{}, store.ErrAPIKeyNotFound
+		}
+		return store.APIKey{}, errors.Wrapf(err,
+			"[sqlite3:apikeys] query row scan failed query=%q", query)
+	}
+
+	return r, nil
+}
+
+// ListAPIKeys returns all api_keys rows for a user, newest first.
+func (q *Queries) ListAPIKeys(ctx context.Context, userID string) ([]store.APIKey, error) {
+	const query = `
+select
+  api_key_id, user_id, name, prefix, token_hash, scopes,
+  expires_at, last_used_at, created_at
+from api_keys
+where user_id = :user_id
+order by created_at desc
+`
+	rows, err := q.readonly.QueryContext(ctx, query,
+		sql.Named("user_id", userID), // :user_id
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[sqlite3:apikeys] query failed query=%q", query)
+	}
+	defer rows.Close()
+
+	var keys []store.APIKey
+	for rows.Next() {
+		var r store.APIKey
+		if err := rows.Scan(
+			&r.APIKeyID,   // 0 api_key_id
+			&r.UserID,     // 1 user_id
+			&r.Name,       // 2 name
+			&r.Prefix,     // 3 prefix
+			&r.TokenHash,  // 4 token_hash
+			&r.Scopes,     // 5 scopes
+			&r.ExpiresAt,  // 6 expires_at
+			&r.LastUsedAt, // 7 last_used_at
+			&r.CreatedAt,  // 8 created_at
+		); err != nil {
+			return nil, errors.Wrapf(err, "[sqlite3:apikeys] rows scan failed query=%q", query)
+		}
+		keys = append(keys, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err, "[sqlite3:apikeys] rows next failed query=%q", query)
+	}
+
+	return keys, nil
+}
+
+// TouchAPIKey records the time an API key was last used.
+func (q *Queries) TouchAPIKey(ctx context.Context, apiKeyID string, lastUsedAt store.Datetime) error {
+	const query = `
+update api_keys
+set last_used_at = :last_used_at
+where api_key_id = :api_key_id
+`
+	if _, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("last_used_at", &lastUsedAt), // :last_used_at
+		sql.Named("api_key_id", apiKeyID),      // :api_key_id
+	); err != nil {
+		return errors.Wrapf(err, "[sqlite3:apikeys] exec failed query=%q", query)
+	}
+
+	return nil
+}
+
+// DeleteAPIKey deletes an api_keys row belonging to a user. If there is no
+// such row store.ErrAPIKeyNotFound is returned.
+func (q *Queries) DeleteAPIKey(ctx context.Context, userID, apiKeyID string) error {
+	const query = `
+delete from api_keys
+where api_key_id = :api_key_id and user_id = :user_id
+`
+	res, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("api_key_id", apiKeyID), // :api_key_id
+		sql.Named("user_id", userID),      // :user_id
+	)
+	if err != nil {
+		return errors.Wrapf(err, "[sqlite3:apikeys] exec failed query=%q", query)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrapf(err, "[sqlite3:apikeys] rows affected failed query=%q", query)
+	}
+	if n == 0 {
+		return store.ErrAPIKeyNotFound
+	}
+
+	return nil
+}
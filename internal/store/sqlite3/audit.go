@@ -0,0 +1,208 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/andyfusniak/monolith/internal/store"
+	"github.com/pkg/errors"
+)
+
+const auditEventColumns = `
+  event_id, action, actor_id, subject_id, ip, details, created_at,
+  coalesce(prev_hash, ''), coalesce(hash, '')`
+
+// InsertAuditEvent appends an event to the audit log, chained to the
+// event before it. Any events recorded before chaining are sealed first.
+func (s *Store) InsertAuditEvent(ctx context.Context, params store.AddAuditEvent) (store.AuditEvent, error) {
+	var r store.AuditEvent
+	err := s.execTx(ctx, func(q *Queries) error {
+		var err error
+		r, err = q.appendAuditEvent(ctx, params)
+		return err
+	})
+	if err != nil {
+		return store.AuditEvent{}, err
+	}
+
+	return r, nil
+}
+
+// appendAuditEvent chains and inserts an event. It must be called within a
+// transaction.
+func (q *Queries) appendAuditEvent(ctx context.Context, params store.AddAuditEvent) (store.AuditEvent, error) {
+	head, err := q.sealAuditEvents(ctx)
+	if err != nil {
+		return store.AuditEvent{}, err
+	}
+
+	r := store.AuditEvent{
+		EventID:   head.EventID + 1,
+		Action:    params.Action,
+		ActorID:   params.ActorID,
+		SubjectID: params.SubjectID,
+		IP:        params.IP,
+		Details:   params.Details,
+		CreatedAt: store.Datetime(time.Now().UTC().Truncate(time.Microsecond)),
+		PrevHash:  head.Hash,
+	}
+	r.Hash = r.ChainHash()
+	if err := q.insertAuditEvent(ctx, r); err != nil {
+		return store.AuditEvent{}, err
+	}
+
+	return r, nil
+}
+
+// sealAuditEvents chains any unsealed events and returns the last event,
+// which is the zero AuditEvent if the log is empty.
+func (q *Queries) sealAuditEvents(ctx context.Context) (store.AuditEvent, error) {
+	const query = `
+select` + auditEventColumns + `
+from audit_events
+where hash is not null
+order by event_id desc
+limit 1
+`
+	head, err := scanAuditEvent(q.readwrite.QueryRowContext(ctx, query))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return store.AuditEvent{}, errors.Wrapf(err,
+			"[sqlite3:audit] query row scan failed query=%q", query)
+	}
+
+	unsealed, err := q.listAuditEvents(ctx, `
+select`+auditEventColumns+`
+from audit_events
+where hash is null
+order by event_id
+`)
+	if err != nil {
+		return store.AuditEvent{}, err
+	}
+
+	const update = `
+update audit_events
+set prev_hash = :prev_hash, hash = :hash
+where event_id = :event_id
+`
+	for _, e := range unsealed {
+		e.PrevHash = head.Hash
+		e.Hash = e.ChainHash()
+		if _, err := q.readwrite.ExecContext(ctx, update,
+			sql.Named("prev_hash", e.PrevHash), // :prev_hash
+			sql.Named("hash", e.Hash),          // :hash
+			sql.Named("event_id", e.EventID),   // :event_id
+		); err != nil {
+			return store.AuditEvent{}, errors.Wrapf(err, "[sqlite3:audit] exec failed query=%q", update)
+		}
+		head = e
+	}
+
+	return head, nil
+}
+
+func (q *Queries) insertAuditEvent(ctx context.Context, e store.AuditEvent) error {
+	const query = `
+insert into audit_events
+  (event_id, action, actor_id, subject_id, ip, details, created_at, prev_hash, hash)
+values
+  (:event_id, :action, :actor_id, :subject_id, :ip, :details, :created_at, :prev_hash, :hash)
+`
+	if _, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("event_id", e.EventID),      // :event_id
+		sql.Named("action", e.Action),         // :action
+		sql.Named("actor_id", e.ActorID),      // :actor_id
+		sql.Named("subject_id", e.SubjectID),  // :subject_id
+		sql.Named("ip", e.IP),                 // :ip
+		sql.Named("details", e.Details),       // :details
+		sql.Named("created_at", &e.CreatedAt), // :created_at
+		sql.Named("prev_hash", e.PrevHash),    // :prev_hash
+		sql.Named("hash", e.Hash),             // :hash
+	); err != nil {
+		return errors.Wrapf(err, "[sqlite3:audit] exec failed query=%q", query)
+	}
+
+	return nil
+}
+
+// ListAuditEvents returns audit events matching filter, newest first.
+func (q *Queries) ListAuditEvents(ctx context.Context, filter store.AuditEventFilter) ([]store.AuditEvent, error) {
+	const query = `
+select` + auditEventColumns + `
+from audit_events
+where (:action = '' or action = :action)
+  and (:actor_id = '' or actor_id = :actor_id)
+  and (:subject_id = '' or subject_id = :subject_id)
+  and (:user_id = '' or actor_id = :user_id or subject_id = :user_id)
+  and (:since is null or created_at >= :since)
+  and (:until is null or created_at < :until)
+  and (:before_id = 0 or event_id < :before_id)
+order by event_id desc
+limit :limit
+`
+	return q.listAuditEvents(ctx, query,
+		sql.Named("action", filter.Action),        // :action
+		sql.Named("actor_id", filter.ActorID),     // :actor_id
+		sql.Named("subject_id", filter.SubjectID), // :subject_id
+		sql.Named("user_id", filter.UserID),       // :user_id
+		sql.Named("since", filter.Since),          // :since
+		sql.Named("until", filter.Until),          // :until
+		sql.Named("before_id", filter.BeforeID),   // :before_id
+		sql.Named("limit", filter.Limit),          // :limit
+	)
+}
+
+// ListAuditChain returns up to limit audit events after afterID in chain
+// order.
+func (q *Queries) ListAuditChain(ctx context.Context, afterID int64, limit int) ([]store.AuditEvent, error) {
+	const query = `
+select` + auditEventColumns + `
+from audit_events
+where event_id > :after_id
+order by event_id
+limit :limit
+`
+	return q.listAuditEvents(ctx, query,
+		sql.Named("after_id", afterID), // :after_id
+		sql.Named("limit", limit),      // :limit
+	)
+}
+
+func (q *Queries) listAuditEvents(ctx context.Context, query string, args ...any) ([]store.AuditEvent, error) {
+	rows, err := q.readonly.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[sqlite3:audit] query failed query=%q", query)
+	}
+	defer rows.Close()
+
+	var events []store.AuditEvent
+	for rows.Next() {
+		r, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, errors.Wrapf(err, "[sqlite3:audit] rows scan failed query=%q", query)
+		}
+		events = append(events, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err, "[sqlite3:audit] rows next failed query=%q", query)
+	}
+
+	return events, nil
+}
+
+func scanAuditEvent(row interface{ Scan(...any) error }) (store.AuditEvent, error) {
+	var r store.AuditEvent
+	err := row.Scan(
+		&r.EventID,   // 0 event_id
+		&r.Action,    // 1 action
+		&r.ActorID,   // 2 actor_id
+		&r.SubjectID, // 3 subject_id
+		&r.IP,        // 4 ip
+		&r.Details,   // 5 details
+		&r.CreatedAt, // 6 created_at
+		&r.PrevHash,  // 7 prev_hash
+		&r.Hash,      // 8 hash
+	)
+	return r, err
+}
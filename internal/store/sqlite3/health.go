@@ -0,0 +1,56 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// PingDB runs a trivial query using the read-only or read-write database.
+// The read-write database has a single connection, so PingDB waits for
+// any write in progress.
+func (q *Queries) PingDB(ctx context.Context, readwrite bool) error {
+	db := q.readonly
+	if readwrite {
+		db = q.readwrite
+	}
+	var one int
+	if err := db.QueryRowContext(ctx, "select 1").Scan(&one); err != nil {
+		return errors.Wrap(err, "[sqlite3:health] select 1 failed")
+	}
+	return nil
+}
+
+// SchemaVersion returns the version recorded by the migrate command.
+func (q *Queries) SchemaVersion(ctx context.Context) (uint, bool, error) {
+	const query = `
+select
+  version, dirty
+from schema_migrations
+limit 1
+`
+	var version uint
+	var dirty bool
+	if err := q.readonly.QueryRowContext(ctx, query).Scan(
+		&version, // 0 version
+		&dirty,   // 1 dirty
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, false, nil
+		}
+		return 0, false, errors.Wrapf(err,
+			"[sqlite3:health] query row scan failed query=%q", query)
+	}
+	return version, dirty, nil
+}
+
+// PingDB checks the directory of tenant databases can be read. Tenant
+// databases are opened on demand, so there is no single database to ping.
+func (s *TenantStore) PingDB(ctx context.Context, readwrite bool) error {
+	if _, err := os.ReadDir(s.pools.dir); err != nil {
+		return errors.Wrapf(err, "[sqlite3:health] read dir %q failed", s.pools.dir)
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/andyfusniak/monolith/internal/store"
+	"github.com/pkg/errors"
+)
+
+// InsertIdempotencyKey adds a new idempotency_keys row for a request in
+// progress. If a row for the key exists that has not expired, and is
+// completed or was created after params.StaleBefore, it is kept and
+// store.ErrIdempotencyKeyExists returned.
+func (q *Queries) InsertIdempotencyKey(ctx context.Context, params store.AddIdempotencyKey) error {
+	const query = `
+insert into idempotency_keys
+  (scope, idempotency_key, fingerprint, status_code, headers, body,
+   created_at, completed_at, expires_at)
+values
+  (:scope, :idempotency_key, :fingerprint, null, null, null,
+   :now, null, :expires_at)
+on conflict (scope, idempotency_key) do update set
+  fingerprint = excluded.fingerprint,
+  status_code = null,
+  headers = null,
+  body = null,
+  created_at = excluded.created_at,
+  completed_at = null,
+  expires_at = excluded.expires_at
+where expires_at < :now
+   or (completed_at is null and created_at < :stale_before)
+`
+	now := store.Datetime(time.Now().UTC())
+	res, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("scope", params.Scope),               // :scope
+		sql.Named("idempotency_key", params.Key),       // :idempotency_key
+		sql.Named("fingerprint", params.Fingerprint),   // :fingerprint
+		sql.Named("now", &now),                         // :now
+		sql.Named("expires_at", &params.ExpiresAt),     // :expires_at
+		sql.Named("stale_before", &params.StaleBefore), // :stale_before
+	)
+	if err != nil {
+		return errors.Wrapf(err, "[sqlite3:idempotency] exec failed query=%q", query)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrapf(err, "[sqlite3:idempotency] rows affected failed query=%q", query)
+	}
+	if n == 0 {
+		return store.ErrIdempotencyKeyExists
+	}
+
+	return nil
+}
+
+// GetIdempotencyKey gets an idempotency_keys row by scope and key.
+func (q *Queries) GetIdempotencyKey(ctx context.Context, scope, key string) (store.IdempotencyKey, error) {
+	const query = `
+select
+  scope, idempotency_key, fingerprint, status_code, headers, body,
+  created_at, completed_at, expires_at
+from idempotency_keys
+where scope = :scope and idempotency_key = :idempotency_key
+`
+	r := store.IdempotencyKey{}
+	if err := q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("scope", scope),         // :scope
+		sql.Named("idempotency_key", key), // :idempotency_key
+	).Scan(
+		&r.Scope,       // 0 scope
+		&r.Key,         // 1 idempotency_key
+		&r.Fingerprint, // 2 fingerprint
+		&r.StatusCode,  // 3 status_code
+		&r.Headers,     // 4 headers
+		&r.Body,        // 5 body
+		&r.CreatedAt,   // 6 created_at
+		&r.CompletedAt, // 7 completed_at
+		&r.ExpiresAt,   // 8 expires_at
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return store.IdempotencyKey{}, store.ErrIdempotencyKeyNotFound
+		}
+
+		return store.IdempotencyKey{}, errors.Wrapf(err,
+			"[sqlite3:idempotency] query row scan failed query=%q", query)
+	}
+
+	return r, nil
+}
+
+// CompleteIdempotencyKey records the response to the request with an
+// idempotency key.
+func (q *Queries) CompleteIdempotencyKey(ctx context.Context, params store.CompleteIdempotencyKey) error {
+	const query = `
+update idempotency_keys
+set status_code = :status_code,
+    headers = :headers,
+    body = :body,
+    completed_at = :completed_at
+where scope = :scope and idempotency_key = :idempotency_key
+`
+	now := store.Datetime(time.Now().UTC())
+	res, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("status_code", params.StatusCode), // :status_code
+		sql.Named("headers", params.Headers),        // :headers
+		sql.Named("body", params.Body),              // :body
+		sql.Named("completed_at", &now),             // :completed_at
+		sql.Named("scope", params.Scope),            // :scope
+		sql.Named("idempotency_key", params.Key),    // :idempotency_key
+	)
+	if err != nil {
+		return errors.Wrapf(err, "[sqlite3:idempotency] exec failed query=%q", query)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrapf(err, "[sqlite3:idempotency] rows affected failed query=%q", query)
+	}
+	if n == 0 {
+		return store.ErrIdempotencyKeyNotFound
+	}
+
+	return nil
+}
+
+// DeleteIdempotencyKey deletes an idempotency_keys row by scope and key.
+func (q *Queries) DeleteIdempotencyKey(ctx context.Context, scope, key string) error {
+	const query = `
+delete from idempotency_keys
+where scope = :scope and idempotency_key = :idempotency_key
+`
+	if _, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("scope", scope),         // :scope
+		sql.Named("idempotency_key", key), // :idempotency_key
+	); err != nil {
+		return errors.Wrapf(err, "[sqlite3:idempotency] exec failed query=%q", query)
+	}
+
+	return nil
+}
+
+// DeleteExpiredIdempotencyKeys deletes idempotency_keys rows that expired
+// before now, returning the number deleted.
+func (q *Queries) DeleteExpiredIdempotencyKeys(ctx context.Context, now store.Datetime) (int64, error) {
+	const query = `
+delete from idempotency_keys
+where expires_at < :now
+`
+	res, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("now", &now), // :now
+	)
+	if err != nil {
+		return 0, errors.Wrapf(err, "[sqlite3:idempotency] exec failed query=%q", query)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "[sqlite3:idempotency] rows affected failed")
+	}
+
+	return n, nil
+}
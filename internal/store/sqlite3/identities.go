@@ -0,0 +1,226 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/andyfusniak/monolith/internal/store"
+	"github.com/pkg/errors"
+)
+
+// InsertOIDCAuthRequest adds a new row to the oidc_auth_requests table.
+func (q *Queries) InsertOIDCAuthRequest(ctx context.Context, params store.AddOIDCAuthRequest) error {
+	const query = `
+insert into oidc_auth_requests
+  (state_hash, provider, nonce, code_verifier, expires_at, created_at)
+values
+  (:state_hash, :provider, :nonce, :code_verifier, :expires_at, :created_at)
+`
+	now := store.Datetime(time.Now().UTC())
+	if _, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("state_hash", params.StateHash),       // :state_hash
+		sql.Named("provider", params.Provider),          // :provider
+		sql.Named("nonce", params.Nonce),                // :nonce
+		sql.Named("code_verifier", params.CodeVerifier), // :code_verifier
+		sql.Named("expires_at", &params.ExpiresAt),      // :expires_at
+		sql.Named("created_at", &now),                   // :created_at
+	); err != nil {
+		return errors.Wrapf(err, "[sqlite3:identities] exec failed query=%q", query)
+	}
+
+	return nil
+}
+
+// TakeOIDCAuthRequest deletes and returns an oidc_auth_requests row so
+// each state can only be used once.
+func (q *Queries) TakeOIDCAuthRequest(ctx context.Context, stateHash string) (store.OIDCAuthRequest, error) {
+	const query = `
+delete from oidc_auth_requests
+where state_hash = :state_hash
+returning
+  state_hash, provider, nonce, code_verifier, expires_at, created_at
+`
+	r := store.OIDCAuthRequest{}
+	if err := q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("state_hash", stateHash), // :state_hash
+	).Scan(
+		&r.StateHash,    // 0 state_hash
+		&r.Provider,     // 1 provider
+		&r.Nonce,        // 2 nonce
+		&r.CodeVerifier, // 3 code_verifier
+		&r.ExpiresAt,    // 4 expires_at
+		&r.CreatedAt,    // 5 created_at
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return store.OIDCAuthRequest{}, store.ErrOIDCAuthRequestNotFound
+		}
+		return store.OIDCAuthRequest{}, errors.Wrapf(err,
+			"[sqlite3:identities] query row scan failed query=%q", query)
+	}
+
+	return r, nil
+}
+
+// DeleteExpiredOIDCAuthRequests deletes oidc_auth_requests rows that
+// expired before now, returning the number deleted.
+func (q *Queries) DeleteExpiredOIDCAuthRequests(ctx context.Context, now store.Datetime) (int64, error) {
+	const query = `
+delete from oidc_auth_requests
+where expires_at < :now
+`
+	res, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("now", &now), // :now
+	)
+	if err != nil {
+		return 0, errors.Wrapf(err, "[sqlite3:identities] exec failed query=%q", query)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "[sqlite3:identities] rows affected failed")
+	}
+
+	return n, nil
+}
+
+// InsertUserIdentity adds a new row to the user_identities table.
+func (q *Queries) InsertUserIdentity(ctx context.Context, params store.AddUserIdentity) (store.UserIdentity, error) {
+	const query = `
+insert into user_identities
+  (provider, subject, user_id, email, last_used_at, created_at)
+values
+  (:provider, :subject, :user_id, :email, :last_used_at, :created_at)
+returning
+  provider, subject, user_id, email, last_used_at, created_at
+`
+	r := store.UserIdentity{}
+	now := store.Datetime(time.Now().UTC())
+	if err := q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("provider", params.Provider), // :provider
+		sql.Named("subject", params.Subject),   // :subject
+		sql.Named("user_id", params.UserID),    // :user_id
+		sql.Named("email", params.Email),       // :email
+		sql.Named("last_used_at", &now),        // :last_used_at
+		sql.Named("created_at", &now),          // :created_at
+	).Scan(
+		&r.Provider,   // 0 provider
+		&r.Subject,    // 1 subject
+		&r.UserID,     // 2 user_id
+		&r.Email,      // 3 email
+		&r.LastUsedAt, // 4 last_used_at
+		&r.CreatedAt,  // 5 created_at
+	); err != nil {
+		return store.UserIdentity{}, errors.Wrapf(err,
+			"[sqlite3:identities] query row scan failed query=%q", query)
+	}
+
+	return r, nil
+}
+
+// InsertUserWithIdentity adds a new user and links the identity to it in
+// a single transaction.
+func (s *Store) InsertUserWithIdentity(ctx context.Context, user store.AddUser, identity store.AddUserIdentity) (store.User, error) {
+	var u store.User
+	err := s.execTx(ctx, func(q *Queries) error {
+		var err error
+		if u, err = q.InsertUser(ctx, user); err != nil {
+			return err
+		}
+		identity.UserID = u.UserID
+		_, err = q.InsertUserIdentity(ctx, identity)
+		return err
+	})
+	if err != nil {
+		return store.User{}, err
+	}
+
+	return u, nil
+}
+
+// GetUserIdentity gets a user_identities row by primary key.
+func (q *Queries) GetUserIdentity(ctx context.Context, provider, subject string) (store.UserIdentity, error) {
+	const query = `
+select
+  provider, subject, user_id, email, last_used_at, created_at
+from user_identities
+where provider = :provider and subject = :subject
+`
+	r := store.UserIdentity{}
+	if err := q.readonly.QueryRowContext(ctx, query,
+		sql.Named("provider", provider), // :provider
+		sql.Named("subject", subject),   // :subject
+	).Scan(
+		&r.Provider,   // 0 provider
+		&r.Subject,    // 1 subject
+		&r.UserID,     // 2 user_id
+		&r.Email,      // 3 email
+		&r.LastUsedAt, // 4 last_used_at
+		&r.CreatedAt,  // 5 created_at
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return store.UserIdentity{}, store.ErrUserIdentityNotFound
+		}
+		return store.UserIdentity{}, errors.Wrapf(err,
+			"[sqlite3:identities] query row scan failed query=%q", query)
+	}
+
+	return r, nil
+}
+
+// ListUserIdentities returns all user_identities rows for a user.
+func (q *Queries) ListUserIdentities(ctx context.Context, userID string) ([]store.UserIdentity, error) {
+	const query = `
+select
+  provider, subject, user_id, email, last_used_at, created_at
+from user_identities
+where user_id = :user_id
+order by created_at
+`
+	rows, err := q.readonly.QueryContext(ctx, query,
+		sql.Named("user_id", userID), // :user_id
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[sqlite3:identities] query failed query=%q", query)
+	}
+	defer rows.Close()
+
+	var identities []store.UserIdentity
+	for rows.Next() {
+		var r store.UserIdentity
+		if err := rows.Scan(
+			&r.Provider,   // 0 provider
+			&r.Subject,    // 1 subject
+			&r.UserID,     // 2 user_id
+			&r.Email,      // 3 email
+			&r.LastUsedAt, // 4 last_used_at
+			&r.CreatedAt,  // 5 created_at
+		); err != nil {
+			return nil, errors.Wrapf(err, "[sqlite3:identities] rows scan failed query=%q", query)
+		}
+		identities = append(identities, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err, "[sqlite3:identities] rows next failed query=%q", query)
+	}
+
+	return identities, nil
+}
+
+// TouchUserIdentity records a sign in using the identity.
+func (q *Queries) TouchUserIdentity(ctx context.Context, provider, subject string) error {
+	const query = `
+update user_identities
+set last_used_at = :last_used_at
+where provider = :provider and subject = :subject
+`
+	now := store.Datetime(time.Now().UTC())
+	if _, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("last_used_at", &now), // :last_used_at
+		sql.Named("provider", provider), // :provider
+		sql.Named("subject", subject),   // :subject
+	); err != nil {
+		return errors.Wrapf(err, "[sqlite3:identities] exec failed query=%q", query)
+	}
+
+	return nil
+}
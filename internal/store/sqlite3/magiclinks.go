@@ -0,0 +1,127 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/andyfusniak/monolith/internal/store"
+	"github.com/pkg/errors"
+)
+
+// InsertMagicLink adds a new row to the magic_links table.
+func (q *Queries) InsertMagicLink(ctx context.Context, params store.AddMagicLink) error {
+	const query = `
+insert into magic_links
+  (token_hash, email, user_id, ip, expires_at, used_at, created_at)
+values
+  (:token_hash, :email, :user_id, :ip, :expires_at, null, :created_at)
+`
+	now := store.Datetime(time.Now().UTC())
+	if _, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("token_hash", params.TokenHash),  // :token_hash
+		sql.Named("email", params.Email),           // :email
+		sql.Named("user_id", params.UserID),        // :user_id
+		sql.Named("ip", params.IP),                 // :ip
+		sql.Named("expires_at", &params.ExpiresAt), // :expires_at
+		sql.Named("created_at", &now),              // :created_at
+	); err != nil {
+		return errors.Wrapf(err, "[sqlite3:magiclinks] exec failed query=%q", query)
+	}
+
+	return nil
+}
+
+// CountMagicLinksByEmail counts the magic links requested for an email
+// since the given time.
+func (q *Queries) CountMagicLinksByEmail(ctx context.Context, email string, since store.Datetime) (int, error) {
+	const query = `
+select count(*)
+from magic_links
+where email = :email and created_at >= :since
+`
+	var n int
+	if err := q.readonly.QueryRowContext(ctx, query,
+		sql.Named("email", email),  // :email
+		sql.Named("since", &since), // :since
+	).Scan(&n); err != nil {
+		return 0, errors.Wrapf(err, "[sqlite3:magiclinks] query row scan failed query=%q", query)
+	}
+
+	return n, nil
+}
+
+// CountMagicLinksByIP counts the magic links requested from a client IP
+// address since the given time.
+func (q *Queries) CountMagicLinksByIP(ctx context.Context, ip string, since store.Datetime) (int, error) {
+	const query = `
+select count(*)
+from magic_links
+where ip = :ip and created_at >= :since
+`
+	var n int
+	if err := q.readonly.QueryRowContext(ctx, query,
+		sql.Named("ip", ip),        // :ip
+		sql.Named("since", &since), // :since
+	).Scan(&n); err != nil {
+		return 0, errors.Wrapf(err, "[sqlite3:magiclinks] query row scan failed query=%q", query)
+	}
+
+	return n, nil
+}
+
+// UseMagicLink marks an unused magic link as used and returns it. It
+// returns store.ErrMagicLinkNotFound if the token is unknown or has
+// already been used.
+func (q *Queries) UseMagicLink(ctx context.Context, tokenHash string) (store.MagicLink, error) {
+	const query = `
+update magic_links
+set used_at = :used_at
+where token_hash = :token_hash and used_at is null
+returning
+  token_hash, email, user_id, ip, expires_at, used_at, created_at
+`
+	r := store.MagicLink{}
+	now := store.Datetime(time.Now().UTC())
+	if err := q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("used_at", &now),         // :used_at
+		sql.Named("token_hash", tokenHash), // :token_hash
+	).Scan(
+		&r.TokenHash, // 0 token_hash
+		&r.Email,     // 1 email
+		&r.UserID,    // 2 user_id
+		&r.IP,        // 3 ip
+		&r.ExpiresAt, // 4 expires_at
+		&r.UsedAt,    // 5 used_at
+		&r.CreatedAt, // 6 created_at
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return store.MagicLink{}, store.ErrMagicLinkNotFound
+		}
+		return store.MagicLink{}, errors.Wrapf(err,
+			"[sqlite3:magiclinks] query row scan failed query=%q", query)
+	}
+
+	return r, nil
+}
+
+// DeleteExpiredMagicLinks deletes magic_links rows that expired before
+// the given time, returning the number deleted.
+func (q *Queries) DeleteExpiredMagicLinks(ctx context.Context, before store.Datetime) (int64, error) {
+	const query = `
+delete from magic_links
+where expires_at < :before
+`
+	res, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("before", &before), // :before
+	)
+	if err != nil {
+		return 0, errors.Wrapf(err, "[sqlite3:magiclinks] exec failed query=%q", query)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "[sqlite3:magiclinks] rows affected failed")
+	}
+
+	return n, nil
+}
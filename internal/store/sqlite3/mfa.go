@@ -0,0 +1,298 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/andyfusniak/monolith/internal/store"
+	"github.com/pkg/errors"
+)
+
+// UpsertUserTOTP adds an unconfirmed TOTP secret for a user, replacing any
+// existing secret that has not been confirmed.
+func (q *Queries) UpsertUserTOTP(ctx context.Context, userID, secret string) (store.UserTOTP, error) {
+	const query = `
+insert into user_totp
+  (user_id, secret, confirmed_at, last_used_step, created_at)
+values
+  (:user_id, :secret, null, 0, :created_at)
+on conflict (user_id) do update set
+  secret = excluded.secret,
+  last_used_step = 0,
+  created_at = excluded.created_at
+where confirmed_at is null
+returning
+  user_id, secret, confirmed_at, last_used_step, created_at
+`
+	r := store.UserTOTP{}
+	now := store.Datetime(time.Now().UTC())
+	if err := q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("user_id", userID),  // :user_id
+		sql.Named("secret", secret),   // :secret
+		sql.Named("created_at", &now), // :created_at
+	).Scan(
+		&r.UserID,       // 0 user_id
+		&r.Secret,       // 1 secret
+		&r.ConfirmedAt,  // 2 confirmed_at
+		&r.LastUsedStep, // 3 last_used_step
+		&r.CreatedAt,    // 4 created_at
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// the where clause prevented a confirmed secret being replaced
+			return q.GetUserTOTP(ctx, userID)
+		}
+		return store.UserTOTP{}, errors.Wrapf(err,
+			"[sqlite3:mfa] query row scan failed query=%q", query)
+	}
+
+	return r, nil
+}
+
+// GetUserTOTP gets a user_totp row by user id.
+func (q *Queries) GetUserTOTP(ctx context.Context, userID string) (store.UserTOTP, error) {
+	const query = `
+select
+  user_id, secret, confirmed_at, last_used_step, created_at
+from user_totp
+where user_id = :user_id
+`
+	r := store.UserTOTP{}
+	if err := q.readonly.QueryRowContext(ctx, query,
+		sql.Named("user_id", userID), // :user_id
+	).Scan(
+		&r.UserID,       // 0 user_id
+		&r.Secret,       // 1 secret
+		&r.ConfirmedAt,  // 2 confirmed_at
+		&r.LastUsedStep, // 3 last_used_step
+		&r.CreatedAt,    // 4 created_at
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return store.UserTOTP{}, store.ErrUserTOTPNotFound
+		}
+		return store.UserTOTP{}, errors.Wrapf(err,
+			"[sqlite3:mfa] query row scan failed query=%q", query)
+	}
+
+	return r, nil
+}
+
+// ConfirmUserTOTP marks the TOTP secret for a user as confirmed and
+// replaces the user's recovery codes. It returns store.ErrUserTOTPNotFound
+// if the user has no unconfirmed secret matching params.Secret, such as
+// when it was confirmed or replaced concurrently.
+func (s *Store) ConfirmUserTOTP(ctx context.Context, params store.ConfirmUserTOTP) error {
+	return s.execTx(ctx, func(q *Queries) error {
+		const confirm = `
+update user_totp
+set confirmed_at = :confirmed_at,
+    last_used_step = :step
+where user_id = :user_id and secret = :secret and confirmed_at is null
+`
+		now := store.Datetime(time.Now().UTC())
+		res, err := q.readwrite.ExecContext(ctx, confirm,
+			sql.Named("confirmed_at", &now),     // :confirmed_at
+			sql.Named("step", params.Step),      // :step
+			sql.Named("user_id", params.UserID), // :user_id
+			sql.Named("secret", params.Secret),  // :secret
+		)
+		if err != nil {
+			return errors.Wrapf(err, "[sqlite3:mfa] exec failed query=%q", confirm)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return errors.Wrap(err, "[sqlite3:mfa] rows affected failed")
+		}
+		if n == 0 {
+			return store.ErrUserTOTPNotFound
+		}
+
+		const purge = `
+delete from user_recovery_codes
+where user_id = :user_id
+`
+		if _, err := q.readwrite.ExecContext(ctx, purge,
+			sql.Named("user_id", params.UserID), // :user_id
+		); err != nil {
+			return errors.Wrapf(err, "[sqlite3:mfa] exec failed query=%q", purge)
+		}
+
+		const insert = `
+insert into user_recovery_codes
+  (code_hash, user_id, used_at, created_at)
+values
+  (:code_hash, :user_id, null, :created_at)
+`
+		for _, h := range params.RecoveryCodeHashes {
+			if _, err := q.readwrite.ExecContext(ctx, insert,
+				sql.Named("code_hash", h),           // :code_hash
+				sql.Named("user_id", params.UserID), // :user_id
+				sql.Named("created_at", &now),       // :created_at
+			); err != nil {
+				return errors.Wrapf(err, "[sqlite3:mfa] exec failed query=%q", insert)
+			}
+		}
+
+		return nil
+	})
+}
+
+// UseUserTOTPStep records step as the last used TOTP time step for a user.
+// It returns store.ErrUserTOTPStepReused if step is not later than the last
+// used step.
+func (q *Queries) UseUserTOTPStep(ctx context.Context, userID string, step int64) error {
+	const query = `
+update user_totp
+set last_used_step = :step
+where user_id = :user_id and last_used_step < :step
+`
+	res, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("step", step),      // :step
+		sql.Named("user_id", userID), // :user_id
+	)
+	if err != nil {
+		return errors.Wrapf(err, "[sqlite3:mfa] exec failed query=%q", query)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "[sqlite3:mfa] rows affected failed")
+	}
+	if n == 0 {
+		return store.ErrUserTOTPStepReused
+	}
+
+	return nil
+}
+
+// UseRecoveryCode marks an unused recovery code as used. It returns
+// store.ErrRecoveryCodeNotFound if there is no unused code matching
+// codeHash for the user.
+func (q *Queries) UseRecoveryCode(ctx context.Context, userID, codeHash string) error {
+	const query = `
+update user_recovery_codes
+set used_at = :used_at
+where code_hash = :code_hash and user_id = :user_id and used_at is null
+`
+	now := store.Datetime(time.Now().UTC())
+	res, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("used_at", &now),       // :used_at
+		sql.Named("code_hash", codeHash), // :code_hash
+		sql.Named("user_id", userID),     // :user_id
+	)
+	if err != nil {
+		return errors.Wrapf(err, "[sqlite3:mfa] exec failed query=%q", query)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "[sqlite3:mfa] rows affected failed")
+	}
+	if n == 0 {
+		return store.ErrRecoveryCodeNotFound
+	}
+
+	return nil
+}
+
+// InsertMFAChallenge adds a new row to the mfa_challenges table.
+func (q *Queries) InsertMFAChallenge(ctx context.Context, params store.AddMFAChallenge) (store.MFAChallenge, error) {
+	const query = `
+insert into mfa_challenges
+  (token_hash, user_id, attempts, expires_at, created_at)
+values
+  (:token_hash, :user_id, 0, :expires_at, :created_at)
+returning
+  token_hash, user_id, attempts, expires_at, created_at
+`
+	r := store.MFAChallenge{}
+	now := store.Datetime(time.Now().UTC())
+	if err := q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("token_hash", params.TokenHash),  // :token_hash
+		sql.Named("user_id", params.UserID),        // :user_id
+		sql.Named("expires_at", &params.ExpiresAt), // :expires_at
+		sql.Named("created_at", &now),              // :created_at
+	).Scan(
+		&r.TokenHash, // 0 token_hash
+		&r.UserID,    // 1 user_id
+		&r.Attempts,  // 2 attempts
+		&r.ExpiresAt, // 3 expires_at
+		&r.CreatedAt, // 4 created_at
+	); err != nil {
+		return store.MFAChallenge{}, errors.Wrapf(err,
+			"[sqlite3:mfa] query row scan failed query=%q", query)
+	}
+
+	return r, nil
+}
+
+// GetMFAChallenge gets an mfa_challenges row by token hash.
+func (q *Queries) GetMFAChallenge(ctx context.Context, tokenHash string) (store.MFAChallenge, error) {
+	const query = `
+select
+  token_hash, user_id, attempts, expires_at, created_at
+from mfa_challenges
+where token_hash = :token_hash
+`
+	r := store.MFAChallenge{}
+	if err := q.readonly.QueryRowContext(ctx, query,
+		sql.Named("token_hash", tokenHash), // :token_hash
+	).Scan(
+		&r.TokenHash, // 0 token_hash
+		&r.UserID,    // 1 user_id
+		&r.Attempts,  // 2 attempts
+		&r.ExpiresAt, // 3 expires_at
+		&r.CreatedAt, // 4 created_at
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return store.MFAChallenge{}, store.ErrMFAChallengeNotFound
+		}
+		return store.MFAChallenge{}, errors.Wrapf(err,
+			"[sqlite3:mfa] query row scan failed query=%q", query)
+	}
+
+	return r, nil
+}
+
+// IncrMFAChallengeAttempts increments the attempt count of an
+// mfa_challenges row, returning the updated row.
+func (q *Queries) IncrMFAChallengeAttempts(ctx context.Context, tokenHash string) (store.MFAChallenge, error) {
+	const query = `
+update mfa_challenges
+set attempts = attempts + 1
+where token_hash = :token_hash
+returning
+  token_hash, user_id, attempts, expires_at, created_at
+`
+	r := store.MFAChallenge{}
+	if err := q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("token_hash", tokenHash), // :token_hash
+	).Scan(
+		&r.TokenHash, // 0 token_hash
+		&r.UserID,    // 1 user_id
+		&r.Attempts,  // 2 attempts
+		&r.ExpiresAt, // 3 expires_at
+		&r.CreatedAt, // 4 created_at
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return store.MFAChallenge{}, store.ErrMFAChallengeNotFound
+		}
+		return store.MFAChallenge{}, errors.Wrapf(err,
+			"[sqlite3:mfa] query row scan failed query=%q", query)
+	}
+
+	return r, nil
+}
+
+// DeleteMFAChallenge deletes an mfa_challenges row by token hash.
+func (q *Queries) DeleteMFAChallenge(ctx context.Context, tokenHash string) error {
+	const query = `
+delete from mfa_challenges
+where token_hash = :token_hash
+`
+	if _, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("token_hash", tokenHash), // :token_hash
+	); err != nil {
+		return errors.Wrapf(err, "[sqlite3:mfa] exec failed query=%q", query)
+	}
+
+	return nil
+}
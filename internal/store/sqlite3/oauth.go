@@ -0,0 +1,325 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/andyfusniak/monolith/internal/store"
+	"github.com/pkg/errors"
+)
+
+// InsertOAuthSigningKey adds a new row to the oauth_signing_keys table.
+func (q *Queries) InsertOAuthSigningKey(ctx context.Context, params store.AddOAuthSigningKey) (store.OAuthSigningKey, error) {
+	const query = `
+insert into oauth_signing_keys
+  (kid, alg, private_key, retires_at, expires_at, created_at)
+values
+  (:kid, :alg, :private_key, :retires_at, :expires_at, :created_at)
+returning
+  kid, alg, private_key, retires_at, expires_at, created_at
+`
+	r := store.OAuthSigningKey{}
+	now := store.Datetime(time.Now().UTC())
+	if err := q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("kid", params.KID),                // :kid
+		sql.Named("alg", params.Alg),                // :alg
+		sql.Named("private_key", params.PrivateKey), // :private_key
+		sql.Named("retires_at", &params.RetiresAt),  // :retires_at
+		sql.Named("expires_at", &params.ExpiresAt),  // :expires_at
+		sql.Named("created_at", &now),               // :created_at
+	).Scan(
+		&r.KID,        // 0 kid
+		&r.Alg,        // 1 alg
+		&r.PrivateKey, // 2 private_key
+		&r.RetiresAt,  // 3 retires_at
+		&r.ExpiresAt,  // 4 expires_at
+		&r.CreatedAt,  // 5 created_at
+	); err != nil {
+		return store.OAuthSigningKey{}, errors.Wrapf(err,
+			"[sqlite3:oauth] query row scan failed query=%q", query)
+	}
+
+	return r, nil
+}
+
+// ListOAuthSigningKeys returns the oauth_signing_keys rows that expire
+// after the given time, newest first.
+func (q *Queries) ListOAuthSigningKeys(ctx context.Context, expiresAfter store.Datetime) ([]store.OAuthSigningKey, error) {
+	const query = `
+select
+  kid, alg, private_key, retires_at, expires_at, created_at
+from oauth_signing_keys
+where expires_at > :expires_after
+order by created_at desc
+`
+	rows, err := q.readonly.QueryContext(ctx, query,
+		sql.Named("expires_after", &expiresAfter), // :expires_after
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[sqlite3:oauth] query failed query=%q", query)
+	}
+	defer rows.Close()
+
+	var keys []store.OAuthSigningKey
+	for rows.Next() {
+		var r store.OAuthSigningKey
+		if err := rows.Scan(
+			&r.KID,        // 0 kid
+			&r.Alg,        // 1 alg
+			&r.PrivateKey, // 2 private_key
+			&r.RetiresAt,  // 3 retires_at
+			&r.ExpiresAt,  // 4 expires_at
+			&r.CreatedAt,  // 5 created_at
+		); err != nil {
+			return nil, errors.Wrapf(err, "[sqlite3:oauth] rows scan failed query=%q", query)
+		}
+		keys = append(keys, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err, "[sqlite3:oauth] rows next failed query=%q", query)
+	}
+
+	return keys, nil
+}
+
+// InsertOAuthClient adds a new row to the oauth_clients table.
+func (q *Queries) InsertOAuthClient(ctx context.Context, params store.AddOAuthClient) (store.OAuthClient, error) {
+	const query = `
+insert into oauth_clients
+  (client_id, name, secret_hash, redirect_uris, created_at)
+values
+  (:client_id, :name, :secret_hash, :redirect_uris, :created_at)
+returning
+  client_id, name, secret_hash, redirect_uris, created_at
+`
+	r := store.OAuthClient{}
+	now := store.Datetime(time.Now().UTC())
+	if err := q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("client_id", params.ClientID),         // :client_id
+		sql.Named("name", params.Name),                  // :name
+		sql.Named("secret_hash", params.SecretHash),     // :secret_hash
+		sql.Named("redirect_uris", params.RedirectURIs), // :redirect_uris
+		sql.Named("created_at", &now),                   // :created_at
+	).Scan(
+		&r.ClientID,     // 0 client_id
+		&r.Name,         // 1 name
+		&r.SecretHash,   // 2 secret_hash
+		&r.RedirectURIs, // 3 redirect_uris
+		&r.CreatedAt,    // 4 created_at
+	); err != nil {
+		return store.OAuthClient{}, errors.Wrapf(err,
+			"[sqlite3:oauth] query row scan failed query=%q", query)
+	}
+
+	return r, nil
+}
+
+// GetOAuthClient gets an oauth_clients row by primary key.
+func (q *Queries) GetOAuthClient(ctx context.Context, clientID string) (store.OAuthClient, error) {
+	const query = `
+select
+  client_id, name, secret_hash, redirect_uris, created_at
+from oauth_clients
+where client_id = :client_id
+`
+	r := store.OAuthClient{}
+	if err := q.readonly.QueryRowContext(ctx, query,
+		sql.Named("client_id", clientID), // :client_id
+	).Scan(
+		&r.ClientID,     // 0 client_id
+		&r.Name,         // 1 name
+		&r.SecretHash,   // 2 secret_hash
+		&r.RedirectURIs, // 3 redirect_uris
+		&r.CreatedAt,    // 4 created_at
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return store.OAuthClient{}, store.ErrOAuthClientNotFound
+		}
+		return store.OAuthClient{}, errors.Wrapf(err,
+			"[sqlite3:oauth] query row scan failed query=%q", query)
+	}
+
+	return r, nil
+}
+
+// ListOAuthClients returns all oauth_clients rows ordered by creation
+// time.
+func (q *Queries) ListOAuthClients(ctx context.Context) ([]store.OAuthClient, error) {
+	const query = `
+select
+  client_id, name, secret_hash, redirect_uris, created_at
+from oauth_clients
+order by created_at
+`
+	rows, err := q.readonly.QueryContext(ctx, query)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[sqlite3:oauth] query failed query=%q", query)
+	}
+	defer rows.Close()
+
+	var clients []store.OAuthClient
+	for rows.Next() {
+		var r store.OAuthClient
+		if err := rows.Scan(
+			&r.ClientID,     // 0 client_id
+			&r.Name,         // 1 name
+			&r.SecretHash,   // 2 secret_hash
+			&r.RedirectURIs, // 3 redirect_uris
+			&r.CreatedAt,    // 4 created_at
+		); err != nil {
+			return nil, errors.Wrapf(err, "[sqlite3:oauth] rows scan failed query=%q", query)
+		}
+		clients = append(clients, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err, "[sqlite3:oauth] rows next failed query=%q", query)
+	}
+
+	return clients, nil
+}
+
+// DeleteOAuthClient deletes an oauth_clients row and, by cascade, its
+// codes and refresh tokens.
+func (q *Queries) DeleteOAuthClient(ctx context.Context, clientID string) error {
+	const query = `
+delete from oauth_clients
+where client_id = :client_id
+`
+	res, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("client_id", clientID), // :client_id
+	)
+	if err != nil {
+		return errors.Wrapf(err, "[sqlite3:oauth] exec failed query=%q", query)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrapf(err, "[sqlite3:oauth] rows affected failed query=%q", query)
+	}
+	if n == 0 {
+		return store.ErrOAuthClientNotFound
+	}
+
+	return nil
+}
+
+// InsertOAuthAuthorizationCode adds a new row to the
+// oauth_authorization_codes table.
+func (q *Queries) InsertOAuthAuthorizationCode(ctx context.Context, params store.AddOAuthAuthorizationCode) error {
+	const query = `
+insert into oauth_authorization_codes
+  (code_hash, client_id, user_id, redirect_uri, redirect_uri_required, scope, nonce,
+   code_challenge, code_challenge_method, expires_at, created_at)
+values
+  (:code_hash, :client_id, :user_id, :redirect_uri, :redirect_uri_required, :scope, :nonce,
+   :code_challenge, :code_challenge_method, :expires_at, :created_at)
+`
+	now := store.Datetime(time.Now().UTC())
+	if _, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("code_hash", params.CodeHash),                        // :code_hash
+		sql.Named("client_id", params.ClientID),                        // :client_id
+		sql.Named("user_id", params.UserID),                            // :user_id
+		sql.Named("redirect_uri", params.RedirectURI),                  // :redirect_uri
+		sql.Named("redirect_uri_required", params.RedirectURIRequired), // :redirect_uri_required
+		sql.Named("scope", params.Scope),                               // :scope
+		sql.Named("nonce", params.Nonce),                               // :nonce
+		sql.Named("code_challenge", params.CodeChallenge),              // :code_challenge
+		sql.Named("code_challenge_method", params.CodeChallengeMethod), // :code_challenge_method
+		sql.Named("expires_at", &params.ExpiresAt),                     // :expires_at
+		sql.Named("created_at", &now),                                  // :created_at
+	); err != nil {
+		return errors.Wrapf(err, "[sqlite3:oauth] exec failed query=%q", query)
+	}
+
+	return nil
+}
+
+// TakeOAuthAuthorizationCode deletes and returns an
+// oauth_authorization_codes row so each code can only be used once.
+func (q *Queries) TakeOAuthAuthorizationCode(ctx context.Context, codeHash string) (store.OAuthAuthorizationCode, error) {
+	const query = `
+delete from oauth_authorization_codes
+where code_hash = :code_hash
+returning
+  code_hash, client_id, user_id, redirect_uri, redirect_uri_required, scope, nonce,
+  code_challenge, code_challenge_method, expires_at, created_at
+`
+	r := store.OAuthAuthorizationCode{}
+	if err := q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("code_hash", codeHash), // :code_hash
+	).Scan(
+		&r.CodeHash,            // 0 code_hash
+		&r.ClientID,            // 1 client_id
+		&r.UserID,              // 2 user_id
+		&r.RedirectURI,         // 3 redirect_uri
+		&r.RedirectURIRequired, // 4 redirect_uri_required
+		&r.Scope,               // 5 scope
+		&r.Nonce,               // 6 nonce
+		&r.CodeChallenge,       // 7 code_challenge
+		&r.CodeChallengeMethod, // 8 code_challenge_method
+		&r.ExpiresAt,           // 9 expires_at
+		&r.CreatedAt,           // 10 created_at
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return store.OAuthAuthorizationCode{}, store.ErrOAuthAuthorizationCodeNotFound
+		}
+		return store.OAuthAuthorizationCode{}, errors.Wrapf(err,
+			"[sqlite3:oauth] query row scan failed query=%q", query)
+	}
+
+	return r, nil
+}
+
+// InsertOAuthRefreshToken adds a new row to the oauth_refresh_tokens
+// table.
+func (q *Queries) InsertOAuthRefreshToken(ctx context.Context, params store.AddOAuthRefreshToken) error {
+	const query = `
+insert into oauth_refresh_tokens
+  (token_hash, client_id, user_id, scope, expires_at, created_at)
+values
+  (:token_hash, :client_id, :user_id, :scope, :expires_at, :created_at)
+`
+	now := store.Datetime(time.Now().UTC())
+	if _, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("token_hash", params.TokenHash),  // :token_hash
+		sql.Named("client_id", params.ClientID),    // :client_id
+		sql.Named("user_id", params.UserID),        // :user_id
+		sql.Named("scope", params.Scope),           // :scope
+		sql.Named("expires_at", &params.ExpiresAt), // :expires_at
+		sql.Named("created_at", &now),              // :created_at
+	); err != nil {
+		return errors.Wrapf(err, "[sqlite3:oauth] exec failed query=%q", query)
+	}
+
+	return nil
+}
+
+// TakeOAuthRefreshToken deletes and returns an oauth_refresh_tokens row so
+// each refresh token can only be used once.
+func (q *Queries) TakeOAuthRefreshToken(ctx context.Context, tokenHash string) (store.OAuthRefreshToken, error) {
+	const query = `
+delete from oauth_refresh_tokens
+where token_hash = :token_hash
+returning
+  token_hash, client_id, user_id, scope, expires_at, created_at
+`
+	r := store.OAuthRefreshToken{}
+	if err := q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("token_hash", tokenHash), // :token_hash
+	).Scan(
+		&r.TokenHash, // 0 token_hash
+		&r.ClientID,  // 1 client_id
+		&r.UserID,    // 2 user_id
+		&r.Scope,     // 3 scope
+		&r.ExpiresAt, // 4 expires_at
+		&r.CreatedAt, // 5 created_at
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return store.OAuthRefreshToken{}, store.ErrOAuthRefreshTokenNotFound
+		}
+		return store.OAuthRefreshToken{}, errors.Wrapf(err,
+			"[sqlite3:oauth] query row scan failed query=%q", query)
+	}
+
+	return r, nil
+}
@@ -0,0 +1,569 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/andyfusniak/monolith/internal/store"
+	"github.com/pkg/errors"
+)
+
+// organizations
+
+// InsertOrganization adds a new organizations row and the owner's
+// org_memberships row in a single transaction.
+func (s *Store) InsertOrganization(ctx context.Context, params store.AddOrganization) (store.Organization, error) {
+	var r store.Organization
+	err := s.execTx(ctx, func(q *Queries) error {
+		const query = `
+insert into organizations
+  (org_id, name, created_at)
+values
+  (:org_id, :name, :created_at)
+returning
+  org_id, name, created_at
+`
+		now := store.Datetime(time.Now().UTC())
+		if err := q.readwrite.QueryRowContext(ctx, query,
+			sql.Named("org_id", params.OrgID), // :org_id
+			sql.Named("name", params.Name),    // :name
+			sql.Named("created_at", &now),     // :created_at
+		).Scan(
+			&r.OrgID,     // 0 org_id
+			&r.Name,      // 1 name
+			&r.CreatedAt, // 2 created_at
+		); err != nil {
+			return errors.Wrapf(err, "[sqlite3:organizations] query row scan failed query=%q", query)
+		}
+
+		return q.insertOrgMember(ctx, params.OrgID, params.OwnerUserID, "owner")
+	})
+	if err != nil {
+		return store.Organization{}, err
+	}
+
+	return r, nil
+}
+
+// GetOrganization gets an organizations row by primary key.
+func (q *Queries) GetOrganization(ctx context.Context, orgID string) (store.Organization, error) {
+	const query = `
+select
+  org_id, name, created_at
+from organizations
+where org_id = :org_id
+`
+	r := store.Organization{}
+	if err := q.readonly.QueryRowContext(ctx, query,
+		sql.Named("org_id", orgID), // :org_id
+	).Scan(
+		&r.OrgID,     // 0 org_id
+		&r.Name,      // 1 name
+		&r.CreatedAt, // 2 created_at
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return store.Organization{}, store.ErrOrganizationNotFound
+		}
+		return store.Organization{}, errors.Wrapf(err,
+			"[sqlite3:organizations] query row scan failed query=%q", query)
+	}
+
+	return r, nil
+}
+
+// ListUserOrganizations returns the organizations a user is a member of
+// with the user's role in each.
+func (q *Queries) ListUserOrganizations(ctx context.Context, userID string) ([]store.UserOrganization, error) {
+	const query = `
+select
+  o.org_id, o.name, m.role, o.created_at
+from org_memberships as m
+join organizations as o on o.org_id = m.org_id
+where m.user_id = :user_id
+order by o.name, o.org_id
+`
+	rows, err := q.readonly.QueryContext(ctx, query,
+		sql.Named("user_id", userID), // :user_id
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[sqlite3:organizations] query failed query=%q", query)
+	}
+	defer rows.Close()
+
+	var orgs []store.UserOrganization
+	for rows.Next() {
+		var r store.UserOrganization
+		if err := rows.Scan(
+			&r.OrgID,     // 0 org_id
+			&r.Name,      // 1 name
+			&r.Role,      // 2 role
+			&r.CreatedAt, // 3 created_at
+		); err != nil {
+			return nil, errors.Wrapf(err, "[sqlite3:organizations] rows scan failed query=%q", query)
+		}
+		orgs = append(orgs, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err, "[sqlite3:organizations] rows next failed query=%q", query)
+	}
+
+	return orgs, nil
+}
+
+// DeleteOrganization deletes an organizations row and, by cascade, its
+// memberships and invitations.
+func (q *Queries) DeleteOrganization(ctx context.Context, orgID string) error {
+	const query = `
+delete from organizations
+where org_id = :org_id
+`
+	res, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("org_id", orgID), // :org_id
+	)
+	if err != nil {
+		return errors.Wrapf(err, "[sqlite3:organizations] exec failed query=%q", query)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrapf(err, "[sqlite3:organizations] rows affected failed query=%q", query)
+	}
+	if n == 0 {
+		return store.ErrOrganizationNotFound
+	}
+
+	return nil
+}
+
+// members
+
+func (q *Queries) insertOrgMember(ctx context.Context, orgID, userID, role string) error {
+	const query = `
+insert into org_memberships
+  (org_id, user_id, role, created_at)
+values
+  (:org_id, :user_id, :role, :created_at)
+on conflict (org_id, user_id) do nothing
+`
+	now := store.Datetime(time.Now().UTC())
+	res, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("org_id", orgID),    // :org_id
+		sql.Named("user_id", userID),  // :user_id
+		sql.Named("role", role),       // :role
+		sql.Named("created_at", &now), // :created_at
+	)
+	if err != nil {
+		return errors.Wrapf(err, "[sqlite3:organizations] exec failed query=%q", query)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrapf(err, "[sqlite3:organizations] rows affected failed query=%q", query)
+	}
+	if n == 0 {
+		return store.ErrOrgMemberExists
+	}
+
+	return nil
+}
+
+// GetOrgMember gets an org_memberships row by primary key.
+func (q *Queries) GetOrgMember(ctx context.Context, orgID, userID string) (store.OrgMember, error) {
+	const query = `
+select
+  m.org_id, m.user_id, u.email, m.role, m.created_at
+from org_memberships as m
+join users as u on u.user_id = m.user_id
+where m.org_id = :org_id and m.user_id = :user_id
+`
+	r := store.OrgMember{}
+	if err := q.readonly.QueryRowContext(ctx, query,
+		sql.Named("org_id", orgID),   // :org_id
+		sql.Named("user_id", userID), // :user_id
+	).Scan(
+		&r.OrgID,     // 0 org_id
+		&r.UserID,    // 1 user_id
+		&r.Email,     // 2 email
+		&r.Role,      // 3 role
+		&r.CreatedAt, // 4 created_at
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return store.OrgMember{}, store.ErrOrgMemberNotFound
+		}
+		return store.OrgMember{}, errors.Wrapf(err,
+			"[sqlite3:organizations] query row scan failed query=%q", query)
+	}
+
+	return r, nil
+}
+
+// ListOrgMembers returns the members of an organization.
+func (q *Queries) ListOrgMembers(ctx context.Context, orgID string) ([]store.OrgMember, error) {
+	const query = `
+select
+  m.org_id, m.user_id, u.email, m.role, m.created_at
+from org_memberships as m
+join users as u on u.user_id = m.user_id
+where m.org_id = :org_id
+order by m.created_at
+`
+	rows, err := q.readonly.QueryContext(ctx, query,
+		sql.Named("org_id", orgID), // :org_id
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[sqlite3:organizations] query failed query=%q", query)
+	}
+	defer rows.Close()
+
+	var members []store.OrgMember
+	for rows.Next() {
+		var r store.OrgMember
+		if err := rows.Scan(
+			&r.OrgID,     // 0 org_id
+			&r.UserID,    // 1 user_id
+			&r.Email,     // 2 email
+			&r.Role,      // 3 role
+			&r.CreatedAt, // 4 created_at
+		); err != nil {
+			return nil, errors.Wrapf(err, "[sqlite3:organizations] rows scan failed query=%q", query)
+		}
+		members = append(members, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err, "[sqlite3:organizations] rows next failed query=%q", query)
+	}
+
+	return members, nil
+}
+
+// countOrgOwners returns the number of members of an organization with the
+// owner role.
+func (q *Queries) countOrgOwners(ctx context.Context, orgID string) (int, error) {
+	const query = `
+select count(*)
+from org_memberships
+where org_id = :org_id and role = 'owner'
+`
+	var n int
+	if err := q.readonly.QueryRowContext(ctx, query,
+		sql.Named("org_id", orgID), // :org_id
+	).Scan(
+		&n, // 0 count(*)
+	); err != nil {
+		return 0, errors.Wrapf(err, "[sqlite3:organizations] query row scan failed query=%q", query)
+	}
+
+	return n, nil
+}
+
+// checkNotLastOwner returns store.ErrOrgLastOwner if the member is the only
+// owner of the organization. It must be called in the transaction that
+// demotes or removes the member so the count cannot change in between.
+func (q *Queries) checkNotLastOwner(ctx context.Context, orgID, userID string) error {
+	m, err := q.GetOrgMember(ctx, orgID, userID)
+	if err != nil {
+		return err
+	}
+	if m.Role != "owner" {
+		return nil
+	}
+	n, err := q.countOrgOwners(ctx, orgID)
+	if err != nil {
+		return err
+	}
+	if n <= 1 {
+		return store.ErrOrgLastOwner
+	}
+
+	return nil
+}
+
+// UpdateOrgMemberRole sets the role of an org_memberships row. Demoting the
+// last owner returns store.ErrOrgLastOwner.
+func (s *Store) UpdateOrgMemberRole(ctx context.Context, orgID, userID, role string) error {
+	return s.execTx(ctx, func(q *Queries) error {
+		if role != "owner" {
+			if err := q.checkNotLastOwner(ctx, orgID, userID); err != nil {
+				return err
+			}
+		}
+		return q.updateOrgMemberRole(ctx, orgID, userID, role)
+	})
+}
+
+func (q *Queries) updateOrgMemberRole(ctx context.Context, orgID, userID, role string) error {
+	const query = `
+update org_memberships
+set role = :role
+where org_id = :org_id and user_id = :user_id
+`
+	res, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("role", role),      // :role
+		sql.Named("org_id", orgID),   // :org_id
+		sql.Named("user_id", userID), // :user_id
+	)
+	if err != nil {
+		return errors.Wrapf(err, "[sqlite3:organizations] exec failed query=%q", query)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrapf(err, "[sqlite3:organizations] rows affected failed query=%q", query)
+	}
+	if n == 0 {
+		return store.ErrOrgMemberNotFound
+	}
+
+	return nil
+}
+
+// DeleteOrgMember deletes an org_memberships row. Removing the last owner
+// returns store.ErrOrgLastOwner.
+func (s *Store) DeleteOrgMember(ctx context.Context, orgID, userID string) error {
+	return s.execTx(ctx, func(q *Queries) error {
+		if err := q.checkNotLastOwner(ctx, orgID, userID); err != nil {
+			return err
+		}
+		return q.deleteOrgMember(ctx, orgID, userID)
+	})
+}
+
+func (q *Queries) deleteOrgMember(ctx context.Context, orgID, userID string) error {
+	const query = `
+delete from org_memberships
+where org_id = :org_id and user_id = :user_id
+`
+	res, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("org_id", orgID),   // :org_id
+		sql.Named("user_id", userID), // :user_id
+	)
+	if err != nil {
+		return errors.Wrapf(err, "[sqlite3:organizations] exec failed query=%q", query)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrapf(err, "[sqlite3:organizations] rows affected failed query=%q", query)
+	}
+	if n == 0 {
+		return store.ErrOrgMemberNotFound
+	}
+
+	return nil
+}
+
+// invitations
+
+// UpsertOrgInvitation adds an org_invitations row, replacing any existing
+// invitation for the same organization and email.
+func (q *Queries) UpsertOrgInvitation(ctx context.Context, params store.AddOrgInvitation) (store.OrgInvitation, error) {
+	const query = `
+insert into org_invitations
+  (invitation_id, org_id, email, role, token_hash, invited_by, expires_at, created_at)
+values
+  (:invitation_id, :org_id, :email, :role, :token_hash, :invited_by, :expires_at, :created_at)
+on conflict (org_id, email) do update set
+  invitation_id = excluded.invitation_id,
+  role          = excluded.role,
+  token_hash    = excluded.token_hash,
+  invited_by    = excluded.invited_by,
+  expires_at    = excluded.expires_at,
+  created_at    = excluded.created_at
+returning
+  invitation_id, org_id,
+  (select name from organizations where org_id = :org_id),
+  email, role, token_hash, invited_by, expires_at, created_at
+`
+	r := store.OrgInvitation{}
+	now := store.Datetime(time.Now().UTC())
+	if err := q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("invitation_id", params.InvitationID), // :invitation_id
+		sql.Named("org_id", params.OrgID),               // :org_id
+		sql.Named("email", params.Email),                // :email
+		sql.Named("role", params.Role),                  // :role
+		sql.Named("token_hash", params.TokenHash),       // :token_hash
+		sql.Named("invited_by", params.InvitedBy),       // :invited_by
+		sql.Named("expires_at", &params.ExpiresAt),      // :expires_at
+		sql.Named("created_at", &now),                   // :created_at
+	).Scan(
+		&r.InvitationID, // 0 invitation_id
+		&r.OrgID,        // 1 org_id
+		&r.OrgName,      // 2 organizations.name
+		&r.Email,        // 3 email
+		&r.Role,         // 4 role
+		&r.TokenHash,    // 5 token_hash
+		&r.InvitedBy,    // 6 invited_by
+		&r.ExpiresAt,    // 7 expires_at
+		&r.CreatedAt,    // 8 created_at
+	); err != nil {
+		return store.OrgInvitation{}, errors.Wrapf(err,
+			"[sqlite3:organizations] query row scan failed query=%q", query)
+	}
+
+	return r, nil
+}
+
+// ListOrgInvitations returns the pending invitations of an organization.
+func (q *Queries) ListOrgInvitations(ctx context.Context, orgID string) ([]store.OrgInvitation, error) {
+	const query = `
+select
+  i.invitation_id, i.org_id, o.name, i.email, i.role, i.token_hash,
+  i.invited_by, i.expires_at, i.created_at
+from org_invitations as i
+join organizations as o on o.org_id = i.org_id
+where i.org_id = :org_id
+order by i.created_at desc
+`
+	rows, err := q.readonly.QueryContext(ctx, query,
+		sql.Named("org_id", orgID), // :org_id
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[sqlite3:organizations] query failed query=%q", query)
+	}
+	defer rows.Close()
+
+	var invitations []store.OrgInvitation
+	for rows.Next() {
+		var r store.OrgInvitation
+		if err := rows.Scan(
+			&r.InvitationID, // 0 invitation_id
+			&r.OrgID,        // 1 org_id
+			&r.OrgName,      // 2 name
+			&r.Email,        // 3 email
+			&r.Role,         // 4 role
+			&r.TokenHash,    // 5 token_hash
+			&r.InvitedBy,    // 6 invited_by
+			&r.ExpiresAt,    // 7 expires_at
+			&r.CreatedAt,    // 8 created_at
+		); err != nil {
+			return nil, errors.Wrapf(err, "[sqlite3:organizations] rows scan failed query=%q", query)
+		}
+		invitations = append(invitations, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err, "[sqlite3:organizations] rows next failed query=%q", query)
+	}
+
+	return invitations, nil
+}
+
+// DeleteOrgInvitation deletes an org_invitations row belonging to an
+// organization.
+func (q *Queries) DeleteOrgInvitation(ctx context.Context, orgID, invitationID string) error {
+	const query = `
+delete from org_invitations
+where org_id = :org_id and invitation_id = :invitation_id
+`
+	res, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("org_id", orgID),               // :org_id
+		sql.Named("invitation_id", invitationID), // :invitation_id
+	)
+	if err != nil {
+		return errors.Wrapf(err, "[sqlite3:organizations] exec failed query=%q", query)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrapf(err, "[sqlite3:organizations] rows affected failed query=%q", query)
+	}
+	if n == 0 {
+		return store.ErrOrgInvitationNotFound
+	}
+
+	return nil
+}
+
+// GetOrgInvitationByTokenHash gets an org_invitations row by token hash.
+func (q *Queries) GetOrgInvitationByTokenHash(ctx context.Context, tokenHash string) (store.OrgInvitation, error) {
+	const query = `
+select
+  i.invitation_id, i.org_id, o.name, i.email, i.role, i.token_hash,
+  i.invited_by, i.expires_at, i.created_at
+from org_invitations as i
+join organizations as o on o.org_id = i.org_id
+where i.token_hash = :token_hash
+`
+	r := store.OrgInvitation{}
+	if err := q.readonly.QueryRowContext(ctx, query,
+		sql.Named("token_hash", tokenHash), // :token_hash
+	).Scan(
+		&r.InvitationID, // 0 invitation_id
+		&r.OrgID,        // 1 org_id
+		&r.OrgName,      // 2 name
+		&r.Email,        // 3 email
+		&r.Role,         // 4 role
+		&r.TokenHash,    // 5 token_hash
+		&r.InvitedBy,    // 6 invited_by
+		&r.ExpiresAt,    // 7 expires_at
+		&r.CreatedAt,    // 8 created_at
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return store.OrgInvitation{}, store.ErrOrgInvitationNotFound
+		}
+		return store.OrgInvitation{}, errors.Wrapf(err,
+			"[sqlite3:organizations] query row scan failed query=%q", query)
+	}
+
+	return r, nil
+}
+
+// AcceptOrgInvitation deletes an org_invitations row by token hash and adds
+// the user as a member with the invited role in a single transaction. If
+// the user is already a member the invitation is still deleted and
+// store.ErrOrgMemberExists returned.
+func (s *Store) AcceptOrgInvitation(ctx context.Context, tokenHash, userID string) (store.OrgMember, error) {
+	var orgID string
+	var memberErr error
+	err := s.execTx(ctx, func(q *Queries) error {
+		inv, err := q.takeOrgInvitation(ctx, tokenHash)
+		if err != nil {
+			return err
+		}
+		orgID = inv.OrgID
+		memberErr = q.insertOrgMember(ctx, inv.OrgID, userID, inv.Role)
+		if errors.Is(memberErr, store.ErrOrgMemberExists) {
+			return nil // commit the delete
+		}
+		return memberErr
+	})
+	if err != nil {
+		return store.OrgMember{}, err
+	}
+	if memberErr != nil {
+		return store.OrgMember{}, memberErr
+	}
+
+	return s.GetOrgMember(ctx, orgID, userID)
+}
+
+// DeclineOrgInvitation deletes an org_invitations row by token hash.
+func (q *Queries) DeclineOrgInvitation(ctx context.Context, tokenHash string) error {
+	_, err := q.takeOrgInvitation(ctx, tokenHash)
+	return err
+}
+
+func (q *Queries) takeOrgInvitation(ctx context.Context, tokenHash string) (store.OrgInvitation, error) {
+	const query = `
+delete from org_invitations
+where token_hash = :token_hash
+returning
+  invitation_id, org_id, email, role, token_hash, invited_by, expires_at, created_at
+`
+	r := store.OrgInvitation{}
+	if err := q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("token_hash", tokenHash), // :token_hash
+	).Scan(
+		&r.InvitationID, // 0 invitation_id
+		&r.OrgID,        // 1 org_id
+		&r.Email,        // 2 email
+		&r.Role,         // 3 role
+		&r.TokenHash,    // 4 token_hash
+		&r.InvitedBy,    // 5 invited_by
+		&r.ExpiresAt,    // 6 expires_at
+		&r.CreatedAt,    // 7 created_at
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return store.OrgInvitation{}, store.ErrOrgInvitationNotFound
+		}
+		return store.OrgInvitation{}, errors.Wrapf(err,
+			"[sqlite3:organizations] query row scan failed query=%q", query)
+	}
+
+	return r, nil
+}
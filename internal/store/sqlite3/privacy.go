@@ -0,0 +1,256 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/andyfusniak/monolith/internal/store"
+	"github.com/pkg/errors"
+)
+
+// user exports
+
+// InsertUserExport adds a new pending user_exports row.
+func (q *Queries) InsertUserExport(ctx context.Context, params store.AddUserExport) (store.UserExport, error) {
+	const query = `
+insert into user_exports
+  (export_id, user_id, status, created_at, expires_at)
+values
+  (:export_id, :user_id, 'pending', :created_at, :expires_at)
+returning
+  export_id, user_id, status, archive, created_at, completed_at, expires_at
+`
+	now := store.Datetime(time.Now().UTC())
+	r, err := scanUserExport(q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("export_id", params.ExportID),    // :export_id
+		sql.Named("user_id", params.UserID),        // :user_id
+		sql.Named("created_at", &now),              // :created_at
+		sql.Named("expires_at", &params.ExpiresAt), // :expires_at
+	))
+	if err != nil {
+		return store.UserExport{}, errors.Wrapf(err,
+			"[sqlite3:privacy] query row scan failed query=%q", query)
+	}
+
+	return r, nil
+}
+
+// GetUserExport gets a user_exports row by primary key. The userID must
+// match that of the row.
+func (q *Queries) GetUserExport(ctx context.Context, userID, exportID string) (store.UserExport, error) {
+	const query = `
+select
+  export_id, user_id, status, archive, created_at, completed_at, expires_at
+from user_exports
+where export_id = :export_id
+  and user_id = :user_id
+`
+	r, err := scanUserExport(q.readonly.QueryRowContext(ctx, query,
+		sql.Named("export_id", exportID), // :export_id
+		sql.Named("user_id", userID),     // :user_id
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return store.UserExport{}, store.ErrUserExportNotFound
+		}
+		return store.UserExport{}, errors.Wrapf(err,
+			"[sqlite3:privacy] query row scan failed query=%q", query)
+	}
+
+	return r, nil
+}
+
+// CompleteUserExport sets the status of a pending user_exports row and,
+// if it is ready, its archive.
+func (q *Queries) CompleteUserExport(ctx context.Context, exportID, status string, archive *string) error {
+	const query = `
+update user_exports
+set status = :status, archive = :archive, completed_at = :completed_at
+where export_id = :export_id
+  and status = 'pending'
+`
+	now := store.Datetime(time.Now().UTC())
+	res, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("status", status),      // :status
+		sql.Named("archive", archive),    // :archive
+		sql.Named("completed_at", &now),  // :completed_at
+		sql.Named("export_id", exportID), // :export_id
+	)
+	if err != nil {
+		return errors.Wrapf(err, "[sqlite3:privacy] exec failed query=%q", query)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "[sqlite3:privacy] rows affected failed")
+	}
+	if n == 0 {
+		return store.ErrUserExportNotFound
+	}
+
+	return nil
+}
+
+// FailPendingUserExports sets the status of user_exports rows created
+// before the given time and still pending to failed, returning the number
+// updated.
+func (q *Queries) FailPendingUserExports(ctx context.Context, before store.Datetime) (int64, error) {
+	const query = `
+update user_exports
+set status = 'failed', completed_at = :completed_at
+where status = 'pending'
+  and created_at < :before
+`
+	now := store.Datetime(time.Now().UTC())
+	res, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("completed_at", &now), // :completed_at
+		sql.Named("before", &before),    // :before
+	)
+	if err != nil {
+		return 0, errors.Wrapf(err, "[sqlite3:privacy] exec failed query=%q", query)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "[sqlite3:privacy] rows affected failed")
+	}
+
+	return n, nil
+}
+
+// DeleteExpiredUserExports deletes user_exports rows that expired before
+// now, returning the number deleted.
+func (q *Queries) DeleteExpiredUserExports(ctx context.Context, now store.Datetime) (int64, error) {
+	const query = `
+delete from user_exports
+where expires_at < :now
+`
+	res, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("now", &now), // :now
+	)
+	if err != nil {
+		return 0, errors.Wrapf(err, "[sqlite3:privacy] exec failed query=%q", query)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "[sqlite3:privacy] rows affected failed")
+	}
+
+	return n, nil
+}
+
+func scanUserExport(row interface{ Scan(...any) error }) (store.UserExport, error) {
+	var r store.UserExport
+	err := row.Scan(
+		&r.ExportID,    // 0 export_id
+		&r.UserID,      // 1 user_id
+		&r.Status,      // 2 status
+		&r.Archive,     // 3 archive
+		&r.CreatedAt,   // 4 created_at
+		&r.CompletedAt, // 5 completed_at
+		&r.ExpiresAt,   // 6 expires_at
+	)
+	return r, err
+}
+
+// erasure
+
+// EraseUser deletes a user and all data tied to them in a single
+// transaction, leaving a user_tombstones row and appending event to the
+// audit log.
+//
+// Rows referencing the user are removed by cascading foreign keys. Rows
+// that hold the user's email without a foreign key are deleted
+// explicitly, as are organizations the user is the only member of. If
+// the user is the only owner of an organization with other members
+// store.ErrUserSoleOwner is returned and nothing is changed. Audit events
+// are kept and refer to the user by user_id only.
+func (s *Store) EraseUser(ctx context.Context, userID string, event store.AddAuditEvent) error {
+	return s.execTx(ctx, func(q *Queries) error {
+		user, err := q.GetUser(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		const soleOwnerQuery = `
+select count(*)
+from org_memberships m
+where m.user_id = :user_id
+  and m.role = 'owner'
+  and not exists (
+    select 1 from org_memberships o
+    where o.org_id = m.org_id and o.user_id != m.user_id and o.role = 'owner')
+  and exists (
+    select 1 from org_memberships o
+    where o.org_id = m.org_id and o.user_id != m.user_id)
+`
+		var n int
+		if err := q.readwrite.QueryRowContext(ctx, soleOwnerQuery,
+			sql.Named("user_id", userID), // :user_id
+		).Scan(&n); err != nil {
+			return errors.Wrapf(err, "[sqlite3:privacy] query row scan failed query=%q", soleOwnerQuery)
+		}
+		if n > 0 {
+			return store.ErrUserSoleOwner
+		}
+
+		const orgsQuery = `
+delete from organizations
+where org_id in (
+  select m.org_id from org_memberships m
+  where m.user_id = :user_id
+    and not exists (
+      select 1 from org_memberships o
+      where o.org_id = m.org_id and o.user_id != m.user_id))
+`
+		if _, err := q.readwrite.ExecContext(ctx, orgsQuery,
+			sql.Named("user_id", userID), // :user_id
+		); err != nil {
+			return errors.Wrapf(err, "[sqlite3:privacy] exec failed query=%q", orgsQuery)
+		}
+
+		// rows keyed by email rather than user_id
+		for _, query := range []string{`
+delete from signin_failures
+where scope = 'account' and key = lower(:email)
+`, `
+delete from magic_links
+where email = lower(:email)
+`, `
+delete from org_invitations
+where lower(email) = lower(:email)
+`} {
+			if _, err := q.readwrite.ExecContext(ctx, query,
+				sql.Named("email", user.Email), // :email
+			); err != nil {
+				return errors.Wrapf(err, "[sqlite3:privacy] exec failed query=%q", query)
+			}
+		}
+
+		const usersQuery = `
+delete from users
+where user_id = :user_id
+`
+		if _, err := q.readwrite.ExecContext(ctx, usersQuery,
+			sql.Named("user_id", userID), // :user_id
+		); err != nil {
+			return errors.Wrapf(err, "[sqlite3:privacy] exec failed query=%q", usersQuery)
+		}
+
+		const tombstoneQuery = `
+insert into user_tombstones
+  (user_id, erased_at)
+values
+  (:user_id, :erased_at)
+`
+		now := store.Datetime(time.Now().UTC())
+		if _, err := q.readwrite.ExecContext(ctx, tombstoneQuery,
+			sql.Named("user_id", userID), // :user_id
+			sql.Named("erased_at", &now), // :erased_at
+		); err != nil {
+			return errors.Wrapf(err, "[sqlite3:privacy] exec failed query=%q", tombstoneQuery)
+		}
+
+		_, err = q.appendAuditEvent(ctx, event)
+		return err
+	})
+}
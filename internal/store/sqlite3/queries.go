@@ -21,7 +21,7 @@ type Queries struct {
 // NewQueries create a new comments query.
 func NewQueries(ro, rw DBTx) *Queries {
 	return &Queries{
-		readonly:  ro,
-		readwrite: rw,
+		readonly:  tracedDBTx{ro},
+		readwrite: tracedDBTx{rw},
 	}
 }
@@ -0,0 +1,99 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/andyfusniak/monolith/internal/store"
+	"github.com/pkg/errors"
+)
+
+// TakeRateLimitToken refills the token bucket for params.Key for the time
+// since it was last updated and takes a token if it holds one. Buckets
+// are refilled and taken from in a single statement, so concurrent
+// requests cannot take the same token. A bucket left without a token is
+// not updated, and is returned with Taken false.
+func (q *Queries) TakeRateLimitToken(ctx context.Context, params store.TakeRateLimitToken) (store.RateLimitBucket, error) {
+	const query = `
+insert into rate_limit_buckets
+  (key, tokens, updated_at, expires_at)
+values
+  (:key, :burst - 1.0, :now, :expires_at)
+on conflict (key) do update set
+  tokens = min(:burst, tokens + max(julianday(:now) - julianday(updated_at), 0) * 86400.0 * :rate) - 1.0,
+  updated_at = :now,
+  expires_at = :expires_at
+where min(:burst, tokens + max(julianday(:now) - julianday(updated_at), 0) * 86400.0 * :rate) >= 1.0
+returning
+  key, tokens, updated_at, expires_at
+`
+	r := store.RateLimitBucket{Taken: true}
+	now := store.Datetime(time.Now().UTC())
+	err := q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("key", params.Key),               // :key
+		sql.Named("burst", params.Burst),           // :burst
+		sql.Named("rate", params.Rate),             // :rate
+		sql.Named("now", &now),                     // :now
+		sql.Named("expires_at", &params.ExpiresAt), // :expires_at
+	).Scan(
+		&r.Key,       // 0 key
+		&r.Tokens,    // 1 tokens
+		&r.UpdatedAt, // 2 updated_at
+		&r.ExpiresAt, // 3 expires_at
+	)
+	if err == nil {
+		return r, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return store.RateLimitBucket{}, errors.Wrapf(err,
+			"[sqlite3:ratelimits] query row scan failed query=%q", query)
+	}
+
+	// the bucket has no token to take; report what it holds
+	const emptyQuery = `
+select
+  key, min(:burst, tokens + max(julianday(:now) - julianday(updated_at), 0) * 86400.0 * :rate),
+  updated_at, expires_at
+from rate_limit_buckets
+where key = :key
+`
+	r = store.RateLimitBucket{}
+	if err := q.readwrite.QueryRowContext(ctx, emptyQuery,
+		sql.Named("burst", params.Burst), // :burst
+		sql.Named("now", &now),           // :now
+		sql.Named("rate", params.Rate),   // :rate
+		sql.Named("key", params.Key),     // :key
+	).Scan(
+		&r.Key,       // 0 key
+		&r.Tokens,    // 1 tokens
+		&r.UpdatedAt, // 2 updated_at
+		&r.ExpiresAt, // 3 expires_at
+	); err != nil {
+		return store.RateLimitBucket{}, errors.Wrapf(err,
+			"[sqlite3:ratelimits] query row scan failed query=%q", emptyQuery)
+	}
+
+	return r, nil
+}
+
+// DeleteExpiredRateLimitBuckets deletes rate_limit_buckets rows that
+// expired before now, returning the number deleted.
+func (q *Queries) DeleteExpiredRateLimitBuckets(ctx context.Context, now store.Datetime) (int64, error) {
+	const query = `
+delete from rate_limit_buckets
+where expires_at < :now
+`
+	res, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("now", &now), // :now
+	)
+	if err != nil {
+		return 0, errors.Wrapf(err, "[sqlite3:ratelimits] exec failed query=%q", query)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "[sqlite3:ratelimits] rows affected failed")
+	}
+
+	return n, nil
+}
@@ -0,0 +1,134 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/andyfusniak/monolith/internal/store"
+	"github.com/pkg/errors"
+)
+
+// InsertRefreshTokenFamily adds a new refresh_token_families row and its
+// first refresh token in a single transaction.
+func (s *Store) InsertRefreshTokenFamily(ctx context.Context, params store.AddRefreshTokenFamily) error {
+	return s.execTx(ctx, func(q *Queries) error {
+		const query = `
+insert into refresh_token_families
+  (family_id, user_id, created_at)
+values
+  (:family_id, :user_id, :created_at)
+`
+		now := store.Datetime(time.Now().UTC())
+		if _, err := q.readwrite.ExecContext(ctx, query,
+			sql.Named("family_id", params.FamilyID), // :family_id
+			sql.Named("user_id", params.UserID),     // :user_id
+			sql.Named("created_at", &now),           // :created_at
+		); err != nil {
+			return errors.Wrapf(err, "[sqlite3:refreshtokens] exec failed query=%q", query)
+		}
+
+		return q.insertRefreshToken(ctx, params.TokenHash, params.FamilyID, params.ExpiresAt)
+	})
+}
+
+func (q *Queries) insertRefreshToken(ctx context.Context, tokenHash, familyID string, expiresAt store.Datetime) error {
+	const query = `
+insert into refresh_tokens
+  (token_hash, family_id, expires_at, created_at)
+values
+  (:token_hash, :family_id, :expires_at, :created_at)
+`
+	now := store.Datetime(time.Now().UTC())
+	if _, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("token_hash", tokenHash),  // :token_hash
+		sql.Named("family_id", familyID),    // :family_id
+		sql.Named("expires_at", &expiresAt), // :expires_at
+		sql.Named("created_at", &now),       // :created_at
+	); err != nil {
+		return errors.Wrapf(err, "[sqlite3:refreshtokens] exec failed query=%q", query)
+	}
+
+	return nil
+}
+
+// GetRefreshToken gets a refresh_tokens row, with the user and revocation
+// time of its family, by token hash.
+func (q *Queries) GetRefreshToken(ctx context.Context, tokenHash string) (store.RefreshToken, error) {
+	const query = `
+select
+  t.token_hash, t.family_id, f.user_id, t.expires_at, t.used_at,
+  f.revoked_at, t.created_at
+from refresh_tokens as t
+join refresh_token_families as f on f.family_id = t.family_id
+where t.token_hash = :token_hash
+`
+	r := store.RefreshToken{}
+	if err := q.readonly.QueryRowContext(ctx, query,
+		sql.Named("token_hash", tokenHash), // :token_hash
+	).Scan(
+		&r.TokenHash,       // 0 token_hash
+		&r.FamilyID,        // 1 family_id
+		&r.UserID,          // 2 user_id
+		&r.ExpiresAt,       // 3 expires_at
+		&r.UsedAt,          // 4 used_at
+		&r.FamilyRevokedAt, // 5 revoked_at
+		&r.CreatedAt,       // 6 created_at
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return store.RefreshToken{}, store.ErrRefreshTokenNotFound
+		}
+		return store.RefreshToken{}, errors.Wrapf(err,
+			"[sqlite3:refreshtokens] query row scan failed query=%q", query)
+	}
+
+	return r, nil
+}
+
+// RotateRefreshToken marks a refresh token used and adds its replacement
+// to the same family in a single transaction. The update only matches an
+// unused token so two concurrent rotations cannot both succeed.
+func (s *Store) RotateRefreshToken(ctx context.Context, params store.RotateRefreshToken) error {
+	return s.execTx(ctx, func(q *Queries) error {
+		const query = `
+update refresh_tokens
+set used_at = :used_at
+where token_hash = :token_hash and used_at is null
+`
+		now := store.Datetime(time.Now().UTC())
+		res, err := q.readwrite.ExecContext(ctx, query,
+			sql.Named("used_at", &now),                   // :used_at
+			sql.Named("token_hash", params.OldTokenHash), // :token_hash
+		)
+		if err != nil {
+			return errors.Wrapf(err, "[sqlite3:refreshtokens] exec failed query=%q", query)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return errors.Wrapf(err, "[sqlite3:refreshtokens] rows affected failed query=%q", query)
+		}
+		if n == 0 {
+			return store.ErrRefreshTokenUsed
+		}
+
+		return q.insertRefreshToken(ctx, params.NewTokenHash, params.FamilyID, params.ExpiresAt)
+	})
+}
+
+// RevokeRefreshTokenFamily marks a refresh_token_families row revoked.
+func (q *Queries) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	const query = `
+update refresh_token_families
+set revoked_at = :revoked_at
+where family_id = :family_id and revoked_at is null
+`
+	now := store.Datetime(time.Now().UTC())
+	if _, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("revoked_at", &now),    // :revoked_at
+		sql.Named("family_id", familyID), // :family_id
+	); err != nil {
+		return errors.Wrapf(err, "[sqlite3:refreshtokens] exec failed query=%q", query)
+	}
+
+	return nil
+}
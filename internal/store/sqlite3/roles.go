@@ -0,0 +1,90 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/andyfusniak/monolith/internal/store"
+	"github.com/pkg/errors"
+)
+
+// ListUserRoles returns the roles held by a user in order.
+func (q *Queries) ListUserRoles(ctx context.Context, userID string) ([]string, error) {
+	const query = `
+select
+  role
+from user_roles
+where user_id = :user_id
+order by role
+`
+	rows, err := q.readonly.QueryContext(ctx, query,
+		sql.Named("user_id", userID), // :user_id
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[sqlite3:roles] query failed query=%q", query)
+	}
+	defer rows.Close()
+
+	var roles []string
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(
+			&role, // 0 role
+		); err != nil {
+			return nil, errors.Wrapf(err, "[sqlite3:roles] rows scan failed query=%q", query)
+		}
+		roles = append(roles, role)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err, "[sqlite3:roles] rows next failed query=%q", query)
+	}
+
+	return roles, nil
+}
+
+// InsertUserRole grants a role to a user. Granting a role the user already
+// holds does nothing.
+func (q *Queries) InsertUserRole(ctx context.Context, userID, role string) error {
+	const query = `
+insert into user_roles
+  (user_id, role, created_at)
+values
+  (:user_id, :role, :created_at)
+on conflict (user_id, role) do nothing
+`
+	now := store.Datetime(time.Now().UTC())
+	if _, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("user_id", userID),  // :user_id
+		sql.Named("role", role),       // :role
+		sql.Named("created_at", &now), // :created_at
+	); err != nil {
+		return errors.Wrapf(err, "[sqlite3:roles] exec failed query=%q", query)
+	}
+
+	return nil
+}
+
+// DeleteUserRole revokes a role from a user.
+func (q *Queries) DeleteUserRole(ctx context.Context, userID, role string) error {
+	const query = `
+delete from user_roles
+where user_id = :user_id and role = :role
+`
+	res, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("user_id", userID), // :user_id
+		sql.Named("role", role),      // :role
+	)
+	if err != nil {
+		return errors.Wrapf(err, "[sqlite3:roles] exec failed query=%q", query)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrapf(err, "[sqlite3:roles] rows affected failed query=%q", query)
+	}
+	if n == 0 {
+		return store.ErrUserRoleNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,16 @@
+begin immediate;
+
+-- failed sign in attempts tracked per account (lower cased email) and per
+-- client IP address. Accounts are keyed by email rather than user_id so
+-- unknown emails are tracked identically to known ones.
+create table signin_failures (
+  scope          text not null,
+  key            text not null,
+  failures       integer not null,
+  last_failed_at text not null,
+  locked_until   text,
+  constraint signin_failures_pkey primary key (scope, key),
+  constraint signin_failures_scope_check check (scope in ('account', 'ip'))
+) strict;
+
+commit;
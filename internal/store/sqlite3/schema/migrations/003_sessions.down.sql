@@ -0,0 +1,6 @@
+begin immediate;
+
+drop index if exists sessions_user_id_idx;
+drop table if exists sessions;
+
+commit;
@@ -0,0 +1,17 @@
+begin immediate;
+
+-- only a SHA-256 hash of the session token is stored
+create table sessions (
+  session_id    text primary key,
+  token_hash    text not null,
+  user_id       text not null,
+  expires_at    text not null,
+  created_at    text not null,
+  constraint sessions_token_hash_ukey unique (token_hash),
+  constraint sessions_user_id_fkey foreign key (user_id)
+    references users (user_id) on delete cascade
+) strict;
+
+create index sessions_user_id_idx on sessions (user_id);
+
+commit;
@@ -0,0 +1,8 @@
+begin immediate;
+
+drop table if exists mfa_challenges;
+drop index if exists user_recovery_codes_user_id_idx;
+drop table if exists user_recovery_codes;
+drop table if exists user_totp;
+
+commit;
@@ -0,0 +1,38 @@
+begin immediate;
+
+-- confirmed_at is null until the user proves possession of the secret.
+-- last_used_step prevents a code being replayed within its time step.
+create table user_totp (
+  user_id        text primary key,
+  secret         text not null,
+  confirmed_at   text,
+  last_used_step integer not null default 0,
+  created_at     text not null,
+  constraint user_totp_user_id_fkey foreign key (user_id)
+    references users (user_id) on delete cascade
+) strict;
+
+-- only a SHA-256 hash of each recovery code is stored
+create table user_recovery_codes (
+  code_hash  text primary key,
+  user_id    text not null,
+  used_at    text,
+  created_at text not null,
+  constraint user_recovery_codes_user_id_fkey foreign key (user_id)
+    references users (user_id) on delete cascade
+) strict;
+
+create index user_recovery_codes_user_id_idx on user_recovery_codes (user_id);
+
+-- pending second factor challenges issued after a successful password check
+create table mfa_challenges (
+  token_hash text primary key,
+  user_id    text not null,
+  attempts   integer not null default 0,
+  expires_at text not null,
+  created_at text not null,
+  constraint mfa_challenges_user_id_fkey foreign key (user_id)
+    references users (user_id) on delete cascade
+) strict;
+
+commit;
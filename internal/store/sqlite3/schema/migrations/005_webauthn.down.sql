@@ -0,0 +1,7 @@
+begin immediate;
+
+drop table if exists webauthn_challenges;
+drop index if exists webauthn_credentials_user_id_idx;
+drop table if exists webauthn_credentials;
+
+commit;
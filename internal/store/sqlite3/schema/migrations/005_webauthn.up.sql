@@ -0,0 +1,33 @@
+begin immediate;
+
+-- credential_id is the base64url encoded raw credential id and public_key
+-- the COSE encoded credential public key.
+create table webauthn_credentials (
+  credential_id text primary key,
+  user_id       text not null,
+  public_key    blob not null,
+  sign_count    integer not null,
+  aaguid        text not null,
+  transports    text not null,
+  last_used_at  text,
+  created_at    text not null,
+  constraint webauthn_credentials_user_id_fkey foreign key (user_id)
+    references users (user_id) on delete cascade
+) strict;
+
+create index webauthn_credentials_user_id_idx on webauthn_credentials (user_id);
+
+-- single-use ceremony challenges. user_id is null for login ceremonies
+-- using discoverable credentials.
+create table webauthn_challenges (
+  challenge  text primary key,
+  ceremony   text not null,
+  user_id    text,
+  expires_at text not null,
+  created_at text not null,
+  constraint webauthn_challenges_ceremony_check check (ceremony in ('registration', 'login')),
+  constraint webauthn_challenges_user_id_fkey foreign key (user_id)
+    references users (user_id) on delete cascade
+) strict;
+
+commit;
@@ -0,0 +1,7 @@
+begin immediate;
+
+drop index if exists magic_links_ip_created_at_idx;
+drop index if exists magic_links_email_created_at_idx;
+drop table if exists magic_links;
+
+commit;
@@ -0,0 +1,21 @@
+begin immediate;
+
+-- a row is written for every request, including emails with no account,
+-- so rate limits behave the same whether or not the user exists.
+-- only a SHA-256 hash of the token is stored.
+create table magic_links (
+  token_hash text primary key,
+  email      text not null,
+  user_id    text,
+  ip         text not null,
+  expires_at text not null,
+  used_at    text,
+  created_at text not null,
+  constraint magic_links_user_id_fkey foreign key (user_id)
+    references users (user_id) on delete cascade
+) strict;
+
+create index magic_links_email_created_at_idx on magic_links (email, created_at);
+create index magic_links_ip_created_at_idx on magic_links (ip, created_at);
+
+commit;
@@ -0,0 +1,7 @@
+begin immediate;
+
+drop table if exists oidc_auth_requests;
+drop index if exists user_identities_user_id_idx;
+drop table if exists user_identities;
+
+commit;
@@ -0,0 +1,31 @@
+begin immediate;
+
+-- links a subject at an external OAuth2 / OpenID Connect provider to a
+-- user. email is the address asserted by the provider when the identity
+-- was linked.
+create table user_identities (
+  provider     text not null,
+  subject      text not null,
+  user_id      text not null,
+  email        text not null,
+  last_used_at text,
+  created_at   text not null,
+  constraint user_identities_pkey primary key (provider, subject),
+  constraint user_identities_user_id_fkey foreign key (user_id)
+    references users (user_id) on delete cascade
+) strict;
+
+create index user_identities_user_id_idx on user_identities (user_id);
+
+-- pending authorization code flows. only a SHA-256 hash of the state is
+-- stored; the nonce and PKCE code verifier are needed to complete the flow.
+create table oidc_auth_requests (
+  state_hash    text primary key,
+  provider      text not null,
+  nonce         text not null,
+  code_verifier text not null,
+  expires_at    text not null,
+  created_at    text not null
+) strict;
+
+commit;
@@ -0,0 +1,9 @@
+begin immediate;
+
+drop index if exists oauth_refresh_tokens_user_id_idx;
+drop table if exists oauth_refresh_tokens;
+drop table if exists oauth_authorization_codes;
+drop table if exists oauth_clients;
+drop table if exists oauth_signing_keys;
+
+commit;
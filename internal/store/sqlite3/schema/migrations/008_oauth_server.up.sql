@@ -0,0 +1,62 @@
+begin immediate;
+
+-- token signing keys. private_key is PKCS #8 DER. a key is used to sign
+-- until retires_at and published in the JWKS until expires_at so tokens
+-- signed shortly before rotation can still be verified.
+create table oauth_signing_keys (
+  kid         text primary key,
+  alg         text not null,
+  private_key blob not null,
+  retires_at  text not null,
+  expires_at  text not null,
+  created_at  text not null,
+  constraint oauth_signing_keys_alg_check check (alg in ('RS256', 'ES256'))
+) strict;
+
+-- registered relying parties. secret_hash is null for public clients,
+-- which must use PKCE. redirect_uris is space separated.
+create table oauth_clients (
+  client_id     text primary key,
+  name          text not null,
+  secret_hash   text,
+  redirect_uris text not null,
+  created_at    text not null
+) strict;
+
+-- single-use authorization codes. only a SHA-256 hash of the code is
+-- stored.
+create table oauth_authorization_codes (
+  code_hash             text primary key,
+  client_id             text not null,
+  user_id               text not null,
+  redirect_uri          text not null,
+  scope                 text not null,
+  nonce                 text not null,
+  code_challenge        text not null,
+  code_challenge_method text not null,
+  expires_at            text not null,
+  created_at            text not null,
+  constraint oauth_authorization_codes_client_id_fkey foreign key (client_id)
+    references oauth_clients (client_id) on delete cascade,
+  constraint oauth_authorization_codes_user_id_fkey foreign key (user_id)
+    references users (user_id) on delete cascade
+) strict;
+
+-- refresh tokens are replaced on every use. only a SHA-256 hash of the
+-- token is stored.
+create table oauth_refresh_tokens (
+  token_hash text primary key,
+  client_id  text not null,
+  user_id    text not null,
+  scope      text not null,
+  expires_at text not null,
+  created_at text not null,
+  constraint oauth_refresh_tokens_client_id_fkey foreign key (client_id)
+    references oauth_clients (client_id) on delete cascade,
+  constraint oauth_refresh_tokens_user_id_fkey foreign key (user_id)
+    references users (user_id) on delete cascade
+) strict;
+
+create index oauth_refresh_tokens_user_id_idx on oauth_refresh_tokens (user_id);
+
+commit;
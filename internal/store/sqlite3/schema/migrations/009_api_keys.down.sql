@@ -0,0 +1,6 @@
+begin immediate;
+
+drop index if exists api_keys_user_id_idx;
+drop table if exists api_keys;
+
+commit;
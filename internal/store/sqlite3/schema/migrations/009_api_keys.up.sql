@@ -0,0 +1,23 @@
+begin immediate;
+
+-- personal API keys for machine clients. Only a SHA-256 hash of the key is
+-- stored; prefix holds the first characters of the key so users can tell
+-- their keys apart. scopes is a space separated list.
+create table api_keys (
+  api_key_id    text primary key,
+  user_id       text not null,
+  name          text not null,
+  prefix        text not null,
+  token_hash    text not null,
+  scopes        text not null,
+  expires_at    text,
+  last_used_at  text,
+  created_at    text not null,
+  constraint api_keys_token_hash_ukey unique (token_hash),
+  constraint api_keys_user_id_fkey foreign key (user_id)
+    references users (user_id) on delete cascade
+) strict;
+
+create index api_keys_user_id_idx on api_keys (user_id);
+
+commit;
@@ -0,0 +1,8 @@
+begin immediate;
+
+drop index if exists refresh_tokens_family_id_idx;
+drop table if exists refresh_tokens;
+drop index if exists refresh_token_families_user_id_idx;
+drop table if exists refresh_token_families;
+
+commit;
@@ -0,0 +1,30 @@
+begin immediate;
+
+-- a token family is the chain of refresh tokens descended from one sign
+-- in. Revoking a family invalidates every refresh token in it.
+create table refresh_token_families (
+  family_id     text primary key,
+  user_id       text not null,
+  revoked_at    text,
+  created_at    text not null,
+  constraint refresh_token_families_user_id_fkey foreign key (user_id)
+    references users (user_id) on delete cascade
+) strict;
+
+create index refresh_token_families_user_id_idx on refresh_token_families (user_id);
+
+-- only a SHA-256 hash of each refresh token is stored. Used tokens are
+-- kept, with used_at set, so that replaying one can be detected.
+create table refresh_tokens (
+  token_hash    text primary key,
+  family_id     text not null,
+  expires_at    text not null,
+  used_at       text,
+  created_at    text not null,
+  constraint refresh_tokens_family_id_fkey foreign key (family_id)
+    references refresh_token_families (family_id) on delete cascade
+) strict;
+
+create index refresh_tokens_family_id_idx on refresh_tokens (family_id);
+
+commit;
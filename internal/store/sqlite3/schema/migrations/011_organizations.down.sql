@@ -0,0 +1,8 @@
+begin immediate;
+
+drop table if exists org_invitations;
+drop index if exists org_memberships_user_id_idx;
+drop table if exists org_memberships;
+drop table if exists organizations;
+
+commit;
@@ -0,0 +1,47 @@
+begin immediate;
+
+create table organizations (
+  org_id        text primary key,
+  name          text not null,
+  created_at    text not null
+) strict;
+
+-- a user belongs to an organization with exactly one role. Resources owned
+-- by an organization carry its org_id and every query on them filters by
+-- it.
+create table org_memberships (
+  org_id        text not null,
+  user_id       text not null,
+  role          text not null,
+  created_at    text not null,
+  constraint org_memberships_pkey primary key (org_id, user_id),
+  constraint org_memberships_org_id_fkey foreign key (org_id)
+    references organizations (org_id) on delete cascade,
+  constraint org_memberships_user_id_fkey foreign key (user_id)
+    references users (user_id) on delete cascade,
+  constraint org_memberships_role_check check (role in ('owner', 'admin', 'member'))
+) strict;
+
+create index org_memberships_user_id_idx on org_memberships (user_id);
+
+-- pending invitations. Inviting the same email again replaces the previous
+-- invitation. Only a SHA-256 hash of the invitation token is stored.
+create table org_invitations (
+  invitation_id text primary key,
+  org_id        text not null,
+  email         text not null,
+  role          text not null,
+  token_hash    text not null,
+  invited_by    text,
+  expires_at    text not null,
+  created_at    text not null,
+  constraint org_invitations_org_id_email_ukey unique (org_id, email),
+  constraint org_invitations_token_hash_ukey unique (token_hash),
+  constraint org_invitations_org_id_fkey foreign key (org_id)
+    references organizations (org_id) on delete cascade,
+  constraint org_invitations_invited_by_fkey foreign key (invited_by)
+    references users (user_id) on delete set null,
+  constraint org_invitations_role_check check (role in ('owner', 'admin', 'member'))
+) strict;
+
+commit;
@@ -0,0 +1,8 @@
+begin immediate;
+
+drop index if exists audit_events_subject_id_idx;
+drop table if exists audit_events;
+alter table sessions drop column impersonator_id;
+drop table if exists user_roles;
+
+commit;
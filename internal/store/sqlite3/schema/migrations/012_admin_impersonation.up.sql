@@ -0,0 +1,33 @@
+begin immediate;
+
+-- staff roles; users without a row are regular users
+create table user_roles (
+  user_id       text not null,
+  role          text not null,
+  created_at    text not null,
+  constraint user_roles_pkey primary key (user_id, role),
+  constraint user_roles_user_id_fkey foreign key (user_id)
+    references users (user_id) on delete cascade,
+  constraint user_roles_role_check check (role in ('admin'))
+) strict;
+
+-- impersonator_id is the admin acting as user_id in an impersonation
+-- session; null for sessions the user signed in to
+alter table sessions add column impersonator_id text
+  references users (user_id) on delete cascade;
+
+-- audit events outlive the users they mention so there are no foreign
+-- keys; event_id orders the events
+create table audit_events (
+  event_id      integer primary key,
+  action        text not null,
+  actor_id      text,
+  subject_id    text,
+  ip            text not null,
+  details       text not null,
+  created_at    text not null
+) strict;
+
+create index audit_events_subject_id_idx on audit_events (subject_id);
+
+commit;
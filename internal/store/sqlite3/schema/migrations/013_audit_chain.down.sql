@@ -0,0 +1,11 @@
+begin immediate;
+
+drop trigger if exists audit_events_no_delete;
+drop trigger if exists audit_events_no_update;
+drop index if exists audit_events_action_idx;
+drop index if exists audit_events_actor_id_idx;
+drop index if exists audit_events_unsealed_idx;
+alter table audit_events drop column hash;
+alter table audit_events drop column prev_hash;
+
+commit;
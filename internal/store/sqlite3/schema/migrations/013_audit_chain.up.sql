@@ -0,0 +1,25 @@
+begin immediate;
+
+-- each event stores the hash of the event before it and its own hash over
+-- both, so changing or removing an event breaks the chain. Events recorded
+-- before this migration are sealed into the chain by the next append.
+alter table audit_events add column prev_hash text;
+alter table audit_events add column hash text;
+
+create index audit_events_unsealed_idx on audit_events (event_id) where hash is null;
+create index audit_events_actor_id_idx on audit_events (actor_id);
+create index audit_events_action_idx on audit_events (action);
+
+-- append-only; sealed events cannot be changed and no event removed
+create trigger audit_events_no_update before update on audit_events
+  when old.hash is not null
+begin
+  select raise(abort, 'audit_events is append-only');
+end;
+
+create trigger audit_events_no_delete before delete on audit_events
+begin
+  select raise(abort, 'audit_events is append-only');
+end;
+
+commit;
@@ -0,0 +1,8 @@
+begin immediate;
+
+drop trigger if exists users_tombstone_check;
+drop table if exists user_tombstones;
+drop index if exists user_exports_user_id_idx;
+drop table if exists user_exports;
+
+commit;
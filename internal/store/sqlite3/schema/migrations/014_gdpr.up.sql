@@ -0,0 +1,33 @@
+begin immediate;
+
+-- JSON archives of a user's data, built in the background. archive is
+-- null until status is 'ready'.
+create table user_exports (
+  export_id     text primary key,
+  user_id       text not null,
+  status        text not null,
+  archive       text,
+  created_at    text not null,
+  completed_at  text,
+  expires_at    text not null,
+  constraint user_exports_user_id_fkey foreign key (user_id)
+    references users (user_id) on delete cascade,
+  constraint user_exports_status_check check (status in ('pending', 'ready', 'failed'))
+) strict;
+
+create index user_exports_user_id_idx on user_exports (user_id);
+
+-- erased users. Audit events still refer to erased users by user_id, so
+-- an erased user_id is never issued again.
+create table user_tombstones (
+  user_id       text primary key,
+  erased_at     text not null
+) strict;
+
+create trigger users_tombstone_check before insert on users
+  when exists (select 1 from user_tombstones where user_id = new.user_id)
+begin
+  select raise(abort, 'user_id belongs to an erased user');
+end;
+
+commit;
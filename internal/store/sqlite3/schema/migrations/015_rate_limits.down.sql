@@ -0,0 +1,6 @@
+begin immediate;
+
+drop index if exists rate_limit_buckets_expires_at_idx;
+drop table if exists rate_limit_buckets;
+
+commit;
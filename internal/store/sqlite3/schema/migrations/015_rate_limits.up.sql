@@ -0,0 +1,17 @@
+begin immediate;
+
+-- token buckets of rate limited clients, used when RATE_LIMIT_STORE is
+-- sqlite so limits survive restarts. tokens is refilled for the time
+-- since updated_at when the next request is made. A bucket is full again
+-- by expires_at and may then be deleted.
+create table rate_limit_buckets (
+  key        text not null,
+  tokens     real not null,
+  updated_at text not null,
+  expires_at text not null,
+  constraint rate_limit_buckets_pkey primary key (key)
+) strict;
+
+create index rate_limit_buckets_expires_at_idx on rate_limit_buckets (expires_at);
+
+commit;
@@ -0,0 +1,6 @@
+begin immediate;
+
+drop index if exists idempotency_keys_expires_at_idx;
+drop table if exists idempotency_keys;
+
+commit;
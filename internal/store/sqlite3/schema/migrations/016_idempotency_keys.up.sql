@@ -0,0 +1,24 @@
+begin immediate;
+
+-- the first response to requests made with an Idempotency-Key header,
+-- replayed to repeats of the request. Keys are scoped to the
+-- authenticated user, or '' for unauthenticated requests. fingerprint is
+-- a hash of the request, so a key reused for another request is
+-- rejected. status_code, headers and body are null until the first
+-- request completes.
+create table idempotency_keys (
+  scope           text not null,
+  idempotency_key text not null,
+  fingerprint     text not null,
+  status_code     integer,
+  headers         text,
+  body            blob,
+  created_at      text not null,
+  completed_at    text,
+  expires_at      text not null,
+  constraint idempotency_keys_pkey primary key (scope, idempotency_key)
+) strict;
+
+create index idempotency_keys_expires_at_idx on idempotency_keys (expires_at);
+
+commit;
@@ -0,0 +1,5 @@
+begin immediate;
+
+-- the original case of emails is not kept, so there is nothing to undo
+
+commit;
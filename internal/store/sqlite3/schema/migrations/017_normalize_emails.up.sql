@@ -0,0 +1,14 @@
+begin immediate;
+
+-- emails are stored trimmed and lower case so lookups match however the
+-- address was typed. Users whose normalized email would collide with
+-- another account are left unchanged. lower() only folds ASCII letters.
+update or ignore users
+set email = lower(trim(email))
+where email <> lower(trim(email));
+
+update or ignore org_invitations
+set email = lower(trim(email))
+where email <> lower(trim(email));
+
+commit;
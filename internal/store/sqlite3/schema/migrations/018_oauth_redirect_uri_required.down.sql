@@ -0,0 +1,5 @@
+begin immediate;
+
+alter table oauth_authorization_codes drop column redirect_uri_required;
+
+commit;
@@ -0,0 +1,11 @@
+begin immediate;
+
+-- redirect_uri_required is 1 if the authorization request included
+-- redirect_uri, so the token request must repeat it (RFC 6749 section
+-- 4.1.3), and 0 if it was omitted and the client's only redirect URI was
+-- used.
+alter table oauth_authorization_codes
+  add column redirect_uri_required integer not null default 1
+  constraint oauth_authorization_codes_redirect_uri_required_check check (redirect_uri_required in (0, 1));
+
+commit;
@@ -0,0 +1,5 @@
+begin immediate;
+
+alter table signin_failures drop column pending;
+
+commit;
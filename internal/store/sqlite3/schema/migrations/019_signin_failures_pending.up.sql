@@ -0,0 +1,10 @@
+begin immediate;
+
+-- pending counts sign in attempts reserved but not yet verified, so
+-- concurrent attempts cannot all pass the throttle before any failure is
+-- recorded.
+alter table signin_failures
+  add column pending integer not null default 0
+  constraint signin_failures_pending_check check (pending >= 0);
+
+commit;
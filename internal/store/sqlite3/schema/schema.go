@@ -2,9 +2,34 @@ package schema
 
 import (
 	"embed"
+	"fmt"
+	"strconv"
+	"strings"
 )
 
 // Migrations used to embed the SQL init files.
 //
 //go:embed migrations
 var Migrations embed.FS
+
+// LatestVersion returns the version of the newest migration, the number
+// prefixing its file name.
+func LatestVersion() (uint, error) {
+	entries, err := Migrations.ReadDir("migrations")
+	if err != nil {
+		return 0, err
+	}
+	var latest uint
+	for _, e := range entries {
+		prefix, _, ok := strings.Cut(e.Name(), "_")
+		if !ok {
+			continue
+		}
+		v, err := strconv.ParseUint(prefix, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("migration %s has no version: %w", e.Name(), err)
+		}
+		latest = max(latest, uint(v))
+	}
+	return latest, nil
+}
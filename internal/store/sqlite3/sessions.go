@@ -0,0 +1,128 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/andyfusniak/monolith/internal/store"
+	"github.com/pkg/errors"
+)
+
+// InsertSession adds a new session row to the sessions table.
+func (q *Queries) InsertSession(ctx context.Context, params store.AddSession) (store.Session, error) {
+	const query = `
+insert into sessions
+  (session_id, token_hash, user_id, impersonator_id, expires_at, created_at)
+values
+  (:session_id, :token_hash, :user_id, :impersonator_id, :expires_at, :created_at)
+returning
+  session_id, token_hash, user_id, impersonator_id, expires_at, created_at
+`
+	r := store.Session{}
+	now := store.Datetime(time.Now().UTC())
+	if err := q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("session_id", params.SessionID),           // :session_id
+		sql.Named("token_hash", params.TokenHash),           // :token_hash
+		sql.Named("user_id", params.UserID),                 // :user_id
+		sql.Named("impersonator_id", params.ImpersonatorID), // :impersonator_id
+		sql.Named("expires_at", &params.ExpiresAt),          // :expires_at
+		sql.Named("created_at", &now),                       // :created_at
+	).Scan(
+		&r.SessionID,      // 0 session_id
+		&r.TokenHash,      // 1 token_hash
+		&r.UserID,         // 2 user_id
+		&r.ImpersonatorID, // 3 impersonator_id
+		&r.ExpiresAt,      // 4 expires_at
+		&r.CreatedAt,      // 5 created_at
+	); err != nil {
+		return store.Session{}, errors.Wrapf(err,
+			"[sqlite3:sessions] query row scan failed query=%q", query)
+	}
+
+	return r, nil
+}
+
+// GetSessionByTokenHash gets a session row by token hash.
+func (q *Queries) GetSessionByTokenHash(ctx context.Context, tokenHash string) (store.Session, error) {
+	const query = `
+select
+  session_id, token_hash, user_id, impersonator_id, expires_at, created_at
+from sessions
+where token_hash = :token_hash
+`
+	r := store.Session{}
+	if err := q.readonly.QueryRowContext(ctx, query,
+		sql.Named("token_hash", tokenHash), // :token_hash
+	).Scan(
+		&r.SessionID,      // 0 session_id
+		&r.TokenHash,      // 1 token_hash
+		&r.UserID,         // 2 user_id
+		&r.ImpersonatorID, // 3 impersonator_id
+		&r.ExpiresAt,      // 4 expires_at
+		&r.CreatedAt,      // 5 created_at
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return store.Session{}, store.ErrSessionNotFound
+		}
+
+		return store.Session{}, errors.Wrapf(err,
+			"[sqlite3:sessions] query row scan failed query=%q", query)
+	}
+
+	return r, nil
+}
+
+// ListUserSessions returns the session rows of a user, oldest first.
+func (q *Queries) ListUserSessions(ctx context.Context, userID string) ([]store.Session, error) {
+	const query = `
+select
+  session_id, token_hash, user_id, impersonator_id, expires_at, created_at
+from sessions
+where user_id = :user_id
+order by created_at
+`
+	rows, err := q.readonly.QueryContext(ctx, query,
+		sql.Named("user_id", userID), // :user_id
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[sqlite3:sessions] query failed query=%q", query)
+	}
+	defer rows.Close()
+
+	var sessions []store.Session
+	for rows.Next() {
+		var r store.Session
+		if err := rows.Scan(
+			&r.SessionID,      // 0 session_id
+			&r.TokenHash,      // 1 token_hash
+			&r.UserID,         // 2 user_id
+			&r.ImpersonatorID, // 3 impersonator_id
+			&r.ExpiresAt,      // 4 expires_at
+			&r.CreatedAt,      // 5 created_at
+		); err != nil {
+			return nil, errors.Wrapf(err, "[sqlite3:sessions] rows scan failed query=%q", query)
+		}
+		sessions = append(sessions, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err, "[sqlite3:sessions] rows next failed query=%q", query)
+	}
+
+	return sessions, nil
+}
+
+// DeleteSession deletes a session row by primary key.
+func (q *Queries) DeleteSession(ctx context.Context, sessionID string) error {
+	const query = `
+delete from sessions
+where session_id = :session_id
+`
+	if _, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("session_id", sessionID), // :session_id
+	); err != nil {
+		return errors.Wrapf(err, "[sqlite3:sessions] exec failed query=%q", query)
+	}
+
+	return nil
+}
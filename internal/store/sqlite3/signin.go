@@ -0,0 +1,192 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/andyfusniak/monolith/internal/store"
+	"github.com/pkg/errors"
+)
+
+// GetSignInFailure gets a sign in failure row by scope and key.
+func (q *Queries) GetSignInFailure(ctx context.Context, scope, key string) (store.SignInFailure, error) {
+	const query = `
+select
+  scope, key, failures, pending, last_failed_at, locked_until
+from signin_failures
+where scope = :scope and key = :key
+`
+	r := store.SignInFailure{}
+	if err := q.readonly.QueryRowContext(ctx, query,
+		sql.Named("scope", scope), // :scope
+		sql.Named("key", key),     // :key
+	).Scan(
+		&r.Scope,        // 0 scope
+		&r.Key,          // 1 key
+		&r.Failures,     // 2 failures
+		&r.Pending,      // 3 pending
+		&r.LastFailedAt, // 4 last_failed_at
+		&r.LockedUntil,  // 5 locked_until
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return store.SignInFailure{}, store.ErrSignInFailureNotFound
+		}
+
+		return store.SignInFailure{}, errors.Wrapf(err,
+			"[sqlite3:signin] query row scan failed query=%q", query)
+	}
+
+	return r, nil
+}
+
+// ReserveSignInAttempt reserves a sign in attempt by incrementing the
+// pending count, returning the updated row. The check and the increment
+// are a single statement so concurrent attempts cannot all be reserved.
+// If the attempt is refused it returns store.ErrSignInAttemptRefused.
+func (q *Queries) ReserveSignInAttempt(ctx context.Context, params store.ReserveSignInAttempt) (store.SignInFailure, error) {
+	const query = `
+insert into signin_failures
+  (scope, key, failures, pending, last_failed_at, locked_until)
+values
+  (:scope, :key, 0, 1, :now, null)
+on conflict (scope, key) do update set
+  failures = case
+    when last_failed_at < :window_start then 0
+    else failures
+  end,
+  pending = case
+    when last_failed_at < :window_start then 1
+    else pending + 1
+  end,
+  locked_until = case
+    when last_failed_at < :window_start then null
+    else locked_until
+  end
+where (locked_until is null or locked_until <= :now)
+  and (last_failed_at < :window_start
+    or failures + pending < :max
+    or (locked_until is not null and pending = 0))
+returning
+  scope, key, failures, pending, last_failed_at, locked_until
+`
+	r := store.SignInFailure{}
+	now := store.Datetime(time.Now().UTC())
+	if err := q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("scope", params.Scope),               // :scope
+		sql.Named("key", params.Key),                   // :key
+		sql.Named("now", &now),                         // :now
+		sql.Named("window_start", &params.WindowStart), // :window_start
+		sql.Named("max", params.Max),                   // :max
+	).Scan(
+		&r.Scope,        // 0 scope
+		&r.Key,          // 1 key
+		&r.Failures,     // 2 failures
+		&r.Pending,      // 3 pending
+		&r.LastFailedAt, // 4 last_failed_at
+		&r.LockedUntil,  // 5 locked_until
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return store.SignInFailure{}, store.ErrSignInAttemptRefused
+		}
+
+		return store.SignInFailure{}, errors.Wrapf(err,
+			"[sqlite3:signin] query row scan failed query=%q", query)
+	}
+
+	return r, nil
+}
+
+// ReleaseSignInAttempt releases an attempt reserved by ReserveSignInAttempt
+// without recording a failure.
+func (q *Queries) ReleaseSignInAttempt(ctx context.Context, scope, key string) error {
+	const query = `
+update signin_failures
+set pending = max(pending - 1, 0)
+where scope = :scope and key = :key
+`
+	if _, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("scope", scope), // :scope
+		sql.Named("key", key),     // :key
+	); err != nil {
+		return errors.Wrapf(err, "[sqlite3:signin] exec failed query=%q", query)
+	}
+
+	return nil
+}
+
+// IncrSignInFailure records a failed sign in attempt, releasing any
+// attempt reserved for it, and returns the updated row. The failure count
+// restarts if the previous failure was before params.WindowStart.
+func (q *Queries) IncrSignInFailure(ctx context.Context, params store.IncrSignInFailure) (store.SignInFailure, error) {
+	const query = `
+insert into signin_failures
+  (scope, key, failures, pending, last_failed_at, locked_until)
+values
+  (:scope, :key, 1, 0, :now, null)
+on conflict (scope, key) do update set
+  failures = case
+    when last_failed_at < :window_start then 1
+    else failures + 1
+  end,
+  pending = max(pending - 1, 0),
+  last_failed_at = :now
+returning
+  scope, key, failures, pending, last_failed_at, locked_until
+`
+	r := store.SignInFailure{}
+	now := store.Datetime(time.Now().UTC())
+	if err := q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("scope", params.Scope),               // :scope
+		sql.Named("key", params.Key),                   // :key
+		sql.Named("now", &now),                         // :now
+		sql.Named("window_start", &params.WindowStart), // :window_start
+	).Scan(
+		&r.Scope,        // 0 scope
+		&r.Key,          // 1 key
+		&r.Failures,     // 2 failures
+		&r.Pending,      // 3 pending
+		&r.LastFailedAt, // 4 last_failed_at
+		&r.LockedUntil,  // 5 locked_until
+	); err != nil {
+		return store.SignInFailure{}, errors.Wrapf(err,
+			"[sqlite3:signin] query row scan failed query=%q", query)
+	}
+
+	return r, nil
+}
+
+// LockSignInFailure sets the locked until time of a sign in failure row.
+func (q *Queries) LockSignInFailure(ctx context.Context, scope, key string, until store.Datetime) error {
+	const query = `
+update signin_failures
+set locked_until = :locked_until
+where scope = :scope and key = :key
+`
+	if _, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("locked_until", &until), // :locked_until
+		sql.Named("scope", scope),         // :scope
+		sql.Named("key", key),             // :key
+	); err != nil {
+		return errors.Wrapf(err, "[sqlite3:signin] exec failed query=%q", query)
+	}
+
+	return nil
+}
+
+// DeleteSignInFailure deletes a sign in failure row, clearing both the
+// failure count and any lock.
+func (q *Queries) DeleteSignInFailure(ctx context.Context, scope, key string) error {
+	const query = `
+delete from signin_failures
+where scope = :scope and key = :key
+`
+	if _, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("scope", scope), // :scope
+		sql.Named("key", key),     // :key
+	); err != nil {
+		return errors.Wrapf(err, "[sqlite3:signin] exec failed query=%q", query)
+	}
+
+	return nil
+}
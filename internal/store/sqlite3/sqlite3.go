@@ -10,10 +10,15 @@ import (
 	"github.com/pkg/errors"
 )
 
-// Store memberships store.
+// Store implements store.Repository using sqlite3.
 type Store struct {
 	*Queries
-	rw *sql.DB
+	rw txBeginner
+}
+
+// txBeginner starts transactions on the read-write database.
+type txBeginner interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
 }
 
 // NewStore returns a new store.
@@ -126,3 +131,62 @@ where email = :email
 
 	return r, nil
 }
+
+// ListUsers returns all user rows ordered by creation time.
+func (q *Queries) ListUsers(ctx context.Context) ([]store.User, error) {
+	const query = `
+select
+  user_id, email, password_hash, created_at
+from users
+order by created_at
+`
+	rows, err := q.readonly.QueryContext(ctx, query)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[sqlite3:users] query failed query=%q", query)
+	}
+	defer rows.Close()
+
+	var users []store.User
+	for rows.Next() {
+		var r store.User
+		if err := rows.Scan(
+			&r.UserID,       // 0 user_id
+			&r.Email,        // 1 email
+			&r.PasswordHash, // 2 password_hash
+			&r.CreatedAt,    // 3 created_at
+		); err != nil {
+			return nil, errors.Wrapf(err, "[sqlite3:users] rows scan failed query=%q", query)
+		}
+		users = append(users, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err, "[sqlite3:users] rows next failed query=%q", query)
+	}
+
+	return users, nil
+}
+
+// UpdateUserPasswordHash replaces the password hash of a user row.
+func (q *Queries) UpdateUserPasswordHash(ctx context.Context, userID, passwordHash string) error {
+	const query = `
+update users
+set password_hash = :password_hash
+where user_id = :user_id
+`
+	res, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("password_hash", passwordHash), // :password_hash
+		sql.Named("user_id", userID),             // :user_id
+	)
+	if err != nil {
+		return errors.Wrapf(err, "[sqlite3:users] exec failed query=%q", query)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "[sqlite3:users] rows affected failed")
+	}
+	if n == 0 {
+		return store.ErrUserNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,39 @@
+package sqlite3
+
+import (
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/andyfusniak/monolith/internal/store/sqlite3/schema"
+)
+
+// newTestStore returns a Store using a new migrated database in a
+// temporary directory.
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+
+	db, err := OpenDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	db.SetMaxOpenConns(1)
+
+	migrations, err := fs.Glob(schema.Migrations, "migrations/*.up.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(migrations)
+	for _, name := range migrations {
+		b, err := fs.ReadFile(schema.Migrations, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(string(b)); err != nil {
+			t.Fatalf("migration %s: %v", name, err)
+		}
+	}
+	return NewStore(db, db)
+}
@@ -0,0 +1,267 @@
+package sqlite3
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andyfusniak/monolith/internal/store"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	tenantDBExt        = ".db"
+	tenantMaxOpenConns = 20
+	tenantMaxIdleConns = 2
+)
+
+// TenantPools opens the database of each tenant lazily from a directory of
+// <tenant_id>.db files. At most max tenants are kept open; when another is
+// needed the least recently used tenant without requests in flight is
+// closed. Tenant databases are created by running the migrations against
+// a new file, so a tenant without a file does not exist.
+type TenantPools struct {
+	dir string
+	max int
+
+	mu    sync.Mutex
+	pools map[string]*list.Element // of *tenantPool
+	lru   *list.List               // most recently used at the front
+}
+
+// tenantPool is a read-only and a read-write pool for one tenant, as used
+// by the server for the single database.
+type tenantPool struct {
+	tenantID string
+	ro, rw   *sql.DB
+	refs     int
+}
+
+// NewTenantPools returns pools for the tenant databases in dir.
+func NewTenantPools(dir string, max int) *TenantPools {
+	return &TenantPools{
+		dir:   dir,
+		max:   max,
+		pools: make(map[string]*list.Element),
+		lru:   list.New(),
+	}
+}
+
+// IsValidTenantID reports whether id can name a tenant. Tenant IDs are
+// DNS labels so they can be used as subdomains and file names.
+func IsValidTenantID(id string) bool {
+	if len(id) == 0 || len(id) > 63 || id[0] == '-' || id[len(id)-1] == '-' {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// TenantDBPath returns the path of the database file for tenantID.
+func TenantDBPath(dir, tenantID string) string {
+	return filepath.Join(dir, tenantID+tenantDBExt)
+}
+
+// ListTenants returns the IDs of the tenant databases in dir in order.
+func ListTenants(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[sqlite3:tenants] read dir %q failed", dir)
+	}
+
+	var ids []string
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), tenantDBExt)
+		if !ok || e.IsDir() || !IsValidTenantID(id) {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// acquire returns the open pool for tenantID, opening it if needed, and
+// holds a reference to it until release.
+func (t *TenantPools) acquire(tenantID string) (*tenantPool, error) {
+	if !IsValidTenantID(tenantID) {
+		return nil, store.ErrTenantNotFound
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if e, ok := t.pools[tenantID]; ok {
+		t.lru.MoveToFront(e)
+		p := e.Value.(*tenantPool)
+		p.refs++
+		return p, nil
+	}
+
+	// opening a missing file would create an empty database
+	path := TenantDBPath(t.dir, tenantID)
+	if _, err := os.Stat(path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, store.ErrTenantNotFound
+		}
+		return nil, errors.Wrapf(err, "[sqlite3:tenants] stat %q failed", path)
+	}
+
+	rw, err := OpenDB(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[sqlite3:tenants] open %q failed", path)
+	}
+	rw.SetMaxOpenConns(1)
+	rw.SetMaxIdleConns(1)
+	rw.SetConnMaxIdleTime(5 * time.Minute)
+
+	ro, err := OpenDB(path)
+	if err != nil {
+		rw.Close()
+		return nil, errors.Wrapf(err, "[sqlite3:tenants] open %q failed", path)
+	}
+	ro.SetMaxOpenConns(tenantMaxOpenConns)
+	ro.SetMaxIdleConns(tenantMaxIdleConns)
+	ro.SetConnMaxIdleTime(5 * time.Minute)
+
+	p := &tenantPool{tenantID: tenantID, ro: ro, rw: rw, refs: 1}
+	t.pools[tenantID] = t.lru.PushFront(p)
+	log.Debugf("[sqlite3:tenants] opened tenant=%s (%d open)", tenantID, t.lru.Len())
+
+	t.evictLocked()
+	return p, nil
+}
+
+// release drops a reference taken by acquire.
+func (t *TenantPools) release(p *tenantPool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p.refs--
+	t.evictLocked()
+}
+
+// evictLocked closes idle pools, least recently used first, until no more
+// than max are open. Pools with requests in flight are skipped so the open
+// count can briefly exceed max under load.
+func (t *TenantPools) evictLocked() {
+	for e := t.lru.Back(); e != nil && t.lru.Len() > t.max; {
+		prev := e.Prev()
+		if p := e.Value.(*tenantPool); p.refs == 0 {
+			t.lru.Remove(e)
+			delete(t.pools, p.tenantID)
+			go p.close()
+			log.Debugf("[sqlite3:tenants] closed idle tenant=%s", p.tenantID)
+		}
+		e = prev
+	}
+}
+
+func (p *tenantPool) close() {
+	if err := p.ro.Close(); err != nil {
+		log.Errorf("[sqlite3:tenants] close tenant=%s failed: %+v", p.tenantID, err)
+	}
+	if err := p.rw.Close(); err != nil {
+		log.Errorf("[sqlite3:tenants] close tenant=%s failed: %+v", p.tenantID, err)
+	}
+}
+
+// Close closes every open tenant database.
+func (t *TenantPools) Close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for e := t.lru.Front(); e != nil; e = e.Next() {
+		e.Value.(*tenantPool).close()
+	}
+	t.pools = make(map[string]*list.Element)
+	t.lru.Init()
+}
+
+type tenantPoolKey struct{}
+
+// tenantDB implements DBTx and txBeginner by running each query against
+// the pool of the tenant acquired for the context. Queries made with a
+// context that has no tenant are a programming error and panic.
+type tenantDB struct {
+	readwrite bool
+}
+
+func (d tenantDB) db(ctx context.Context) *sql.DB {
+	p, ok := ctx.Value(tenantPoolKey{}).(*tenantPool)
+	if !ok {
+		panic("[sqlite3:tenants] query without a tenant; use AcquireTenant")
+	}
+	if d.readwrite {
+		return p.rw
+	}
+	return p.ro
+}
+
+func (d tenantDB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return d.db(ctx).ExecContext(ctx, query, args...)
+}
+
+func (d tenantDB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return d.db(ctx).QueryContext(ctx, query, args...)
+}
+
+func (d tenantDB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return d.db(ctx).QueryRowContext(ctx, query, args...)
+}
+
+func (d tenantDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	return d.db(ctx).BeginTx(ctx, opts)
+}
+
+// TenantStore implements store.Repository and store.TenantRepository with
+// a database per tenant.
+type TenantStore struct {
+	*Store
+	pools *TenantPools
+}
+
+// NewTenantStore returns a new store using the tenant databases in pools.
+func NewTenantStore(pools *TenantPools) *TenantStore {
+	ro, rw := tenantDB{}, tenantDB{readwrite: true}
+	return &TenantStore{
+		Store: &Store{
+			rw:      rw,
+			Queries: NewQueries(ro, rw),
+		},
+		pools: pools,
+	}
+}
+
+// AcquireTenant returns a copy of ctx whose queries run against the
+// database of tenantID. store.ErrTenantNotFound is returned if the tenant
+// has no database.
+func (s *TenantStore) AcquireTenant(ctx context.Context, tenantID string) (context.Context, func(), error) {
+	p, err := s.pools.acquire(tenantID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() { s.pools.release(p) })
+	}
+	ctx = context.WithValue(store.WithTenant(ctx, tenantID), tenantPoolKey{}, p)
+	return ctx, release, nil
+}
+
+// ListTenants returns the IDs of the tenant databases in order.
+func (s *TenantStore) ListTenants(ctx context.Context) ([]string, error) {
+	return ListTenants(s.pools.dir)
+}
@@ -0,0 +1,70 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"runtime"
+	"strings"
+
+	"github.com/andyfusniak/monolith/internal/tracing"
+)
+
+// tracedDBTx records a span for each query run on a DBTx. Spans of
+// QueryContext end when the query returns, before its rows are read, and
+// QueryRowContext errors reported only by Scan are not recorded.
+type tracedDBTx struct {
+	DBTx
+}
+
+func (db tracedDBTx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	ctx, span := startQuerySpan(ctx, query)
+	defer span.End()
+
+	res, err := db.DBTx.ExecContext(ctx, query, args...)
+	span.RecordError(err)
+	return res, err
+}
+
+func (db tracedDBTx) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	ctx, span := startQuerySpan(ctx, query)
+	defer span.End()
+
+	rows, err := db.DBTx.QueryContext(ctx, query, args...)
+	span.RecordError(err)
+	return rows, err
+}
+
+func (db tracedDBTx) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	ctx, span := startQuerySpan(ctx, query)
+	defer span.End()
+
+	row := db.DBTx.QueryRowContext(ctx, query, args...)
+	span.RecordError(row.Err())
+	return row
+}
+
+// startQuerySpan starts a span named after the Queries method running
+// query, such as sqlite3.GetUser. Queries hold no values, only named
+// parameters, so the statement is recorded in full.
+func startQuerySpan(ctx context.Context, query string) (context.Context, *tracing.Span) {
+	if !tracing.Enabled() {
+		return ctx, nil
+	}
+
+	name := "sqlite3.query"
+	if pc, _, _, ok := runtime.Caller(2); ok {
+		if fn := runtime.FuncForPC(pc); fn != nil {
+			name = fn.Name()
+			name = name[strings.LastIndex(name, "/")+1:]
+			name = strings.NewReplacer("(*Queries).", "", "(*Store).", "").Replace(name)
+		}
+	}
+
+	statement := strings.Join(strings.Fields(query), " ")
+	operation, _, _ := strings.Cut(statement, " ")
+	return tracing.Start(ctx, name,
+		tracing.String("db.system", "sqlite"),
+		tracing.String("db.operation.name", strings.ToUpper(operation)),
+		tracing.String("db.query.text", statement),
+	)
+}
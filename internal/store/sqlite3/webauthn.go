@@ -0,0 +1,213 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/andyfusniak/monolith/internal/store"
+	"github.com/pkg/errors"
+)
+
+// InsertWebAuthnCredential adds a new row to the webauthn_credentials
+// table.
+func (q *Queries) InsertWebAuthnCredential(ctx context.Context, params store.AddWebAuthnCredential) (store.WebAuthnCredential, error) {
+	const query = `
+insert into webauthn_credentials
+  (credential_id, user_id, public_key, sign_count, aaguid, transports, last_used_at, created_at)
+values
+  (:credential_id, :user_id, :public_key, :sign_count, :aaguid, :transports, null, :created_at)
+returning
+  credential_id, user_id, public_key, sign_count, aaguid, transports, last_used_at, created_at
+`
+	r := store.WebAuthnCredential{}
+	now := store.Datetime(time.Now().UTC())
+	if err := q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("credential_id", params.CredentialID), // :credential_id
+		sql.Named("user_id", params.UserID),             // :user_id
+		sql.Named("public_key", params.PublicKey),       // :public_key
+		sql.Named("sign_count", params.SignCount),       // :sign_count
+		sql.Named("aaguid", params.AAGUID),              // :aaguid
+		sql.Named("transports", params.Transports),      // :transports
+		sql.Named("created_at", &now),                   // :created_at
+	).Scan(
+		&r.CredentialID, // 0 credential_id
+		&r.UserID,       // 1 user_id
+		&r.PublicKey,    // 2 public_key
+		&r.SignCount,    // 3 sign_count
+		&r.AAGUID,       // 4 aaguid
+		&r.Transports,   // 5 transports
+		&r.LastUsedAt,   // 6 last_used_at
+		&r.CreatedAt,    // 7 created_at
+	); err != nil {
+		return store.WebAuthnCredential{}, errors.Wrapf(err,
+			"[sqlite3:webauthn] query row scan failed query=%q", query)
+	}
+
+	return r, nil
+}
+
+// GetWebAuthnCredential gets a webauthn_credentials row by primary key.
+func (q *Queries) GetWebAuthnCredential(ctx context.Context, credentialID string) (store.WebAuthnCredential, error) {
+	const query = `
+select
+  credential_id, user_id, public_key, sign_count, aaguid, transports, last_used_at, created_at
+from webauthn_credentials
+where credential_id = :credential_id
+`
+	r := store.WebAuthnCredential{}
+	if err := q.readonly.QueryRowContext(ctx, query,
+		sql.Named("credential_id", credentialID), // :credential_id
+	).Scan(
+		&r.CredentialID, // 0 credential_id
+		&r.UserID,       // 1 user_id
+		&r.PublicKey,    // 2 public_key
+		&r.SignCount,    // 3 sign_count
+		&r.AAGUID,       // 4 aaguid
+		&r.Transports,   // 5 transports
+		&r.LastUsedAt,   // 6 last_used_at
+		&r.CreatedAt,    // 7 created_at
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return store.WebAuthnCredential{}, store.ErrWebAuthnCredentialNotFound
+		}
+		return store.WebAuthnCredential{}, errors.Wrapf(err,
+			"[sqlite3:webauthn] query row scan failed query=%q", query)
+	}
+
+	return r, nil
+}
+
+// ListWebAuthnCredentialsByUser returns all webauthn_credentials rows for
+// a user.
+func (q *Queries) ListWebAuthnCredentialsByUser(ctx context.Context, userID string) ([]store.WebAuthnCredential, error) {
+	const query = `
+select
+  credential_id, user_id, public_key, sign_count, aaguid, transports, last_used_at, created_at
+from webauthn_credentials
+where user_id = :user_id
+order by created_at
+`
+	rows, err := q.readonly.QueryContext(ctx, query,
+		sql.Named("user_id", userID), // :user_id
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[sqlite3:webauthn] query failed query=%q", query)
+	}
+	defer rows.Close()
+
+	var creds []store.WebAuthnCredential
+	for rows.Next() {
+		var r store.WebAuthnCredential
+		if err := rows.Scan(
+			&r.CredentialID, // 0 credential_id
+			&r.UserID,       // 1 user_id
+			&r.PublicKey,    // 2 public_key
+			&r.SignCount,    // 3 sign_count
+			&r.AAGUID,       // 4 aaguid
+			&r.Transports,   // 5 transports
+			&r.LastUsedAt,   // 6 last_used_at
+			&r.CreatedAt,    // 7 created_at
+		); err != nil {
+			return nil, errors.Wrapf(err, "[sqlite3:webauthn] rows scan failed query=%q", query)
+		}
+		creds = append(creds, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err, "[sqlite3:webauthn] rows next failed query=%q", query)
+	}
+
+	return creds, nil
+}
+
+// UpdateWebAuthnCredentialSignCount records a successful assertion.
+func (q *Queries) UpdateWebAuthnCredentialSignCount(ctx context.Context, credentialID string, signCount int64) error {
+	const query = `
+update webauthn_credentials
+set sign_count = :sign_count,
+    last_used_at = :last_used_at
+where credential_id = :credential_id
+`
+	now := store.Datetime(time.Now().UTC())
+	if _, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("sign_count", signCount),       // :sign_count
+		sql.Named("last_used_at", &now),          // :last_used_at
+		sql.Named("credential_id", credentialID), // :credential_id
+	); err != nil {
+		return errors.Wrapf(err, "[sqlite3:webauthn] exec failed query=%q", query)
+	}
+
+	return nil
+}
+
+// InsertWebAuthnChallenge adds a new row to the webauthn_challenges table.
+func (q *Queries) InsertWebAuthnChallenge(ctx context.Context, params store.AddWebAuthnChallenge) error {
+	const query = `
+insert into webauthn_challenges
+  (challenge, ceremony, user_id, expires_at, created_at)
+values
+  (:challenge, :ceremony, :user_id, :expires_at, :created_at)
+`
+	now := store.Datetime(time.Now().UTC())
+	if _, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("challenge", params.Challenge),   // :challenge
+		sql.Named("ceremony", params.Ceremony),     // :ceremony
+		sql.Named("user_id", params.UserID),        // :user_id
+		sql.Named("expires_at", &params.ExpiresAt), // :expires_at
+		sql.Named("created_at", &now),              // :created_at
+	); err != nil {
+		return errors.Wrapf(err, "[sqlite3:webauthn] exec failed query=%q", query)
+	}
+
+	return nil
+}
+
+// TakeWebAuthnChallenge deletes and returns a webauthn_challenges row so
+// each challenge can only be used once.
+func (q *Queries) TakeWebAuthnChallenge(ctx context.Context, challenge string) (store.WebAuthnChallenge, error) {
+	const query = `
+delete from webauthn_challenges
+where challenge = :challenge
+returning
+  challenge, ceremony, user_id, expires_at, created_at
+`
+	r := store.WebAuthnChallenge{}
+	if err := q.readwrite.QueryRowContext(ctx, query,
+		sql.Named("challenge", challenge), // :challenge
+	).Scan(
+		&r.Challenge, // 0 challenge
+		&r.Ceremony,  // 1 ceremony
+		&r.UserID,    // 2 user_id
+		&r.ExpiresAt, // 3 expires_at
+		&r.CreatedAt, // 4 created_at
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return store.WebAuthnChallenge{}, store.ErrWebAuthnChallengeNotFound
+		}
+		return store.WebAuthnChallenge{}, errors.Wrapf(err,
+			"[sqlite3:webauthn] query row scan failed query=%q", query)
+	}
+
+	return r, nil
+}
+
+// DeleteExpiredWebAuthnChallenges deletes webauthn_challenges rows that
+// expired before now, returning the number deleted.
+func (q *Queries) DeleteExpiredWebAuthnChallenges(ctx context.Context, now store.Datetime) (int64, error) {
+	const query = `
+delete from webauthn_challenges
+where expires_at < :now
+`
+	res, err := q.readwrite.ExecContext(ctx, query,
+		sql.Named("now", &now), // :now
+	)
+	if err != nil {
+		return 0, errors.Wrapf(err, "[sqlite3:webauthn] exec failed query=%q", query)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "[sqlite3:webauthn] rows affected failed")
+	}
+
+	return n, nil
+}
@@ -2,7 +2,10 @@ package store
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"time"
 )
@@ -21,12 +24,63 @@ func (t *Datetime) Scan(v any) error {
 }
 
 func (t *Datetime) Value() (driver.Value, error) {
+	if t == nil {
+		return nil, nil // NULL
+	}
 	return time.Time(*t).UTC().Format(RFC3339Micro), nil
 }
 
 // Repository store operations.
 type Repository interface {
 	UsersRepository
+	SignInFailuresRepository
+	SessionsRepository
+	MFARepository
+	WebAuthnRepository
+	MagicLinksRepository
+	IdentitiesRepository
+	OAuthRepository
+	APIKeysRepository
+	RefreshTokensRepository
+	OrganizationsRepository
+	RolesRepository
+	AuditRepository
+	PrivacyRepository
+	HealthRepository
+	RateLimitsRepository
+	IdempotencyKeysRepository
+}
+
+// tenants
+
+var (
+	ErrTenantNotFound = errors.New("tenant not found")
+)
+
+// TenantRepository is implemented by repositories that keep each tenant in
+// a separate database.
+type TenantRepository interface {
+	// AcquireTenant returns a copy of ctx whose queries run against the
+	// database of tenantID, opening it if needed. The database stays open
+	// until release is called.
+	AcquireTenant(ctx context.Context, tenantID string) (tctx context.Context, release func(), err error)
+
+	// ListTenants returns the IDs of every tenant in order.
+	ListTenants(ctx context.Context) ([]string, error)
+}
+
+type tenantKey struct{}
+
+// WithTenant returns a copy of ctx carrying tenantID.
+func WithTenant(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenantID)
+}
+
+// TenantFromContext returns the tenant set by WithTenant, or "" when the
+// repository is not per-tenant.
+func TenantFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(tenantKey{}).(string)
+	return id
 }
 
 // user repository
@@ -40,6 +94,8 @@ type UsersRepository interface {
 	InsertUser(ctx context.Context, params AddUser) (User, error)
 	GetUser(ctx context.Context, userID string) (User, error)
 	GetUserByEmail(ctx context.Context, email string) (User, error)
+	ListUsers(ctx context.Context) ([]User, error)
+	UpdateUserPasswordHash(ctx context.Context, userID, passwordHash string) error
 }
 
 type AddUser struct {
@@ -60,3 +116,779 @@ type InsertUserParams struct {
 	Email        string
 	PasswordHash string
 }
+
+// sign in failures repository
+
+const (
+	SignInScopeAccount = "account"
+	SignInScopeIP      = "ip"
+)
+
+var (
+	ErrSignInFailureNotFound = errors.New("sign in failure not found")
+	ErrSignInAttemptRefused  = errors.New("sign in attempt refused")
+)
+
+// SignInFailuresRepository defines the failed sign in tracking operations.
+type SignInFailuresRepository interface {
+	GetSignInFailure(ctx context.Context, scope, key string) (SignInFailure, error)
+	ReserveSignInAttempt(ctx context.Context, params ReserveSignInAttempt) (SignInFailure, error)
+	ReleaseSignInAttempt(ctx context.Context, scope, key string) error
+	IncrSignInFailure(ctx context.Context, params IncrSignInFailure) (SignInFailure, error)
+	LockSignInFailure(ctx context.Context, scope, key string, until Datetime) error
+	DeleteSignInFailure(ctx context.Context, scope, key string) error
+}
+
+// ReserveSignInAttempt params. An attempt is reserved unless the key is
+// locked or its failures and pending attempts have reached Max. Once a
+// lock has expired one attempt at a time is reserved. If the last failure
+// is before WindowStart the failures are forgotten.
+type ReserveSignInAttempt struct {
+	Scope       string
+	Key         string
+	Max         int
+	WindowStart Datetime
+}
+
+// IncrSignInFailure params. If the last failure is before WindowStart the
+// failure count restarts from one.
+type IncrSignInFailure struct {
+	Scope       string
+	Key         string
+	WindowStart Datetime
+}
+
+type SignInFailure struct {
+	Scope        string
+	Key          string
+	Failures     int
+	Pending      int
+	LastFailedAt Datetime
+	LockedUntil  *Datetime
+}
+
+// sessions repository
+
+var (
+	ErrSessionNotFound = errors.New("session not found")
+)
+
+// SessionsRepository defines the session store operations.
+type SessionsRepository interface {
+	InsertSession(ctx context.Context, params AddSession) (Session, error)
+	GetSessionByTokenHash(ctx context.Context, tokenHash string) (Session, error)
+	ListUserSessions(ctx context.Context, userID string) ([]Session, error)
+	DeleteSession(ctx context.Context, sessionID string) error
+}
+
+// AddSession params. ImpersonatorID is set for sessions where an admin
+// acts as UserID.
+type AddSession struct {
+	SessionID      string
+	TokenHash      string
+	UserID         string
+	ImpersonatorID *string
+	ExpiresAt      Datetime
+}
+
+type Session struct {
+	SessionID      string
+	TokenHash      string
+	UserID         string
+	ImpersonatorID *string
+	ExpiresAt      Datetime
+	CreatedAt      Datetime
+}
+
+// mfa repository
+
+var (
+	ErrUserTOTPNotFound     = errors.New("user totp not found")
+	ErrUserTOTPStepReused   = errors.New("user totp step already used")
+	ErrRecoveryCodeNotFound = errors.New("recovery code not found")
+	ErrMFAChallengeNotFound = errors.New("mfa challenge not found")
+)
+
+// MFARepository defines the multi-factor authentication store operations.
+type MFARepository interface {
+	UpsertUserTOTP(ctx context.Context, userID, secret string) (UserTOTP, error)
+	GetUserTOTP(ctx context.Context, userID string) (UserTOTP, error)
+	ConfirmUserTOTP(ctx context.Context, params ConfirmUserTOTP) error
+	UseUserTOTPStep(ctx context.Context, userID string, step int64) error
+	UseRecoveryCode(ctx context.Context, userID, codeHash string) error
+
+	InsertMFAChallenge(ctx context.Context, params AddMFAChallenge) (MFAChallenge, error)
+	GetMFAChallenge(ctx context.Context, tokenHash string) (MFAChallenge, error)
+	IncrMFAChallengeAttempts(ctx context.Context, tokenHash string) (MFAChallenge, error)
+	DeleteMFAChallenge(ctx context.Context, tokenHash string) error
+}
+
+type UserTOTP struct {
+	UserID       string
+	Secret       string
+	ConfirmedAt  *Datetime
+	LastUsedStep int64
+	CreatedAt    Datetime
+}
+
+// ConfirmUserTOTP params. Secret is the unconfirmed secret the code was
+// verified against. Any existing recovery codes for the user are replaced
+// by RecoveryCodeHashes.
+type ConfirmUserTOTP struct {
+	UserID             string
+	Secret             string
+	Step               int64
+	RecoveryCodeHashes []string
+}
+
+type AddMFAChallenge struct {
+	TokenHash string
+	UserID    string
+	ExpiresAt Datetime
+}
+
+type MFAChallenge struct {
+	TokenHash string
+	UserID    string
+	Attempts  int
+	ExpiresAt Datetime
+	CreatedAt Datetime
+}
+
+// webauthn repository
+
+const (
+	WebAuthnCeremonyRegistration = "registration"
+	WebAuthnCeremonyLogin        = "login"
+)
+
+var (
+	ErrWebAuthnCredentialNotFound = errors.New("webauthn credential not found")
+	ErrWebAuthnChallengeNotFound  = errors.New("webauthn challenge not found")
+)
+
+// WebAuthnRepository defines the WebAuthn credential store operations.
+type WebAuthnRepository interface {
+	InsertWebAuthnCredential(ctx context.Context, params AddWebAuthnCredential) (WebAuthnCredential, error)
+	GetWebAuthnCredential(ctx context.Context, credentialID string) (WebAuthnCredential, error)
+	ListWebAuthnCredentialsByUser(ctx context.Context, userID string) ([]WebAuthnCredential, error)
+	UpdateWebAuthnCredentialSignCount(ctx context.Context, credentialID string, signCount int64) error
+
+	InsertWebAuthnChallenge(ctx context.Context, params AddWebAuthnChallenge) error
+	TakeWebAuthnChallenge(ctx context.Context, challenge string) (WebAuthnChallenge, error)
+	DeleteExpiredWebAuthnChallenges(ctx context.Context, now Datetime) (int64, error)
+}
+
+type AddWebAuthnCredential struct {
+	CredentialID string
+	UserID       string
+	PublicKey    []byte
+	SignCount    int64
+	AAGUID       string
+	Transports   string
+}
+
+type WebAuthnCredential struct {
+	CredentialID string
+	UserID       string
+	PublicKey    []byte
+	SignCount    int64
+	AAGUID       string
+	Transports   string
+	LastUsedAt   *Datetime
+	CreatedAt    Datetime
+}
+
+type AddWebAuthnChallenge struct {
+	Challenge string
+	Ceremony  string
+	UserID    *string
+	ExpiresAt Datetime
+}
+
+type WebAuthnChallenge struct {
+	Challenge string
+	Ceremony  string
+	UserID    *string
+	ExpiresAt Datetime
+	CreatedAt Datetime
+}
+
+// magic links repository
+
+var (
+	ErrMagicLinkNotFound = errors.New("magic link not found")
+)
+
+// MagicLinksRepository defines the passwordless sign in store operations.
+type MagicLinksRepository interface {
+	InsertMagicLink(ctx context.Context, params AddMagicLink) error
+	CountMagicLinksByEmail(ctx context.Context, email string, since Datetime) (int, error)
+	CountMagicLinksByIP(ctx context.Context, ip string, since Datetime) (int, error)
+	UseMagicLink(ctx context.Context, tokenHash string) (MagicLink, error)
+	DeleteExpiredMagicLinks(ctx context.Context, before Datetime) (int64, error)
+}
+
+type AddMagicLink struct {
+	TokenHash string
+	Email     string
+	UserID    *string
+	IP        string
+	ExpiresAt Datetime
+}
+
+type MagicLink struct {
+	TokenHash string
+	Email     string
+	UserID    *string
+	IP        string
+	ExpiresAt Datetime
+	UsedAt    *Datetime
+	CreatedAt Datetime
+}
+
+// identities repository
+
+var (
+	ErrUserIdentityNotFound    = errors.New("user identity not found")
+	ErrOIDCAuthRequestNotFound = errors.New("oidc auth request not found")
+)
+
+// IdentitiesRepository defines the external identity provider store
+// operations.
+type IdentitiesRepository interface {
+	InsertOIDCAuthRequest(ctx context.Context, params AddOIDCAuthRequest) error
+	TakeOIDCAuthRequest(ctx context.Context, stateHash string) (OIDCAuthRequest, error)
+	DeleteExpiredOIDCAuthRequests(ctx context.Context, now Datetime) (int64, error)
+	InsertUserIdentity(ctx context.Context, params AddUserIdentity) (UserIdentity, error)
+	InsertUserWithIdentity(ctx context.Context, user AddUser, identity AddUserIdentity) (User, error)
+	GetUserIdentity(ctx context.Context, provider, subject string) (UserIdentity, error)
+	ListUserIdentities(ctx context.Context, userID string) ([]UserIdentity, error)
+	TouchUserIdentity(ctx context.Context, provider, subject string) error
+}
+
+type AddOIDCAuthRequest struct {
+	StateHash    string
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	ExpiresAt    Datetime
+}
+
+type OIDCAuthRequest struct {
+	StateHash    string
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	ExpiresAt    Datetime
+	CreatedAt    Datetime
+}
+
+// AddUserIdentity params. When used with InsertUserWithIdentity the
+// UserID is taken from the new user.
+type AddUserIdentity struct {
+	Provider string
+	Subject  string
+	UserID   string
+	Email    string
+}
+
+type UserIdentity struct {
+	Provider   string
+	Subject    string
+	UserID     string
+	Email      string
+	LastUsedAt *Datetime
+	CreatedAt  Datetime
+}
+
+// oauth authorization server repository
+
+var (
+	ErrOAuthClientNotFound            = errors.New("oauth client not found")
+	ErrOAuthAuthorizationCodeNotFound = errors.New("oauth authorization code not found")
+	ErrOAuthRefreshTokenNotFound      = errors.New("oauth refresh token not found")
+)
+
+// OAuthRepository defines the authorization server store operations.
+type OAuthRepository interface {
+	InsertOAuthSigningKey(ctx context.Context, params AddOAuthSigningKey) (OAuthSigningKey, error)
+	ListOAuthSigningKeys(ctx context.Context, expiresAfter Datetime) ([]OAuthSigningKey, error)
+
+	InsertOAuthClient(ctx context.Context, params AddOAuthClient) (OAuthClient, error)
+	GetOAuthClient(ctx context.Context, clientID string) (OAuthClient, error)
+	ListOAuthClients(ctx context.Context) ([]OAuthClient, error)
+	DeleteOAuthClient(ctx context.Context, clientID string) error
+
+	InsertOAuthAuthorizationCode(ctx context.Context, params AddOAuthAuthorizationCode) error
+	TakeOAuthAuthorizationCode(ctx context.Context, codeHash string) (OAuthAuthorizationCode, error)
+
+	InsertOAuthRefreshToken(ctx context.Context, params AddOAuthRefreshToken) error
+	TakeOAuthRefreshToken(ctx context.Context, tokenHash string) (OAuthRefreshToken, error)
+}
+
+type AddOAuthSigningKey struct {
+	KID        string
+	Alg        string
+	PrivateKey []byte
+	RetiresAt  Datetime
+	ExpiresAt  Datetime
+}
+
+type OAuthSigningKey struct {
+	KID        string
+	Alg        string
+	PrivateKey []byte
+	RetiresAt  Datetime
+	ExpiresAt  Datetime
+	CreatedAt  Datetime
+}
+
+type AddOAuthClient struct {
+	ClientID     string
+	Name         string
+	SecretHash   *string
+	RedirectURIs string
+}
+
+type OAuthClient struct {
+	ClientID     string
+	Name         string
+	SecretHash   *string
+	RedirectURIs string
+	CreatedAt    Datetime
+}
+
+// AddOAuthAuthorizationCode params. RedirectURIRequired is true if the
+// authorization request included RedirectURI.
+type AddOAuthAuthorizationCode struct {
+	CodeHash            string
+	ClientID            string
+	UserID              string
+	RedirectURI         string
+	RedirectURIRequired bool
+	Scope               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	ExpiresAt           Datetime
+}
+
+type OAuthAuthorizationCode struct {
+	CodeHash            string
+	ClientID            string
+	UserID              string
+	RedirectURI         string
+	RedirectURIRequired bool
+	Scope               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	ExpiresAt           Datetime
+	CreatedAt           Datetime
+}
+
+type AddOAuthRefreshToken struct {
+	TokenHash string
+	ClientID  string
+	UserID    string
+	Scope     string
+	ExpiresAt Datetime
+}
+
+type OAuthRefreshToken struct {
+	TokenHash string
+	ClientID  string
+	UserID    string
+	Scope     string
+	ExpiresAt Datetime
+	CreatedAt Datetime
+}
+
+// api keys repository
+
+var (
+	ErrAPIKeyNotFound = errors.New("api key not found")
+)
+
+// APIKeysRepository defines the personal API key store operations.
+type APIKeysRepository interface {
+	InsertAPIKey(ctx context.Context, params AddAPIKey) (APIKey, error)
+	GetAPIKeyByTokenHash(ctx context.Context, tokenHash string) (APIKey, error)
+	ListAPIKeys(ctx context.Context, userID string) ([]APIKey, error)
+	TouchAPIKey(ctx context.Context, apiKeyID string, lastUsedAt Datetime) error
+	DeleteAPIKey(ctx context.Context, userID, apiKeyID string) error
+}
+
+type AddAPIKey struct {
+	APIKeyID  string
+	UserID    string
+	Name      string
+	Prefix    string
+	TokenHash string
+	Scopes    string
+	ExpiresAt *Datetime
+}
+
+type APIKey struct {
+	APIKeyID   string
+	UserID     string
+	Name       string
+	Prefix     string
+	TokenHash  string
+	Scopes     string
+	ExpiresAt  *Datetime
+	LastUsedAt *Datetime
+	CreatedAt  Datetime
+}
+
+// refresh tokens repository
+
+var (
+	ErrRefreshTokenNotFound = errors.New("refresh token not found")
+	ErrRefreshTokenUsed     = errors.New("refresh token already used")
+)
+
+// RefreshTokensRepository defines the refresh token store operations.
+type RefreshTokensRepository interface {
+	InsertRefreshTokenFamily(ctx context.Context, params AddRefreshTokenFamily) error
+	GetRefreshToken(ctx context.Context, tokenHash string) (RefreshToken, error)
+	RotateRefreshToken(ctx context.Context, params RotateRefreshToken) error
+	RevokeRefreshTokenFamily(ctx context.Context, familyID string) error
+}
+
+// AddRefreshTokenFamily params for a new family and its first token.
+type AddRefreshTokenFamily struct {
+	FamilyID  string
+	UserID    string
+	TokenHash string
+	ExpiresAt Datetime
+}
+
+// RotateRefreshToken params. The token with OldTokenHash is marked used
+// and a new token added to the same family. If the old token has already
+// been used ErrRefreshTokenUsed is returned.
+type RotateRefreshToken struct {
+	OldTokenHash string
+	NewTokenHash string
+	FamilyID     string
+	ExpiresAt    Datetime
+}
+
+// RefreshToken is a refresh_tokens row joined with its family.
+type RefreshToken struct {
+	TokenHash       string
+	FamilyID        string
+	UserID          string
+	ExpiresAt       Datetime
+	UsedAt          *Datetime
+	FamilyRevokedAt *Datetime
+	CreatedAt       Datetime
+}
+
+// organizations repository
+
+var (
+	ErrOrganizationNotFound  = errors.New("organization not found")
+	ErrOrgMemberNotFound     = errors.New("organization member not found")
+	ErrOrgMemberExists       = errors.New("organization member already exists")
+	ErrOrgInvitationNotFound = errors.New("organization invitation not found")
+	ErrOrgLastOwner          = errors.New("organization must have at least one owner")
+)
+
+// OrganizationsRepository defines the organization store operations.
+// Every operation on the members or invitations of an organization takes
+// its orgID, so no query can reach another organization's rows. The only
+// exceptions are GetOrgInvitationByTokenHash and AcceptOrgInvitation,
+// where the invitation token itself grants access.
+type OrganizationsRepository interface {
+	InsertOrganization(ctx context.Context, params AddOrganization) (Organization, error)
+	GetOrganization(ctx context.Context, orgID string) (Organization, error)
+	ListUserOrganizations(ctx context.Context, userID string) ([]UserOrganization, error)
+	DeleteOrganization(ctx context.Context, orgID string) error
+
+	GetOrgMember(ctx context.Context, orgID, userID string) (OrgMember, error)
+	ListOrgMembers(ctx context.Context, orgID string) ([]OrgMember, error)
+	UpdateOrgMemberRole(ctx context.Context, orgID, userID, role string) error
+	DeleteOrgMember(ctx context.Context, orgID, userID string) error
+
+	UpsertOrgInvitation(ctx context.Context, params AddOrgInvitation) (OrgInvitation, error)
+	ListOrgInvitations(ctx context.Context, orgID string) ([]OrgInvitation, error)
+	DeleteOrgInvitation(ctx context.Context, orgID, invitationID string) error
+	GetOrgInvitationByTokenHash(ctx context.Context, tokenHash string) (OrgInvitation, error)
+	AcceptOrgInvitation(ctx context.Context, tokenHash, userID string) (OrgMember, error)
+	DeclineOrgInvitation(ctx context.Context, tokenHash string) error
+}
+
+// AddOrganization params. The user with OwnerUserID becomes the first
+// member with the owner role.
+type AddOrganization struct {
+	OrgID       string
+	Name        string
+	OwnerUserID string
+}
+
+type Organization struct {
+	OrgID     string
+	Name      string
+	CreatedAt Datetime
+}
+
+// UserOrganization is an organization with the role of one of its members.
+type UserOrganization struct {
+	OrgID     string
+	Name      string
+	Role      string
+	CreatedAt Datetime
+}
+
+// OrgMember is an org_memberships row with the member's email.
+type OrgMember struct {
+	OrgID     string
+	UserID    string
+	Email     string
+	Role      string
+	CreatedAt Datetime
+}
+
+type AddOrgInvitation struct {
+	InvitationID string
+	OrgID        string
+	Email        string
+	Role         string
+	TokenHash    string
+	InvitedBy    string
+	ExpiresAt    Datetime
+}
+
+// OrgInvitation is an org_invitations row with the organization name.
+type OrgInvitation struct {
+	InvitationID string
+	OrgID        string
+	OrgName      string
+	Email        string
+	Role         string
+	TokenHash    string
+	InvitedBy    *string
+	ExpiresAt    Datetime
+	CreatedAt    Datetime
+}
+
+// roles repository
+
+var (
+	ErrUserRoleNotFound = errors.New("user role not found")
+)
+
+// RolesRepository defines the staff role store operations.
+type RolesRepository interface {
+	ListUserRoles(ctx context.Context, userID string) ([]string, error)
+	InsertUserRole(ctx context.Context, userID, role string) error
+	DeleteUserRole(ctx context.Context, userID, role string) error
+}
+
+// audit repository
+
+// AuditRepository defines the audit log store operations. Events are
+// append-only and hash chained in event_id order.
+type AuditRepository interface {
+	InsertAuditEvent(ctx context.Context, params AddAuditEvent) (AuditEvent, error)
+	ListAuditEvents(ctx context.Context, filter AuditEventFilter) ([]AuditEvent, error)
+	ListAuditChain(ctx context.Context, afterID int64, limit int) ([]AuditEvent, error)
+}
+
+// AddAuditEvent params. ActorID is the user who performed the action and
+// SubjectID the user it was performed on; either may be nil. Details is a
+// JSON object.
+type AddAuditEvent struct {
+	Action    string
+	ActorID   *string
+	SubjectID *string
+	IP        string
+	Details   string
+}
+
+// AuditEventFilter selects events for ListAuditEvents. Zero values match
+// all events. UserID matches events with the user as either actor or
+// subject. Events are returned newest first, starting before BeforeID if
+// it is set.
+type AuditEventFilter struct {
+	Action    string
+	ActorID   string
+	SubjectID string
+	UserID    string
+	Since     *Datetime
+	Until     *Datetime
+	BeforeID  int64
+	Limit     int
+}
+
+// AuditEvent is an audit log row. PrevHash and Hash are empty for events
+// recorded before hash chaining that have not yet been sealed.
+type AuditEvent struct {
+	EventID   int64
+	Action    string
+	ActorID   *string
+	SubjectID *string
+	IP        string
+	Details   string
+	CreatedAt Datetime
+	PrevHash  string
+	Hash      string
+}
+
+// ChainHash returns the hex encoded SHA-256 hash of e and the hash of the
+// event before it. The fields are JSON encoded so none can run into the
+// next.
+func (e AuditEvent) ChainHash() string {
+	b, _ := json.Marshal([]any{
+		e.EventID,
+		e.Action,
+		e.ActorID,
+		e.SubjectID,
+		e.IP,
+		e.Details,
+		time.Time(e.CreatedAt).UTC().Format(RFC3339Micro),
+		e.PrevHash,
+	})
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// privacy repository
+
+const (
+	UserExportPending = "pending"
+	UserExportReady   = "ready"
+	UserExportFailed  = "failed"
+)
+
+var (
+	ErrUserExportNotFound = errors.New("user export not found")
+	ErrUserSoleOwner      = errors.New("user is the only owner of an organization with other members")
+)
+
+// PrivacyRepository defines the data export and erasure store operations.
+type PrivacyRepository interface {
+	InsertUserExport(ctx context.Context, params AddUserExport) (UserExport, error)
+	GetUserExport(ctx context.Context, userID, exportID string) (UserExport, error)
+	CompleteUserExport(ctx context.Context, exportID, status string, archive *string) error
+	FailPendingUserExports(ctx context.Context, before Datetime) (int64, error)
+	DeleteExpiredUserExports(ctx context.Context, now Datetime) (int64, error)
+	EraseUser(ctx context.Context, userID string, event AddAuditEvent) error
+}
+
+type AddUserExport struct {
+	ExportID  string
+	UserID    string
+	ExpiresAt Datetime
+}
+
+// UserExport is a user_exports row. Archive is set once Status is
+// UserExportReady.
+type UserExport struct {
+	ExportID    string
+	UserID      string
+	Status      string
+	Archive     *string
+	CreatedAt   Datetime
+	CompletedAt *Datetime
+	ExpiresAt   Datetime
+}
+
+// health repository
+
+// HealthRepository defines the operations used to check the store can
+// serve requests.
+type HealthRepository interface {
+	// PingDB runs a trivial query using the read-only or read-write
+	// database.
+	PingDB(ctx context.Context, readwrite bool) error
+
+	// SchemaVersion returns the version of the last migration applied and
+	// whether it failed part way, leaving the schema dirty.
+	SchemaVersion(ctx context.Context) (version uint, dirty bool, err error)
+}
+
+// rate limits repository
+
+// RateLimitsRepository defines the token bucket operations used to rate
+// limit clients.
+type RateLimitsRepository interface {
+	TakeRateLimitToken(ctx context.Context, params TakeRateLimitToken) (RateLimitBucket, error)
+	DeleteExpiredRateLimitBuckets(ctx context.Context, now Datetime) (int64, error)
+}
+
+// TakeRateLimitToken params. The bucket for Key holds at most Burst
+// tokens and is refilled at Rate tokens per second. A missing bucket is
+// full. ExpiresAt is when the bucket will be full again if a token is
+// taken, after which it may be deleted.
+type TakeRateLimitToken struct {
+	Key       string
+	Rate      float64
+	Burst     int
+	ExpiresAt Datetime
+}
+
+// RateLimitBucket is a token bucket after a call to TakeRateLimitToken.
+// Taken is true if a token was taken by the call, otherwise Tokens is
+// what the bucket holds, less than one.
+type RateLimitBucket struct {
+	Key       string
+	Tokens    float64
+	Taken     bool
+	UpdatedAt Datetime
+	ExpiresAt Datetime
+}
+
+// idempotency keys repository
+
+var (
+	ErrIdempotencyKeyNotFound = errors.New("idempotency key not found")
+	ErrIdempotencyKeyExists   = errors.New("idempotency key already exists")
+)
+
+// IdempotencyKeysRepository defines the operations storing the first
+// response to requests made with an Idempotency-Key header.
+type IdempotencyKeysRepository interface {
+	InsertIdempotencyKey(ctx context.Context, params AddIdempotencyKey) error
+	GetIdempotencyKey(ctx context.Context, scope, key string) (IdempotencyKey, error)
+	CompleteIdempotencyKey(ctx context.Context, params CompleteIdempotencyKey) error
+	DeleteIdempotencyKey(ctx context.Context, scope, key string) error
+	DeleteExpiredIdempotencyKeys(ctx context.Context, now Datetime) (int64, error)
+}
+
+// AddIdempotencyKey params. An existing row for the key is replaced if it
+// has expired, or was never completed and was created before
+// StaleBefore.
+type AddIdempotencyKey struct {
+	Scope       string
+	Key         string
+	Fingerprint string
+	StaleBefore Datetime
+	ExpiresAt   Datetime
+}
+
+// CompleteIdempotencyKey params. Headers is a JSON object of the response
+// headers to replay.
+type CompleteIdempotencyKey struct {
+	Scope      string
+	Key        string
+	StatusCode int
+	Headers    string
+	Body       []byte
+}
+
+// IdempotencyKey is an idempotency_keys row. StatusCode, Headers and Body
+// are set once the first request with the key has completed.
+type IdempotencyKey struct {
+	Scope       string
+	Key         string
+	Fingerprint string
+	StatusCode  *int
+	Headers     *string
+	Body        []byte
+	CreatedAt   Datetime
+	CompletedAt *Datetime
+	ExpiresAt   Datetime
+}
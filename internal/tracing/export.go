@@ -0,0 +1,342 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	queueSize     = 4096
+	batchSize     = 512
+	flushInterval = 5 * time.Second
+)
+
+// Exporter sends completed spans to a tracing backend.
+type Exporter interface {
+	Export(ctx context.Context, spans []SpanData) error
+}
+
+// SpanData is a completed span.
+type SpanData struct {
+	Name         string
+	Kind         SpanKind
+	TraceID      TraceID
+	SpanID       SpanID
+	ParentSpanID SpanID
+	Start        time.Time
+	End          time.Time
+	Attrs        []Attr
+	Error        bool
+	StatusMsg    string
+}
+
+// Tracer samples spans and exports them in batches in the background.
+type Tracer struct {
+	exporter Exporter
+	ratio    float64
+
+	queue   chan SpanData
+	done    chan struct{}
+	dropped int64
+	closeMu sync.Mutex
+	closed  bool
+}
+
+// NewTracer returns a Tracer sending spans to exporter. ratio is the
+// fraction of traces started by this service that are sampled; traces
+// continued from another service follow the sampling decision of their
+// caller. Shutdown must be called to flush queued spans.
+func NewTracer(exporter Exporter, ratio float64) *Tracer {
+	t := &Tracer{
+		exporter: exporter,
+		ratio:    ratio,
+		queue:    make(chan SpanData, queueSize),
+		done:     make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+func (t *Tracer) sample(id TraceID) bool {
+	return t.ratio >= 1 || traceIDRatio(id) < t.ratio
+}
+
+func (t *Tracer) enqueue(s *Span) {
+	s.mu.Lock()
+	d := SpanData{
+		Name:         s.name,
+		Kind:         s.kind,
+		TraceID:      s.sc.TraceID,
+		SpanID:       s.sc.SpanID,
+		ParentSpanID: s.parent,
+		Start:        s.start,
+		End:          s.end,
+		Attrs:        s.attrs,
+		Error:        s.errored,
+		StatusMsg:    s.statusMsg,
+	}
+	s.mu.Unlock()
+
+	t.closeMu.Lock()
+	defer t.closeMu.Unlock()
+	if t.closed {
+		return
+	}
+	select {
+	case t.queue <- d:
+	default:
+		// never block the request being traced
+		t.dropped++
+	}
+}
+
+func (t *Tracer) run() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]SpanData, 0, batchSize)
+	export := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := t.exporter.Export(ctx, batch); err != nil {
+			log.Warnf("[tracing] export of %d spans failed: %v", len(batch), err)
+		}
+		batch = make([]SpanData, 0, batchSize)
+	}
+
+	for {
+		select {
+		case d, ok := <-t.queue:
+			if !ok {
+				export()
+				return
+			}
+			batch = append(batch, d)
+			if len(batch) >= batchSize {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		}
+	}
+}
+
+// Shutdown exports queued spans and stops the tracer. Spans ended
+// afterwards are discarded.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	t.closeMu.Lock()
+	if t.closed {
+		t.closeMu.Unlock()
+		return nil
+	}
+	t.closed = true
+	dropped := t.dropped
+	close(t.queue)
+	t.closeMu.Unlock()
+
+	if dropped > 0 {
+		log.Warnf("[tracing] %d spans dropped as the export queue was full", dropped)
+	}
+	select {
+	case <-t.done:
+		return nil
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "[tracing] shutdown failed")
+	}
+}
+
+// OTLPExporter sends spans to an OpenTelemetry collector using OTLP over
+// HTTP with JSON encoding.
+type OTLPExporter struct {
+	endpoint string
+	headers  map[string]string
+	service  string
+	client   *http.Client
+}
+
+// NewOTLPExporter returns an exporter posting to endpoint, the full URL
+// of the collector's traces path such as http://localhost:4318/v1/traces.
+// headers are added to each request, for authentication.
+func NewOTLPExporter(endpoint string, headers map[string]string, serviceName string) *OTLPExporter {
+	return &OTLPExporter{
+		endpoint: endpoint,
+		headers:  headers,
+		service:  serviceName,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Export posts spans to the collector.
+func (e *OTLPExporter) Export(ctx context.Context, spans []SpanData) error {
+	body, err := json.Marshal(otlpRequest(e.service, spans))
+	if err != nil {
+		return errors.Wrap(err, "[tracing] json marshal failed")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "[tracing] new request failed")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "[tracing] POST %s failed", e.endpoint)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode/100 != 2 {
+		return errors.Errorf("[tracing] POST %s returned %s", e.endpoint, resp.Status)
+	}
+	return nil
+}
+
+// WriterExporter writes each span as a line of OTLP JSON, for local use
+// without a collector.
+type WriterExporter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	service string
+}
+
+// NewWriterExporter returns an exporter writing to w.
+func NewWriterExporter(w io.Writer, serviceName string) *WriterExporter {
+	return &WriterExporter{w: w, service: serviceName}
+}
+
+// Export writes spans to w.
+func (e *WriterExporter) Export(_ context.Context, spans []SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	enc := json.NewEncoder(e.w)
+	for _, s := range spans {
+		if err := enc.Encode(otlpRequest(e.service, []SpanData{s})); err != nil {
+			return errors.Wrap(err, "[tracing] write span failed")
+		}
+	}
+	return nil
+}
+
+// OTLP JSON encoding. IDs are hex and 64-bit integers are strings.
+// https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding
+
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              SpanKind       `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"` // 2 error
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+func otlpRequest(serviceName string, spans []SpanData) otlpTraces {
+	out := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		o := otlpSpan{
+			TraceID:           s.TraceID.String(),
+			SpanID:            s.SpanID.String(),
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        otlpAttributes(s.Attrs),
+		}
+		if s.ParentSpanID.IsValid() {
+			o.ParentSpanID = s.ParentSpanID.String()
+		}
+		if s.Error {
+			o.Status = otlpStatus{Code: 2, Message: s.StatusMsg}
+		}
+		out = append(out, o)
+	}
+
+	return otlpTraces{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: otlpAttributes([]Attr{
+			String("service.name", serviceName),
+		})},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "github.com/andyfusniak/monolith"},
+			Spans: out,
+		}},
+	}}}
+}
+
+func otlpAttributes(attrs []Attr) []otlpKeyValue {
+	if len(attrs) == 0 {
+		return nil
+	}
+	kvs := make([]otlpKeyValue, 0, len(attrs))
+	for _, a := range attrs {
+		var v map[string]any
+		switch x := a.Value.(type) {
+		case string:
+			v = map[string]any{"stringValue": x}
+		case bool:
+			v = map[string]any{"boolValue": x}
+		case int:
+			v = map[string]any{"intValue": strconv.Itoa(x)}
+		case int64:
+			v = map[string]any{"intValue": strconv.FormatInt(x, 10)}
+		case float64:
+			v = map[string]any{"doubleValue": x}
+		default:
+			v = map[string]any{"stringValue": fmt.Sprint(x)}
+		}
+		kvs = append(kvs, otlpKeyValue{Key: a.Key, Value: v})
+	}
+	return kvs
+}
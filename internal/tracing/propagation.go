@@ -0,0 +1,134 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+const (
+	traceparentHeader = "traceparent"
+	tracestateHeader  = "tracestate"
+)
+
+// ParseTraceparent parses a W3C traceparent header value of the form
+// version-traceid-spanid-flags. ok is false if v is not valid.
+// https://www.w3.org/TR/trace-context/#traceparent-header
+func ParseTraceparent(v string) (sc SpanContext, ok bool) {
+	parts := strings.Split(strings.TrimSpace(v), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return SpanContext{}, false
+	}
+	// version 00 has exactly four fields; later versions may add more
+	if parts[0] == "00" && len(parts) != 4 {
+		return SpanContext{}, false
+	}
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return SpanContext{}, false
+	}
+	var version, flags [1]byte
+	if _, err := hex.Decode(version[:], []byte(parts[0])); err != nil {
+		return SpanContext{}, false
+	}
+	if !isLowerHex(parts[1]) || !isLowerHex(parts[2]) {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return SpanContext{}, false
+	}
+	if !sc.IsValid() {
+		return SpanContext{}, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, true
+}
+
+// FormatTraceparent returns sc as a version 00 traceparent header value.
+func FormatTraceparent(sc SpanContext) string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+func isLowerHex(s string) bool {
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// Extract returns a copy of ctx carrying the span context in the
+// traceparent and tracestate headers of h, if any, as the parent of the
+// next span started.
+func Extract(ctx context.Context, h http.Header) context.Context {
+	sc, ok := ParseTraceparent(h.Get(traceparentHeader))
+	if !ok {
+		return ctx
+	}
+	sc.TraceState = h.Get(tracestateHeader)
+	return ContextWithRemoteSpanContext(ctx, sc)
+}
+
+// Inject sets the traceparent and tracestate headers of h from the span
+// context of ctx.
+func Inject(ctx context.Context, h http.Header) {
+	sc := SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return
+	}
+	h.Set(traceparentHeader, FormatTraceparent(sc))
+	if sc.TraceState != "" {
+		h.Set(tracestateHeader, sc.TraceState)
+	}
+}
+
+// Transport returns an http.RoundTripper that records a client span for
+// each request made with base and propagates it to the server.
+func Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return transport{base: base}
+}
+
+type transport struct {
+	base http.RoundTripper
+}
+
+func (t transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	ctx, span := StartKind(r.Context(), "HTTP "+r.Method, SpanKindClient,
+		String("http.request.method", r.Method),
+		String("server.address", r.URL.Host),
+		// without the query, which may carry credentials
+		String("url.full", r.URL.Scheme+"://"+r.URL.Host+r.URL.Path),
+	)
+	defer span.End()
+
+	if span != nil {
+		// RoundTrippers must not modify the caller's request
+		r = r.Clone(ctx)
+		Inject(ctx, r.Header)
+	}
+
+	resp, err := t.base.RoundTrip(r)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	span.SetAttributes(Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= 500 {
+		span.SetError(resp.Status)
+	}
+	return resp, nil
+}
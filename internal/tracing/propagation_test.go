@@ -0,0 +1,216 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+const (
+	testTraceID     = "4bf92f3577b34da6a3ce929d0e0e4736"
+	testSpanID      = "00f067aa0ba902b7"
+	testTraceparent = "00-" + testTraceID + "-" + testSpanID + "-01"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name        string
+		v           string
+		wantOK      bool
+		wantSampled bool
+	}{
+		{name: "sampled", v: testTraceparent, wantOK: true, wantSampled: true},
+		{name: "not sampled", v: "00-" + testTraceID + "-" + testSpanID + "-00", wantOK: true},
+		{name: "other flags", v: "00-" + testTraceID + "-" + testSpanID + "-03", wantOK: true, wantSampled: true},
+		{name: "surrounding space", v: " " + testTraceparent + " ", wantOK: true, wantSampled: true},
+		{name: "future version with more fields", v: "cc-" + testTraceID + "-" + testSpanID + "-01-extra", wantOK: true, wantSampled: true},
+		{name: "version 00 with more fields", v: testTraceparent + "-extra"},
+		{name: "version ff", v: "ff-" + testTraceID + "-" + testSpanID + "-01"},
+		{name: "version not hex", v: "0g-" + testTraceID + "-" + testSpanID + "-01"},
+		{name: "upper case trace id", v: "00-4BF92F3577B34DA6A3CE929D0E0E4736-" + testSpanID + "-01"},
+		{name: "zero trace id", v: "00-00000000000000000000000000000000-" + testSpanID + "-01"},
+		{name: "zero span id", v: "00-" + testTraceID + "-0000000000000000-01"},
+		{name: "short trace id", v: "00-" + testTraceID[2:] + "-" + testSpanID + "-01"},
+		{name: "long span id", v: "00-" + testTraceID + "-" + testSpanID + "00-01"},
+		{name: "flags not hex", v: "00-" + testTraceID + "-" + testSpanID + "-0x"},
+		{name: "too few fields", v: "00-" + testTraceID + "-" + testSpanID},
+		{name: "empty", v: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, ok := ParseTraceparent(tt.v)
+			if ok != tt.wantOK {
+				t.Fatalf("ParseTraceparent(%q) ok = %t, want %t", tt.v, ok, tt.wantOK)
+			}
+			if !ok {
+				if sc != (SpanContext{}) {
+					t.Errorf("ParseTraceparent(%q) = %+v, want zero", tt.v, sc)
+				}
+				return
+			}
+			if sc.TraceID.String() != testTraceID || sc.SpanID.String() != testSpanID || sc.Sampled != tt.wantSampled {
+				t.Errorf("ParseTraceparent(%q) = %s %s sampled=%t, want %s %s sampled=%t", tt.v,
+					sc.TraceID, sc.SpanID, sc.Sampled, testTraceID, testSpanID, tt.wantSampled)
+			}
+		})
+	}
+}
+
+func TestFormatTraceparent(t *testing.T) {
+	sc, ok := ParseTraceparent(testTraceparent)
+	if !ok {
+		t.Fatal("ParseTraceparent failed")
+	}
+	if got := FormatTraceparent(sc); got != testTraceparent {
+		t.Errorf("FormatTraceparent = %s, want %s", got, testTraceparent)
+	}
+	sc.Sampled = false
+	if got, want := FormatTraceparent(sc), "00-"+testTraceID+"-"+testSpanID+"-00"; got != want {
+		t.Errorf("FormatTraceparent not sampled = %s, want %s", got, want)
+	}
+}
+
+// recordExporter keeps exported spans.
+type recordExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func (e *recordExporter) Export(_ context.Context, spans []SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+// useTestTracer sets a default tracer sampling with ratio for the test.
+// The returned function flushes the tracer and returns its spans.
+func useTestTracer(t *testing.T, ratio float64) func() []SpanData {
+	t.Helper()
+	exp := &recordExporter{}
+	tracer := NewTracer(exp, ratio)
+	SetDefault(tracer)
+	t.Cleanup(func() {
+		SetDefault(nil)
+		tracer.Shutdown(context.Background())
+	})
+	return func() []SpanData {
+		if err := tracer.Shutdown(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		exp.mu.Lock()
+		defer exp.mu.Unlock()
+		return exp.spans
+	}
+}
+
+func TestExtractInject(t *testing.T) {
+	flush := useTestTracer(t, 0) // only continued traces are sampled
+
+	h := http.Header{}
+	h.Set("traceparent", testTraceparent)
+	h.Set("tracestate", "vendor=value")
+	ctx := Extract(context.Background(), h)
+
+	remote := SpanContextFromContext(ctx)
+	if !remote.Remote || remote.TraceID.String() != testTraceID || remote.TraceState != "vendor=value" {
+		t.Fatalf("extracted span context = %+v, want remote trace %s", remote, testTraceID)
+	}
+
+	ctx, span := StartKind(ctx, "GET /", SpanKindServer)
+	out := http.Header{}
+	Inject(ctx, out)
+	span.End()
+
+	sc := span.SpanContext()
+	if got, want := out.Get("traceparent"), "00-"+testTraceID+"-"+sc.SpanID.String()+"-01"; got != want {
+		t.Errorf("injected traceparent = %s, want %s", got, want)
+	}
+	if sc.SpanID.String() == testSpanID {
+		t.Error("server span reused the caller's span id")
+	}
+	if got := out.Get("tracestate"); got != "vendor=value" {
+		t.Errorf("injected tracestate = %q, want vendor=value", got)
+	}
+
+	spans := flush()
+	if len(spans) != 1 || spans[0].ParentSpanID.String() != testSpanID || spans[0].TraceID.String() != testTraceID {
+		t.Fatalf("exported spans = %+v, want one child of %s", spans, testSpanID)
+	}
+}
+
+func TestExtractInvalid(t *testing.T) {
+	h := http.Header{}
+	h.Set("traceparent", "00-"+testTraceID+"-0000000000000000-01")
+	ctx := context.Background()
+	if got := Extract(ctx, h); got != ctx {
+		t.Error("Extract of an invalid traceparent changed the context")
+	}
+
+	out := http.Header{}
+	Inject(ctx, out)
+	if len(out) != 0 {
+		t.Errorf("Inject without a span set %v", out)
+	}
+}
+
+func TestTransportPropagates(t *testing.T) {
+	flush := useTestTracer(t, 1)
+
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("traceparent")
+	}))
+	defer srv.Close()
+
+	ctx, parent := Start(context.Background(), "parent")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/path?token=secret", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := (&http.Client{Transport: Transport(nil)}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	parent.End()
+
+	if req.Header.Get("traceparent") != "" {
+		t.Error("Transport modified the caller's request")
+	}
+	sc, ok := ParseTraceparent(got)
+	if !ok || sc.TraceID != parent.SpanContext().TraceID || sc.SpanID == parent.SpanContext().SpanID {
+		t.Fatalf("server received traceparent %q, want a child of %s", got, FormatTraceparent(parent.SpanContext()))
+	}
+
+	var client *SpanData
+	spans := flush()
+	for i := range spans {
+		if spans[i].Kind == SpanKindClient {
+			client = &spans[i]
+		}
+	}
+	if client == nil || client.SpanID != sc.SpanID || client.ParentSpanID != parent.SpanContext().SpanID {
+		t.Fatalf("exported spans = %+v, want a client span %s", spans, sc.SpanID)
+	}
+	for _, a := range client.Attrs {
+		if a.Key == "url.full" && a.Value != srv.URL+"/path" {
+			t.Errorf("url.full = %v, want it without the query", a.Value)
+		}
+	}
+}
+
+func TestStartDisabled(t *testing.T) {
+	SetDefault(nil)
+	ctx := context.Background()
+	got, span := Start(ctx, "noop")
+	if span != nil || got != ctx {
+		t.Fatalf("Start without a tracer = %v, want no span", span)
+	}
+	// methods of a nil span are safe to call
+	span.SetAttributes(String("k", "v"))
+	span.End()
+}
@@ -0,0 +1,268 @@
+// Package tracing records distributed traces. Spans are started with
+// Start, carried in the context, propagated between services using the
+// W3C traceparent header and exported in batches to an OpenTelemetry
+// collector or written as JSON lines.
+//
+// Tracing is disabled until SetDefault is called with a Tracer. While it
+// is disabled Start returns a nil *Span, whose methods do nothing.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TraceID identifies a trace.
+type TraceID [16]byte
+
+// IsValid returns true if t is not all zeros.
+func (t TraceID) IsValid() bool { return t != TraceID{} }
+
+// String returns t as lowercase hex.
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+
+// SpanID identifies a span within a trace.
+type SpanID [8]byte
+
+// IsValid returns true if s is not all zeros.
+func (s SpanID) IsValid() bool { return s != SpanID{} }
+
+// String returns s as lowercase hex.
+func (s SpanID) String() string { return hex.EncodeToString(s[:]) }
+
+// SpanContext is the part of a span propagated to child spans and other
+// services.
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Sampled    bool
+	TraceState string
+	Remote     bool
+}
+
+// IsValid returns true if sc has both a trace and span ID.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// SpanKind describes the relationship of a span to its parent and
+// children. The values are those of OTLP.
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+// Attr is a span attribute. Value is a string, bool, int, int64 or
+// float64; other types are formatted as strings when exported.
+type Attr struct {
+	Key   string
+	Value any
+}
+
+// String returns a string attribute.
+func String(k, v string) Attr { return Attr{Key: k, Value: v} }
+
+// Int returns an integer attribute.
+func Int(k string, v int) Attr { return Attr{Key: k, Value: int64(v)} }
+
+// Bool returns a boolean attribute.
+func Bool(k string, v bool) Attr { return Attr{Key: k, Value: v} }
+
+// Span is a timed operation within a trace. A nil *Span is valid and
+// does nothing.
+type Span struct {
+	tracer *Tracer
+	sc     SpanContext
+	parent SpanID
+	name   string
+	kind   SpanKind
+	start  time.Time
+
+	mu        sync.Mutex
+	end       time.Time
+	attrs     []Attr
+	errored   bool
+	statusMsg string
+	ended     bool
+}
+
+// SpanContext returns the propagated context of s.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// SetName replaces the name of s, for spans whose name is not known
+// until they have run.
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.name = name
+}
+
+// SetAttributes adds attrs to s.
+func (s *Span) SetAttributes(attrs ...Attr) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attrs = append(s.attrs, attrs...)
+}
+
+// RecordError marks s as failed with err. It does nothing if err is nil.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.SetError(err.Error())
+}
+
+// SetError marks s as failed with a description of the failure.
+func (s *Span) SetError(msg string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errored = true
+	s.statusMsg = msg
+}
+
+// End completes s and queues it for export if it is sampled. Calls after
+// the first do nothing.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+
+	if s.sc.Sampled {
+		s.tracer.enqueue(s)
+	}
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+// ContextWithSpan returns a copy of ctx carrying s.
+func ContextWithSpan(ctx context.Context, s *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, s)
+}
+
+// SpanFromContext returns the span carried by ctx, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// ContextWithRemoteSpanContext returns a copy of ctx carrying sc, received
+// from another service, as the parent of the next span started.
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	sc.Remote = true
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// SpanContextFromContext returns the context of the span carried by ctx,
+// or the remote span context if no span has been started.
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if s := SpanFromContext(ctx); s != nil {
+		return s.sc
+	}
+	sc, _ := ctx.Value(remoteKey{}).(SpanContext)
+	return sc
+}
+
+var defaultTracer atomic.Pointer[Tracer]
+
+// SetDefault sets the tracer used by Start. Pass nil to disable tracing.
+func SetDefault(t *Tracer) {
+	defaultTracer.Store(t)
+}
+
+// Enabled returns true if a tracer has been set.
+func Enabled() bool {
+	return defaultTracer.Load() != nil
+}
+
+// Start starts a span of kind SpanKindInternal as a child of the span in
+// ctx, returning a context carrying the new span. End must be called on
+// the span.
+func Start(ctx context.Context, name string, attrs ...Attr) (context.Context, *Span) {
+	return StartKind(ctx, name, SpanKindInternal, attrs...)
+}
+
+// StartKind starts a span of the given kind.
+func StartKind(ctx context.Context, name string, kind SpanKind, attrs ...Attr) (context.Context, *Span) {
+	t := defaultTracer.Load()
+	if t == nil {
+		return ctx, nil
+	}
+
+	parent := SpanContextFromContext(ctx)
+	s := &Span{
+		tracer: t,
+		name:   name,
+		kind:   kind,
+		start:  time.Now(),
+		attrs:  attrs,
+	}
+	s.sc.SpanID = newSpanID()
+	if parent.IsValid() {
+		s.sc.TraceID = parent.TraceID
+		s.sc.Sampled = parent.Sampled
+		s.sc.TraceState = parent.TraceState
+		s.parent = parent.SpanID
+	} else {
+		s.sc.TraceID = newTraceID()
+		s.sc.Sampled = t.sample(s.sc.TraceID)
+	}
+	return ContextWithSpan(ctx, s), s
+}
+
+func newTraceID() TraceID {
+	var t TraceID
+	for !t.IsValid() {
+		if _, err := rand.Read(t[:]); err != nil {
+			panic(fmt.Sprintf("tracing: crypto/rand failed: %v", err))
+		}
+	}
+	return t
+}
+
+func newSpanID() SpanID {
+	var s SpanID
+	for !s.IsValid() {
+		if _, err := rand.Read(s[:]); err != nil {
+			panic(fmt.Sprintf("tracing: crypto/rand failed: %v", err))
+		}
+	}
+	return s
+}
+
+// traceIDRatio returns the lower 63 bits of the trace ID's random part as
+// a fraction of the range, for sampling.
+func traceIDRatio(t TraceID) float64 {
+	return float64(binary.BigEndian.Uint64(t[8:])>>1) / (1 << 63)
+}
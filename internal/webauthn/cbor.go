@@ -0,0 +1,183 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// maxCBORDepth limits nesting so malicious input cannot exhaust the stack.
+const maxCBORDepth = 16
+
+var errCBORTruncated = errors.New("cbor: unexpected end of input")
+
+// decodeCBOR decodes a single CBOR data item from b, returning the item and
+// the remaining bytes. Only the definite length subset used by CTAP2 is
+// supported. Integers decode to int64, byte strings to []byte, text strings
+// to string, arrays to []any and maps to map[any]any.
+func decodeCBOR(b []byte) (any, []byte, error) {
+	return decodeCBORItem(b, 0)
+}
+
+func decodeCBORItem(b []byte, depth int) (any, []byte, error) {
+	if depth > maxCBORDepth {
+		return nil, nil, errors.New("cbor: maximum nesting depth exceeded")
+	}
+	if len(b) == 0 {
+		return nil, nil, errCBORTruncated
+	}
+
+	major := b[0] >> 5
+	info := b[0] & 0x1f
+	b = b[1:]
+
+	// simple values and floats carry their payload differently
+	if major == 7 {
+		return decodeCBORSimple(info, b)
+	}
+
+	arg, b, err := decodeCBORArg(info, b)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch major {
+	case 0: // unsigned integer
+		if arg > math.MaxInt64 {
+			return nil, nil, errors.New("cbor: integer overflow")
+		}
+		return int64(arg), b, nil
+	case 1: // negative integer
+		if arg > math.MaxInt64 {
+			return nil, nil, errors.New("cbor: integer overflow")
+		}
+		return -1 - int64(arg), b, nil
+	case 2: // byte string
+		if uint64(len(b)) < arg {
+			return nil, nil, errCBORTruncated
+		}
+		v := make([]byte, arg)
+		copy(v, b[:arg])
+		return v, b[arg:], nil
+	case 3: // text string
+		if uint64(len(b)) < arg {
+			return nil, nil, errCBORTruncated
+		}
+		return string(b[:arg]), b[arg:], nil
+	case 4: // array
+		if arg > uint64(len(b)) {
+			return nil, nil, errCBORTruncated
+		}
+		v := make([]any, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			var item any
+			item, b, err = decodeCBORItem(b, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			v = append(v, item)
+		}
+		return v, b, nil
+	case 5: // map
+		if arg > uint64(len(b)) {
+			return nil, nil, errCBORTruncated
+		}
+		v := make(map[any]any, arg)
+		for i := uint64(0); i < arg; i++ {
+			var key, val any
+			key, b, err = decodeCBORItem(b, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, errors.New("cbor: unsupported map key type")
+			}
+			val, b, err = decodeCBORItem(b, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			v[key] = val
+		}
+		return v, b, nil
+	case 6: // tag; the tag number is ignored
+		return decodeCBORItem(b, depth+1)
+	}
+
+	return nil, nil, errors.Errorf("cbor: unsupported major type %d", major)
+}
+
+func decodeCBORArg(info byte, b []byte) (uint64, []byte, error) {
+	switch {
+	case info < 24:
+		return uint64(info), b, nil
+	case info == 24:
+		if len(b) < 1 {
+			return 0, nil, errCBORTruncated
+		}
+		return uint64(b[0]), b[1:], nil
+	case info == 25:
+		if len(b) < 2 {
+			return 0, nil, errCBORTruncated
+		}
+		return uint64(binary.BigEndian.Uint16(b)), b[2:], nil
+	case info == 26:
+		if len(b) < 4 {
+			return 0, nil, errCBORTruncated
+		}
+		return uint64(binary.BigEndian.Uint32(b)), b[4:], nil
+	case info == 27:
+		if len(b) < 8 {
+			return 0, nil, errCBORTruncated
+		}
+		return binary.BigEndian.Uint64(b), b[8:], nil
+	}
+	return 0, nil, errors.New("cbor: indefinite length items are not supported")
+}
+
+func decodeCBORSimple(info byte, b []byte) (any, []byte, error) {
+	switch info {
+	case 20:
+		return false, b, nil
+	case 21:
+		return true, b, nil
+	case 22, 23: // null, undefined
+		return nil, b, nil
+	case 25:
+		if len(b) < 2 {
+			return nil, nil, errCBORTruncated
+		}
+		return float64(halfToFloat(binary.BigEndian.Uint16(b))), b[2:], nil
+	case 26:
+		if len(b) < 4 {
+			return nil, nil, errCBORTruncated
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), b[4:], nil
+	case 27:
+		if len(b) < 8 {
+			return nil, nil, errCBORTruncated
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), b[8:], nil
+	}
+	return nil, nil, errors.Errorf("cbor: unsupported simple value %d", info)
+}
+
+func halfToFloat(h uint16) float32 {
+	sign := uint32(h>>15) << 31
+	exp := uint32(h>>10) & 0x1f
+	frac := uint32(h) & 0x3ff
+
+	switch exp {
+	case 0:
+		f := float32(frac) / (1 << 24)
+		if sign != 0 {
+			return -f
+		}
+		return f
+	case 0x1f:
+		return math.Float32frombits(sign | 0x7f800000 | frac<<13)
+	}
+	return math.Float32frombits(sign | (exp+112)<<23 | frac<<13)
+}
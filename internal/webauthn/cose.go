@@ -0,0 +1,137 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"math/big"
+
+	"github.com/pkg/errors"
+)
+
+// COSE algorithm identifiers (RFC 9053).
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+// COSE key parameters (RFC 9052 section 7).
+const (
+	coseKeyKty = 1
+	coseKeyAlg = 3
+	coseKeyCrv = -1
+	coseKeyX   = -2 // also RSA e
+	coseKeyY   = -3
+	coseKeyN   = -1
+
+	coseKtyOKP = 1
+	coseKtyEC2 = 2
+	coseKtyRSA = 3
+
+	coseCrvP256    = 1
+	coseCrvEd25519 = 6
+)
+
+var ErrSignatureInvalid = errors.New("webauthn: signature invalid")
+
+// SupportedAlgorithms are the COSE algorithms accepted for new credentials
+// in order of preference.
+var SupportedAlgorithms = []int{AlgES256, AlgEdDSA, AlgRS256}
+
+// PublicKey is a credential public key decoded from its COSE encoding.
+type PublicKey struct {
+	Alg int
+	key crypto.PublicKey
+}
+
+// ParsePublicKey decodes a COSE_Key.
+func ParsePublicKey(cose []byte) (PublicKey, error) {
+	v, rest, err := decodeCBOR(cose)
+	if err != nil {
+		return PublicKey{}, errors.Wrap(err, "webauthn: decode cose key")
+	}
+	if len(rest) != 0 {
+		return PublicKey{}, errors.New("webauthn: trailing bytes after cose key")
+	}
+	m, ok := v.(map[any]any)
+	if !ok {
+		return PublicKey{}, errors.New("webauthn: cose key is not a map")
+	}
+
+	kty, _ := m[int64(coseKeyKty)].(int64)
+	alg, _ := m[int64(coseKeyAlg)].(int64)
+
+	switch {
+	case kty == coseKtyEC2 && alg == AlgES256:
+		crv, _ := m[int64(coseKeyCrv)].(int64)
+		x, _ := m[int64(coseKeyX)].([]byte)
+		y, _ := m[int64(coseKeyY)].([]byte)
+		if crv != coseCrvP256 || len(x) != 32 || len(y) != 32 {
+			return PublicKey{}, errors.New("webauthn: invalid ec2 key")
+		}
+		// ecdh validates that the point is on the curve
+		point := append([]byte{0x04}, append(x, y...)...)
+		if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+			return PublicKey{}, errors.Wrap(err, "webauthn: invalid p-256 point")
+		}
+		return PublicKey{Alg: AlgES256, key: &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}}, nil
+
+	case kty == coseKtyOKP && alg == AlgEdDSA:
+		crv, _ := m[int64(coseKeyCrv)].(int64)
+		x, _ := m[int64(coseKeyX)].([]byte)
+		if crv != coseCrvEd25519 || len(x) != ed25519.PublicKeySize {
+			return PublicKey{}, errors.New("webauthn: invalid okp key")
+		}
+		return PublicKey{Alg: AlgEdDSA, key: ed25519.PublicKey(x)}, nil
+
+	case kty == coseKtyRSA && alg == AlgRS256:
+		n, _ := m[int64(coseKeyN)].([]byte)
+		e, _ := m[int64(coseKeyX)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return PublicKey{}, errors.New("webauthn: invalid rsa key")
+		}
+		var exp int
+		for _, c := range e {
+			exp = exp<<8 | int(c)
+		}
+		return PublicKey{Alg: AlgRS256, key: &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: exp,
+		}}, nil
+	}
+
+	return PublicKey{}, errors.Errorf("webauthn: unsupported cose key kty=%d alg=%d", kty, alg)
+}
+
+// Verify checks sig is a valid signature of data.
+func (k PublicKey) Verify(data, sig []byte) error {
+	switch pub := k.key.(type) {
+	case *ecdsa.PublicKey:
+		sum := sha256.Sum256(data)
+		if !ecdsa.VerifyASN1(pub, sum[:], sig) {
+			return ErrSignatureInvalid
+		}
+		return nil
+	case ed25519.PublicKey:
+		if !ed25519.Verify(pub, data, sig) {
+			return ErrSignatureInvalid
+		}
+		return nil
+	case *rsa.PublicKey:
+		sum := sha256.Sum256(data)
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], sig); err != nil {
+			return ErrSignatureInvalid
+		}
+		return nil
+	}
+	return errors.New("webauthn: unsupported public key")
+}
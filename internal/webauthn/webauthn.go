@@ -0,0 +1,450 @@
+// Package webauthn implements the relying party side of the W3C Web
+// Authentication registration and authentication ceremonies.
+//
+// Only attestation conveyance "none" is requested. Attestation statements
+// returned by authenticators are not verified, so registered credentials
+// carry no claim about the authenticator make or model.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/url"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	challengeBytes = 32
+
+	ceremonyCreate = "webauthn.create"
+	ceremonyGet    = "webauthn.get"
+
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttestedData = 0x40
+	flagExtensions   = 0x80
+)
+
+var (
+	ErrSignCountInvalid = errors.New("webauthn: signature counter did not increase")
+)
+
+// Encoding is the base64url encoding used for binary values in JSON.
+var Encoding = base64.RawURLEncoding
+
+// Config identifies the relying party.
+type Config struct {
+	// RPID is the relying party identifier, the effective domain of Origin.
+	RPID string
+
+	// RPName is a human readable relying party name.
+	RPName string
+
+	// Origin is the expected origin of client data, for example
+	// https://example.com.
+	Origin string
+}
+
+// NewConfig returns a Config for the given base URL. The relying party
+// identifier is the host name of the URL.
+func NewConfig(baseURL, rpName string) (Config, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return Config{}, errors.Wrapf(err, "webauthn: parse base url %q", baseURL)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return Config{}, errors.Errorf("webauthn: base url %q must be absolute", baseURL)
+	}
+	return Config{
+		RPID:   u.Hostname(),
+		RPName: rpName,
+		Origin: u.Scheme + "://" + u.Host,
+	}, nil
+}
+
+// NewChallenge returns a random base64url encoded challenge.
+func NewChallenge() (string, error) {
+	b := make([]byte, challengeBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "webauthn: generate challenge")
+	}
+	return Encoding.EncodeToString(b), nil
+}
+
+type RelyingParty struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type UserEntity struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type CredentialDescriptor struct {
+	Type       string   `json:"type"`
+	ID         string   `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// CreationOptions are passed to navigator.credentials.create() as the
+// publicKey member.
+type CreationOptions struct {
+	Challenge              string                 `json:"challenge"`
+	RP                     RelyingParty           `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int                    `json:"timeout"`
+	Attestation            string                 `json:"attestation"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+}
+
+// RequestOptions are passed to navigator.credentials.get() as the
+// publicKey member.
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	Timeout          int                    `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// CreationOptions returns registration options for a user. The user handle
+// is the raw bytes of userID.
+func (c Config) CreationOptions(challenge, userID, userName string, timeoutMS int, exclude []CredentialDescriptor) CreationOptions {
+	params := make([]CredentialParameter, 0, len(SupportedAlgorithms))
+	for _, alg := range SupportedAlgorithms {
+		params = append(params, CredentialParameter{Type: "public-key", Alg: alg})
+	}
+	if exclude == nil {
+		exclude = []CredentialDescriptor{}
+	}
+
+	return CreationOptions{
+		Challenge: challenge,
+		RP:        RelyingParty{ID: c.RPID, Name: c.RPName},
+		User: UserEntity{
+			ID:          Encoding.EncodeToString([]byte(userID)),
+			Name:        userName,
+			DisplayName: userName,
+		},
+		PubKeyCredParams:   params,
+		Timeout:            timeoutMS,
+		Attestation:        "none",
+		ExcludeCredentials: exclude,
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:      "preferred",
+			UserVerification: "preferred",
+		},
+	}
+}
+
+// RequestOptions returns authentication options. If allow is empty the
+// client may offer any discoverable credential for the relying party.
+func (c Config) RequestOptions(challenge string, timeoutMS int, allow []CredentialDescriptor) RequestOptions {
+	if allow == nil {
+		allow = []CredentialDescriptor{}
+	}
+	return RequestOptions{
+		Challenge:        challenge,
+		Timeout:          timeoutMS,
+		RPID:             c.RPID,
+		AllowCredentials: allow,
+		UserVerification: "preferred",
+	}
+}
+
+// AttestationResponse is the JSON serialisation of a PublicKeyCredential
+// returned by navigator.credentials.create().
+type AttestationResponse struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string   `json:"clientDataJSON"`
+		AttestationObject string   `json:"attestationObject"`
+		Transports        []string `json:"transports,omitempty"`
+	} `json:"response"`
+}
+
+// AssertionResponse is the JSON serialisation of a PublicKeyCredential
+// returned by navigator.credentials.get().
+type AssertionResponse struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AuthenticatorData string `json:"authenticatorData"`
+		Signature         string `json:"signature"`
+		UserHandle        string `json:"userHandle,omitempty"`
+	} `json:"response"`
+}
+
+// ClientData is the decoded clientDataJSON.
+type ClientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// ParseClientData decodes a base64url encoded clientDataJSON.
+func ParseClientData(clientDataJSON string) (ClientData, []byte, error) {
+	raw, err := Encoding.DecodeString(clientDataJSON)
+	if err != nil {
+		return ClientData{}, nil, errors.Wrap(err, "webauthn: decode clientDataJSON")
+	}
+	var cd ClientData
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return ClientData{}, nil, errors.Wrap(err, "webauthn: unmarshal clientDataJSON")
+	}
+	return cd, raw, nil
+}
+
+func (c Config) verifyClientData(cd ClientData, ceremony, challenge string) error {
+	if cd.Type != ceremony {
+		return errors.Errorf("webauthn: client data type %q, expected %q", cd.Type, ceremony)
+	}
+	if subtle.ConstantTimeCompare([]byte(cd.Challenge), []byte(challenge)) != 1 {
+		return errors.New("webauthn: challenge mismatch")
+	}
+	if cd.Origin != c.Origin {
+		return errors.Errorf("webauthn: origin %q, expected %q", cd.Origin, c.Origin)
+	}
+	if cd.CrossOrigin {
+		return errors.New("webauthn: cross origin requests are not accepted")
+	}
+	return nil
+}
+
+// AuthenticatorData is the decoded authenticator data structure.
+type AuthenticatorData struct {
+	RPIDHash  []byte
+	Flags     byte
+	SignCount uint32
+
+	// attested credential data, present during registration
+	AAGUID       []byte
+	CredentialID []byte
+	PublicKey    []byte
+}
+
+// UserVerified returns true if the authenticator verified the user.
+func (a AuthenticatorData) UserVerified() bool {
+	return a.Flags&flagUserVerified != 0
+}
+
+// ParseAuthenticatorData decodes raw authenticator data.
+func ParseAuthenticatorData(b []byte) (AuthenticatorData, error) {
+	if len(b) < 37 {
+		return AuthenticatorData{}, errors.New("webauthn: authenticator data too short")
+	}
+	a := AuthenticatorData{
+		RPIDHash:  b[:32],
+		Flags:     b[32],
+		SignCount: binary.BigEndian.Uint32(b[33:37]),
+	}
+	rest := b[37:]
+
+	if a.Flags&flagAttestedData != 0 {
+		if len(rest) < 18 {
+			return AuthenticatorData{}, errors.New("webauthn: attested credential data too short")
+		}
+		a.AAGUID = rest[:16]
+		n := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if n == 0 || n > 1023 || len(rest) < n {
+			return AuthenticatorData{}, errors.New("webauthn: invalid credential id length")
+		}
+		a.CredentialID = rest[:n]
+		rest = rest[n:]
+
+		_, after, err := decodeCBOR(rest)
+		if err != nil {
+			return AuthenticatorData{}, errors.Wrap(err, "webauthn: decode credential public key")
+		}
+		a.PublicKey = rest[:len(rest)-len(after)]
+		rest = after
+	}
+
+	if a.Flags&flagExtensions != 0 {
+		_, after, err := decodeCBOR(rest)
+		if err != nil {
+			return AuthenticatorData{}, errors.Wrap(err, "webauthn: decode extensions")
+		}
+		rest = after
+	}
+	if len(rest) != 0 {
+		return AuthenticatorData{}, errors.New("webauthn: trailing bytes in authenticator data")
+	}
+
+	return a, nil
+}
+
+func (c Config) verifyAuthenticatorData(a AuthenticatorData) error {
+	want := sha256.Sum256([]byte(c.RPID))
+	if !bytes.Equal(a.RPIDHash, want[:]) {
+		return errors.New("webauthn: rp id hash mismatch")
+	}
+	if a.Flags&flagUserPresent == 0 {
+		return errors.New("webauthn: user not present")
+	}
+	return nil
+}
+
+// Credential is a newly registered and verified credential.
+type Credential struct {
+	ID           []byte
+	PublicKey    []byte // COSE_Key
+	SignCount    uint32
+	AAGUID       []byte
+	Transports   []string
+	UserVerified bool
+}
+
+// VerifyRegistration performs the relying party checks for a registration
+// ceremony started with the given challenge.
+func (c Config) VerifyRegistration(resp AttestationResponse, challenge string) (Credential, error) {
+	if resp.Type != "public-key" {
+		return Credential{}, errors.Errorf("webauthn: credential type %q", resp.Type)
+	}
+
+	cd, _, err := ParseClientData(resp.Response.ClientDataJSON)
+	if err != nil {
+		return Credential{}, err
+	}
+	if err := c.verifyClientData(cd, ceremonyCreate, challenge); err != nil {
+		return Credential{}, err
+	}
+
+	rawAtt, err := Encoding.DecodeString(resp.Response.AttestationObject)
+	if err != nil {
+		return Credential{}, errors.Wrap(err, "webauthn: decode attestationObject")
+	}
+	v, rest, err := decodeCBOR(rawAtt)
+	if err != nil {
+		return Credential{}, errors.Wrap(err, "webauthn: decode attestationObject")
+	}
+	att, ok := v.(map[any]any)
+	if !ok || len(rest) != 0 {
+		return Credential{}, errors.New("webauthn: attestationObject is not a map")
+	}
+	if _, ok := att["fmt"].(string); !ok {
+		return Credential{}, errors.New("webauthn: attestationObject missing fmt")
+	}
+	rawAuth, ok := att["authData"].([]byte)
+	if !ok {
+		return Credential{}, errors.New("webauthn: attestationObject missing authData")
+	}
+
+	a, err := ParseAuthenticatorData(rawAuth)
+	if err != nil {
+		return Credential{}, err
+	}
+	if err := c.verifyAuthenticatorData(a); err != nil {
+		return Credential{}, err
+	}
+	if a.CredentialID == nil {
+		return Credential{}, errors.New("webauthn: no attested credential data")
+	}
+
+	rawID, err := Encoding.DecodeString(resp.RawID)
+	if err != nil {
+		return Credential{}, errors.Wrap(err, "webauthn: decode rawId")
+	}
+	if !bytes.Equal(rawID, a.CredentialID) {
+		return Credential{}, errors.New("webauthn: rawId does not match credential id")
+	}
+
+	// reject algorithms or keys we could not later verify
+	if _, err := ParsePublicKey(a.PublicKey); err != nil {
+		return Credential{}, err
+	}
+
+	return Credential{
+		ID:           a.CredentialID,
+		PublicKey:    a.PublicKey,
+		SignCount:    a.SignCount,
+		AAGUID:       a.AAGUID,
+		Transports:   resp.Response.Transports,
+		UserVerified: a.UserVerified(),
+	}, nil
+}
+
+// Assertion is the result of a verified authentication ceremony.
+type Assertion struct {
+	// SignCount is the new signature counter to be stored.
+	SignCount    uint32
+	UserVerified bool
+}
+
+// VerifyAssertion performs the relying party checks for an authentication
+// ceremony started with the given challenge against a stored credential.
+func (c Config) VerifyAssertion(resp AssertionResponse, challenge string, publicKey []byte, signCount uint32) (Assertion, error) {
+	if resp.Type != "public-key" {
+		return Assertion{}, errors.Errorf("webauthn: credential type %q", resp.Type)
+	}
+
+	cd, rawClientData, err := ParseClientData(resp.Response.ClientDataJSON)
+	if err != nil {
+		return Assertion{}, err
+	}
+	if err := c.verifyClientData(cd, ceremonyGet, challenge); err != nil {
+		return Assertion{}, err
+	}
+
+	rawAuth, err := Encoding.DecodeString(resp.Response.AuthenticatorData)
+	if err != nil {
+		return Assertion{}, errors.Wrap(err, "webauthn: decode authenticatorData")
+	}
+	a, err := ParseAuthenticatorData(rawAuth)
+	if err != nil {
+		return Assertion{}, err
+	}
+	if err := c.verifyAuthenticatorData(a); err != nil {
+		return Assertion{}, err
+	}
+
+	sig, err := Encoding.DecodeString(resp.Response.Signature)
+	if err != nil {
+		return Assertion{}, errors.Wrap(err, "webauthn: decode signature")
+	}
+	key, err := ParsePublicKey(publicKey)
+	if err != nil {
+		return Assertion{}, err
+	}
+	clientDataHash := sha256.Sum256(rawClientData)
+	signed := append(append([]byte{}, rawAuth...), clientDataHash[:]...)
+	if err := key.Verify(signed, sig); err != nil {
+		return Assertion{}, err
+	}
+
+	// authenticators that do not implement a counter always return zero
+	if (a.SignCount != 0 || signCount != 0) && a.SignCount <= signCount {
+		return Assertion{}, ErrSignCountInvalid
+	}
+
+	return Assertion{
+		SignCount:    a.SignCount,
+		UserVerified: a.UserVerified(),
+	}, nil
+}
@@ -0,0 +1,252 @@
+package webauthn_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/andyfusniak/monolith/internal/webauthn"
+	"github.com/andyfusniak/monolith/internal/webauthn/webauthntest"
+)
+
+const (
+	testRPID   = "example.com"
+	testOrigin = "https://example.com"
+)
+
+var testConfig = webauthn.Config{RPID: testRPID, RPName: "Example", Origin: testOrigin}
+
+func newAuthenticator(t *testing.T) *webauthntest.Authenticator {
+	t.Helper()
+	a, err := webauthntest.NewAuthenticator(testRPID, testOrigin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func newChallenge(t *testing.T) string {
+	t.Helper()
+	c, err := webauthn.NewChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// register returns the credential registered by a.
+func register(t *testing.T, a *webauthntest.Authenticator) webauthn.Credential {
+	t.Helper()
+	challenge := newChallenge(t)
+	cred, err := testConfig.VerifyRegistration(a.Create(challenge), challenge)
+	if err != nil {
+		t.Fatalf("VerifyRegistration: %v", err)
+	}
+	return cred
+}
+
+func TestVerifyRegistration(t *testing.T) {
+	a := newAuthenticator(t)
+	cred := register(t, a)
+
+	if got := webauthn.Encoding.EncodeToString(cred.ID); got != a.CredentialID() {
+		t.Errorf("credential id = %q, want %q", got, a.CredentialID())
+	}
+	if !cred.UserVerified {
+		t.Error("UserVerified = false, want true")
+	}
+	if _, err := webauthn.ParsePublicKey(cred.PublicKey); err != nil {
+		t.Errorf("ParsePublicKey: %v", err)
+	}
+}
+
+func TestVerifyRegistrationRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(a *webauthntest.Authenticator, challenge string) (webauthn.AttestationResponse, string)
+	}{
+		{
+			name: "bad origin",
+			modify: func(a *webauthntest.Authenticator, challenge string) (webauthn.AttestationResponse, string) {
+				a.Origin = "https://evil.example"
+				return a.Create(challenge), challenge
+			},
+		},
+		{
+			name: "bad rp id",
+			modify: func(a *webauthntest.Authenticator, challenge string) (webauthn.AttestationResponse, string) {
+				a.RPID = "evil.example"
+				return a.Create(challenge), challenge
+			},
+		},
+		{
+			name: "bad challenge",
+			modify: func(a *webauthntest.Authenticator, challenge string) (webauthn.AttestationResponse, string) {
+				return a.Create("AAAA"), challenge
+			},
+		},
+		{
+			name: "rawId mismatch",
+			modify: func(a *webauthntest.Authenticator, challenge string) (webauthn.AttestationResponse, string) {
+				resp := a.Create(challenge)
+				resp.RawID = "AAAA"
+				return resp, challenge
+			},
+		},
+		{
+			name: "malformed cbor",
+			modify: func(a *webauthntest.Authenticator, challenge string) (webauthn.AttestationResponse, string) {
+				resp := a.Create(challenge)
+				// a map of three pairs with no pairs
+				resp.Response.AttestationObject = webauthn.Encoding.EncodeToString([]byte{0xa3})
+				return resp, challenge
+			},
+		},
+		{
+			name: "truncated attestation object",
+			modify: func(a *webauthntest.Authenticator, challenge string) (webauthn.AttestationResponse, string) {
+				resp := a.Create(challenge)
+				raw, _ := webauthn.Encoding.DecodeString(resp.Response.AttestationObject)
+				resp.Response.AttestationObject = webauthn.Encoding.EncodeToString(raw[:len(raw)-10])
+				return resp, challenge
+			},
+		},
+		{
+			name: "indefinite length cbor",
+			modify: func(a *webauthntest.Authenticator, challenge string) (webauthn.AttestationResponse, string) {
+				resp := a.Create(challenge)
+				resp.Response.AttestationObject = webauthn.Encoding.EncodeToString([]byte{0xbf, 0xff})
+				return resp, challenge
+			},
+		},
+		{
+			name: "deeply nested cbor",
+			modify: func(a *webauthntest.Authenticator, challenge string) (webauthn.AttestationResponse, string) {
+				resp := a.Create(challenge)
+				nested := make([]byte, 64)
+				for i := range nested {
+					nested[i] = 0x81 // array of one item
+				}
+				resp.Response.AttestationObject = webauthn.Encoding.EncodeToString(append(nested, 0x00))
+				return resp, challenge
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, challenge := tt.modify(newAuthenticator(t), newChallenge(t))
+			if _, err := testConfig.VerifyRegistration(resp, challenge); err == nil {
+				t.Fatal("VerifyRegistration succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestVerifyAssertion(t *testing.T) {
+	a := newAuthenticator(t)
+	cred := register(t, a)
+
+	signCount := cred.SignCount
+	for i := 0; i < 2; i++ {
+		challenge := newChallenge(t)
+		assertion, err := testConfig.VerifyAssertion(a.Get(challenge, nil), challenge, cred.PublicKey, signCount)
+		if err != nil {
+			t.Fatalf("VerifyAssertion: %v", err)
+		}
+		if assertion.SignCount != a.SignCount {
+			t.Errorf("SignCount = %d, want %d", assertion.SignCount, a.SignCount)
+		}
+		signCount = assertion.SignCount
+	}
+}
+
+func TestVerifyAssertionRejected(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(a *webauthntest.Authenticator, challenge string) (webauthn.AssertionResponse, string)
+		wantErr error
+	}{
+		{
+			name: "bad origin",
+			modify: func(a *webauthntest.Authenticator, challenge string) (webauthn.AssertionResponse, string) {
+				a.Origin = "https://evil.example"
+				return a.Get(challenge, nil), challenge
+			},
+		},
+		{
+			name: "bad challenge",
+			modify: func(a *webauthntest.Authenticator, challenge string) (webauthn.AssertionResponse, string) {
+				return a.Get("AAAA", nil), challenge
+			},
+		},
+		{
+			name: "counter regression",
+			modify: func(a *webauthntest.Authenticator, challenge string) (webauthn.AssertionResponse, string) {
+				a.SignCount = 3 // signs with 4, stored count is 10
+				return a.Get(challenge, nil), challenge
+			},
+			wantErr: webauthn.ErrSignCountInvalid,
+		},
+		{
+			name: "bad signature",
+			modify: func(a *webauthntest.Authenticator, challenge string) (webauthn.AssertionResponse, string) {
+				resp := a.Get(challenge, nil)
+				other := a.Get(challenge, nil)
+				resp.Response.Signature = other.Response.Signature
+				return resp, challenge
+			},
+			wantErr: webauthn.ErrSignatureInvalid,
+		},
+		{
+			name: "registration client data",
+			modify: func(a *webauthntest.Authenticator, challenge string) (webauthn.AssertionResponse, string) {
+				resp := a.Get(challenge, nil)
+				resp.Response.ClientDataJSON = a.Create(challenge).Response.ClientDataJSON
+				return resp, challenge
+			},
+		},
+		{
+			name: "truncated authenticator data",
+			modify: func(a *webauthntest.Authenticator, challenge string) (webauthn.AssertionResponse, string) {
+				resp := a.Get(challenge, nil)
+				resp.Response.AuthenticatorData = webauthn.Encoding.EncodeToString(make([]byte, 36))
+				return resp, challenge
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newAuthenticator(t)
+			cred := register(t, a)
+			a.SignCount = 10
+
+			resp, challenge := tt.modify(a, newChallenge(t))
+			_, err := testConfig.VerifyAssertion(resp, challenge, cred.PublicKey, 10)
+			if err == nil {
+				t.Fatal("VerifyAssertion succeeded, want error")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("VerifyAssertion error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParsePublicKeyMalformed(t *testing.T) {
+	tests := map[string][]byte{
+		"empty":                {},
+		"not a map":            {0x01},
+		"truncated":            {0xa5, 0x01, 0x02},
+		"trailing bytes":       {0xa0, 0x00},
+		"byte string too long": {0xa1, 0x01, 0x5a, 0xff, 0xff, 0xff, 0xff},
+		"unsupported key type": {0xa2, 0x01, 0x02, 0x03, 0x26},
+	}
+	for name, b := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := webauthn.ParsePublicKey(b); err == nil {
+				t.Fatal("ParsePublicKey succeeded, want error")
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+// Package webauthntest provides a software authenticator for testing
+// WebAuthn relying parties.
+package webauthntest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+
+	"github.com/andyfusniak/monolith/internal/webauthn"
+	"github.com/pkg/errors"
+)
+
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttestedData = 0x40
+)
+
+// Authenticator is a software ES256 authenticator holding one credential.
+// Its fields may be changed between ceremonies to produce responses a
+// relying party should reject.
+type Authenticator struct {
+	// RPID is hashed into the authenticator data.
+	RPID string
+
+	// Origin is written to the client data.
+	Origin string
+
+	// SignCount is incremented before each assertion is signed.
+	SignCount uint32
+
+	// UserVerified sets the UV flag in the authenticator data.
+	UserVerified bool
+
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+}
+
+// NewAuthenticator returns an authenticator with a new P-256 key and a
+// random credential ID.
+func NewAuthenticator(rpID, origin string) (*Authenticator, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "webauthntest: generate key")
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, errors.Wrap(err, "webauthntest: generate credential id")
+	}
+	return &Authenticator{
+		RPID:         rpID,
+		Origin:       origin,
+		UserVerified: true,
+		key:          key,
+		credentialID: id,
+	}, nil
+}
+
+// CredentialID returns the base64url encoded credential ID.
+func (a *Authenticator) CredentialID() string {
+	return webauthn.Encoding.EncodeToString(a.credentialID)
+}
+
+// Create returns the response to navigator.credentials.create() for
+// challenge with "none" attestation.
+func (a *Authenticator) Create(challenge string) webauthn.AttestationResponse {
+	// attested credential data: zero AAGUID, credential ID and COSE key
+	attested := make([]byte, 16, 16+2+len(a.credentialID))
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credentialID)))
+	attested = append(attested, a.credentialID...)
+	attested = append(attested, a.coseKey()...)
+
+	authData := a.authData(flagAttestedData, attested)
+
+	var att []byte
+	att = appendHead(att, 5, 3)
+	att = appendText(att, "fmt")
+	att = appendText(att, "none")
+	att = appendText(att, "attStmt")
+	att = appendHead(att, 5, 0)
+	att = appendText(att, "authData")
+	att = appendBytes(att, authData)
+
+	var resp webauthn.AttestationResponse
+	resp.ID = a.CredentialID()
+	resp.RawID = a.CredentialID()
+	resp.Type = "public-key"
+	resp.Response.ClientDataJSON = a.clientData("webauthn.create", challenge)
+	resp.Response.AttestationObject = webauthn.Encoding.EncodeToString(att)
+	resp.Response.Transports = []string{"internal"}
+	return resp
+}
+
+// Get returns the response to navigator.credentials.get() for challenge,
+// incrementing SignCount first. userHandle is the raw user ID, or nil to
+// omit it.
+func (a *Authenticator) Get(challenge string, userHandle []byte) webauthn.AssertionResponse {
+	a.SignCount++
+	authData := a.authData(0, nil)
+	clientData := a.clientData("webauthn.get", challenge)
+
+	raw, _ := webauthn.Encoding.DecodeString(clientData)
+	clientDataHash := sha256.Sum256(raw)
+	signed := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, a.key, signed[:])
+	if err != nil {
+		panic(err)
+	}
+
+	var resp webauthn.AssertionResponse
+	resp.ID = a.CredentialID()
+	resp.RawID = a.CredentialID()
+	resp.Type = "public-key"
+	resp.Response.ClientDataJSON = clientData
+	resp.Response.AuthenticatorData = webauthn.Encoding.EncodeToString(authData)
+	resp.Response.Signature = webauthn.Encoding.EncodeToString(sig)
+	if userHandle != nil {
+		resp.Response.UserHandle = webauthn.Encoding.EncodeToString(userHandle)
+	}
+	return resp
+}
+
+func (a *Authenticator) authData(flags byte, attested []byte) []byte {
+	flags |= flagUserPresent
+	if a.UserVerified {
+		flags |= flagUserVerified
+	}
+	rpIDHash := sha256.Sum256([]byte(a.RPID))
+
+	b := append([]byte{}, rpIDHash[:]...)
+	b = append(b, flags)
+	b = binary.BigEndian.AppendUint32(b, a.SignCount)
+	return append(b, attested...)
+}
+
+func (a *Authenticator) clientData(typ, challenge string) string {
+	b, err := json.Marshal(webauthn.ClientData{
+		Type:      typ,
+		Challenge: challenge,
+		Origin:    a.Origin,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return webauthn.Encoding.EncodeToString(b)
+}
+
+// coseKey returns the credential public key as an EC2 COSE_Key.
+func (a *Authenticator) coseKey() []byte {
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	a.key.X.FillBytes(x)
+	a.key.Y.FillBytes(y)
+
+	var b []byte
+	b = appendHead(b, 5, 5)
+	b = appendInt(b, 1) // kty
+	b = appendInt(b, 2) // EC2
+	b = appendInt(b, 3) // alg
+	b = appendInt(b, webauthn.AlgES256)
+	b = appendInt(b, -1) // crv
+	b = appendInt(b, 1)  // P-256
+	b = appendInt(b, -2) // x
+	b = appendBytes(b, x)
+	b = appendInt(b, -3) // y
+	b = appendBytes(b, y)
+	return b
+}
+
+// appendHead appends a CBOR data item head of the given major type and
+// argument.
+func appendHead(b []byte, major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return append(b, major<<5|byte(n))
+	case n <= 0xff:
+		return append(b, major<<5|24, byte(n))
+	case n <= 0xffff:
+		return binary.BigEndian.AppendUint16(append(b, major<<5|25), uint16(n))
+	case n <= 0xffffffff:
+		return binary.BigEndian.AppendUint32(append(b, major<<5|26), uint32(n))
+	}
+	return binary.BigEndian.AppendUint64(append(b, major<<5|27), n)
+}
+
+func appendInt(b []byte, n int64) []byte {
+	if n < 0 {
+		return appendHead(b, 1, uint64(-1-n))
+	}
+	return appendHead(b, 0, uint64(n))
+}
+
+func appendBytes(b, v []byte) []byte {
+	return append(appendHead(b, 2, uint64(len(v))), v...)
+}
+
+func appendText(b []byte, s string) []byte {
+	return append(appendHead(b, 3, uint64(len(s))), s...)
+}
@@ -0,0 +1,224 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/andyfusniak/base58"
+	"github.com/andyfusniak/monolith/internal/store"
+	"github.com/andyfusniak/monolith/internal/tracing"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// APIKeyPrefix starts every API key so keys are easy to recognise in
+	// Authorization headers and by secret scanners.
+	APIKeyPrefix = "mono_"
+
+	// apiKeyDisplayLen is the number of characters of the key, including
+	// APIKeyPrefix, kept in the clear to identify it
+	apiKeyDisplayLen = len(APIKeyPrefix) + 6
+
+	// last_used_at is updated at most once per apiKeyTouchInterval to
+	// avoid a write on every request
+	apiKeyTouchInterval = time.Minute
+
+	maxAPIKeysPerUser = 25
+	maxAPIKeyNameLen  = 100
+)
+
+// API key scopes. Requests using an API key with only APIScopeRead are
+// limited to safe methods (GET and HEAD).
+const (
+	APIScopeRead  = "read"
+	APIScopeWrite = "write"
+)
+
+var (
+	ErrAPIKeyNotFound      = errors.New("api key not found")
+	ErrAPIKeyInvalid       = errors.New("api key invalid or expired")
+	ErrAPIKeyNameInvalid   = errors.New("api key name invalid")
+	ErrAPIKeyScopeInvalid  = errors.New("api key scope invalid")
+	ErrAPIKeyExpiryInvalid = errors.New("api key expiry must be in the future")
+	ErrAPIKeyLimitReached  = errors.New("api key limit reached")
+)
+
+// APIKey is a personal API key. The Key is only set when the API key is
+// created; the store holds a hash of it.
+type APIKey struct {
+	ID         string   `json:"api_key_id"`
+	Key        string   `json:"key,omitempty"`
+	UserID     string   `json:"user_id"`
+	Name       string   `json:"name"`
+	Prefix     string   `json:"prefix"`
+	Scopes     []string `json:"scopes"`
+	ExpiresAt  *ISOTime `json:"expires_at"`
+	LastUsedAt *ISOTime `json:"last_used_at"`
+	CreatedAt  ISOTime  `json:"created_at"`
+}
+
+// CreateAPIKey mints a new API key for a user. scopes must contain at
+// least one of APIScopeRead and APIScopeWrite; APIScopeWrite implies
+// APIScopeRead. A nil expiresAt creates a key that does not expire.
+func (s *Service) CreateAPIKey(ctx context.Context, userID, name string, scopes []string, expiresAt *time.Time) (APIKey, error) {
+	ctx, span := tracing.Start(ctx, "service.CreateAPIKey")
+	defer span.End()
+
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxAPIKeyNameLen {
+		return APIKey{}, ErrAPIKeyNameInvalid
+	}
+	if len(scopes) == 0 {
+		return APIKey{}, ErrAPIKeyScopeInvalid
+	}
+	var write bool
+	for _, sc := range scopes {
+		switch sc {
+		case APIScopeRead:
+		case APIScopeWrite:
+			write = true
+		default:
+			return APIKey{}, errors.Wrapf(ErrAPIKeyScopeInvalid, "%q", sc)
+		}
+	}
+	scopes = []string{APIScopeRead}
+	if write {
+		scopes = append(scopes, APIScopeWrite)
+	}
+
+	var exp *store.Datetime
+	if expiresAt != nil {
+		if !expiresAt.After(time.Now()) {
+			return APIKey{}, ErrAPIKeyExpiryInvalid
+		}
+		t := store.Datetime(expiresAt.UTC())
+		exp = &t
+	}
+
+	existing, err := s.repo.ListAPIKeys(ctx, userID)
+	if err != nil {
+		return APIKey{}, errors.Wrapf(err, "[service] s.repo.ListAPIKeys(ctx, userID=%q) failed", userID)
+	}
+	if len(existing) >= maxAPIKeysPerUser {
+		return APIKey{}, ErrAPIKeyLimitReached
+	}
+
+	token, hash, err := newToken()
+	if err != nil {
+		return APIKey{}, err
+	}
+	key := APIKeyPrefix + token
+	apiKeyID, err := base58.RandString(22)
+	if err != nil {
+		return APIKey{}, errors.Wrap(err, "[service] failed to generate random base58 string")
+	}
+
+	row, err := s.repo.InsertAPIKey(ctx, store.AddAPIKey{
+		APIKeyID:  apiKeyID,
+		UserID:    userID,
+		Name:      name,
+		Prefix:    key[:apiKeyDisplayLen],
+		TokenHash: hash,
+		Scopes:    strings.Join(scopes, " "),
+		ExpiresAt: exp,
+	})
+	if err != nil {
+		return APIKey{}, errors.Wrap(err, "[service] s.repo.InsertAPIKey failed")
+	}
+
+	k := apiKeyFromRow(row)
+	k.Key = key
+	return k, nil
+}
+
+// ListAPIKeys returns a user's API keys, newest first.
+func (s *Service) ListAPIKeys(ctx context.Context, userID string) ([]APIKey, error) {
+	ctx, span := tracing.Start(ctx, "service.ListAPIKeys")
+	defer span.End()
+
+	rows, err := s.repo.ListAPIKeys(ctx, userID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] s.repo.ListAPIKeys(ctx, userID=%q) failed", userID)
+	}
+
+	keys := make([]APIKey, 0, len(rows))
+	for _, row := range rows {
+		keys = append(keys, apiKeyFromRow(row))
+	}
+	return keys, nil
+}
+
+// DeleteAPIKey revokes one of a user's API keys. ErrAPIKeyNotFound is
+// returned if the user has no API key with the given apiKeyID.
+func (s *Service) DeleteAPIKey(ctx context.Context, userID, apiKeyID string) error {
+	ctx, span := tracing.Start(ctx, "service.DeleteAPIKey")
+	defer span.End()
+
+	if err := s.repo.DeleteAPIKey(ctx, userID, apiKeyID); err != nil {
+		if errors.Is(err, store.ErrAPIKeyNotFound) {
+			return ErrAPIKeyNotFound
+		}
+		return errors.Wrapf(err, "[service] s.repo.DeleteAPIKey(ctx, userID=%q, apiKeyID=%q) failed",
+			userID, apiKeyID)
+	}
+	return nil
+}
+
+// AuthenticateAPIKey returns the API key for the given key. If the key is
+// unknown or has expired ErrAPIKeyInvalid is returned.
+func (s *Service) AuthenticateAPIKey(ctx context.Context, key string) (APIKey, error) {
+	ctx, span := tracing.Start(ctx, "service.AuthenticateAPIKey")
+	defer span.End()
+
+	if !strings.HasPrefix(key, APIKeyPrefix) {
+		return APIKey{}, ErrAPIKeyInvalid
+	}
+
+	row, err := s.repo.GetAPIKeyByTokenHash(ctx, hashToken(strings.TrimPrefix(key, APIKeyPrefix)))
+	if err != nil {
+		if errors.Is(err, store.ErrAPIKeyNotFound) {
+			return APIKey{}, ErrAPIKeyInvalid
+		}
+		return APIKey{}, errors.Wrap(err, "[service] s.repo.GetAPIKeyByTokenHash failed")
+	}
+
+	now := time.Now().UTC()
+	if row.ExpiresAt != nil && now.After(time.Time(*row.ExpiresAt)) {
+		return APIKey{}, ErrAPIKeyInvalid
+	}
+
+	if row.LastUsedAt == nil || now.Sub(time.Time(*row.LastUsedAt)) >= apiKeyTouchInterval {
+		// a failure to record usage must not fail the request
+		if err := s.repo.TouchAPIKey(ctx, row.APIKeyID, store.Datetime(now)); err != nil {
+			log.WithContext(ctx).Warnf("[service] s.repo.TouchAPIKey(ctx, apiKeyID=%q) failed: %+v",
+				row.APIKeyID, err)
+		} else {
+			t := store.Datetime(now)
+			row.LastUsedAt = &t
+		}
+	}
+
+	return apiKeyFromRow(row), nil
+}
+
+func apiKeyFromRow(row store.APIKey) APIKey {
+	k := APIKey{
+		ID:        row.APIKeyID,
+		UserID:    row.UserID,
+		Name:      row.Name,
+		Prefix:    row.Prefix,
+		Scopes:    strings.Fields(row.Scopes),
+		CreatedAt: ISOTime(row.CreatedAt),
+	}
+	if row.ExpiresAt != nil {
+		t := ISOTime(*row.ExpiresAt)
+		k.ExpiresAt = &t
+	}
+	if row.LastUsedAt != nil {
+		t := ISOTime(*row.LastUsedAt)
+		k.LastUsedAt = &t
+	}
+	return k
+}
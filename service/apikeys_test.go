@@ -0,0 +1,232 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andyfusniak/monolith/internal/store"
+)
+
+func TestCreateAPIKey(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+	user := newTestUser(t, s, "alice@example.com")
+
+	key, err := s.CreateAPIKey(ctx, user.ID, "  ci  ", []string{APIScopeWrite}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(key.Key, APIKeyPrefix) {
+		t.Errorf("Key = %q, want prefix %q", key.Key, APIKeyPrefix)
+	}
+	if key.Prefix != key.Key[:apiKeyDisplayLen] || !strings.HasPrefix(key.Prefix, APIKeyPrefix) {
+		t.Errorf("Prefix = %q, want the first %d characters of the key", key.Prefix, apiKeyDisplayLen)
+	}
+	if key.Name != "ci" {
+		t.Errorf("Name = %q, want ci", key.Name)
+	}
+	// write implies read
+	if !slices.Equal(key.Scopes, []string{APIScopeRead, APIScopeWrite}) {
+		t.Errorf("Scopes = %v, want [read write]", key.Scopes)
+	}
+	if key.ExpiresAt != nil || key.LastUsedAt != nil {
+		t.Errorf("ExpiresAt = %v LastUsedAt = %v, want neither", key.ExpiresAt, key.LastUsedAt)
+	}
+
+	// the key itself is only returned once
+	keys, err := s.ListAPIKeys(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0].Key != "" || keys[0].Prefix != key.Prefix {
+		t.Errorf("ListAPIKeys = %+v, want one key without the secret", keys)
+	}
+
+	past := time.Now().Add(-time.Minute)
+	tests := []struct {
+		name      string
+		keyName   string
+		scopes    []string
+		expiresAt *time.Time
+		wantErr   error
+	}{
+		{name: "empty name", keyName: " ", scopes: []string{APIScopeRead}, wantErr: ErrAPIKeyNameInvalid},
+		{name: "long name", keyName: strings.Repeat("n", maxAPIKeyNameLen+1), scopes: []string{APIScopeRead}, wantErr: ErrAPIKeyNameInvalid},
+		{name: "no scopes", keyName: "ci", wantErr: ErrAPIKeyScopeInvalid},
+		{name: "unknown scope", keyName: "ci", scopes: []string{APIScopeRead, "admin"}, wantErr: ErrAPIKeyScopeInvalid},
+		{name: "expired", keyName: "ci", scopes: []string{APIScopeRead}, expiresAt: &past, wantErr: ErrAPIKeyExpiryInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := s.CreateAPIKey(ctx, user.ID, tt.keyName, tt.scopes, tt.expiresAt); !errors.Is(err, tt.wantErr) {
+				t.Errorf("CreateAPIKey error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCreateAPIKeyLimit(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+	user := newTestUser(t, s, "alice@example.com")
+
+	for i := 0; i < maxAPIKeysPerUser; i++ {
+		if _, err := s.CreateAPIKey(ctx, user.ID, "ci", []string{APIScopeRead}, nil); err != nil {
+			t.Fatalf("key %d: %v", i, err)
+		}
+	}
+	if _, err := s.CreateAPIKey(ctx, user.ID, "ci", []string{APIScopeRead}, nil); !errors.Is(err, ErrAPIKeyLimitReached) {
+		t.Fatalf("CreateAPIKey over the limit error = %v, want %v", err, ErrAPIKeyLimitReached)
+	}
+}
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+	user := newTestUser(t, s, "alice@example.com")
+
+	expiresAt := time.Now().Add(time.Hour)
+	key, err := s.CreateAPIKey(ctx, user.ID, "ci", []string{APIScopeRead}, &expiresAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.AuthenticateAPIKey(ctx, key.Key)
+	if err != nil {
+		t.Fatalf("AuthenticateAPIKey: %v", err)
+	}
+	if got.ID != key.ID || got.UserID != user.ID || !slices.Equal(got.Scopes, []string{APIScopeRead}) {
+		t.Errorf("AuthenticateAPIKey = %+v, want key %s of user %s with the read scope", got, key.ID, user.ID)
+	}
+
+	secret := strings.TrimPrefix(key.Key, APIKeyPrefix)
+	for _, k := range []string{
+		secret,            // without the prefix
+		"other_" + secret, // another prefix
+		strings.ToUpper(APIKeyPrefix) + secret,
+		key.Key + "x",
+		APIKeyPrefix,
+	} {
+		if _, err := s.AuthenticateAPIKey(ctx, k); !errors.Is(err, ErrAPIKeyInvalid) {
+			t.Errorf("AuthenticateAPIKey(%q) error = %v, want %v", k, err, ErrAPIKeyInvalid)
+		}
+	}
+
+	if err := s.DeleteAPIKey(ctx, user.ID, key.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AuthenticateAPIKey(ctx, key.Key); !errors.Is(err, ErrAPIKeyInvalid) {
+		t.Errorf("AuthenticateAPIKey after delete error = %v, want %v", err, ErrAPIKeyInvalid)
+	}
+	if err := s.DeleteAPIKey(ctx, user.ID, key.ID); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("DeleteAPIKey twice error = %v, want %v", err, ErrAPIKeyNotFound)
+	}
+}
+
+func TestAuthenticateAPIKeyExpired(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+	user := newTestUser(t, s, "alice@example.com")
+
+	token, hash, err := newToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired := store.Datetime(time.Now().UTC().Add(-time.Second))
+	if _, err := s.repo.InsertAPIKey(ctx, store.AddAPIKey{
+		APIKeyID:  "expiredkey",
+		UserID:    user.ID,
+		Name:      "ci",
+		Prefix:    (APIKeyPrefix + token)[:apiKeyDisplayLen],
+		TokenHash: hash,
+		Scopes:    APIScopeRead,
+		ExpiresAt: &expired,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.AuthenticateAPIKey(ctx, APIKeyPrefix+token); !errors.Is(err, ErrAPIKeyInvalid) {
+		t.Fatalf("AuthenticateAPIKey expired error = %v, want %v", err, ErrAPIKeyInvalid)
+	}
+}
+
+func TestAuthenticateAPIKeyLastUsed(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+	user := newTestUser(t, s, "alice@example.com")
+
+	key, err := s.CreateAPIKey(ctx, user.ID, "ci", []string{APIScopeRead}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := s.AuthenticateAPIKey(ctx, key.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.LastUsedAt == nil {
+		t.Fatal("LastUsedAt not set by the first use")
+	}
+
+	// used again within apiKeyTouchInterval: not written again
+	second, err := s.AuthenticateAPIKey(ctx, key.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.LastUsedAt == nil || !sameMicrosecond(*second.LastUsedAt, *first.LastUsedAt) {
+		t.Errorf("LastUsedAt = %v, want unchanged %v", second.LastUsedAt, first.LastUsedAt)
+	}
+
+	// used again after apiKeyTouchInterval: written
+	stale := time.Now().UTC().Add(-apiKeyTouchInterval - time.Second)
+	if err := s.repo.TouchAPIKey(ctx, key.ID, store.Datetime(stale)); err != nil {
+		t.Fatal(err)
+	}
+	third, err := s.AuthenticateAPIKey(ctx, key.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third.LastUsedAt == nil || !time.Time(*third.LastUsedAt).After(stale.Add(apiKeyTouchInterval)) {
+		t.Errorf("LastUsedAt = %v, want updated from %v", third.LastUsedAt, stale)
+	}
+
+	keys, err := s.ListAPIKeys(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0].LastUsedAt == nil || !sameMicrosecond(*keys[0].LastUsedAt, *third.LastUsedAt) {
+		t.Errorf("stored LastUsedAt = %v, want %v", keys[0].LastUsedAt, third.LastUsedAt)
+	}
+}
+
+// sameMicrosecond returns true if a and b are equal to the microsecond
+// precision of stored times.
+func sameMicrosecond(a, b ISOTime) bool {
+	return time.Time(a).Truncate(time.Microsecond).Equal(time.Time(b).Truncate(time.Microsecond))
+}
+
+func TestPrincipalHasScope(t *testing.T) {
+	tests := []struct {
+		name  string
+		p     Principal
+		scope string
+		want  bool
+	}{
+		{name: "session read", p: Principal{UserID: "u"}, scope: APIScopeRead, want: true},
+		{name: "session write", p: Principal{UserID: "u"}, scope: APIScopeWrite, want: true},
+		{name: "read key read", p: Principal{UserID: "u", APIKeyID: "k", Scopes: []string{APIScopeRead}}, scope: APIScopeRead, want: true},
+		{name: "read key write", p: Principal{UserID: "u", APIKeyID: "k", Scopes: []string{APIScopeRead}}, scope: APIScopeWrite, want: false},
+		{name: "write key write", p: Principal{UserID: "u", APIKeyID: "k", Scopes: []string{APIScopeRead, APIScopeWrite}}, scope: APIScopeWrite, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.p.HasScope(tt.scope); got != tt.want {
+				t.Errorf("HasScope(%s) = %t, want %t", tt.scope, got, tt.want)
+			}
+		})
+	}
+}
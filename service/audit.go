@@ -0,0 +1,251 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/andyfusniak/monolith/internal/store"
+	"github.com/andyfusniak/monolith/internal/tracing"
+	"github.com/pkg/errors"
+)
+
+// Audit event actions.
+const (
+	AuditUserCreate         = "user.create"
+	AuditPasswordChange     = "user.password-change"
+	AuditSignInSuccess      = "signin.success"
+	AuditSignInFailure      = "signin.failure"
+	AuditRoleGrant          = "role.grant"
+	AuditRoleRevoke         = "role.revoke"
+	AuditImpersonationStart = "impersonation.start"
+	AuditImpersonationStop  = "impersonation.stop"
+	AuditUserExport         = "user.export"
+	AuditUserErase          = "user.erase"
+	AuditOrgRoleChange      = "org.role-change"
+	AuditOrgMemberRemove    = "org.member-remove"
+)
+
+const (
+	defaultAuditListLimit = 100
+	maxAuditListLimit     = 500
+	auditVerifyBatch      = 1000
+)
+
+var (
+	ErrAuditFilterInvalid = errors.New("audit filter invalid")
+)
+
+// AuditEvent is an entry in the audit log. ActorID is the user who
+// performed the action, if any, and SubjectID the user it was performed
+// on.
+type AuditEvent struct {
+	ID        int64           `json:"event_id"`
+	Action    string          `json:"action"`
+	ActorID   *string         `json:"actor_id"`
+	SubjectID *string         `json:"subject_id"`
+	IP        string          `json:"ip"`
+	Details   json.RawMessage `json:"details"`
+	CreatedAt ISOTime         `json:"created_at"`
+	Hash      string          `json:"hash"`
+}
+
+// AuditFilter selects audit events. Zero values match all events.
+type AuditFilter struct {
+	Action    string
+	ActorID   string
+	SubjectID string
+	Since     *time.Time
+	Until     *time.Time
+	BeforeID  int64
+	Limit     int
+}
+
+// AuditVerification is the result of a successful VerifyAuditLog.
+type AuditVerification struct {
+	Events   int
+	Unsealed int
+	Head     string
+}
+
+// AuditChainError reports the first event at which the audit log hash
+// chain is broken.
+type AuditChainError struct {
+	EventID int64
+	Reason  string
+}
+
+func (e *AuditChainError) Error() string {
+	return fmt.Sprintf("audit chain broken at event_id=%d: %s", e.EventID, e.Reason)
+}
+
+// WithAuditHashKey configures the key used to hash email addresses
+// recorded in the audit log. Without a key they are not recorded.
+func WithAuditHashKey(key []byte) Option {
+	return func(s *Service) {
+		s.auditHashKey = key
+	}
+}
+
+// auditEmailHash returns the hex encoded HMAC-SHA256 of the normalized
+// email, so events for the same email can be correlated without storing
+// it, or "" if there is no audit hash key.
+func (s *Service) auditEmailHash(email string) string {
+	if len(s.auditHashKey) == 0 {
+		return ""
+	}
+	mac := hmac.New(sha256.New, s.auditHashKey)
+	mac.Write([]byte(normalizeEmail(email)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// recordAudit appends an event to the audit log. The actor is the real
+// user behind the principal in ctx, if any, and the IP the client IP in
+// ctx; subjectID is the user acted upon, or "" if none.
+func (s *Service) recordAudit(ctx context.Context, action, subjectID string, details map[string]any) error {
+	params, err := s.newAuditEvent(ctx, action, subjectID, details)
+	if err != nil {
+		return err
+	}
+	if _, err := s.repo.InsertAuditEvent(ctx, params); err != nil {
+		return errors.Wrapf(err, "[service] s.repo.InsertAuditEvent(ctx, action=%s) failed", action)
+	}
+	return nil
+}
+
+// newAuditEvent returns the params recordAudit appends, for store
+// operations that append an event in their own transaction.
+func (s *Service) newAuditEvent(ctx context.Context, action, subjectID string, details map[string]any) (store.AddAuditEvent, error) {
+	if details == nil {
+		details = map[string]any{}
+	}
+	b, err := json.Marshal(details)
+	if err != nil {
+		return store.AddAuditEvent{}, errors.Wrapf(err, "[service] json.Marshal audit details for action=%s failed", action)
+	}
+
+	params := store.AddAuditEvent{
+		Action:  action,
+		IP:      ClientIPFromContext(ctx),
+		Details: string(b),
+	}
+	if p, ok := PrincipalFromContext(ctx); ok && p.UserID != "" {
+		actorID := p.ActorID()
+		params.ActorID = &actorID
+	}
+	if subjectID != "" {
+		params.SubjectID = &subjectID
+	}
+	return params, nil
+}
+
+// ListAuditEvents returns audit events matching filter, newest first.
+// Admins only.
+func (s *Service) ListAuditEvents(ctx context.Context, filter AuditFilter) ([]AuditEvent, error) {
+	ctx, span := tracing.Start(ctx, "service.ListAuditEvents")
+	defer span.End()
+
+	if _, err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	if filter.Limit == 0 {
+		filter.Limit = defaultAuditListLimit
+	}
+	if filter.Limit < 0 || filter.Limit > maxAuditListLimit || filter.BeforeID < 0 {
+		return nil, ErrAuditFilterInvalid
+	}
+
+	params := store.AuditEventFilter{
+		Action:    filter.Action,
+		ActorID:   filter.ActorID,
+		SubjectID: filter.SubjectID,
+		BeforeID:  filter.BeforeID,
+		Limit:     filter.Limit,
+	}
+	if filter.Since != nil {
+		t := store.Datetime(filter.Since.UTC())
+		params.Since = &t
+	}
+	if filter.Until != nil {
+		t := store.Datetime(filter.Until.UTC())
+		params.Until = &t
+	}
+
+	rows, err := s.repo.ListAuditEvents(ctx, params)
+	if err != nil {
+		return nil, errors.Wrap(err, "[service] s.repo.ListAuditEvents failed")
+	}
+
+	events := make([]AuditEvent, 0, len(rows))
+	for _, row := range rows {
+		events = append(events, auditEventFromRow(row))
+	}
+	return events, nil
+}
+
+// VerifyAuditLog walks the audit log in order checking each event links
+// to the hash of the one before it and that its own hash matches its
+// contents. An *AuditChainError is returned for the first broken link.
+// Events recorded before chaining that have not yet been sealed are
+// counted but cannot be verified.
+func (s *Service) VerifyAuditLog(ctx context.Context) (AuditVerification, error) {
+	ctx, span := tracing.Start(ctx, "service.VerifyAuditLog")
+	defer span.End()
+
+	var (
+		v      AuditVerification
+		lastID int64
+	)
+	for {
+		rows, err := s.repo.ListAuditChain(ctx, lastID, auditVerifyBatch)
+		if err != nil {
+			return AuditVerification{}, errors.Wrap(err, "[service] s.repo.ListAuditChain failed")
+		}
+
+		for _, row := range rows {
+			prevID := lastID
+			lastID = row.EventID
+			v.Events++
+
+			if row.Hash == "" {
+				if v.Head != "" {
+					return v, &AuditChainError{EventID: row.EventID, Reason: "event not sealed"}
+				}
+				v.Unsealed++
+				continue
+			}
+			if row.EventID != prevID+1 {
+				return v, &AuditChainError{EventID: prevID + 1, Reason: "event missing"}
+			}
+			if row.PrevHash != v.Head {
+				return v, &AuditChainError{EventID: row.EventID, Reason: "previous hash does not match"}
+			}
+			if row.ChainHash() != row.Hash {
+				return v, &AuditChainError{EventID: row.EventID, Reason: "hash does not match contents"}
+			}
+			v.Head = row.Hash
+		}
+
+		if len(rows) < auditVerifyBatch {
+			return v, nil
+		}
+	}
+}
+
+func auditEventFromRow(row store.AuditEvent) AuditEvent {
+	return AuditEvent{
+		ID:        row.EventID,
+		Action:    row.Action,
+		ActorID:   row.ActorID,
+		SubjectID: row.SubjectID,
+		IP:        row.IP,
+		Details:   json.RawMessage(row.Details),
+		CreatedAt: ISOTime(row.CreatedAt),
+		Hash:      row.Hash,
+	}
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+type contextKey int
+
+const (
+	principalKey contextKey = iota
+	clientIPKey
+	requestIDKey
+)
+
+// ErrNoPrincipal is returned by service methods that act on behalf of the
+// caller when ctx carries no Principal.
+var ErrNoPrincipal = errors.New("no principal in context")
+
+// Principal identifies the authenticated user making a request.
+type Principal struct {
+	UserID    string
+	SessionID string
+
+	// APIKeyID and Scopes are set when the request is authenticated
+	// using an API key in place of a session.
+	APIKeyID string
+	Scopes   []string
+
+	// ImpersonatorID is set when an admin is acting as UserID using an
+	// impersonation session.
+	ImpersonatorID string
+}
+
+// ActorID returns the user actually making the request: the admin when
+// impersonating, otherwise UserID.
+func (p Principal) ActorID() string {
+	if p.ImpersonatorID != "" {
+		return p.ImpersonatorID
+	}
+	return p.UserID
+}
+
+// HasScope returns true if p may act with the given API key scope.
+// Session principals are not limited by scopes.
+func (p Principal) HasScope(scope string) bool {
+	if p.APIKeyID == "" {
+		return true
+	}
+	return containsString(p.Scopes, scope)
+}
+
+// ContextWithPrincipal returns a copy of ctx carrying p.
+func ContextWithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey, p)
+}
+
+// PrincipalFromContext returns the Principal carried by ctx, if any.
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey).(Principal)
+	return p, ok
+}
+
+// principalUserID returns the user ID of the principal in ctx.
+func principalUserID(ctx context.Context) (string, error) {
+	p, ok := PrincipalFromContext(ctx)
+	if !ok || p.UserID == "" {
+		return "", ErrNoPrincipal
+	}
+	return p.UserID, nil
+}
+
+// ContextWithClientIP returns a copy of ctx carrying the IP address of the
+// client making the request, as recorded in the audit log.
+func ContextWithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey, ip)
+}
+
+// ClientIPFromContext returns the client IP address carried by ctx, or ""
+// outside of a request.
+func ClientIPFromContext(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey).(string)
+	return ip
+}
+
+// ContextWithRequestID returns a copy of ctx carrying the ID of the
+// request being served.
+func ContextWithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey, requestID)
+}
+
+// RequestIDFromContext returns the request ID carried by ctx, or ""
+// outside of a request.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
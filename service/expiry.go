@@ -0,0 +1,48 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/andyfusniak/monolith/internal/store"
+	"github.com/andyfusniak/monolith/internal/tracing"
+	"github.com/pkg/errors"
+)
+
+// expiringTable is a table of short-lived rows deleted by DeleteExpired
+// once they expire.
+type expiringTable struct {
+	name          string
+	deleteExpired func(ctx context.Context, now store.Datetime) (int64, error)
+}
+
+func (s *Service) expiringTables() []expiringTable {
+	return []expiringTable{
+		{"WebAuthnChallenges", s.repo.DeleteExpiredWebAuthnChallenges},
+		{"MagicLinks", s.deleteExpiredMagicLinks},
+		{"OIDCAuthRequests", s.repo.DeleteExpiredOIDCAuthRequests},
+		{"IdempotencyKeys", s.repo.DeleteExpiredIdempotencyKeys},
+	}
+}
+
+// DeleteExpired deletes expired WebAuthn challenges, magic links, OIDC
+// authorization requests and idempotency keys, from every tenant's
+// database in tenant mode, returning the number of rows deleted.
+func (s *Service) DeleteExpired(ctx context.Context) (int64, error) {
+	ctx, span := tracing.Start(ctx, "service.DeleteExpired")
+	defer span.End()
+
+	now := store.Datetime(time.Now().UTC())
+	var total int64
+	err := s.forEachTenant(ctx, func(ctx context.Context) error {
+		for _, t := range s.expiringTables() {
+			n, err := t.deleteExpired(ctx, now)
+			if err != nil {
+				return errors.Wrapf(err, "[service] s.repo.DeleteExpired%s failed", t.name)
+			}
+			total += n
+		}
+		return nil
+	})
+	return total, err
+}
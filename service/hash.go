@@ -0,0 +1,131 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/alexedwards/argon2id"
+	"github.com/andyfusniak/monolith/internal/tracing"
+	"github.com/pkg/errors"
+)
+
+// DefaultHashParams returns a copy of the argon2id parameters used if the
+// service is not configured using WithHashParams.
+func DefaultHashParams() argon2id.Params {
+	return *argon2id.DefaultParams
+}
+
+// WithHashParams configures the argon2id parameters used to hash new
+// passwords. Existing hashes created with weaker parameters are upgraded
+// the next time the user signs in.
+func WithHashParams(p argon2id.Params) Option {
+	return func(s *Service) {
+		s.hashParams = p
+	}
+}
+
+// HashParams returns the argon2id parameters used by the service.
+func (s *Service) HashParams() argon2id.Params {
+	return s.hashParams
+}
+
+func (s *Service) createHash(password string) (string, error) {
+	defer s.observeHash("create", time.Now())
+	p := s.hashParams
+	hash, err := argon2id.CreateHash(password, &p)
+	if err != nil {
+		return "", errors.Wrap(err, "[service] failed to create argon2id hash")
+	}
+	return hash, nil
+}
+
+func (s *Service) comparePasswordAndHash(password, hash string) (bool, error) {
+	defer s.observeHash("compare", time.Now())
+	return argon2id.ComparePasswordAndHash(password, hash)
+}
+
+// observeHash records the time taken by an argon2id operation started at
+// start.
+func (s *Service) observeHash(op string, start time.Time) {
+	s.hashDuration.Observe(time.Since(start).Seconds(), op)
+}
+
+// dummyHash returns a hash created with the service parameters. It is
+// compared against when a user is not found so the response time is
+// similar to that of a wrong password.
+func (s *Service) dummyHash() string {
+	s.dummyHashOnce.Do(func() {
+		hash, err := s.createHash("dummy-password")
+		if err != nil {
+			// fall back to an invalid hash; comparisons fail fast
+			hash = "dummyhash"
+		}
+		s.dummyHashValue = hash
+	})
+	return s.dummyHashValue
+}
+
+// isWeakerParams returns true if any of the parameters in p are weaker
+// than those in target.
+func isWeakerParams(p, target argon2id.Params) bool {
+	return p.Memory < target.Memory ||
+		p.Iterations < target.Iterations ||
+		p.Parallelism < target.Parallelism ||
+		p.SaltLength < target.SaltLength ||
+		p.KeyLength < target.KeyLength
+}
+
+// NeedsRehash returns true if hash was created with parameters weaker than
+// those configured for the service or if it cannot be decoded.
+func (s *Service) NeedsRehash(hash string) bool {
+	p, _, _, err := argon2id.DecodeHash(hash)
+	if err != nil {
+		return true
+	}
+	return isWeakerParams(*p, s.hashParams)
+}
+
+// HashReport summarises the argon2id parameters of stored password hashes.
+type HashReport struct {
+	Total    int
+	Outdated int
+
+	// ByParams counts users grouped by a m=,t=,p= parameter string. Users
+	// without a password are counted as "none".
+	ByParams map[string]int
+}
+
+// PasswordHashReport counts the users whose password hashes were created
+// with parameters weaker than those configured for the service.
+func (s *Service) PasswordHashReport(ctx context.Context) (HashReport, error) {
+	ctx, span := tracing.Start(ctx, "service.PasswordHashReport")
+	defer span.End()
+
+	rows, err := s.repo.ListUsers(ctx)
+	if err != nil {
+		return HashReport{}, errors.Wrap(err, "[service] s.repo.ListUsers failed")
+	}
+
+	report := HashReport{ByParams: make(map[string]int)}
+	for _, row := range rows {
+		report.Total++
+
+		if row.PasswordHash == "" {
+			report.ByParams["none"]++
+			continue
+		}
+		p, _, _, err := argon2id.DecodeHash(row.PasswordHash)
+		if err != nil {
+			report.Outdated++
+			report.ByParams["invalid"]++
+			continue
+		}
+		if isWeakerParams(*p, s.hashParams) {
+			report.Outdated++
+		}
+		report.ByParams[fmt.Sprintf("m=%d,t=%d,p=%d", p.Memory, p.Iterations, p.Parallelism)]++
+	}
+
+	return report, nil
+}
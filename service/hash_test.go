@@ -0,0 +1,96 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alexedwards/argon2id"
+)
+
+func TestNeedsRehash(t *testing.T) {
+	target := argon2id.Params{Memory: 16 * 1024, Iterations: 2, Parallelism: 2, SaltLength: 16, KeyLength: 32}
+
+	tests := []struct {
+		name   string
+		params argon2id.Params
+		want   bool
+	}{
+		{name: "same", params: target, want: false},
+		{name: "stronger", params: argon2id.Params{Memory: 32 * 1024, Iterations: 3, Parallelism: 4, SaltLength: 16, KeyLength: 32}, want: false},
+		{name: "less memory", params: argon2id.Params{Memory: 8 * 1024, Iterations: 2, Parallelism: 2, SaltLength: 16, KeyLength: 32}, want: true},
+		{name: "fewer iterations", params: argon2id.Params{Memory: 16 * 1024, Iterations: 1, Parallelism: 2, SaltLength: 16, KeyLength: 32}, want: true},
+		{name: "less parallelism", params: argon2id.Params{Memory: 16 * 1024, Iterations: 2, Parallelism: 1, SaltLength: 16, KeyLength: 32}, want: true},
+		{name: "shorter key", params: argon2id.Params{Memory: 16 * 1024, Iterations: 2, Parallelism: 2, SaltLength: 16, KeyLength: 16}, want: true},
+	}
+
+	s := newTestService(t, WithHashParams(target))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hash, err := argon2id.CreateHash("correct horse battery staple", &tt.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.NeedsRehash(hash); got != tt.want {
+				t.Errorf("NeedsRehash(%s) = %t, want %t", hash, got, tt.want)
+			}
+		})
+	}
+
+	if !s.NeedsRehash("not-a-hash") {
+		t.Error("NeedsRehash of an invalid hash = false, want true")
+	}
+}
+
+func TestSignInUpgradesWeakerHash(t *testing.T) {
+	ctx := context.Background()
+	target := argon2id.Params{Memory: 16 * 1024, Iterations: 2, Parallelism: 2, SaltLength: 16, KeyLength: 32}
+	s := newTestService(t, WithHashParams(target))
+	user := newTestUser(t, s, "alice@example.com")
+
+	weak := argon2id.Params{Memory: 8 * 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	hash, err := argon2id.CreateHash("correct horse battery staple", &weak)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.repo.UpdateUserPasswordHash(ctx, user.ID, hash); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := s.PasswordHashReport(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Total != 1 || report.Outdated != 1 || report.ByParams["m=8192,t=1,p=1"] != 1 {
+		t.Errorf("PasswordHashReport before sign in = %+v, want 1 outdated m=8192,t=1,p=1", report)
+	}
+
+	if _, err := s.SignInWithPassword(ctx, "alice@example.com", "correct horse battery staple", "192.0.2.1", GrantSession); err != nil {
+		t.Fatalf("SignInWithPassword: %v", err)
+	}
+
+	row, err := s.repo.GetUserByEmail(ctx, "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row.PasswordHash == hash {
+		t.Fatal("password hash was not replaced")
+	}
+	p, _, _, err := argon2id.DecodeHash(row.PasswordHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *p != target {
+		t.Errorf("upgraded hash params = %+v, want %+v", *p, target)
+	}
+	if ok, err := argon2id.ComparePasswordAndHash("correct horse battery staple", row.PasswordHash); err != nil || !ok {
+		t.Errorf("upgraded hash does not match the password: %t %v", ok, err)
+	}
+
+	report, err = s.PasswordHashReport(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Outdated != 0 || report.ByParams["m=16384,t=2,p=2"] != 1 {
+		t.Errorf("PasswordHashReport after sign in = %+v, want none outdated", report)
+	}
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"fmt"
+)
+
+const (
+	HealthOK   = "ok"
+	HealthFail = "fail"
+	HealthSkip = "skipped"
+)
+
+// HealthCheck is the result of one readiness check.
+type HealthCheck struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Version  *uint  `json:"version,omitempty"`
+	Expected *uint  `json:"expected,omitempty"`
+}
+
+// Readiness reports whether the service can serve requests, with the
+// result of each check.
+type Readiness struct {
+	Status string                 `json:"status"`
+	Checks map[string]HealthCheck `json:"checks"`
+}
+
+// Ready returns true if every check passed or was skipped.
+func (r Readiness) Ready() bool {
+	return r.Status == HealthOK
+}
+
+// WithSchemaVersion sets the migration version the database must be at
+// for the service to be ready, normally the newest migration.
+func WithSchemaVersion(v uint) Option {
+	return func(s *Service) {
+		s.schemaVersion = v
+	}
+}
+
+// SetDraining marks the service as shutting down, so it reports itself
+// not ready and load balancers stop sending it requests.
+func (s *Service) SetDraining(draining bool) {
+	s.draining.Store(draining)
+}
+
+// IsDraining returns true once SetDraining(true) has been called.
+func (s *Service) IsDraining() bool {
+	return s.draining.Load()
+}
+
+// SetMaintenance turns maintenance mode on or off. While it is on the
+// public API responds 503 to every request.
+func (s *Service) SetMaintenance(on bool) {
+	s.maintenance.Store(on)
+}
+
+// InMaintenance returns true while maintenance mode is on.
+func (s *Service) InMaintenance() bool {
+	return s.maintenance.Load()
+}
+
+// Readiness checks that both database pools answer queries, that the
+// schema is at the expected migration version and that the service is
+// not draining. With a database per tenant the tenant directory is
+// checked instead, and migrations are checked per tenant by
+// migrate up --all-tenants rather than here.
+func (s *Service) Readiness(ctx context.Context) Readiness {
+	checks := make(map[string]HealthCheck)
+
+	ping := func(readwrite bool) HealthCheck {
+		if err := s.repo.PingDB(ctx, readwrite); err != nil {
+			return HealthCheck{Status: HealthFail, Error: err.Error()}
+		}
+		return HealthCheck{Status: HealthOK}
+	}
+	if s.IsMultiTenant() {
+		checks["tenants"] = ping(false)
+		checks["migrations"] = HealthCheck{Status: HealthSkip}
+	} else {
+		checks["database_ro"] = ping(false)
+		checks["database_rw"] = ping(true)
+		checks["migrations"] = s.checkSchemaVersion(ctx)
+	}
+
+	if s.IsDraining() {
+		checks["draining"] = HealthCheck{Status: HealthFail, Error: "shutting down"}
+	} else {
+		checks["draining"] = HealthCheck{Status: HealthOK}
+	}
+
+	r := Readiness{Status: HealthOK, Checks: checks}
+	for _, c := range checks {
+		if c.Status == HealthFail {
+			r.Status = HealthFail
+		}
+	}
+	return r
+}
+
+func (s *Service) checkSchemaVersion(ctx context.Context) HealthCheck {
+	version, dirty, err := s.repo.SchemaVersion(ctx)
+	if err != nil {
+		return HealthCheck{Status: HealthFail, Error: err.Error()}
+	}
+
+	c := HealthCheck{Status: HealthOK, Version: &version}
+	if s.schemaVersion == 0 {
+		return c
+	}
+	expected := s.schemaVersion
+	c.Expected = &expected
+	switch {
+	case dirty:
+		c.Status, c.Error = HealthFail, fmt.Sprintf("migration %d failed; the schema is dirty", version)
+	case version != s.schemaVersion:
+		c.Status, c.Error = HealthFail, fmt.Sprintf("schema at version %d, expected %d; run migrate up", version, s.schemaVersion)
+	}
+	return c
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/andyfusniak/monolith/internal/store"
+)
+
+// healthRepo answers the readiness queries with fixed results.
+type healthRepo struct {
+	store.Repository
+	pingErr error
+	version uint
+	dirty   bool
+	err     error
+}
+
+func (r healthRepo) PingDB(ctx context.Context, readwrite bool) error {
+	return r.pingErr
+}
+
+func (r healthRepo) SchemaVersion(ctx context.Context) (uint, bool, error) {
+	return r.version, r.dirty, r.err
+}
+
+func TestReadiness(t *testing.T) {
+	tests := []struct {
+		name       string
+		repo       healthRepo
+		expected   uint
+		draining   bool
+		wantReady  bool
+		wantFailed string
+	}{
+		{name: "ready", repo: healthRepo{version: 19}, expected: 19, wantReady: true},
+		{name: "version not checked", repo: healthRepo{version: 3}, wantReady: true},
+		{name: "draining", repo: healthRepo{version: 19}, expected: 19, draining: true, wantFailed: "draining"},
+		{name: "schema behind", repo: healthRepo{version: 18}, expected: 19, wantFailed: "migrations"},
+		{name: "schema ahead", repo: healthRepo{version: 20}, expected: 19, wantFailed: "migrations"},
+		{name: "schema dirty", repo: healthRepo{version: 19, dirty: true}, expected: 19, wantFailed: "migrations"},
+		{name: "never migrated", repo: healthRepo{err: errors.New("no such table: schema_migrations")}, expected: 19,
+			wantFailed: "migrations"},
+		{name: "database down", repo: healthRepo{version: 19, pingErr: errors.New("disk I/O error")}, expected: 19,
+			wantFailed: "database_rw"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New(WithRepository(tt.repo), WithSchemaVersion(tt.expected))
+			s.SetDraining(tt.draining)
+
+			r := s.Readiness(context.Background())
+			if r.Ready() != tt.wantReady {
+				t.Fatalf("Ready() = %t, want %t; checks %+v", r.Ready(), tt.wantReady, r.Checks)
+			}
+			for name, c := range r.Checks {
+				failed := c.Status == HealthFail
+				if failed && c.Error == "" {
+					t.Errorf("check %s failed without an error", name)
+				}
+				if name == tt.wantFailed && !failed {
+					t.Errorf("check %s = %s, want %s", name, c.Status, HealthFail)
+				}
+				if tt.wantFailed == "" && failed {
+					t.Errorf("check %s failed: %s", name, c.Error)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/andyfusniak/monolith/internal/store"
+	"github.com/andyfusniak/monolith/internal/tracing"
+	"github.com/pkg/errors"
+)
+
+const (
+	// idempotencyKeyTTL is how long the response to a request with an
+	// idempotency key is replayed.
+	idempotencyKeyTTL = 24 * time.Hour
+
+	// idempotencyKeyStaleAfter is how long a request with an idempotency
+	// key may be in progress before the key can be claimed again, for
+	// requests that never completed because the server stopped.
+	idempotencyKeyStaleAfter = time.Minute
+)
+
+var (
+	ErrIdempotencyKeyMismatch   = errors.New("idempotency key used for a different request")
+	ErrIdempotencyKeyInProgress = errors.New("request with idempotency key in progress")
+)
+
+// IdempotentResponse is the response to the first request made with an
+// idempotency key, replayed to repeats of the request.
+type IdempotentResponse struct {
+	StatusCode int
+	Headers    map[string]string
+	Body       []byte
+}
+
+// BeginIdempotentRequest claims key for a request identified by
+// fingerprint, a hash of the request. scope is the authenticated user, or
+// "", so clients cannot see each other's responses.
+//
+// If the key is new nil is returned and the caller must handle the
+// request, then call CompleteIdempotentRequest with the response or
+// AbandonIdempotentRequest if it failed. If a request with the key has
+// completed its response is returned to be replayed.
+// ErrIdempotencyKeyMismatch is returned if the key was used for a request
+// with a different fingerprint and ErrIdempotencyKeyInProgress if the
+// first request has not completed.
+func (s *Service) BeginIdempotentRequest(ctx context.Context, scope, key, fingerprint string) (*IdempotentResponse, error) {
+	ctx, span := tracing.Start(ctx, "service.BeginIdempotentRequest")
+	defer span.End()
+
+	now := time.Now().UTC()
+	err := s.repo.InsertIdempotencyKey(ctx, store.AddIdempotencyKey{
+		Scope:       scope,
+		Key:         key,
+		Fingerprint: fingerprint,
+		StaleBefore: store.Datetime(now.Add(-idempotencyKeyStaleAfter)),
+		ExpiresAt:   store.Datetime(now.Add(idempotencyKeyTTL)),
+	})
+	if err == nil {
+		return nil, nil
+	}
+	if !errors.Is(err, store.ErrIdempotencyKeyExists) {
+		return nil, errors.Wrap(err, "[service] s.repo.InsertIdempotencyKey failed")
+	}
+
+	row, err := s.repo.GetIdempotencyKey(ctx, scope, key)
+	if err != nil {
+		if errors.Is(err, store.ErrIdempotencyKeyNotFound) {
+			// completed requests are not deleted, so the first request
+			// failed and was abandoned after the insert
+			return nil, ErrIdempotencyKeyInProgress
+		}
+		return nil, errors.Wrap(err, "[service] s.repo.GetIdempotencyKey failed")
+	}
+	if row.Fingerprint != fingerprint {
+		return nil, ErrIdempotencyKeyMismatch
+	}
+	if row.StatusCode == nil {
+		return nil, ErrIdempotencyKeyInProgress
+	}
+
+	resp := IdempotentResponse{StatusCode: *row.StatusCode, Body: row.Body}
+	if row.Headers != nil {
+		if err := json.Unmarshal([]byte(*row.Headers), &resp.Headers); err != nil {
+			return nil, errors.Wrap(err, "[service] json unmarshal idempotency key headers failed")
+		}
+	}
+	return &resp, nil
+}
+
+// CompleteIdempotentRequest records resp as the response to the request
+// with key, to be replayed to repeats of it.
+func (s *Service) CompleteIdempotentRequest(ctx context.Context, scope, key string, resp IdempotentResponse) error {
+	ctx, span := tracing.Start(ctx, "service.CompleteIdempotentRequest")
+	defer span.End()
+
+	headers, err := json.Marshal(resp.Headers)
+	if err != nil {
+		return errors.Wrap(err, "[service] json marshal idempotency key headers failed")
+	}
+	if err := s.repo.CompleteIdempotencyKey(ctx, store.CompleteIdempotencyKey{
+		Scope:      scope,
+		Key:        key,
+		StatusCode: resp.StatusCode,
+		Headers:    string(headers),
+		Body:       resp.Body,
+	}); err != nil {
+		return errors.Wrap(err, "[service] s.repo.CompleteIdempotencyKey failed")
+	}
+	return nil
+}
+
+// AbandonIdempotentRequest releases key after the request made with it
+// failed, so the request can be retried with the same key.
+func (s *Service) AbandonIdempotentRequest(ctx context.Context, scope, key string) error {
+	ctx, span := tracing.Start(ctx, "service.AbandonIdempotentRequest")
+	defer span.End()
+
+	if err := s.repo.DeleteIdempotencyKey(ctx, scope, key); err != nil {
+		return errors.Wrap(err, "[service] s.repo.DeleteIdempotencyKey failed")
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/andyfusniak/monolith/internal/store"
+)
+
+func TestIdempotentRequest(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+
+	stored, err := s.BeginIdempotentRequest(ctx, "user1", "key1", "fp1")
+	if err != nil || stored != nil {
+		t.Fatalf("BeginIdempotentRequest new key = %+v, %v; want nil, nil", stored, err)
+	}
+	if _, err := s.BeginIdempotentRequest(ctx, "user1", "key1", "fp1"); !errors.Is(err, ErrIdempotencyKeyInProgress) {
+		t.Fatalf("BeginIdempotentRequest in progress error = %v, want %v", err, ErrIdempotencyKeyInProgress)
+	}
+
+	resp := IdempotentResponse{
+		StatusCode: 201,
+		Headers:    map[string]string{"Content-Type": "application/json", "Location": "/v1/orgs/1"},
+		Body:       []byte(`{"id":"1"}` + "\n"),
+	}
+	if err := s.CompleteIdempotentRequest(ctx, "user1", "key1", resp); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err = s.BeginIdempotentRequest(ctx, "user1", "key1", "fp1")
+	if err != nil {
+		t.Fatalf("BeginIdempotentRequest repeat: %v", err)
+	}
+	if stored == nil || !reflect.DeepEqual(*stored, resp) {
+		t.Fatalf("stored response = %+v, want %+v", stored, resp)
+	}
+
+	if _, err := s.BeginIdempotentRequest(ctx, "user1", "key1", "fp2"); !errors.Is(err, ErrIdempotencyKeyMismatch) {
+		t.Errorf("BeginIdempotentRequest other request error = %v, want %v", err, ErrIdempotencyKeyMismatch)
+	}
+
+	// keys are scoped, so another user may use the same key
+	if stored, err := s.BeginIdempotentRequest(ctx, "user2", "key1", "fp2"); err != nil || stored != nil {
+		t.Errorf("BeginIdempotentRequest other scope = %+v, %v; want nil, nil", stored, err)
+	}
+}
+
+func TestAbandonIdempotentRequest(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+
+	if _, err := s.BeginIdempotentRequest(ctx, "", "key1", "fp1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AbandonIdempotentRequest(ctx, "", "key1"); err != nil {
+		t.Fatal(err)
+	}
+	if stored, err := s.BeginIdempotentRequest(ctx, "", "key1", "fp1"); err != nil || stored != nil {
+		t.Errorf("BeginIdempotentRequest after abandoning = %+v, %v; want nil, nil", stored, err)
+	}
+}
+
+func TestIdempotencyKeyStale(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+
+	if _, err := s.BeginIdempotentRequest(ctx, "", "key1", "fp1"); err != nil {
+		t.Fatal(err)
+	}
+	// a request in progress for longer than StaleBefore allows is
+	// treated as abandoned
+	err := s.repo.InsertIdempotencyKey(ctx, store.AddIdempotencyKey{
+		Key:         "key1",
+		Fingerprint: "fp2",
+		StaleBefore: store.Datetime(time.Now().UTC().Add(time.Second)),
+		ExpiresAt:   store.Datetime(time.Now().UTC().Add(time.Hour)),
+	})
+	if err != nil {
+		t.Fatalf("InsertIdempotencyKey over a stale key: %v", err)
+	}
+	row, err := s.repo.GetIdempotencyKey(ctx, "", "key1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row.Fingerprint != "fp2" {
+		t.Errorf("fingerprint = %s, want fp2", row.Fingerprint)
+	}
+}
+
+func TestDeleteExpiredIdempotencyKeys(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+
+	now := time.Now().UTC()
+	for key, expires := range map[string]time.Time{
+		"expired": now.Add(-time.Second),
+		"current": now.Add(time.Hour),
+	} {
+		if err := s.repo.InsertIdempotencyKey(ctx, store.AddIdempotencyKey{
+			Key:         key,
+			Fingerprint: "fp1",
+			StaleBefore: store.Datetime(now.Add(-time.Minute)),
+			ExpiresAt:   store.Datetime(expires),
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.CompleteIdempotentRequest(ctx, "", key, IdempotentResponse{StatusCode: 201}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// an expired key is not replayed, and may be used for a new request
+	if stored, err := s.BeginIdempotentRequest(ctx, "", "expired", "fp2"); err != nil || stored != nil {
+		t.Fatalf("BeginIdempotentRequest expired key = %+v, %v; want nil, nil", stored, err)
+	}
+	if err := s.AbandonIdempotentRequest(ctx, "", "expired"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.repo.InsertIdempotencyKey(ctx, store.AddIdempotencyKey{
+		Key:         "expired",
+		Fingerprint: "fp1",
+		StaleBefore: store.Datetime(now.Add(-time.Minute)),
+		ExpiresAt:   store.Datetime(now.Add(-time.Second)),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := s.DeleteExpired(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("DeleteExpired deleted %d rows, want 1", n)
+	}
+	if _, err := s.repo.GetIdempotencyKey(ctx, "", "expired"); !errors.Is(err, store.ErrIdempotencyKeyNotFound) {
+		t.Errorf("GetIdempotencyKey expired error = %v, want %v", err, store.ErrIdempotencyKeyNotFound)
+	}
+	if stored, err := s.BeginIdempotentRequest(ctx, "", "current", "fp1"); err != nil || stored == nil || stored.StatusCode != 201 {
+		t.Errorf("BeginIdempotentRequest current = %+v, %v; want the stored 201", stored, err)
+	}
+}
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/andyfusniak/monolith/internal/tracing"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// impersonationTTL is how long an impersonation session lasts. It is not
+// extended; the admin starts a new one if needed.
+const impersonationTTL = time.Hour
+
+var (
+	ErrImpersonateSelf  = errors.New("cannot impersonate yourself")
+	ErrImpersonateAdmin = errors.New("cannot impersonate an admin")
+	ErrNotImpersonating = errors.New("not an impersonation session")
+)
+
+// Impersonate starts a session in which the admin in ctx acts as the user
+// with userID. The session records both users so every request made with
+// it can be attributed to the admin. Start is recorded in the audit log.
+func (s *Service) Impersonate(ctx context.Context, userID string) (Session, error) {
+	ctx, span := tracing.Start(ctx, "service.Impersonate")
+	defer span.End()
+
+	admin, err := s.requireAdmin(ctx)
+	if err != nil {
+		return Session{}, err
+	}
+	if userID == admin.UserID {
+		return Session{}, ErrImpersonateSelf
+	}
+	if _, err := s.GetUser(ctx, userID); err != nil {
+		return Session{}, err
+	}
+
+	// an admin acting as another admin would gain nothing but cover
+	isAdmin, err := s.hasRole(ctx, userID, RoleAdmin)
+	if err != nil {
+		return Session{}, err
+	}
+	if isAdmin {
+		return Session{}, ErrImpersonateAdmin
+	}
+
+	session, err := s.newSession(ctx, userID, &admin.UserID, impersonationTTL)
+	if err != nil {
+		return Session{}, err
+	}
+
+	// no impersonation without a record of it
+	if err := s.recordAudit(ctx, AuditImpersonationStart, userID, map[string]any{
+		"session_id": session.ID,
+		"expires_at": session.ExpiresAt,
+	}); err != nil {
+		if derr := s.DeleteSession(ctx, session.ID); derr != nil {
+			log.WithContext(ctx).Errorf("[service] failed to delete unaudited impersonation session_id=%s: %+v",
+				session.ID, derr)
+		}
+		return Session{}, err
+	}
+
+	log.WithContext(ctx).Warnf("[service] admin user_id=%s started impersonating user_id=%s session_id=%s",
+		admin.UserID, userID, session.ID)
+	return session, nil
+}
+
+// StopImpersonation ends an impersonation session and records the stop in
+// the audit log. ErrNotImpersonating is returned for other sessions.
+func (s *Service) StopImpersonation(ctx context.Context, session Session) error {
+	ctx, span := tracing.Start(ctx, "service.StopImpersonation")
+	defer span.End()
+
+	if session.ImpersonatorID == "" {
+		return ErrNotImpersonating
+	}
+
+	if err := s.DeleteSession(ctx, session.ID); err != nil {
+		return err
+	}
+
+	ctx = ContextWithPrincipal(ctx, Principal{
+		UserID:         session.UserID,
+		SessionID:      session.ID,
+		ImpersonatorID: session.ImpersonatorID,
+	})
+	if err := s.recordAudit(ctx, AuditImpersonationStop, session.UserID, map[string]any{
+		"session_id": session.ID,
+	}); err != nil {
+		return err
+	}
+
+	log.WithContext(ctx).Warnf("[service] admin user_id=%s stopped impersonating user_id=%s session_id=%s",
+		session.ImpersonatorID, session.UserID, session.ID)
+	return nil
+}
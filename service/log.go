@@ -0,0 +1,42 @@
+package service
+
+import (
+	"github.com/andyfusniak/monolith/internal/tracing"
+	log "github.com/sirupsen/logrus"
+)
+
+// LogHook is a logrus hook that adds request fields to every entry logged
+// with the context of a request using log.WithContext: the request ID,
+// the trace and span IDs, the authenticated user and, for API keys and
+// impersonation sessions, the key or the impersonating admin.
+type LogHook struct{}
+
+// Levels returns all levels.
+func (LogHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+// Fire adds the request fields to e.
+func (LogHook) Fire(e *log.Entry) error {
+	if e.Context == nil {
+		return nil
+	}
+	if id := RequestIDFromContext(e.Context); id != "" {
+		e.Data["request_id"] = id
+	}
+	if sc := tracing.SpanContextFromContext(e.Context); sc.IsValid() {
+		e.Data["trace_id"] = sc.TraceID.String()
+		e.Data["span_id"] = sc.SpanID.String()
+	}
+	if p, ok := PrincipalFromContext(e.Context); ok && p.UserID != "" {
+		e.Data["user_id"] = p.UserID
+		if p.APIKeyID != "" {
+			e.Data["api_key_id"] = p.APIKeyID
+		}
+		if p.ImpersonatorID != "" {
+			e.Data["impersonator_id"] = p.ImpersonatorID
+			e.Data["impersonated_user_id"] = p.UserID
+		}
+	}
+	return nil
+}
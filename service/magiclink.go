@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/andyfusniak/monolith/internal/mail"
+	"github.com/andyfusniak/monolith/internal/store"
+	"github.com/andyfusniak/monolith/internal/tracing"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const magicLinkTTL = 15 * time.Minute
+
+var (
+	ErrMagicLinkInvalid = errors.New("magic link invalid, used or expired")
+)
+
+// WithMailer configures the mailer used to send email.
+func WithMailer(m mail.Mailer) Option {
+	return func(s *Service) {
+		s.mailer = m
+	}
+}
+
+// WithBaseURL configures the public URL used to build links sent to
+// users.
+func WithBaseURL(u string) Option {
+	return func(s *Service) {
+		s.baseURL = strings.TrimRight(u, "/")
+	}
+}
+
+// RequestMagicLink emails a single-use sign in link to email.
+//
+// To avoid revealing which emails have accounts, RequestMagicLink behaves
+// the same whether or not a user exists: requests count towards the same
+// rate limits, and the email is sent in the background so the response
+// time does not depend on it. If the limits for the email or the client IP
+// address are exceeded a *SignInThrottledError wrapping
+// ErrSignInTooManyAttempts is returned.
+func (s *Service) RequestMagicLink(ctx context.Context, email, ip string) error {
+	ctx, span := tracing.Start(ctx, "service.RequestMagicLink")
+	defer span.End()
+
+	email = normalizeEmail(email)
+	since := store.Datetime(time.Now().UTC().Add(-s.signInThrottle.FailureWindow))
+
+	n, err := s.repo.CountMagicLinksByIP(ctx, ip, since)
+	if err != nil {
+		return errors.Wrap(err, "[service] s.repo.CountMagicLinksByIP failed")
+	}
+	if n >= s.signInThrottle.MaxMagicLinksPerIP {
+		return &SignInThrottledError{Err: ErrSignInTooManyAttempts, RetryAfter: s.signInThrottle.FailureWindow}
+	}
+	n, err = s.repo.CountMagicLinksByEmail(ctx, email, since)
+	if err != nil {
+		return errors.Wrap(err, "[service] s.repo.CountMagicLinksByEmail failed")
+	}
+	if n >= s.signInThrottle.MaxMagicLinksPerEmail {
+		return &SignInThrottledError{Err: ErrSignInTooManyAttempts, RetryAfter: s.signInThrottle.FailureWindow}
+	}
+
+	var userID *string
+	row, err := s.repo.GetUserByEmail(ctx, email)
+	if err == nil {
+		userID = &row.UserID
+	} else if !errors.Is(err, store.ErrUserNotFound) {
+		return errors.Wrap(err, "[service] s.repo.GetUserByEmail failed")
+	}
+
+	token, hash, err := newToken()
+	if err != nil {
+		return err
+	}
+	if err := s.repo.InsertMagicLink(ctx, store.AddMagicLink{
+		TokenHash: hash,
+		Email:     email,
+		UserID:    userID,
+		IP:        ip,
+		ExpiresAt: store.Datetime(time.Now().UTC().Add(magicLinkTTL)),
+	}); err != nil {
+		return errors.Wrap(err, "[service] s.repo.InsertMagicLink failed")
+	}
+
+	if userID == nil {
+		log.WithContext(ctx).Infof("[service] magic link requested for unknown email=%s", email)
+		return nil
+	}
+
+	msg := mail.Message{
+		To:      row.Email,
+		Subject: "Your sign in link",
+		Body: fmt.Sprintf("Use the link below to sign in. It can be used once and expires in %d minutes.\n\n%s/v1/auth/magic-link/%s\n\n"+
+			"If you did not request this email you can ignore it.\n",
+			int(magicLinkTTL.Minutes()), s.publicURL(ctx), token),
+	}
+	go func(ctx context.Context) {
+		if err := s.mailer.Send(ctx, msg); err != nil {
+			log.WithContext(ctx).Errorf("[service] send magic link to user_id=%s failed: %+v", *userID, err)
+		}
+	}(context.WithoutCancel(ctx))
+
+	return nil
+}
+
+// deleteExpiredMagicLinks deletes magic links that expired before now.
+// They are kept for the throttle FailureWindow after expiring as they
+// count towards the request limits until then.
+func (s *Service) deleteExpiredMagicLinks(ctx context.Context, now store.Datetime) (int64, error) {
+	before := time.Time(now).Add(-s.signInThrottle.FailureWindow)
+	return s.repo.DeleteExpiredMagicLinks(ctx, store.Datetime(before))
+}
+
+// RedeemMagicLink exchanges a magic link token for a sign in. The result
+// is the same as a password sign in, so users with TOTP enabled receive an
+// MFAChallenge. If the token is unknown, used or expired
+// ErrMagicLinkInvalid is returned.
+func (s *Service) RedeemMagicLink(ctx context.Context, token string) (SignInResult, error) {
+	ctx, span := tracing.Start(ctx, "service.RedeemMagicLink")
+	defer span.End()
+
+	row, err := s.repo.UseMagicLink(ctx, hashToken(token))
+	if err != nil {
+		if errors.Is(err, store.ErrMagicLinkNotFound) {
+			return SignInResult{}, ErrMagicLinkInvalid
+		}
+		return SignInResult{}, errors.Wrap(err, "[service] s.repo.UseMagicLink failed")
+	}
+	if row.UserID == nil || time.Now().After(time.Time(row.ExpiresAt)) {
+		return SignInResult{}, ErrMagicLinkInvalid
+	}
+
+	user, err := s.GetUser(ctx, *row.UserID)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return SignInResult{}, ErrMagicLinkInvalid
+		}
+		return SignInResult{}, err
+	}
+	return s.newSignIn(ctx, user, GrantSession)
+}
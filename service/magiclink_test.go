@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/andyfusniak/monolith/internal/mail"
+	"github.com/andyfusniak/monolith/internal/store"
+)
+
+// chanMailer delivers sent messages to a channel.
+type chanMailer chan mail.Message
+
+func (m chanMailer) Send(ctx context.Context, msg mail.Message) error {
+	m <- msg
+	return nil
+}
+
+func TestRequestMagicLinkMixedCaseEmail(t *testing.T) {
+	ctx := context.Background()
+	mailer := make(chanMailer, 1)
+	s := newTestService(t, WithMailer(mailer))
+	user := newTestUser(t, s, "Alice@Example.com")
+	if user.Email != "alice@example.com" {
+		t.Errorf("user email = %q, want it stored in lower case", user.Email)
+	}
+
+	if err := s.RequestMagicLink(ctx, " ALICE@example.COM", "192.0.2.1"); err != nil {
+		t.Fatalf("RequestMagicLink: %v", err)
+	}
+	select {
+	case msg := <-mailer:
+		if msg.To != "alice@example.com" {
+			t.Errorf("magic link sent to %q, want alice@example.com", msg.To)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no magic link sent")
+	}
+
+	if _, err := s.VerifyUserPassword(ctx, "ALICE@EXAMPLE.COM", "correct horse battery staple"); err != nil {
+		t.Errorf("VerifyUserPassword with upper case email: %v", err)
+	}
+}
+
+func TestDeleteExpiredMagicLinks(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+
+	now := time.Now().UTC()
+	links := map[string]time.Time{
+		"long-expired":     now.Add(-time.Hour),
+		"recently-expired": now.Add(-time.Minute), // still counts towards the limits
+		"valid":            now.Add(magicLinkTTL),
+	}
+	for hash, expiresAt := range links {
+		if err := s.repo.InsertMagicLink(ctx, store.AddMagicLink{
+			TokenHash: hash,
+			Email:     "alice@example.com",
+			IP:        "192.0.2.1",
+			ExpiresAt: store.Datetime(expiresAt),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n, err := s.DeleteExpired(ctx); err != nil || n != 1 {
+		t.Fatalf("DeleteExpired = %d, %v, want 1", n, err)
+	}
+	n, err := s.repo.CountMagicLinksByEmail(ctx, "alice@example.com", store.Datetime(now.Add(-24*time.Hour)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("%d magic links left, want 2", n)
+	}
+}
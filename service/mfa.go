@@ -0,0 +1,331 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/andyfusniak/base58"
+	"github.com/andyfusniak/monolith/internal/store"
+	"github.com/andyfusniak/monolith/internal/tracing"
+	"github.com/pkg/errors"
+)
+
+const (
+	totpPeriod         = 30 // seconds
+	totpDigits         = 6
+	totpSkew           = 1 // steps either side of the current step
+	totpSecretBytes    = 20
+	recoveryCodeCount  = 10
+	mfaChallengeTTL    = 5 * time.Minute
+	mfaChallengeMaxTry = 5
+	defaultTOTPIssuer  = "monolith"
+)
+
+var (
+	ErrTOTPAlreadyEnabled  = errors.New("totp already enabled")
+	ErrTOTPNotEnrolled     = errors.New("totp not enrolled")
+	ErrMFACodeInvalid      = errors.New("mfa code invalid")
+	ErrMFAChallengeInvalid = errors.New("mfa challenge invalid or expired")
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// WithTOTPIssuer configures the issuer shown by authenticator apps.
+func WithTOTPIssuer(issuer string) Option {
+	return func(s *Service) {
+		s.totpIssuer = issuer
+	}
+}
+
+// TOTPEnrollment is returned when a user begins TOTP enrollment.
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+// MFAChallenge is issued in place of a session when a user with a second
+// factor enabled signs in with a correct password.
+type MFAChallenge struct {
+	Token     string  `json:"mfa_token"`
+	ExpiresAt ISOTime `json:"expires_at"`
+}
+
+// SignInResult is the outcome of a successful password sign in. Exactly
+// one of Session, Tokens or Challenge is set.
+type SignInResult struct {
+	User      User
+	Session   *Session
+	Tokens    *TokenPair
+	Challenge *MFAChallenge
+}
+
+// EnrollTOTP generates a new TOTP secret for the user. The secret is not
+// used for sign in until it is confirmed using ConfirmTOTP. Calling
+// EnrollTOTP again before confirming replaces the secret.
+func (s *Service) EnrollTOTP(ctx context.Context, userID string) (TOTPEnrollment, error) {
+	ctx, span := tracing.Start(ctx, "service.EnrollTOTP")
+	defer span.End()
+
+	user, err := s.GetUser(ctx, userID)
+	if err != nil {
+		return TOTPEnrollment{}, err
+	}
+
+	b := make([]byte, totpSecretBytes)
+	if _, err := rand.Read(b); err != nil {
+		return TOTPEnrollment{}, errors.Wrap(err, "[service] failed to generate totp secret")
+	}
+	secret := totpEncoding.EncodeToString(b)
+
+	row, err := s.repo.UpsertUserTOTP(ctx, userID, secret)
+	if err != nil {
+		return TOTPEnrollment{}, errors.Wrap(err, "[service] s.repo.UpsertUserTOTP failed")
+	}
+	if row.ConfirmedAt != nil {
+		return TOTPEnrollment{}, ErrTOTPAlreadyEnabled
+	}
+
+	return TOTPEnrollment{
+		Secret: secret,
+		URI:    totpURI(s.totpIssuer, user.Email, secret),
+	}, nil
+}
+
+// ConfirmTOTP enables TOTP for the user if code is valid for the pending
+// secret. It returns a new set of single-use recovery codes which are not
+// retrievable again.
+func (s *Service) ConfirmTOTP(ctx context.Context, userID, code string) ([]string, error) {
+	ctx, span := tracing.Start(ctx, "service.ConfirmTOTP")
+	defer span.End()
+
+	row, err := s.repo.GetUserTOTP(ctx, userID)
+	if err != nil {
+		if errors.Is(err, store.ErrUserTOTPNotFound) {
+			return nil, ErrTOTPNotEnrolled
+		}
+		return nil, errors.Wrap(err, "[service] s.repo.GetUserTOTP failed")
+	}
+	if row.ConfirmedAt != nil {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+
+	step, ok := verifyTOTP(row.Secret, code, time.Now())
+	if !ok {
+		return nil, ErrMFACodeInvalid
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		c, err := base58.RandString(10)
+		if err != nil {
+			return nil, errors.Wrap(err, "[service] failed to generate recovery code")
+		}
+		codes[i] = c[:5] + "-" + c[5:]
+		hashes[i] = hashToken(c)
+	}
+
+	if err := s.repo.ConfirmUserTOTP(ctx, store.ConfirmUserTOTP{
+		UserID:             userID,
+		Secret:             row.Secret,
+		Step:               step,
+		RecoveryCodeHashes: hashes,
+	}); err != nil {
+		if errors.Is(err, store.ErrUserTOTPNotFound) {
+			// confirmed by a concurrent request, or the secret was
+			// replaced after the code was checked
+			if enabled, herr := s.HasTOTP(ctx, userID); herr == nil && enabled {
+				return nil, ErrTOTPAlreadyEnabled
+			}
+			return nil, ErrMFACodeInvalid
+		}
+		return nil, errors.Wrap(err, "[service] s.repo.ConfirmUserTOTP failed")
+	}
+
+	return codes, nil
+}
+
+// HasTOTP returns true if the user has confirmed TOTP enrollment.
+func (s *Service) HasTOTP(ctx context.Context, userID string) (bool, error) {
+	ctx, span := tracing.Start(ctx, "service.HasTOTP")
+	defer span.End()
+
+	row, err := s.repo.GetUserTOTP(ctx, userID)
+	if err != nil {
+		if errors.Is(err, store.ErrUserTOTPNotFound) {
+			return false, nil
+		}
+		return false, errors.Wrap(err, "[service] s.repo.GetUserTOTP failed")
+	}
+	return row.ConfirmedAt != nil, nil
+}
+
+// newSignIn completes the first sign in step for user. If the user has
+// TOTP enabled an MFAChallenge is returned, otherwise a new Session or
+// TokenPair according to grant.
+func (s *Service) newSignIn(ctx context.Context, user User, grant SignInGrant) (SignInResult, error) {
+	hasTOTP, err := s.HasTOTP(ctx, user.ID)
+	if err != nil {
+		return SignInResult{}, err
+	}
+
+	if !hasTOTP {
+		return s.issueSignIn(ctx, user, grant)
+	}
+
+	token, hash, err := newToken()
+	if err != nil {
+		return SignInResult{}, err
+	}
+	row, err := s.repo.InsertMFAChallenge(ctx, store.AddMFAChallenge{
+		TokenHash: hash,
+		UserID:    user.ID,
+		ExpiresAt: store.Datetime(time.Now().UTC().Add(mfaChallengeTTL)),
+	})
+	if err != nil {
+		return SignInResult{}, errors.Wrap(err, "[service] s.repo.InsertMFAChallenge failed")
+	}
+
+	return SignInResult{
+		User: user,
+		Challenge: &MFAChallenge{
+			Token:     token,
+			ExpiresAt: ISOTime(row.ExpiresAt),
+		},
+	}, nil
+}
+
+// VerifyMFAChallenge completes a two-step sign in. Either a TOTP code or
+// an unused recovery code must be given. On success the challenge is
+// consumed and a new Session or TokenPair is returned according to grant.
+//
+// If the challenge token is unknown, expired or has had too many attempts
+// ErrMFAChallengeInvalid is returned. If the code is wrong ErrMFACodeInvalid
+// is returned. Each attempt is counted before the code is checked, so
+// concurrent attempts cannot exceed the limit.
+func (s *Service) VerifyMFAChallenge(ctx context.Context, token, code, recoveryCode string, grant SignInGrant) (SignInResult, error) {
+	ctx, span := tracing.Start(ctx, "service.VerifyMFAChallenge")
+	defer span.End()
+
+	hash := hashToken(token)
+	challenge, err := s.repo.IncrMFAChallengeAttempts(ctx, hash)
+	if err != nil {
+		if errors.Is(err, store.ErrMFAChallengeNotFound) {
+			return SignInResult{}, ErrMFAChallengeInvalid
+		}
+		return SignInResult{}, errors.Wrap(err, "[service] s.repo.IncrMFAChallengeAttempts failed")
+	}
+	if time.Now().After(time.Time(challenge.ExpiresAt)) || challenge.Attempts > mfaChallengeMaxTry {
+		if err := s.repo.DeleteMFAChallenge(ctx, hash); err != nil {
+			return SignInResult{}, errors.Wrap(err, "[service] s.repo.DeleteMFAChallenge failed")
+		}
+		return SignInResult{}, ErrMFAChallengeInvalid
+	}
+
+	ok, err := s.verifySecondFactor(ctx, challenge.UserID, code, recoveryCode)
+	if err != nil {
+		return SignInResult{}, err
+	}
+	if !ok {
+		return SignInResult{}, s.auditSignInFailure(ctx, challenge.UserID, "", "mfa-code-invalid", ErrMFACodeInvalid)
+	}
+
+	if err := s.repo.DeleteMFAChallenge(ctx, hash); err != nil {
+		return SignInResult{}, errors.Wrap(err, "[service] s.repo.DeleteMFAChallenge failed")
+	}
+
+	user, err := s.GetUser(ctx, challenge.UserID)
+	if err != nil {
+		return SignInResult{}, err
+	}
+	return s.issueSignIn(ctx, user, grant)
+}
+
+func (s *Service) verifySecondFactor(ctx context.Context, userID, code, recoveryCode string) (bool, error) {
+	if recoveryCode != "" {
+		normalized := strings.NewReplacer("-", "", " ", "").Replace(recoveryCode)
+		err := s.repo.UseRecoveryCode(ctx, userID, hashToken(normalized))
+		if err != nil {
+			if errors.Is(err, store.ErrRecoveryCodeNotFound) {
+				return false, nil
+			}
+			return false, errors.Wrap(err, "[service] s.repo.UseRecoveryCode failed")
+		}
+		return true, nil
+	}
+
+	row, err := s.repo.GetUserTOTP(ctx, userID)
+	if err != nil {
+		return false, errors.Wrap(err, "[service] s.repo.GetUserTOTP failed")
+	}
+	step, ok := verifyTOTP(row.Secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	if err := s.repo.UseUserTOTPStep(ctx, userID, step); err != nil {
+		if errors.Is(err, store.ErrUserTOTPStepReused) {
+			return false, nil
+		}
+		return false, errors.Wrap(err, "[service] s.repo.UseUserTOTPStep failed")
+	}
+	return true, nil
+}
+
+// totpURI returns an otpauth URI understood by authenticator apps.
+func totpURI(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(totpDigits))
+	v.Set("period", fmt.Sprint(totpPeriod))
+
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+// verifyTOTP checks code against the secret for the time steps around t,
+// returning the matching step.
+func verifyTOTP(secret, code string, t time.Time) (int64, bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+
+	now := t.Unix() / totpPeriod
+	for step := now - totpSkew; step <= now+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// totpCode returns the RFC 6238 code for key at the given time step.
+func totpCode(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// dynamic truncation (RFC 4226 section 5.3)
+	offset := sum[len(sum)-1] & 0x0f
+	v := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, v%mod)
+}
@@ -0,0 +1,267 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andyfusniak/monolith/internal/store"
+)
+
+// RFC 6238 appendix B test vectors for SHA-1, truncated to six digits.
+func TestTOTPCodeRFC6238(t *testing.T) {
+	key := []byte("12345678901234567890")
+
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1111111111, want: "050471"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+		{unix: 20000000000, want: "353130"},
+	}
+
+	secret := totpEncoding.EncodeToString(key)
+	for _, tt := range tests {
+		if got := totpCode(key, tt.unix/totpPeriod); got != tt.want {
+			t.Errorf("totpCode at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+		step, ok := verifyTOTP(secret, tt.want, time.Unix(tt.unix, 0))
+		if !ok || step != tt.unix/totpPeriod {
+			t.Errorf("verifyTOTP at %d = %d %t, want %d true", tt.unix, step, ok, tt.unix/totpPeriod)
+		}
+	}
+}
+
+func TestVerifyTOTPSkew(t *testing.T) {
+	key := []byte("12345678901234567890")
+	secret := totpEncoding.EncodeToString(key)
+	now := time.Unix(1234567890, 0)
+	step := now.Unix() / totpPeriod
+
+	tests := []struct {
+		name   string
+		offset int64
+		want   bool
+	}{
+		{name: "two steps behind", offset: -2, want: false},
+		{name: "one step behind", offset: -1, want: true},
+		{name: "current step", offset: 0, want: true},
+		{name: "one step ahead", offset: 1, want: true},
+		{name: "two steps ahead", offset: 2, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code := totpCode(key, step+tt.offset)
+			got, ok := verifyTOTP(secret, code, now)
+			if ok != tt.want {
+				t.Fatalf("verifyTOTP = %t, want %t", ok, tt.want)
+			}
+			if ok && got != step+tt.offset {
+				t.Errorf("verifyTOTP step = %d, want %d", got, step+tt.offset)
+			}
+		})
+	}
+
+	for _, code := range []string{"", "12345", "1234567", "abcdef"} {
+		if _, ok := verifyTOTP(secret, code, now); ok {
+			t.Errorf("verifyTOTP(%q) = true, want false", code)
+		}
+	}
+	if _, ok := verifyTOTP("not base32!", totpCode(key, step), now); ok {
+		t.Error("verifyTOTP with an invalid secret = true, want false")
+	}
+}
+
+// enrollTestTOTP enables TOTP for user, returning the secret and recovery
+// codes.
+func enrollTestTOTP(t *testing.T, s *Service, user User) (secret string, recoveryCodes []string) {
+	t.Helper()
+	ctx := context.Background()
+	enrollment, err := s.EnrollTOTP(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes, err := s.ConfirmTOTP(ctx, user.ID, currentTOTPCode(t, enrollment.Secret))
+	if err != nil {
+		t.Fatalf("ConfirmTOTP: %v", err)
+	}
+	return enrollment.Secret, codes
+}
+
+// currentTOTPCode returns the code for secret at the current time step.
+func currentTOTPCode(t *testing.T, secret string) string {
+	t.Helper()
+	return stepTOTPCode(t, secret, time.Now().Unix()/totpPeriod)
+}
+
+// stepTOTPCode returns the code for secret at step.
+func stepTOTPCode(t *testing.T, secret string, step int64) string {
+	t.Helper()
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return totpCode(key, step)
+}
+
+// mfaChallengeToken signs user in with their password and returns the
+// MFA challenge token.
+func mfaChallengeToken(t *testing.T, s *Service, user User) string {
+	t.Helper()
+	res, err := s.SignInWithPassword(context.Background(), user.Email, "correct horse battery staple", "192.0.2.1", GrantSession)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Challenge == nil {
+		t.Fatal("SignInWithPassword returned no MFA challenge")
+	}
+	return res.Challenge.Token
+}
+
+func TestConfirmTOTPOnce(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+	user := newTestUser(t, s, "alice@example.com")
+
+	enrollment, err := s.EnrollTOTP(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	row, err := s.repo.GetUserTOTP(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a secret replaced after the code was checked is not confirmed
+	err = s.repo.ConfirmUserTOTP(ctx, store.ConfirmUserTOTP{UserID: user.ID, Secret: "OTHERSECRET", Step: 1})
+	if !errors.Is(err, store.ErrUserTOTPNotFound) {
+		t.Fatalf("ConfirmUserTOTP with another secret error = %v, want %v", err, store.ErrUserTOTPNotFound)
+	}
+
+	codes, err := s.ConfirmTOTP(ctx, user.ID, currentTOTPCode(t, enrollment.Secret))
+	if err != nil {
+		t.Fatalf("ConfirmTOTP: %v", err)
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("got %d recovery codes, want %d", len(codes), recoveryCodeCount)
+	}
+
+	// confirming again must not replace the recovery codes
+	err = s.repo.ConfirmUserTOTP(ctx, store.ConfirmUserTOTP{
+		UserID: user.ID, Secret: row.Secret, Step: 1, RecoveryCodeHashes: []string{hashToken("AAAAABBBBB")},
+	})
+	if !errors.Is(err, store.ErrUserTOTPNotFound) {
+		t.Fatalf("ConfirmUserTOTP when confirmed error = %v, want %v", err, store.ErrUserTOTPNotFound)
+	}
+	if _, err := s.ConfirmTOTP(ctx, user.ID, currentTOTPCode(t, enrollment.Secret)); !errors.Is(err, ErrTOTPAlreadyEnabled) {
+		t.Fatalf("ConfirmTOTP when confirmed error = %v, want %v", err, ErrTOTPAlreadyEnabled)
+	}
+	if _, err := s.VerifyMFAChallenge(ctx, mfaChallengeToken(t, s, user), "", codes[0], GrantSession); err != nil {
+		t.Fatalf("VerifyMFAChallenge with the first recovery code: %v", err)
+	}
+}
+
+func TestRecoveryCodeSingleUse(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+	user := newTestUser(t, s, "alice@example.com")
+	_, codes := enrollTestTOTP(t, s, user)
+
+	res, err := s.VerifyMFAChallenge(ctx, mfaChallengeToken(t, s, user), "", codes[0], GrantSession)
+	if err != nil {
+		t.Fatalf("VerifyMFAChallenge: %v", err)
+	}
+	if res.Session == nil {
+		t.Fatal("VerifyMFAChallenge returned no session")
+	}
+
+	token := mfaChallengeToken(t, s, user)
+	if _, err := s.VerifyMFAChallenge(ctx, token, "", codes[0], GrantSession); !errors.Is(err, ErrMFACodeInvalid) {
+		t.Fatalf("VerifyMFAChallenge with a used recovery code error = %v, want %v", err, ErrMFACodeInvalid)
+	}
+	// recovery codes may be given with spaces in place of the dash
+	spaced := codes[1][:5] + " " + codes[1][6:]
+	if _, err := s.VerifyMFAChallenge(ctx, token, "", spaced, GrantSession); err != nil {
+		t.Fatalf("VerifyMFAChallenge with another recovery code: %v", err)
+	}
+}
+
+func TestTOTPStepSingleUse(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+	user := newTestUser(t, s, "alice@example.com")
+	secret, _ := enrollTestTOTP(t, s, user)
+
+	// the code used to confirm enrollment cannot be used to sign in
+	row, err := s.repo.GetUserTOTP(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	step := row.LastUsedStep
+	token := mfaChallengeToken(t, s, user)
+	if _, err := s.VerifyMFAChallenge(ctx, token, stepTOTPCode(t, secret, step), "", GrantSession); !errors.Is(err, ErrMFACodeInvalid) {
+		t.Fatalf("VerifyMFAChallenge with a used step error = %v, want %v", err, ErrMFACodeInvalid)
+	}
+	if _, err := s.VerifyMFAChallenge(ctx, token, stepTOTPCode(t, secret, step+1), "", GrantSession); err != nil {
+		t.Fatalf("VerifyMFAChallenge with the next step: %v", err)
+	}
+	if _, err := s.VerifyMFAChallenge(ctx, token, stepTOTPCode(t, secret, step+1), "", GrantSession); !errors.Is(err, ErrMFAChallengeInvalid) {
+		t.Fatalf("VerifyMFAChallenge with a consumed challenge error = %v, want %v", err, ErrMFAChallengeInvalid)
+	}
+}
+
+func TestMFAChallengeAttempts(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+	user := newTestUser(t, s, "alice@example.com")
+	secret, _ := enrollTestTOTP(t, s, user)
+	token := mfaChallengeToken(t, s, user)
+
+	for i := 0; i < mfaChallengeMaxTry; i++ {
+		if _, err := s.VerifyMFAChallenge(ctx, token, "000000", "", GrantSession); !errors.Is(err, ErrMFACodeInvalid) {
+			t.Fatalf("attempt %d error = %v, want %v", i, err, ErrMFACodeInvalid)
+		}
+	}
+	step := time.Now().Unix()/totpPeriod + 1
+	if _, err := s.VerifyMFAChallenge(ctx, token, stepTOTPCode(t, secret, step), "", GrantSession); !errors.Is(err, ErrMFAChallengeInvalid) {
+		t.Fatalf("attempt after the limit error = %v, want %v", err, ErrMFAChallengeInvalid)
+	}
+}
+
+func TestMFAChallengeConcurrentAttempts(t *testing.T) {
+	s := newTestService(t)
+	user := newTestUser(t, s, "alice@example.com")
+	enrollTestTOTP(t, s, user)
+	token := mfaChallengeToken(t, s, user)
+
+	var wg sync.WaitGroup
+	errs := make([]error, 20)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = s.VerifyMFAChallenge(context.Background(), token, "000000", "", GrantSession)
+		}()
+	}
+	wg.Wait()
+
+	var checked int
+	for _, err := range errs {
+		switch {
+		case errors.Is(err, ErrMFACodeInvalid):
+			checked++
+		case errors.Is(err, ErrMFAChallengeInvalid):
+		default:
+			t.Errorf("error = %v, want %v or %v", err, ErrMFACodeInvalid, ErrMFAChallengeInvalid)
+		}
+	}
+	if checked != mfaChallengeMaxTry {
+		t.Errorf("%d codes checked, want %d", checked, mfaChallengeMaxTry)
+	}
+}
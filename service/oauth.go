@@ -0,0 +1,842 @@
+package service
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andyfusniak/base58"
+	"github.com/andyfusniak/monolith/internal/jwt"
+	"github.com/andyfusniak/monolith/internal/store"
+	"github.com/andyfusniak/monolith/internal/tracing"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	oauthCodeTTL         = 2 * time.Minute
+	oauthAccessTokenTTL  = time.Hour
+	oauthRefreshTokenTTL = 30 * 24 * time.Hour
+
+	// signing keys are replaced every oauthKeyRotation and remain in the
+	// JWKS for oauthKeyRetention afterwards
+	oauthKeyRotation  = 30 * 24 * time.Hour
+	oauthKeyRetention = 24 * time.Hour
+	oauthKeyCacheTTL  = 5 * time.Minute
+	oauthKeyBits      = 2048
+
+	// oauthKeyCacheMissTTL limits reloads of the signing keys caused by
+	// tokens with an unknown kid
+	oauthKeyCacheMissTTL = time.Minute
+
+	oauthAccessTokenType = "at+jwt"
+)
+
+// Scopes supported by the authorization server.
+const (
+	ScopeOpenID        = "openid"
+	ScopeEmail         = "email"
+	ScopeOfflineAccess = "offline_access"
+)
+
+// OAuth error codes from RFC 6749 section 4.1.2.1 and 5.2, RFC 6750
+// section 3.1 and OpenID Connect Core 1.0 section 3.1.2.6.
+const (
+	OAuthErrInvalidRequest          = "invalid_request"
+	OAuthErrInvalidClient           = "invalid_client"
+	OAuthErrInvalidGrant            = "invalid_grant"
+	OAuthErrUnauthorizedClient      = "unauthorized_client"
+	OAuthErrUnsupportedGrantType    = "unsupported_grant_type"
+	OAuthErrUnsupportedResponseType = "unsupported_response_type"
+	OAuthErrInvalidScope            = "invalid_scope"
+	OAuthErrInvalidToken            = "invalid_token"
+	OAuthErrLoginRequired           = "login_required"
+)
+
+var (
+	ErrOAuthClientNotFound     = errors.New("oauth client not found")
+	ErrOAuthRedirectURIInvalid = errors.New("oauth redirect uri invalid")
+)
+
+var oauthScopes = map[string]bool{
+	ScopeOpenID:        true,
+	ScopeEmail:         true,
+	ScopeOfflineAccess: true,
+}
+
+// OAuthError is an error to be returned to an OAuth client using one of
+// the OAuthErr codes.
+type OAuthError struct {
+	Code        string
+	Description string
+}
+
+func (e *OAuthError) Error() string {
+	return fmt.Sprintf("oauth %s: %s", e.Code, e.Description)
+}
+
+func oauthError(code, description string) *OAuthError {
+	return &OAuthError{Code: code, Description: description}
+}
+
+// oauthKeyCache holds the signing keys so they are not read from the
+// store for every token, and their public keys so they are not parsed
+// for every verification.
+type oauthKeyCache struct {
+	mu         sync.Mutex
+	keys       []store.OAuthSigningKey
+	publicKeys map[string]crypto.PublicKey // by kid
+	loadedAt   time.Time
+	missedAt   time.Time
+}
+
+// oauthKeyCache returns the signing key cache for the tenant of ctx. Each
+// tenant database has its own keys.
+func (s *Service) oauthKeyCache(ctx context.Context) *oauthKeyCache {
+	tenantID := store.TenantFromContext(ctx)
+
+	s.oauthKeysMu.Lock()
+	defer s.oauthKeysMu.Unlock()
+	c, ok := s.oauthKeys[tenantID]
+	if !ok {
+		c = &oauthKeyCache{}
+		s.oauthKeys[tenantID] = c
+	}
+	return c
+}
+
+// OAuthClient is a relying party registered with the authorization server.
+// Public clients have no secret and must use PKCE.
+type OAuthClient struct {
+	ID           string   `json:"client_id"`
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Public       bool     `json:"public"`
+	CreatedAt    ISOTime  `json:"created_at"`
+}
+
+// CreateOAuthClient registers a new client. For confidential clients the
+// secret is returned; only a hash of it is stored so it cannot be
+// retrieved again.
+//
+// Redirect URIs must be absolute https URLs without a fragment; http is
+// allowed for loopback addresses only. ErrOAuthRedirectURIInvalid is
+// returned for any other URI.
+func (s *Service) CreateOAuthClient(ctx context.Context, name string, redirectURIs []string, public bool) (OAuthClient, string, error) {
+	ctx, span := tracing.Start(ctx, "service.CreateOAuthClient")
+	defer span.End()
+
+	if len(redirectURIs) == 0 {
+		return OAuthClient{}, "", ErrOAuthRedirectURIInvalid
+	}
+	for _, u := range redirectURIs {
+		if !isValidRedirectURI(u) {
+			return OAuthClient{}, "", errors.Wrapf(ErrOAuthRedirectURIInvalid, "%q", u)
+		}
+	}
+
+	clientID, err := base58.RandString(22)
+	if err != nil {
+		return OAuthClient{}, "", errors.Wrap(err, "[service] failed to generate random base58 string")
+	}
+
+	var secret string
+	var secretHash *string
+	if !public {
+		var hash string
+		if secret, hash, err = newToken(); err != nil {
+			return OAuthClient{}, "", err
+		}
+		secretHash = &hash
+	}
+
+	row, err := s.repo.InsertOAuthClient(ctx, store.AddOAuthClient{
+		ClientID:     clientID,
+		Name:         name,
+		SecretHash:   secretHash,
+		RedirectURIs: strings.Join(redirectURIs, " "),
+	})
+	if err != nil {
+		return OAuthClient{}, "", errors.Wrap(err, "[service] s.repo.InsertOAuthClient failed")
+	}
+
+	return oauthClientFromRow(row), secret, nil
+}
+
+// ListOAuthClients returns all registered clients.
+func (s *Service) ListOAuthClients(ctx context.Context) ([]OAuthClient, error) {
+	ctx, span := tracing.Start(ctx, "service.ListOAuthClients")
+	defer span.End()
+
+	rows, err := s.repo.ListOAuthClients(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "[service] s.repo.ListOAuthClients failed")
+	}
+
+	clients := make([]OAuthClient, 0, len(rows))
+	for _, row := range rows {
+		clients = append(clients, oauthClientFromRow(row))
+	}
+	return clients, nil
+}
+
+// DeleteOAuthClient removes a client and revokes its refresh tokens.
+func (s *Service) DeleteOAuthClient(ctx context.Context, clientID string) error {
+	ctx, span := tracing.Start(ctx, "service.DeleteOAuthClient")
+	defer span.End()
+
+	if err := s.repo.DeleteOAuthClient(ctx, clientID); err != nil {
+		if errors.Is(err, store.ErrOAuthClientNotFound) {
+			return ErrOAuthClientNotFound
+		}
+		return errors.Wrapf(err, "[service] s.repo.DeleteOAuthClient(ctx, clientID=%q) failed", clientID)
+	}
+	return nil
+}
+
+// AuthenticateOAuthClient checks the credentials presented to the token
+// endpoint. Public clients must not present a secret.
+func (s *Service) AuthenticateOAuthClient(ctx context.Context, clientID, secret string) (OAuthClient, error) {
+	ctx, span := tracing.Start(ctx, "service.AuthenticateOAuthClient")
+	defer span.End()
+
+	row, err := s.repo.GetOAuthClient(ctx, clientID)
+	if err != nil {
+		if errors.Is(err, store.ErrOAuthClientNotFound) {
+			return OAuthClient{}, oauthError(OAuthErrInvalidClient, "client authentication failed")
+		}
+		return OAuthClient{}, errors.Wrap(err, "[service] s.repo.GetOAuthClient failed")
+	}
+
+	if row.SecretHash == nil {
+		if secret != "" {
+			return OAuthClient{}, oauthError(OAuthErrInvalidClient, "client authentication failed")
+		}
+		return oauthClientFromRow(row), nil
+	}
+	if subtle.ConstantTimeCompare([]byte(hashToken(secret)), []byte(*row.SecretHash)) != 1 {
+		return OAuthClient{}, oauthError(OAuthErrInvalidClient, "client authentication failed")
+	}
+	return oauthClientFromRow(row), nil
+}
+
+// AuthorizeRequest holds the parameters of an authorization request.
+// RedirectURIDefaulted is set by ValidateAuthorizeRequest if RedirectURI
+// was omitted and filled in.
+type AuthorizeRequest struct {
+	ResponseType         string
+	ClientID             string
+	RedirectURI          string
+	RedirectURIDefaulted bool
+	Scope                string
+	State                string
+	Nonce                string
+	CodeChallenge        string
+	CodeChallengeMethod  string
+}
+
+// ValidateAuthorizeRequest checks an authorization request before the
+// user is asked to approve it. If the redirect URI is omitted and the
+// client has only one, it is filled in.
+//
+// If the client is unknown ErrOAuthClientNotFound is returned, and if the
+// redirect URI is not registered ErrOAuthRedirectURIInvalid; in both cases
+// the user must not be redirected. Other problems are returned as an
+// *OAuthError to be reported to the client at the redirect URI.
+func (s *Service) ValidateAuthorizeRequest(ctx context.Context, req *AuthorizeRequest) (OAuthClient, error) {
+	ctx, span := tracing.Start(ctx, "service.ValidateAuthorizeRequest")
+	defer span.End()
+
+	row, err := s.repo.GetOAuthClient(ctx, req.ClientID)
+	if err != nil {
+		if errors.Is(err, store.ErrOAuthClientNotFound) {
+			return OAuthClient{}, ErrOAuthClientNotFound
+		}
+		return OAuthClient{}, errors.Wrap(err, "[service] s.repo.GetOAuthClient failed")
+	}
+	client := oauthClientFromRow(row)
+
+	if req.RedirectURI == "" && len(client.RedirectURIs) == 1 {
+		req.RedirectURI = client.RedirectURIs[0]
+		req.RedirectURIDefaulted = true
+	}
+	if !containsString(client.RedirectURIs, req.RedirectURI) {
+		return OAuthClient{}, ErrOAuthRedirectURIInvalid
+	}
+
+	if req.ResponseType != "code" {
+		return client, oauthError(OAuthErrUnsupportedResponseType, "only response_type=code is supported")
+	}
+	for _, scope := range strings.Fields(req.Scope) {
+		if !oauthScopes[scope] {
+			return client, oauthError(OAuthErrInvalidScope, fmt.Sprintf("scope %q is not supported", scope))
+		}
+	}
+	if req.CodeChallenge == "" {
+		if client.Public {
+			return client, oauthError(OAuthErrInvalidRequest, "public clients must use PKCE")
+		}
+	} else {
+		if req.CodeChallengeMethod != "S256" {
+			return client, oauthError(OAuthErrInvalidRequest, "code_challenge_method must be S256")
+		}
+		if len(req.CodeChallenge) != 43 {
+			return client, oauthError(OAuthErrInvalidRequest, "code_challenge is not valid")
+		}
+	}
+
+	return client, nil
+}
+
+// Authorize issues an authorization code for a request that has passed
+// ValidateAuthorizeRequest, on behalf of the signed in user.
+func (s *Service) Authorize(ctx context.Context, userID string, req AuthorizeRequest) (string, error) {
+	ctx, span := tracing.Start(ctx, "service.Authorize")
+	defer span.End()
+
+	code, hash, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.repo.InsertOAuthAuthorizationCode(ctx, store.AddOAuthAuthorizationCode{
+		CodeHash:            hash,
+		ClientID:            req.ClientID,
+		UserID:              userID,
+		RedirectURI:         req.RedirectURI,
+		RedirectURIRequired: !req.RedirectURIDefaulted,
+		Scope:               normalizeScope(req.Scope),
+		Nonce:               req.Nonce,
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: req.CodeChallengeMethod,
+		ExpiresAt:           store.Datetime(time.Now().UTC().Add(oauthCodeTTL)),
+	}); err != nil {
+		return "", errors.Wrap(err, "[service] s.repo.InsertOAuthAuthorizationCode failed")
+	}
+
+	return code, nil
+}
+
+// OAuthTokenResponse is a successful token endpoint response.
+type OAuthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope"`
+}
+
+// ExchangeOAuthCode redeems an authorization code for tokens using the
+// authorization_code grant. Codes can only be used once.
+func (s *Service) ExchangeOAuthCode(ctx context.Context, client OAuthClient, code, redirectURI, verifier string) (OAuthTokenResponse, error) {
+	ctx, span := tracing.Start(ctx, "service.ExchangeOAuthCode")
+	defer span.End()
+
+	row, err := s.repo.TakeOAuthAuthorizationCode(ctx, hashToken(code))
+	if err != nil {
+		if errors.Is(err, store.ErrOAuthAuthorizationCodeNotFound) {
+			return OAuthTokenResponse{}, oauthError(OAuthErrInvalidGrant, "code is invalid or has already been used")
+		}
+		return OAuthTokenResponse{}, errors.Wrap(err, "[service] s.repo.TakeOAuthAuthorizationCode failed")
+	}
+
+	if row.ClientID != client.ID || time.Now().After(time.Time(row.ExpiresAt)) {
+		return OAuthTokenResponse{}, oauthError(OAuthErrInvalidGrant, "code is invalid or has expired")
+	}
+	// redirect_uri must be repeated if it was in the authorization request
+	// (RFC 6749 section 4.1.3). If it was omitted the client has only one.
+	if (redirectURI != "" || row.RedirectURIRequired) && row.RedirectURI != redirectURI {
+		return OAuthTokenResponse{}, oauthError(OAuthErrInvalidGrant, "redirect_uri does not match")
+	}
+	if row.CodeChallenge == "" {
+		if verifier != "" {
+			return OAuthTokenResponse{}, oauthError(OAuthErrInvalidGrant, "code_verifier was not expected")
+		}
+	} else {
+		sum := sha256.Sum256([]byte(verifier))
+		challenge := jwt.Encoding.EncodeToString(sum[:])
+		if subtle.ConstantTimeCompare([]byte(challenge), []byte(row.CodeChallenge)) != 1 {
+			return OAuthTokenResponse{}, oauthError(OAuthErrInvalidGrant, "code_verifier does not match")
+		}
+	}
+
+	return s.issueOAuthTokens(ctx, client, row.UserID, row.Scope, row.Nonce)
+}
+
+// RefreshOAuthToken exchanges a refresh token for new tokens using the
+// refresh_token grant. The refresh token is replaced on every use. If
+// scope is given it must be a subset of the original scope.
+func (s *Service) RefreshOAuthToken(ctx context.Context, client OAuthClient, refreshToken, scope string) (OAuthTokenResponse, error) {
+	ctx, span := tracing.Start(ctx, "service.RefreshOAuthToken")
+	defer span.End()
+
+	row, err := s.repo.TakeOAuthRefreshToken(ctx, hashToken(refreshToken))
+	if err != nil {
+		if errors.Is(err, store.ErrOAuthRefreshTokenNotFound) {
+			return OAuthTokenResponse{}, oauthError(OAuthErrInvalidGrant, "refresh_token is invalid or has already been used")
+		}
+		return OAuthTokenResponse{}, errors.Wrap(err, "[service] s.repo.TakeOAuthRefreshToken failed")
+	}
+	if row.ClientID != client.ID || time.Now().After(time.Time(row.ExpiresAt)) {
+		return OAuthTokenResponse{}, oauthError(OAuthErrInvalidGrant, "refresh_token is invalid or has expired")
+	}
+
+	granted := strings.Fields(row.Scope)
+	if scope == "" {
+		scope = row.Scope
+	}
+	for _, sc := range strings.Fields(scope) {
+		if !containsString(granted, sc) {
+			return OAuthTokenResponse{}, oauthError(OAuthErrInvalidScope, fmt.Sprintf("scope %q was not granted", sc))
+		}
+	}
+
+	return s.issueOAuthTokens(ctx, client, row.UserID, normalizeScope(scope), "")
+}
+
+// oauthAccessTokenClaims are the claims of an access token (RFC 9068).
+type oauthAccessTokenClaims struct {
+	jwt.Claims
+	ClientID string `json:"client_id"`
+	Scope    string `json:"scope"`
+}
+
+// oauthIDTokenClaims are the claims of an ID token.
+type oauthIDTokenClaims struct {
+	jwt.Claims
+	AuthorizedParty string `json:"azp"`
+	Nonce           string `json:"nonce,omitempty"`
+	Email           string `json:"email,omitempty"`
+}
+
+func (s *Service) issueOAuthTokens(ctx context.Context, client OAuthClient, userID, scope, nonce string) (OAuthTokenResponse, error) {
+	user, err := s.GetUser(ctx, userID)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return OAuthTokenResponse{}, oauthError(OAuthErrInvalidGrant, "user no longer exists")
+		}
+		return OAuthTokenResponse{}, err
+	}
+
+	now := time.Now()
+	jti, err := base58.RandString(22)
+	if err != nil {
+		return OAuthTokenResponse{}, errors.Wrap(err, "[service] failed to generate random base58 string")
+	}
+	accessToken, err := s.signOAuthToken(ctx, oauthAccessTokenType, oauthAccessTokenClaims{
+		Claims: jwt.Claims{
+			Issuer:    s.baseURL,
+			Subject:   user.ID,
+			Audience:  jwt.Audience{client.ID},
+			ExpiresAt: now.Add(oauthAccessTokenTTL).Unix(),
+			IssuedAt:  now.Unix(),
+			ID:        jti,
+		},
+		ClientID: client.ID,
+		Scope:    scope,
+	})
+	if err != nil {
+		return OAuthTokenResponse{}, err
+	}
+
+	resp := OAuthTokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(oauthAccessTokenTTL.Seconds()),
+		Scope:       scope,
+	}
+
+	scopes := strings.Fields(scope)
+	if containsString(scopes, ScopeOpenID) {
+		claims := oauthIDTokenClaims{
+			Claims: jwt.Claims{
+				Issuer:    s.baseURL,
+				Subject:   user.ID,
+				Audience:  jwt.Audience{client.ID},
+				ExpiresAt: now.Add(oauthAccessTokenTTL).Unix(),
+				IssuedAt:  now.Unix(),
+			},
+			AuthorizedParty: client.ID,
+			Nonce:           nonce,
+		}
+		if containsString(scopes, ScopeEmail) {
+			claims.Email = user.Email
+		}
+		if resp.IDToken, err = s.signOAuthToken(ctx, "JWT", claims); err != nil {
+			return OAuthTokenResponse{}, err
+		}
+	}
+
+	if containsString(scopes, ScopeOfflineAccess) {
+		token, hash, err := newToken()
+		if err != nil {
+			return OAuthTokenResponse{}, err
+		}
+		if err := s.repo.InsertOAuthRefreshToken(ctx, store.AddOAuthRefreshToken{
+			TokenHash: hash,
+			ClientID:  client.ID,
+			UserID:    user.ID,
+			Scope:     scope,
+			ExpiresAt: store.Datetime(now.UTC().Add(oauthRefreshTokenTTL)),
+		}); err != nil {
+			return OAuthTokenResponse{}, errors.Wrap(err, "[service] s.repo.InsertOAuthRefreshToken failed")
+		}
+		resp.RefreshToken = token
+	}
+
+	return resp, nil
+}
+
+// OAuthUserInfo is the userinfo endpoint response.
+type OAuthUserInfo struct {
+	Subject string `json:"sub"`
+	Email   string `json:"email,omitempty"`
+}
+
+// UserInfo returns claims about the user an access token was issued for.
+// The token must have the openid scope. If the token is not valid an
+// *OAuthError with code OAuthErrInvalidToken is returned.
+func (s *Service) UserInfo(ctx context.Context, accessToken string) (OAuthUserInfo, error) {
+	ctx, span := tracing.Start(ctx, "service.UserInfo")
+	defer span.End()
+
+	claims, err := s.verifyOAuthAccessToken(ctx, accessToken)
+	if err != nil {
+		return OAuthUserInfo{}, err
+	}
+	scopes := strings.Fields(claims.Scope)
+	if !containsString(scopes, ScopeOpenID) {
+		return OAuthUserInfo{}, oauthError(OAuthErrInvalidToken, "access token does not have the openid scope")
+	}
+
+	user, err := s.GetUser(ctx, claims.Subject)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return OAuthUserInfo{}, oauthError(OAuthErrInvalidToken, "user no longer exists")
+		}
+		return OAuthUserInfo{}, err
+	}
+
+	info := OAuthUserInfo{Subject: user.ID}
+	if containsString(scopes, ScopeEmail) {
+		info.Email = user.Email
+	}
+	return info, nil
+}
+
+func (s *Service) verifyOAuthAccessToken(ctx context.Context, raw string) (oauthAccessTokenClaims, error) {
+	invalid := oauthError(OAuthErrInvalidToken, "access token is invalid or has expired")
+
+	tok, err := jwt.Parse(raw)
+	if err != nil || tok.Header.Typ != oauthAccessTokenType {
+		return oauthAccessTokenClaims{}, invalid
+	}
+	pub, err := s.oauthVerificationKey(ctx, tok.Header)
+	if err != nil {
+		return oauthAccessTokenClaims{}, err
+	}
+	if pub == nil || tok.Verify(pub) != nil {
+		return oauthAccessTokenClaims{}, invalid
+	}
+
+	var claims oauthAccessTokenClaims
+	if err := tok.DecodeClaims(&claims); err != nil {
+		return oauthAccessTokenClaims{}, invalid
+	}
+	if err := claims.Validate(time.Now(), s.baseURL, claims.ClientID, 0); err != nil {
+		return oauthAccessTokenClaims{}, invalid
+	}
+	return claims, nil
+}
+
+// OpenIDConfiguration is the discovery document (OpenID Connect Discovery
+// 1.0 section 3).
+type OpenIDConfiguration struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// OpenIDConfiguration returns the discovery document for the
+// authorization server. The issuer is the service base URL.
+func (s *Service) OpenIDConfiguration() OpenIDConfiguration {
+	return OpenIDConfiguration{
+		Issuer:                            s.baseURL,
+		AuthorizationEndpoint:             s.baseURL + "/oauth2/authorize",
+		TokenEndpoint:                     s.baseURL + "/oauth2/token",
+		UserInfoEndpoint:                  s.baseURL + "/oauth2/userinfo",
+		JWKSURI:                           s.baseURL + "/oauth2/jwks",
+		ScopesSupported:                   []string{ScopeOpenID, ScopeEmail, ScopeOfflineAccess},
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{"authorization_code", "refresh_token"},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{jwt.RS256},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		ClaimsSupported:                   []string{"iss", "sub", "aud", "exp", "iat", "nonce", "azp", "email"},
+	}
+}
+
+// OAuthJWKS returns the public signing keys, including recently retired
+// keys so tokens signed before a rotation can still be verified.
+func (s *Service) OAuthJWKS(ctx context.Context) (jwt.JWKS, error) {
+	ctx, span := tracing.Start(ctx, "service.OAuthJWKS")
+	defer span.End()
+
+	keys, err := s.oauthSigningKeys(ctx)
+	if err != nil {
+		return jwt.JWKS{}, err
+	}
+
+	set := jwt.JWKS{Keys: make([]jwt.JWK, 0, len(keys))}
+	for _, k := range keys {
+		signer, err := parseSigningKey(k)
+		if err != nil {
+			return jwt.JWKS{}, err
+		}
+		jwk, err := jwt.NewJWK(k.KID, k.Alg, signer.Public())
+		if err != nil {
+			return jwt.JWKS{}, errors.Wrapf(err, "[service] jwt.NewJWK(kid=%q) failed", k.KID)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set, nil
+}
+
+// RotateOAuthSigningKey creates a new signing key which is used for all
+// new tokens. Previous keys remain published until they expire. Running
+// servers pick up the new key within a few minutes.
+func (s *Service) RotateOAuthSigningKey(ctx context.Context) (string, error) {
+	ctx, span := tracing.Start(ctx, "service.RotateOAuthSigningKey")
+	defer span.End()
+
+	row, err := s.newOAuthSigningKey(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	c := s.oauthKeyCache(ctx)
+	c.mu.Lock()
+	c.loadedAt = time.Time{}
+	c.mu.Unlock()
+
+	return row.KID, nil
+}
+
+// oauthSigningKeys returns the published signing keys, newest first. A new
+// key is created if there is none or the newest has retired.
+func (s *Service) oauthSigningKeys(ctx context.Context) ([]store.OAuthSigningKey, error) {
+	c := s.oauthKeyCache(ctx)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if len(c.keys) > 0 && now.Sub(c.loadedAt) < oauthKeyCacheTTL && now.Before(time.Time(c.keys[0].RetiresAt)) {
+		return c.keys, nil
+	}
+
+	rows, err := s.repo.ListOAuthSigningKeys(ctx, store.Datetime(now.UTC()))
+	if err != nil {
+		return nil, errors.Wrap(err, "[service] s.repo.ListOAuthSigningKeys failed")
+	}
+	if len(rows) == 0 || !now.Before(time.Time(rows[0].RetiresAt)) {
+		row, err := s.newOAuthSigningKey(ctx)
+		if err != nil {
+			return nil, err
+		}
+		rows = append([]store.OAuthSigningKey{row}, rows...)
+	}
+
+	publicKeys := make(map[string]crypto.PublicKey, len(rows))
+	for _, k := range rows {
+		signer, err := parseSigningKey(k)
+		if err != nil {
+			return nil, err
+		}
+		publicKeys[k.KID] = signer.Public()
+	}
+
+	c.keys = rows
+	c.publicKeys = publicKeys
+	c.loadedAt = now
+	return rows, nil
+}
+
+// oauthVerificationKey returns the public key for the kid and alg of a
+// token header, or nil if there is no such key. Keys are served from the
+// cache; an unknown kid, such as one created by another server, reloads
+// the keys at most once per oauthKeyCacheMissTTL.
+func (s *Service) oauthVerificationKey(ctx context.Context, h jwt.Header) (crypto.PublicKey, error) {
+	pub, err := s.cachedVerificationKey(ctx, h)
+	if err != nil || pub != nil {
+		return pub, err
+	}
+
+	c := s.oauthKeyCache(ctx)
+	c.mu.Lock()
+	reload := time.Since(c.missedAt) >= oauthKeyCacheMissTTL
+	if reload {
+		c.missedAt = time.Now()
+		c.loadedAt = time.Time{}
+	}
+	c.mu.Unlock()
+	if !reload {
+		return nil, nil
+	}
+	return s.cachedVerificationKey(ctx, h)
+}
+
+// cachedVerificationKey returns the public key parsed when the keys were
+// loaded, or nil if the kid and alg are not in the cache.
+func (s *Service) cachedVerificationKey(ctx context.Context, h jwt.Header) (crypto.PublicKey, error) {
+	if _, err := s.oauthSigningKeys(ctx); err != nil {
+		return nil, err
+	}
+
+	c := s.oauthKeyCache(ctx)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	k, ok := findSigningKey(c.keys, h)
+	if !ok {
+		return nil, nil
+	}
+	return c.publicKeys[k.KID], nil
+}
+
+func findSigningKey(keys []store.OAuthSigningKey, h jwt.Header) (store.OAuthSigningKey, bool) {
+	for _, k := range keys {
+		if k.KID == h.Kid && k.Alg == h.Alg {
+			return k, true
+		}
+	}
+	return store.OAuthSigningKey{}, false
+}
+
+func (s *Service) newOAuthSigningKey(ctx context.Context) (store.OAuthSigningKey, error) {
+	key, err := rsa.GenerateKey(rand.Reader, oauthKeyBits)
+	if err != nil {
+		return store.OAuthSigningKey{}, errors.Wrap(err, "[service] failed to generate rsa key")
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return store.OAuthSigningKey{}, errors.Wrap(err, "[service] failed to marshal rsa key")
+	}
+	kid, err := base58.RandString(16)
+	if err != nil {
+		return store.OAuthSigningKey{}, errors.Wrap(err, "[service] failed to generate random base58 string")
+	}
+
+	retires := time.Now().UTC().Add(oauthKeyRotation)
+	row, err := s.repo.InsertOAuthSigningKey(ctx, store.AddOAuthSigningKey{
+		KID:        kid,
+		Alg:        jwt.RS256,
+		PrivateKey: der,
+		RetiresAt:  store.Datetime(retires),
+		ExpiresAt:  store.Datetime(retires.Add(oauthKeyRetention)),
+	})
+	if err != nil {
+		return store.OAuthSigningKey{}, errors.Wrap(err, "[service] s.repo.InsertOAuthSigningKey failed")
+	}
+	log.WithContext(ctx).Infof("[service] created oauth signing key kid=%s", kid)
+
+	return row, nil
+}
+
+// signOAuthToken signs claims with the current signing key.
+func (s *Service) signOAuthToken(ctx context.Context, typ string, claims any) (string, error) {
+	keys, err := s.oauthSigningKeys(ctx)
+	if err != nil {
+		return "", err
+	}
+	signer, err := parseSigningKey(keys[0])
+	if err != nil {
+		return "", err
+	}
+
+	token, err := jwt.Sign(jwt.Header{Alg: keys[0].Alg, Kid: keys[0].KID, Typ: typ}, signer, claims)
+	if err != nil {
+		return "", errors.Wrap(err, "[service] jwt.Sign failed")
+	}
+	return token, nil
+}
+
+func parseSigningKey(k store.OAuthSigningKey) (crypto.Signer, error) {
+	key, err := x509.ParsePKCS8PrivateKey(k.PrivateKey)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] failed to parse signing key kid=%s", k.KID)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.Errorf("[service] signing key kid=%s is not a crypto.Signer", k.KID)
+	}
+	return signer, nil
+}
+
+// isValidRedirectURI returns true if u is an absolute https URL without a
+// fragment, or an http URL for a loopback address.
+func isValidRedirectURI(u string) bool {
+	p, err := url.Parse(u)
+	if err != nil || p.Fragment != "" || p.Host == "" {
+		return false
+	}
+	switch p.Scheme {
+	case "https":
+		return true
+	case "http":
+		host := p.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	default:
+		return false
+	}
+}
+
+// normalizeScope removes duplicate and surplus whitespace from a space
+// separated scope list.
+func normalizeScope(scope string) string {
+	var out []string
+	for _, sc := range strings.Fields(scope) {
+		if !containsString(out, sc) {
+			out = append(out, sc)
+		}
+	}
+	return strings.Join(out, " ")
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func oauthClientFromRow(row store.OAuthClient) OAuthClient {
+	return OAuthClient{
+		ID:           row.ClientID,
+		Name:         row.Name,
+		RedirectURIs: strings.Fields(row.RedirectURIs),
+		Public:       row.SecretHash == nil,
+		CreatedAt:    ISOTime(row.CreatedAt),
+	}
+}
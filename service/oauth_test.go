@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestExchangeOAuthCodeRedirectURI(t *testing.T) {
+	const redirectURI = "https://client.example/callback"
+
+	tests := []struct {
+		name          string
+		authorizeURI  string
+		exchangeURI   string
+		wantErrorCode string
+	}{
+		{name: "sent and repeated", authorizeURI: redirectURI, exchangeURI: redirectURI},
+		{name: "sent and omitted", authorizeURI: redirectURI, exchangeURI: "", wantErrorCode: OAuthErrInvalidGrant},
+		{name: "sent and changed", authorizeURI: redirectURI, exchangeURI: redirectURI + "/other", wantErrorCode: OAuthErrInvalidGrant},
+		{name: "omitted and omitted", authorizeURI: "", exchangeURI: ""},
+		{name: "omitted and sent", authorizeURI: "", exchangeURI: redirectURI},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newTestService(t)
+			user := newTestUser(t, s, "alice@example.com")
+			client, _, err := s.CreateOAuthClient(ctx, "Example", []string{redirectURI}, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := AuthorizeRequest{
+				ResponseType: "code",
+				ClientID:     client.ID,
+				RedirectURI:  tt.authorizeURI,
+				Scope:        ScopeOpenID,
+			}
+			if _, err := s.ValidateAuthorizeRequest(ctx, &req); err != nil {
+				t.Fatalf("ValidateAuthorizeRequest: %v", err)
+			}
+			code, err := s.Authorize(ctx, user.ID, req)
+			if err != nil {
+				t.Fatalf("Authorize: %v", err)
+			}
+
+			_, err = s.ExchangeOAuthCode(ctx, client, code, tt.exchangeURI, "")
+			if tt.wantErrorCode == "" {
+				if err != nil {
+					t.Fatalf("ExchangeOAuthCode: %v", err)
+				}
+				return
+			}
+			var oerr *OAuthError
+			if !errors.As(err, &oerr) || oerr.Code != tt.wantErrorCode {
+				t.Fatalf("ExchangeOAuthCode error = %v, want %s", err, tt.wantErrorCode)
+			}
+		})
+	}
+}
+
+// issueOAuthTestTokens signs user in to a new confidential client with the
+// openid and email scopes.
+func issueOAuthTestTokens(t *testing.T, s *Service, user User) OAuthTokenResponse {
+	t.Helper()
+	ctx := context.Background()
+	client, _, err := s.CreateOAuthClient(ctx, "Example", []string{"https://client.example/callback"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := AuthorizeRequest{ResponseType: "code", ClientID: client.ID, Scope: ScopeOpenID + " " + ScopeEmail}
+	if _, err := s.ValidateAuthorizeRequest(ctx, &req); err != nil {
+		t.Fatalf("ValidateAuthorizeRequest: %v", err)
+	}
+	code, err := s.Authorize(ctx, user.ID, req)
+	if err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+	resp, err := s.ExchangeOAuthCode(ctx, client, code, "", "")
+	if err != nil {
+		t.Fatalf("ExchangeOAuthCode: %v", err)
+	}
+	return resp
+}
+
+func TestUserInfoAcrossKeyRotation(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+	user := newTestUser(t, s, "alice@example.com")
+
+	before := issueOAuthTestTokens(t, s, user)
+	kid, err := s.RotateOAuthSigningKey(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := issueOAuthTestTokens(t, s, user)
+
+	for _, resp := range []OAuthTokenResponse{before, after} {
+		info, err := s.UserInfo(ctx, resp.AccessToken)
+		if err != nil {
+			t.Fatalf("UserInfo: %v", err)
+		}
+		if info.Subject != user.ID || info.Email != user.Email {
+			t.Errorf("UserInfo = %+v, want %s %s", info, user.ID, user.Email)
+		}
+	}
+
+	c := s.oauthKeyCache(ctx)
+	if len(c.publicKeys) != 2 || c.publicKeys[kid] == nil {
+		t.Errorf("cached public keys = %v, want 2 including kid %s", c.publicKeys, kid)
+	}
+
+	var oerr *OAuthError
+	if _, err := s.UserInfo(ctx, after.AccessToken+"x"); !errors.As(err, &oerr) || oerr.Code != OAuthErrInvalidToken {
+		t.Errorf("UserInfo with a bad signature error = %v, want %s", err, OAuthErrInvalidToken)
+	}
+}
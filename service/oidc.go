@@ -0,0 +1,250 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/andyfusniak/base58"
+	"github.com/andyfusniak/monolith/internal/oidc"
+	"github.com/andyfusniak/monolith/internal/store"
+	"github.com/andyfusniak/monolith/internal/tracing"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const oidcAuthRequestTTL = 10 * time.Minute
+
+var (
+	ErrOIDCProviderNotFound = errors.New("oidc provider not found")
+	ErrOIDCStateInvalid     = errors.New("oidc state invalid or expired")
+	ErrOIDCLoginFailed      = errors.New("oidc login failed")
+	ErrOIDCEmailMissing     = errors.New("oidc provider did not return a verified email")
+)
+
+// WithOIDCProviders configures the external providers users can sign in
+// with.
+func WithOIDCProviders(providers ...*oidc.Provider) Option {
+	return func(s *Service) {
+		s.oidcProviders = make(map[string]*oidc.Provider, len(providers))
+		for _, p := range providers {
+			s.oidcProviders[p.Name()] = p
+		}
+	}
+}
+
+// UserIdentity is an external provider account linked to a user.
+type UserIdentity struct {
+	Provider   string   `json:"provider"`
+	Subject    string   `json:"subject"`
+	Email      string   `json:"email"`
+	LastUsedAt *ISOTime `json:"last_used_at"`
+	CreatedAt  ISOTime  `json:"created_at"`
+}
+
+// OIDCProviders returns the names of the configured providers.
+func (s *Service) OIDCProviders() []string {
+	names := make([]string, 0, len(s.oidcProviders))
+	for name := range s.oidcProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// oidcRedirectURI returns the callback URL registered with the provider.
+// In tenant mode each tenant has its own callback URL.
+func (s *Service) oidcRedirectURI(ctx context.Context, provider string) string {
+	return s.publicURL(ctx) + "/v1/auth/oidc/" + provider + "/callback"
+}
+
+// BeginOIDCLogin starts an authorization code flow with the provider. It
+// returns the URL to redirect the user to and the state, which the caller
+// should bind to the user agent (for example using a cookie) and compare
+// when the user returns.
+func (s *Service) BeginOIDCLogin(ctx context.Context, provider string) (authURL, state string, err error) {
+	ctx, span := tracing.Start(ctx, "service.BeginOIDCLogin")
+	defer span.End()
+
+	p, ok := s.oidcProviders[provider]
+	if !ok {
+		return "", "", ErrOIDCProviderNotFound
+	}
+
+	state, err = oidc.NewState()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := oidc.NewState()
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := oidc.NewVerifier()
+	if err != nil {
+		return "", "", err
+	}
+
+	authURL, err = p.AuthCodeURL(ctx, s.oidcRedirectURI(ctx, provider), state, nonce, verifier)
+	if err != nil {
+		return "", "", errors.Wrapf(err, "[service] p.AuthCodeURL(provider=%q) failed", provider)
+	}
+
+	if err := s.repo.InsertOIDCAuthRequest(ctx, store.AddOIDCAuthRequest{
+		StateHash:    hashToken(state),
+		Provider:     provider,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    store.Datetime(time.Now().UTC().Add(oidcAuthRequestTTL)),
+	}); err != nil {
+		return "", "", errors.Wrap(err, "[service] s.repo.InsertOIDCAuthRequest failed")
+	}
+
+	return authURL, state, nil
+}
+
+// FinishOIDCLogin completes an authorization code flow and signs the user
+// in. The result is the same as a password sign in, so users with TOTP
+// enabled receive an MFAChallenge.
+//
+// If the provider identity is not yet linked it is linked to the user with
+// the same email, provided the provider asserts the email is verified. If
+// no user has the email a new user without a password is created.
+//
+// If the state is unknown, used or expired ErrOIDCStateInvalid is
+// returned. If the provider rejects the code or the ID token is invalid
+// ErrOIDCLoginFailed is returned.
+func (s *Service) FinishOIDCLogin(ctx context.Context, provider, state, code string) (SignInResult, error) {
+	ctx, span := tracing.Start(ctx, "service.FinishOIDCLogin")
+	defer span.End()
+
+	cl := log.WithContext(ctx)
+
+	p, ok := s.oidcProviders[provider]
+	if !ok {
+		return SignInResult{}, ErrOIDCProviderNotFound
+	}
+
+	req, err := s.repo.TakeOIDCAuthRequest(ctx, hashToken(state))
+	if err != nil {
+		if errors.Is(err, store.ErrOIDCAuthRequestNotFound) {
+			return SignInResult{}, ErrOIDCStateInvalid
+		}
+		return SignInResult{}, errors.Wrap(err, "[service] s.repo.TakeOIDCAuthRequest failed")
+	}
+	if req.Provider != provider || time.Now().After(time.Time(req.ExpiresAt)) {
+		return SignInResult{}, ErrOIDCStateInvalid
+	}
+
+	tr, err := p.Exchange(ctx, code, s.oidcRedirectURI(ctx, provider), req.CodeVerifier)
+	if err != nil {
+		cl.Infof("[service] oidc code exchange with provider=%s failed: %v", provider, err)
+		return SignInResult{}, ErrOIDCLoginFailed
+	}
+	id, err := p.Identity(ctx, tr, req.Nonce)
+	if err != nil {
+		cl.Warnf("[service] oidc identity from provider=%s failed: %v", provider, err)
+		return SignInResult{}, ErrOIDCLoginFailed
+	}
+
+	user, err := s.userForIdentity(ctx, provider, id)
+	if err != nil {
+		return SignInResult{}, err
+	}
+	return s.newSignIn(ctx, user, GrantSession)
+}
+
+// userForIdentity returns the user linked to the identity, linking or
+// creating one if needed.
+func (s *Service) userForIdentity(ctx context.Context, provider string, id oidc.Identity) (User, error) {
+	cl := log.WithContext(ctx)
+
+	row, err := s.repo.GetUserIdentity(ctx, provider, id.Subject)
+	if err == nil {
+		if err := s.repo.TouchUserIdentity(ctx, provider, id.Subject); err != nil {
+			return User{}, errors.Wrap(err, "[service] s.repo.TouchUserIdentity failed")
+		}
+		return s.GetUser(ctx, row.UserID)
+	}
+	if !errors.Is(err, store.ErrUserIdentityNotFound) {
+		return User{}, errors.Wrap(err, "[service] s.repo.GetUserIdentity failed")
+	}
+
+	// an unverified email could belong to anyone so is never used to link
+	// or create an account
+	if id.Email == "" || !id.EmailVerified {
+		return User{}, ErrOIDCEmailMissing
+	}
+
+	identity := store.AddUserIdentity{
+		Provider: provider,
+		Subject:  id.Subject,
+		Email:    id.Email,
+	}
+
+	email := normalizeEmail(id.Email)
+	existing, err := s.repo.GetUserByEmail(ctx, email)
+	if err == nil {
+		identity.UserID = existing.UserID
+		if _, err := s.repo.InsertUserIdentity(ctx, identity); err != nil {
+			return User{}, errors.Wrap(err, "[service] s.repo.InsertUserIdentity failed")
+		}
+		cl.Infof("[service] linked provider=%s identity to existing user_id=%s", provider, existing.UserID)
+		return userFromRow(existing), nil
+	}
+	if !errors.Is(err, store.ErrUserNotFound) {
+		return User{}, errors.Wrap(err, "[service] s.repo.GetUserByEmail failed")
+	}
+
+	userID, err := base58.RandString(22) // 58**22 > 2**128
+	if err != nil {
+		return User{}, errors.Wrap(err, "[service] failed to generated random base58 string")
+	}
+	created, err := s.repo.InsertUserWithIdentity(ctx, store.AddUser{
+		UserID:       userID,
+		Email:        email,
+		PasswordHash: "", // no password; sign in with the provider only
+	}, identity)
+	if err != nil {
+		return User{}, errors.Wrap(err, "[service] s.repo.InsertUserWithIdentity failed")
+	}
+	cl.Infof("[service] created user_id=%s from provider=%s identity", userID, provider)
+
+	if err := s.recordAudit(ctx, AuditUserCreate, userID, map[string]any{
+		"method":   "oidc",
+		"provider": provider,
+	}); err != nil {
+		return User{}, err
+	}
+	return userFromRow(created), nil
+}
+
+// ListUserIdentities returns the external identities linked to the user.
+func (s *Service) ListUserIdentities(ctx context.Context, userID string) ([]UserIdentity, error) {
+	ctx, span := tracing.Start(ctx, "service.ListUserIdentities")
+	defer span.End()
+
+	rows, err := s.repo.ListUserIdentities(ctx, userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "[service] s.repo.ListUserIdentities failed")
+	}
+
+	identities := make([]UserIdentity, 0, len(rows))
+	for _, row := range rows {
+		identities = append(identities, userIdentityFromRow(row))
+	}
+	return identities, nil
+}
+
+func userIdentityFromRow(row store.UserIdentity) UserIdentity {
+	id := UserIdentity{
+		Provider:  row.Provider,
+		Subject:   row.Subject,
+		Email:     row.Email,
+		CreatedAt: ISOTime(row.CreatedAt),
+	}
+	if row.LastUsedAt != nil {
+		t := ISOTime(*row.LastUsedAt)
+		id.LastUsedAt = &t
+	}
+	return id
+}
@@ -0,0 +1,152 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/andyfusniak/monolith/internal/oidc"
+	"github.com/andyfusniak/monolith/internal/oidc/oidctest"
+	"github.com/andyfusniak/monolith/internal/store"
+)
+
+func newOIDCTestService(t *testing.T) (*Service, *oidctest.Provider) {
+	t.Helper()
+	stub := oidctest.NewProvider(t, "monolith")
+	p := oidc.NewProvider(oidc.Config{
+		Name:     "test",
+		Issuer:   stub.Issuer(),
+		ClientID: "monolith",
+	}, stub.Client())
+	s := newTestService(t, WithBaseURL("https://example.com"), WithOIDCProviders(p))
+	return s, stub
+}
+
+// oidcLogin signs id in with the test provider.
+func oidcLogin(t *testing.T, s *Service, stub *oidctest.Provider, id oidctest.Identity) (SignInResult, error) {
+	t.Helper()
+	ctx := context.Background()
+
+	authURL, state, err := s.BeginOIDCLogin(ctx, "test")
+	if err != nil {
+		t.Fatalf("BeginOIDCLogin: %v", err)
+	}
+	code, gotState, err := stub.Authorize(authURL, id)
+	if err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+	if gotState != state {
+		t.Fatalf("state = %q, want %q", gotState, state)
+	}
+	return s.FinishOIDCLogin(ctx, "test", state, code)
+}
+
+func TestFinishOIDCLoginCreatesUser(t *testing.T) {
+	s, stub := newOIDCTestService(t)
+	id := oidctest.Identity{Subject: "1234", Email: "Bob@Example.com", EmailVerified: true}
+
+	first, err := oidcLogin(t, s, stub, id)
+	if err != nil {
+		t.Fatalf("FinishOIDCLogin: %v", err)
+	}
+	if first.Session == nil || first.User.Email != "bob@example.com" {
+		t.Fatalf("FinishOIDCLogin = %+v, want a session for a new user bob@example.com", first)
+	}
+
+	// the identity is now linked, so the same user signs in again
+	second, err := oidcLogin(t, s, stub, id)
+	if err != nil {
+		t.Fatalf("FinishOIDCLogin: %v", err)
+	}
+	if second.User.ID != first.User.ID {
+		t.Errorf("second sign in user_id = %s, want %s", second.User.ID, first.User.ID)
+	}
+}
+
+func TestFinishOIDCLoginLinksExistingUser(t *testing.T) {
+	ctx := context.Background()
+	s, stub := newOIDCTestService(t)
+	user := newTestUser(t, s, "Alice@Example.com")
+
+	result, err := oidcLogin(t, s, stub, oidctest.Identity{
+		Subject: "1234", Email: "alice@EXAMPLE.com", EmailVerified: true,
+	})
+	if err != nil {
+		t.Fatalf("FinishOIDCLogin: %v", err)
+	}
+	if result.User.ID != user.ID {
+		t.Fatalf("signed in user_id = %s, want existing user %s", result.User.ID, user.ID)
+	}
+
+	identities, err := s.ListUserIdentities(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(identities) != 1 || identities[0].Subject != "1234" {
+		t.Errorf("identities = %+v, want the linked identity", identities)
+	}
+	if _, err := s.repo.GetUserByEmail(ctx, "alice@example.com"); err != nil {
+		t.Errorf("GetUserByEmail: %v", err)
+	}
+}
+
+func TestFinishOIDCLoginUnverifiedEmail(t *testing.T) {
+	s, stub := newOIDCTestService(t)
+	newTestUser(t, s, "alice@example.com")
+
+	_, err := oidcLogin(t, s, stub, oidctest.Identity{Subject: "1234", Email: "alice@example.com"})
+	if !errors.Is(err, ErrOIDCEmailMissing) {
+		t.Fatalf("FinishOIDCLogin error = %v, want %v", err, ErrOIDCEmailMissing)
+	}
+}
+
+func TestFinishOIDCLoginState(t *testing.T) {
+	ctx := context.Background()
+	s, stub := newOIDCTestService(t)
+
+	authURL, state, err := s.BeginOIDCLogin(ctx, "test")
+	if err != nil {
+		t.Fatalf("BeginOIDCLogin: %v", err)
+	}
+	code, _, err := stub.Authorize(authURL, oidctest.Identity{Subject: "1234"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.FinishOIDCLogin(ctx, "test", "unknown", code); !errors.Is(err, ErrOIDCStateInvalid) {
+		t.Errorf("unknown state error = %v, want %v", err, ErrOIDCStateInvalid)
+	}
+	if _, err := s.FinishOIDCLogin(ctx, "test", state, "bad-code"); !errors.Is(err, ErrOIDCLoginFailed) {
+		t.Errorf("bad code error = %v, want %v", err, ErrOIDCLoginFailed)
+	}
+	// the state was used by the failed attempt
+	if _, err := s.FinishOIDCLogin(ctx, "test", state, code); !errors.Is(err, ErrOIDCStateInvalid) {
+		t.Errorf("reused state error = %v, want %v", err, ErrOIDCStateInvalid)
+	}
+}
+
+func TestDeleteExpiredOIDCAuthRequests(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newOIDCTestService(t)
+
+	if _, _, err := s.BeginOIDCLogin(ctx, "test"); err != nil {
+		t.Fatalf("BeginOIDCLogin: %v", err)
+	}
+	if err := s.repo.InsertOIDCAuthRequest(ctx, store.AddOIDCAuthRequest{
+		StateHash:    "expired",
+		Provider:     "test",
+		Nonce:        "nonce",
+		CodeVerifier: "verifier",
+		ExpiresAt:    store.Datetime(time.Now().UTC().Add(-time.Second)),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := s.DeleteExpired(ctx); err != nil || n != 1 {
+		t.Fatalf("DeleteExpired = %d, %v, want 1", n, err)
+	}
+	if _, err := s.repo.TakeOIDCAuthRequest(ctx, "expired"); !errors.Is(err, store.ErrOIDCAuthRequestNotFound) {
+		t.Errorf("expired auth request not deleted: %v", err)
+	}
+}
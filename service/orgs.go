@@ -0,0 +1,552 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/andyfusniak/base58"
+	"github.com/andyfusniak/monolith/internal/mail"
+	"github.com/andyfusniak/monolith/internal/store"
+	"github.com/andyfusniak/monolith/internal/tracing"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Tenant isolation
+//
+// Every service method for an organization takes the caller from the
+// context using PrincipalFromContext and loads their membership with
+// requireOrgRole before making any other repository call. Repository
+// methods for resources belonging to an organization take its orgID and
+// filter by it, so a query can only ever reach the rows of the
+// organization the caller was checked against. Non-members receive
+// ErrOrgNotFound so that the existence of an organization is not revealed.
+// New organization scoped resources must follow the same pattern.
+
+const (
+	orgInvitationTTL = 7 * 24 * time.Hour
+	maxOrgNameLen    = 100
+)
+
+// Organization member roles, from most to least privileged. Owners may do
+// anything including deleting the organization and managing other owners.
+// Admins manage members and invitations. Members have read access.
+const (
+	OrgRoleOwner  = "owner"
+	OrgRoleAdmin  = "admin"
+	OrgRoleMember = "member"
+)
+
+var (
+	ErrOrgNotFound                = errors.New("organization not found")
+	ErrOrgForbidden               = errors.New("organization role does not permit this action")
+	ErrOrgNameInvalid             = errors.New("organization name invalid")
+	ErrOrgRoleInvalid             = errors.New("organization role invalid")
+	ErrOrgMemberNotFound          = errors.New("organization member not found")
+	ErrOrgLastOwner               = errors.New("organization must have at least one owner")
+	ErrOrgAlreadyMember           = errors.New("already a member of the organization")
+	ErrOrgInvitationNotFound      = errors.New("organization invitation not found")
+	ErrOrgInvitationInvalid       = errors.New("organization invitation invalid or expired")
+	ErrOrgInvitationEmailMismatch = errors.New("organization invitation is for a different email")
+)
+
+// Organization is a tenant. Role is the role of the caller.
+type Organization struct {
+	ID        string  `json:"org_id"`
+	Name      string  `json:"name"`
+	Role      string  `json:"role,omitempty"`
+	CreatedAt ISOTime `json:"created_at"`
+}
+
+// OrgMember is a user's membership of an organization.
+type OrgMember struct {
+	UserID   string  `json:"user_id"`
+	Email    string  `json:"email"`
+	Role     string  `json:"role"`
+	JoinedAt ISOTime `json:"joined_at"`
+}
+
+// OrgInvitation is a pending invitation to join an organization.
+type OrgInvitation struct {
+	ID        string  `json:"invitation_id"`
+	OrgID     string  `json:"org_id"`
+	OrgName   string  `json:"org_name"`
+	Email     string  `json:"email"`
+	Role      string  `json:"role"`
+	InvitedBy *string `json:"invited_by"`
+	ExpiresAt ISOTime `json:"expires_at"`
+	CreatedAt ISOTime `json:"created_at"`
+}
+
+// orgRoleRank orders roles by privilege. Unknown roles rank zero.
+func orgRoleRank(role string) int {
+	switch role {
+	case OrgRoleOwner:
+		return 3
+	case OrgRoleAdmin:
+		return 2
+	case OrgRoleMember:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func isValidOrgRole(role string) bool {
+	return orgRoleRank(role) > 0
+}
+
+// requireOrgRole returns the caller's membership of an organization if
+// their role is at least minRole. Non-members receive ErrOrgNotFound and
+// members with a lesser role ErrOrgForbidden.
+func (s *Service) requireOrgRole(ctx context.Context, orgID, minRole string) (store.OrgMember, error) {
+	userID, err := principalUserID(ctx)
+	if err != nil {
+		return store.OrgMember{}, err
+	}
+
+	m, err := s.repo.GetOrgMember(ctx, orgID, userID)
+	if err != nil {
+		if errors.Is(err, store.ErrOrgMemberNotFound) {
+			return store.OrgMember{}, ErrOrgNotFound
+		}
+		return store.OrgMember{}, errors.Wrapf(err, "[service] s.repo.GetOrgMember(ctx, orgID=%q) failed", orgID)
+	}
+	if orgRoleRank(m.Role) < orgRoleRank(minRole) {
+		return store.OrgMember{}, ErrOrgForbidden
+	}
+	return m, nil
+}
+
+// CreateOrganization creates an organization with the caller as its owner.
+func (s *Service) CreateOrganization(ctx context.Context, name string) (Organization, error) {
+	ctx, span := tracing.Start(ctx, "service.CreateOrganization")
+	defer span.End()
+
+	userID, err := principalUserID(ctx)
+	if err != nil {
+		return Organization{}, err
+	}
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxOrgNameLen {
+		return Organization{}, ErrOrgNameInvalid
+	}
+
+	orgID, err := base58.RandString(22)
+	if err != nil {
+		return Organization{}, errors.Wrap(err, "[service] failed to generate random base58 string")
+	}
+	row, err := s.repo.InsertOrganization(ctx, store.AddOrganization{
+		OrgID:       orgID,
+		Name:        name,
+		OwnerUserID: userID,
+	})
+	if err != nil {
+		return Organization{}, errors.Wrap(err, "[service] s.repo.InsertOrganization failed")
+	}
+
+	return Organization{
+		ID:        row.OrgID,
+		Name:      row.Name,
+		Role:      OrgRoleOwner,
+		CreatedAt: ISOTime(row.CreatedAt),
+	}, nil
+}
+
+// ListOrganizations returns the organizations the caller is a member of.
+func (s *Service) ListOrganizations(ctx context.Context) ([]Organization, error) {
+	ctx, span := tracing.Start(ctx, "service.ListOrganizations")
+	defer span.End()
+
+	userID, err := principalUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.repo.ListUserOrganizations(ctx, userID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] s.repo.ListUserOrganizations(ctx, userID=%q) failed", userID)
+	}
+
+	orgs := make([]Organization, 0, len(rows))
+	for _, row := range rows {
+		orgs = append(orgs, Organization{
+			ID:        row.OrgID,
+			Name:      row.Name,
+			Role:      row.Role,
+			CreatedAt: ISOTime(row.CreatedAt),
+		})
+	}
+	return orgs, nil
+}
+
+// GetOrganization returns an organization the caller is a member of.
+func (s *Service) GetOrganization(ctx context.Context, orgID string) (Organization, error) {
+	ctx, span := tracing.Start(ctx, "service.GetOrganization")
+	defer span.End()
+
+	m, err := s.requireOrgRole(ctx, orgID, OrgRoleMember)
+	if err != nil {
+		return Organization{}, err
+	}
+
+	row, err := s.repo.GetOrganization(ctx, orgID)
+	if err != nil {
+		if errors.Is(err, store.ErrOrganizationNotFound) {
+			return Organization{}, ErrOrgNotFound
+		}
+		return Organization{}, errors.Wrapf(err, "[service] s.repo.GetOrganization(ctx, orgID=%q) failed", orgID)
+	}
+
+	return Organization{
+		ID:        row.OrgID,
+		Name:      row.Name,
+		Role:      m.Role,
+		CreatedAt: ISOTime(row.CreatedAt),
+	}, nil
+}
+
+// DeleteOrganization deletes an organization with its memberships and
+// invitations. Only owners may delete an organization.
+func (s *Service) DeleteOrganization(ctx context.Context, orgID string) error {
+	ctx, span := tracing.Start(ctx, "service.DeleteOrganization")
+	defer span.End()
+
+	if _, err := s.requireOrgRole(ctx, orgID, OrgRoleOwner); err != nil {
+		return err
+	}
+
+	if err := s.repo.DeleteOrganization(ctx, orgID); err != nil {
+		if errors.Is(err, store.ErrOrganizationNotFound) {
+			return ErrOrgNotFound
+		}
+		return errors.Wrapf(err, "[service] s.repo.DeleteOrganization(ctx, orgID=%q) failed", orgID)
+	}
+	return nil
+}
+
+// ListOrgMembers returns the members of an organization the caller is a
+// member of.
+func (s *Service) ListOrgMembers(ctx context.Context, orgID string) ([]OrgMember, error) {
+	ctx, span := tracing.Start(ctx, "service.ListOrgMembers")
+	defer span.End()
+
+	if _, err := s.requireOrgRole(ctx, orgID, OrgRoleMember); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.repo.ListOrgMembers(ctx, orgID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] s.repo.ListOrgMembers(ctx, orgID=%q) failed", orgID)
+	}
+
+	members := make([]OrgMember, 0, len(rows))
+	for _, row := range rows {
+		members = append(members, orgMemberFromRow(row))
+	}
+	return members, nil
+}
+
+// UpdateOrgMemberRole changes the role of a member. Admins may change the
+// roles of admins and members; only owners may grant or remove the owner
+// role. The last owner cannot be demoted.
+func (s *Service) UpdateOrgMemberRole(ctx context.Context, orgID, userID, role string) (OrgMember, error) {
+	ctx, span := tracing.Start(ctx, "service.UpdateOrgMemberRole")
+	defer span.End()
+
+	if !isValidOrgRole(role) {
+		return OrgMember{}, ErrOrgRoleInvalid
+	}
+	caller, err := s.requireOrgRole(ctx, orgID, OrgRoleAdmin)
+	if err != nil {
+		return OrgMember{}, err
+	}
+
+	target, err := s.repo.GetOrgMember(ctx, orgID, userID)
+	if err != nil {
+		if errors.Is(err, store.ErrOrgMemberNotFound) {
+			return OrgMember{}, ErrOrgMemberNotFound
+		}
+		return OrgMember{}, errors.Wrapf(err, "[service] s.repo.GetOrgMember(ctx, orgID=%q, userID=%q) failed", orgID, userID)
+	}
+	if (role == OrgRoleOwner || target.Role == OrgRoleOwner) && caller.Role != OrgRoleOwner {
+		return OrgMember{}, ErrOrgForbidden
+	}
+
+	if err := s.repo.UpdateOrgMemberRole(ctx, orgID, userID, role); err != nil {
+		if errors.Is(err, store.ErrOrgMemberNotFound) {
+			return OrgMember{}, ErrOrgMemberNotFound
+		}
+		if errors.Is(err, store.ErrOrgLastOwner) {
+			return OrgMember{}, ErrOrgLastOwner
+		}
+		return OrgMember{}, errors.Wrapf(err, "[service] s.repo.UpdateOrgMemberRole(ctx, orgID=%q, userID=%q) failed", orgID, userID)
+	}
+	if err := s.recordAudit(ctx, AuditOrgRoleChange, userID, map[string]any{
+		"org_id":        orgID,
+		"role":          role,
+		"previous_role": target.Role,
+	}); err != nil {
+		return OrgMember{}, err
+	}
+
+	target.Role = role
+	return orgMemberFromRow(target), nil
+}
+
+// RemoveOrgMember removes a member from an organization. Any member may
+// remove themselves; admins may remove admins and members and owners may
+// remove anyone. The last owner cannot be removed.
+func (s *Service) RemoveOrgMember(ctx context.Context, orgID, userID string) error {
+	ctx, span := tracing.Start(ctx, "service.RemoveOrgMember")
+	defer span.End()
+
+	caller, err := s.requireOrgRole(ctx, orgID, OrgRoleMember)
+	if err != nil {
+		return err
+	}
+
+	target := caller
+	if userID != caller.UserID {
+		if caller.Role == OrgRoleMember {
+			return ErrOrgForbidden
+		}
+		if target, err = s.repo.GetOrgMember(ctx, orgID, userID); err != nil {
+			if errors.Is(err, store.ErrOrgMemberNotFound) {
+				return ErrOrgMemberNotFound
+			}
+			return errors.Wrapf(err, "[service] s.repo.GetOrgMember(ctx, orgID=%q, userID=%q) failed", orgID, userID)
+		}
+		if target.Role == OrgRoleOwner && caller.Role != OrgRoleOwner {
+			return ErrOrgForbidden
+		}
+	}
+
+	if err := s.repo.DeleteOrgMember(ctx, orgID, userID); err != nil {
+		if errors.Is(err, store.ErrOrgMemberNotFound) {
+			return ErrOrgMemberNotFound
+		}
+		if errors.Is(err, store.ErrOrgLastOwner) {
+			return ErrOrgLastOwner
+		}
+		return errors.Wrapf(err, "[service] s.repo.DeleteOrgMember(ctx, orgID=%q, userID=%q) failed", orgID, userID)
+	}
+	return s.recordAudit(ctx, AuditOrgMemberRemove, userID, map[string]any{
+		"org_id": orgID,
+		"role":   target.Role,
+	})
+}
+
+// InviteToOrg invites an email address to join an organization with the
+// given role and emails the invitation. Inviting the same email again
+// replaces the previous invitation. Admins may invite admins and members;
+// only owners may invite owners.
+func (s *Service) InviteToOrg(ctx context.Context, orgID, email, role string) (OrgInvitation, error) {
+	ctx, span := tracing.Start(ctx, "service.InviteToOrg")
+	defer span.End()
+
+	if !isValidOrgRole(role) {
+		return OrgInvitation{}, ErrOrgRoleInvalid
+	}
+	caller, err := s.requireOrgRole(ctx, orgID, OrgRoleAdmin)
+	if err != nil {
+		return OrgInvitation{}, err
+	}
+	if orgRoleRank(role) > orgRoleRank(caller.Role) {
+		return OrgInvitation{}, ErrOrgForbidden
+	}
+
+	email = normalizeEmail(email)
+	if existing, err := s.repo.GetUserByEmail(ctx, email); err == nil {
+		if _, err := s.repo.GetOrgMember(ctx, orgID, existing.UserID); err == nil {
+			return OrgInvitation{}, ErrOrgAlreadyMember
+		} else if !errors.Is(err, store.ErrOrgMemberNotFound) {
+			return OrgInvitation{}, errors.Wrap(err, "[service] s.repo.GetOrgMember failed")
+		}
+	} else if !errors.Is(err, store.ErrUserNotFound) {
+		return OrgInvitation{}, errors.Wrap(err, "[service] s.repo.GetUserByEmail failed")
+	}
+
+	token, hash, err := newToken()
+	if err != nil {
+		return OrgInvitation{}, err
+	}
+	invitationID, err := base58.RandString(22)
+	if err != nil {
+		return OrgInvitation{}, errors.Wrap(err, "[service] failed to generate random base58 string")
+	}
+
+	row, err := s.repo.UpsertOrgInvitation(ctx, store.AddOrgInvitation{
+		InvitationID: invitationID,
+		OrgID:        orgID,
+		Email:        email,
+		Role:         role,
+		TokenHash:    hash,
+		InvitedBy:    caller.UserID,
+		ExpiresAt:    store.Datetime(time.Now().UTC().Add(orgInvitationTTL)),
+	})
+	if err != nil {
+		return OrgInvitation{}, errors.Wrap(err, "[service] s.repo.UpsertOrgInvitation failed")
+	}
+
+	msg := mail.Message{
+		To:      email,
+		Subject: fmt.Sprintf("You have been invited to join %s", row.OrgName),
+		Body: fmt.Sprintf("%s has invited you to join %s as %s %s. The invitation expires in %d days.\n\n%s/v1/invitations/%s\n\n"+
+			"If you were not expecting this invitation you can ignore it.\n",
+			caller.Email, row.OrgName, article(role), role, int(orgInvitationTTL.Hours()/24), s.publicURL(ctx), token),
+	}
+	go func(ctx context.Context) {
+		if err := s.mailer.Send(ctx, msg); err != nil {
+			log.WithContext(ctx).Errorf("[service] send invitation_id=%s for org_id=%s failed: %+v",
+				row.InvitationID, orgID, err)
+		}
+	}(context.WithoutCancel(ctx))
+
+	return orgInvitationFromRow(row), nil
+}
+
+func article(role string) string {
+	if role == OrgRoleOwner || role == OrgRoleAdmin {
+		return "an"
+	}
+	return "a"
+}
+
+// ListOrgInvitations returns the pending invitations of an organization.
+// Only admins and owners may list invitations.
+func (s *Service) ListOrgInvitations(ctx context.Context, orgID string) ([]OrgInvitation, error) {
+	ctx, span := tracing.Start(ctx, "service.ListOrgInvitations")
+	defer span.End()
+
+	if _, err := s.requireOrgRole(ctx, orgID, OrgRoleAdmin); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.repo.ListOrgInvitations(ctx, orgID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] s.repo.ListOrgInvitations(ctx, orgID=%q) failed", orgID)
+	}
+
+	invitations := make([]OrgInvitation, 0, len(rows))
+	for _, row := range rows {
+		invitations = append(invitations, orgInvitationFromRow(row))
+	}
+	return invitations, nil
+}
+
+// RevokeOrgInvitation deletes a pending invitation. Only admins and owners
+// may revoke invitations.
+func (s *Service) RevokeOrgInvitation(ctx context.Context, orgID, invitationID string) error {
+	ctx, span := tracing.Start(ctx, "service.RevokeOrgInvitation")
+	defer span.End()
+
+	if _, err := s.requireOrgRole(ctx, orgID, OrgRoleAdmin); err != nil {
+		return err
+	}
+
+	if err := s.repo.DeleteOrgInvitation(ctx, orgID, invitationID); err != nil {
+		if errors.Is(err, store.ErrOrgInvitationNotFound) {
+			return ErrOrgInvitationNotFound
+		}
+		return errors.Wrapf(err, "[service] s.repo.DeleteOrgInvitation(ctx, orgID=%q, invitationID=%q) failed",
+			orgID, invitationID)
+	}
+	return nil
+}
+
+// GetOrgInvitation returns the invitation for an invitation token so that
+// it can be shown before being accepted or declined. The token is the
+// only credential required. ErrOrgInvitationInvalid is returned if the
+// token is unknown or the invitation has expired.
+func (s *Service) GetOrgInvitation(ctx context.Context, token string) (OrgInvitation, error) {
+	ctx, span := tracing.Start(ctx, "service.GetOrgInvitation")
+	defer span.End()
+
+	row, err := s.repo.GetOrgInvitationByTokenHash(ctx, hashToken(token))
+	if err != nil {
+		if errors.Is(err, store.ErrOrgInvitationNotFound) {
+			return OrgInvitation{}, ErrOrgInvitationInvalid
+		}
+		return OrgInvitation{}, errors.Wrap(err, "[service] s.repo.GetOrgInvitationByTokenHash failed")
+	}
+	if time.Now().After(time.Time(row.ExpiresAt)) {
+		return OrgInvitation{}, ErrOrgInvitationInvalid
+	}
+	return orgInvitationFromRow(row), nil
+}
+
+// AcceptOrgInvitation adds the caller to the organization with the invited
+// role. The caller's email must match the invited email.
+func (s *Service) AcceptOrgInvitation(ctx context.Context, token string) (Organization, error) {
+	ctx, span := tracing.Start(ctx, "service.AcceptOrgInvitation")
+	defer span.End()
+
+	userID, err := principalUserID(ctx)
+	if err != nil {
+		return Organization{}, err
+	}
+	inv, err := s.GetOrgInvitation(ctx, token)
+	if err != nil {
+		return Organization{}, err
+	}
+	user, err := s.GetUser(ctx, userID)
+	if err != nil {
+		return Organization{}, err
+	}
+	if !strings.EqualFold(user.Email, inv.Email) {
+		return Organization{}, ErrOrgInvitationEmailMismatch
+	}
+
+	m, err := s.repo.AcceptOrgInvitation(ctx, hashToken(token), userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrOrgInvitationNotFound):
+			return Organization{}, ErrOrgInvitationInvalid
+		case errors.Is(err, store.ErrOrgMemberExists):
+			return Organization{}, ErrOrgAlreadyMember
+		}
+		return Organization{}, errors.Wrap(err, "[service] s.repo.AcceptOrgInvitation failed")
+	}
+
+	return s.GetOrganization(ctx, m.OrgID)
+}
+
+// DeclineOrgInvitation deletes the invitation for an invitation token.
+// Like GetOrgInvitation the token is the only credential required.
+func (s *Service) DeclineOrgInvitation(ctx context.Context, token string) error {
+	ctx, span := tracing.Start(ctx, "service.DeclineOrgInvitation")
+	defer span.End()
+
+	if err := s.repo.DeclineOrgInvitation(ctx, hashToken(token)); err != nil {
+		if errors.Is(err, store.ErrOrgInvitationNotFound) {
+			return ErrOrgInvitationInvalid
+		}
+		return errors.Wrap(err, "[service] s.repo.DeclineOrgInvitation failed")
+	}
+	return nil
+}
+
+func orgMemberFromRow(row store.OrgMember) OrgMember {
+	return OrgMember{
+		UserID:   row.UserID,
+		Email:    row.Email,
+		Role:     row.Role,
+		JoinedAt: ISOTime(row.CreatedAt),
+	}
+}
+
+func orgInvitationFromRow(row store.OrgInvitation) OrgInvitation {
+	return OrgInvitation{
+		ID:        row.InvitationID,
+		OrgID:     row.OrgID,
+		OrgName:   row.OrgName,
+		Email:     row.Email,
+		Role:      row.Role,
+		InvitedBy: row.InvitedBy,
+		ExpiresAt: ISOTime(row.ExpiresAt),
+		CreatedAt: ISOTime(row.CreatedAt),
+	}
+}
@@ -0,0 +1,161 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andyfusniak/monolith/internal/store"
+)
+
+// asUser returns ctx with a session principal for user.
+func asUser(ctx context.Context, user User) context.Context {
+	return ContextWithPrincipal(ctx, Principal{UserID: user.ID})
+}
+
+// addTestOrgMember adds user to the organization with role.
+func addTestOrgMember(t *testing.T, s *Service, orgID string, user User, role string) {
+	t.Helper()
+	ctx := context.Background()
+	hash := hashToken("token-" + user.ID)
+	if _, err := s.repo.UpsertOrgInvitation(ctx, store.AddOrgInvitation{
+		InvitationID: "inv-" + user.ID,
+		OrgID:        orgID,
+		Email:        user.Email,
+		Role:         role,
+		TokenHash:    hash,
+		InvitedBy:    user.ID,
+		ExpiresAt:    store.Datetime(time.Now().UTC().Add(time.Hour)),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.repo.AcceptOrgInvitation(ctx, hash, user.ID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRemoveOrgMemberLastOwner(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+	alice := newTestUser(t, s, "alice@example.com")
+	bob := newTestUser(t, s, "bob@example.com")
+
+	org, err := s.CreateOrganization(asUser(ctx, alice), "Example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addTestOrgMember(t, s, org.ID, bob, OrgRoleOwner)
+
+	// both owners leave at once; only one may succeed
+	errs := make([]error, 2)
+	var wg sync.WaitGroup
+	for i, u := range []User{alice, bob} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = s.RemoveOrgMember(asUser(ctx, u), org.ID, u.ID)
+		}()
+	}
+	wg.Wait()
+
+	var removed, refused int
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			removed++
+		case errors.Is(err, ErrOrgLastOwner):
+			refused++
+		default:
+			t.Fatalf("RemoveOrgMember: %v", err)
+		}
+	}
+	if removed != 1 || refused != 1 {
+		t.Errorf("RemoveOrgMember errors = %v, want one success and one %v", errs, ErrOrgLastOwner)
+	}
+}
+
+func TestUpdateOrgMemberRoleLastOwner(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+	alice := newTestUser(t, s, "alice@example.com")
+	bob := newTestUser(t, s, "bob@example.com")
+
+	org, err := s.CreateOrganization(asUser(ctx, alice), "Example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.UpdateOrgMemberRole(asUser(ctx, alice), org.ID, alice.ID, OrgRoleAdmin); !errors.Is(err, ErrOrgLastOwner) {
+		t.Fatalf("demoting the only owner error = %v, want %v", err, ErrOrgLastOwner)
+	}
+
+	addTestOrgMember(t, s, org.ID, bob, OrgRoleOwner)
+	if _, err := s.UpdateOrgMemberRole(asUser(ctx, alice), org.ID, alice.ID, OrgRoleAdmin); err != nil {
+		t.Fatalf("UpdateOrgMemberRole: %v", err)
+	}
+	if _, err := s.UpdateOrgMemberRole(asUser(ctx, bob), org.ID, bob.ID, OrgRoleMember); !errors.Is(err, ErrOrgLastOwner) {
+		t.Fatalf("demoting the remaining owner error = %v, want %v", err, ErrOrgLastOwner)
+	}
+}
+
+func TestAcceptOrgInvitationMixedCaseEmail(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t, WithMailer(make(chanMailer, 1)))
+	alice := newTestUser(t, s, "alice@example.com")
+	bob := newTestUser(t, s, "Bob@Example.com")
+
+	org, err := s.CreateOrganization(asUser(ctx, alice), "Example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.InviteToOrg(asUser(ctx, alice), org.ID, "BOB@example.COM", OrgRoleMember); err != nil {
+		t.Fatalf("InviteToOrg: %v", err)
+	}
+	addTestOrgMember(t, s, org.ID, bob, OrgRoleMember)
+
+	if _, err := s.InviteToOrg(asUser(ctx, alice), org.ID, "bob@EXAMPLE.com", OrgRoleMember); !errors.Is(err, ErrOrgAlreadyMember) {
+		t.Errorf("inviting a member again error = %v, want %v", err, ErrOrgAlreadyMember)
+	}
+}
+
+func TestOrgMemberAudit(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+	alice := newTestUser(t, s, "alice@example.com")
+	bob := newTestUser(t, s, "bob@example.com")
+
+	org, err := s.CreateOrganization(asUser(ctx, alice), "Example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addTestOrgMember(t, s, org.ID, bob, OrgRoleMember)
+
+	if _, err := s.UpdateOrgMemberRole(asUser(ctx, alice), org.ID, bob.ID, OrgRoleAdmin); err != nil {
+		t.Fatalf("UpdateOrgMemberRole: %v", err)
+	}
+	if err := s.RemoveOrgMember(asUser(ctx, alice), org.ID, bob.ID); err != nil {
+		t.Fatalf("RemoveOrgMember: %v", err)
+	}
+
+	tests := []struct {
+		action  string
+		details string
+	}{
+		{AuditOrgRoleChange, `{"org_id":"` + org.ID + `","previous_role":"member","role":"admin"}`},
+		{AuditOrgMemberRemove, `{"org_id":"` + org.ID + `","role":"admin"}`},
+	}
+	for _, tt := range tests {
+		events := auditEvents(t, s, tt.action)
+		if len(events) != 1 {
+			t.Fatalf("got %d %s events, want 1", len(events), tt.action)
+		}
+		e := events[0]
+		if e.ActorID == nil || *e.ActorID != alice.ID || e.SubjectID == nil || *e.SubjectID != bob.ID {
+			t.Errorf("%s actor_id = %v, subject_id = %v, want %s and %s", tt.action, e.ActorID, e.SubjectID, alice.ID, bob.ID)
+		}
+		if e.Details != tt.details {
+			t.Errorf("%s details = %s, want %s", tt.action, e.Details, tt.details)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultPasswordMinRunes = 8
+	defaultPasswordMaxRunes = 64
+)
+
+var (
+	ErrUserPasswordTooLong   = errors.New("password too long")
+	ErrUserPasswordTooCommon = errors.New("password too common")
+	ErrUserPasswordBreached  = errors.New("password found in breach corpus")
+)
+
+//go:embed passwords/common-passwords.txt
+var commonPasswordsFile []byte
+
+// commonPasswords is the embedded list of banned passwords, lower cased.
+var commonPasswords = parseCommonPasswords(commonPasswordsFile)
+
+func parseCommonPasswords(b []byte) map[string]struct{} {
+	m := make(map[string]struct{})
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		m[strings.ToLower(line)] = struct{}{}
+	}
+	return m
+}
+
+// BreachChecker reports whether a password appears in a corpus of
+// previously breached passwords.
+type BreachChecker interface {
+	IsBreached(ctx context.Context, password string) (bool, error)
+}
+
+// PasswordPolicy defines the rules a new password must satisfy. Lengths
+// are measured in runes rather than bytes so multi-byte characters count
+// as a single character.
+type PasswordPolicy struct {
+	// MinRunes is the minimum number of characters.
+	MinRunes int
+
+	// MaxRunes is the maximum number of characters. An upper bound stops
+	// clients submitting very large inputs to the argon2id hash function.
+	MaxRunes int
+
+	// BanCommon rejects passwords found in the embedded list of
+	// commonly used passwords.
+	BanCommon bool
+
+	// Breaches is an optional BreachChecker. If nil, no breach check
+	// is made.
+	Breaches BreachChecker
+}
+
+// DefaultPasswordPolicy returns the policy used if the service is not
+// configured using WithPasswordPolicy.
+func DefaultPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{
+		MinRunes:  defaultPasswordMinRunes,
+		MaxRunes:  defaultPasswordMaxRunes,
+		BanCommon: true,
+	}
+}
+
+// Validate checks password against the policy. It returns one of
+// ErrUserPasswordTooShort, ErrUserPasswordTooLong, ErrUserPasswordTooCommon
+// or ErrUserPasswordBreached if the password is rejected.
+func (p PasswordPolicy) Validate(ctx context.Context, password string) error {
+	n := utf8.RuneCountInString(password)
+	if n < p.MinRunes {
+		return ErrUserPasswordTooShort
+	}
+	if p.MaxRunes > 0 && n > p.MaxRunes {
+		return ErrUserPasswordTooLong
+	}
+
+	if p.BanCommon {
+		if _, ok := commonPasswords[strings.ToLower(password)]; ok {
+			return ErrUserPasswordTooCommon
+		}
+	}
+
+	if p.Breaches != nil {
+		breached, err := p.Breaches.IsBreached(ctx, password)
+		if err != nil {
+			return errors.Wrap(err, "[service] breach check failed")
+		}
+		if breached {
+			return ErrUserPasswordBreached
+		}
+	}
+
+	return nil
+}
+
+// PasswordPolicy returns the password policy used by the service.
+func (s *Service) PasswordPolicy() PasswordPolicy {
+	return s.passwordPolicy
+}
+
+// PwnedPasswords is a BreachChecker backed by a local copy of the Have I
+// Been Pwned SHA-1 password hashes. No network calls are made.
+//
+// Two layouts are supported. If path is a regular file it must be the
+// single hash-ordered file with lines of the form HASH:COUNT. If path is a
+// directory it must contain one file per 5 character hash prefix (for
+// example 21BD1 or 21BD1.txt) with lines of the form SUFFIX:COUNT, as
+// returned by the range API.
+//
+// In both cases lookups follow the k-anonymity model: only the range of
+// hashes sharing the first 5 hex characters of the password hash is read,
+// and the remaining suffix is compared in memory.
+type PwnedPasswords struct {
+	path  string
+	isDir bool
+}
+
+// NewPwnedPasswords returns a PwnedPasswords for the file or directory at
+// path.
+func NewPwnedPasswords(path string) (*PwnedPasswords, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] stat pwned passwords path %q failed", path)
+	}
+	return &PwnedPasswords{
+		path:  path,
+		isDir: fi.IsDir(),
+	}, nil
+}
+
+// IsBreached returns true if the SHA-1 hash of password is found.
+func (p *PwnedPasswords) IsBreached(ctx context.Context, password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	h := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := h[:5], h[5:]
+
+	suffixes, err := p.Range(prefix)
+	if err != nil {
+		return false, err
+	}
+	for _, s := range suffixes {
+		if s == suffix {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Range returns the upper case hash suffixes for the given 5 character
+// hash prefix.
+func (p *PwnedPasswords) Range(prefix string) ([]string, error) {
+	prefix = strings.ToUpper(prefix)
+	if p.isDir {
+		return p.rangeFromDir(prefix)
+	}
+	return p.rangeFromFile(prefix)
+}
+
+func (p *PwnedPasswords) rangeFromDir(prefix string) ([]string, error) {
+	var f *os.File
+	var err error
+	for _, name := range []string{prefix, prefix + ".txt"} {
+		f, err = os.Open(filepath.Join(p.path, name))
+		if err == nil {
+			break
+		}
+		if !os.IsNotExist(err) {
+			return nil, errors.Wrapf(err, "[service] open pwned passwords range %q failed", prefix)
+		}
+	}
+	if f == nil {
+		// missing range files are treated as empty ranges
+		return nil, nil
+	}
+	defer f.Close()
+
+	var suffixes []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if s := hashFromLine(sc.Text()); s != "" {
+			suffixes = append(suffixes, s)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, errors.Wrapf(err, "[service] read pwned passwords range %q failed", prefix)
+	}
+	return suffixes, nil
+}
+
+func (p *PwnedPasswords) rangeFromFile(prefix string) ([]string, error) {
+	f, err := os.Open(p.path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] open pwned passwords file %q failed", p.path)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] stat pwned passwords file %q failed", p.path)
+	}
+
+	// binary search for the first line with a hash >= prefix
+	lo, hi := int64(0), fi.Size()
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		start, err := lineStart(f, mid)
+		if err != nil {
+			return nil, err
+		}
+		if start >= hi {
+			hi = mid
+			continue
+		}
+		line, err := readLine(f, start)
+		if err != nil {
+			return nil, err
+		}
+		if hashFromLine(line) < prefix {
+			lo = start + int64(len(line)) + 1
+		} else {
+			hi = mid
+		}
+	}
+	start, err := lineStart(f, lo)
+	if err != nil {
+		return nil, err
+	}
+
+	var suffixes []string
+	sc := bufio.NewScanner(io.NewSectionReader(f, start, fi.Size()-start))
+	for sc.Scan() {
+		h := hashFromLine(sc.Text())
+		if !strings.HasPrefix(h, prefix) {
+			break
+		}
+		suffixes = append(suffixes, h[len(prefix):])
+	}
+	if err := sc.Err(); err != nil {
+		return nil, errors.Wrapf(err, "[service] read pwned passwords file %q failed", p.path)
+	}
+	return suffixes, nil
+}
+
+// lineStart returns the offset of the first line starting at or after off.
+func lineStart(r io.ReaderAt, off int64) (int64, error) {
+	if off == 0 {
+		return 0, nil
+	}
+	buf := make([]byte, 128)
+	pos := off - 1
+	for {
+		n, err := r.ReadAt(buf, pos)
+		if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+			return pos + int64(i) + 1, nil
+		}
+		if err == io.EOF {
+			return pos + int64(n), nil
+		}
+		if err != nil {
+			return 0, errors.Wrap(err, "[service] pwned passwords read failed")
+		}
+		pos += int64(n)
+	}
+}
+
+// readLine returns the line beginning at off without the trailing newline.
+func readLine(r io.ReaderAt, off int64) (string, error) {
+	buf := make([]byte, 128)
+	n, err := r.ReadAt(buf, off)
+	if err != nil && err != io.EOF {
+		return "", errors.Wrap(err, "[service] pwned passwords read failed")
+	}
+	line := buf[:n]
+	if i := bytes.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	return string(line), nil
+}
+
+// hashFromLine returns the upper case hash from a HASH:COUNT line.
+func hashFromLine(line string) string {
+	line = strings.TrimRight(line, "\r")
+	if i := strings.IndexByte(line, ':'); i >= 0 {
+		line = line[:i]
+	}
+	return strings.ToUpper(strings.TrimSpace(line))
+}
@@ -0,0 +1,185 @@
+package service
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// sha1Hex returns the upper case hex SHA-1 hash of password.
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// writePwnedFile writes the hash-ordered HASH:COUNT file of lines and
+// returns its path.
+func writePwnedFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "pwned-passwords-sha1-ordered-by-hash.txt")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPwnedPasswordsRangeFromFile(t *testing.T) {
+	file := strings.Join([]string{
+		"0000000000000000000000000000000000000001:3",
+		"0000000000000000000000000000000000000002:1",
+		"21BD10018A45C4D1DEF81644B54AB7F969B88D65:10",
+		"21BD1001C2A8C5C9A0E9BD38B9D1F5B1B8E84FE2:2",
+		"21BD2000000000000000000000000000000000AA:7",
+		"FFFFF00000000000000000000000000000000000:4",
+		"FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF:9",
+	}, "\n") + "\n"
+
+	tests := []struct {
+		name    string
+		content string
+		prefix  string
+		want    []string
+	}{
+		{name: "first line", content: file, prefix: "00000",
+			want: []string{"00000000000000000000000000000000001", "00000000000000000000000000000000002"}},
+		{name: "middle", content: file, prefix: "21BD1",
+			want: []string{"0018A45C4D1DEF81644B54AB7F969B88D65", "001C2A8C5C9A0E9BD38B9D1F5B1B8E84FE2"}},
+		{name: "single line range", content: file, prefix: "21bd2",
+			want: []string{"000000000000000000000000000000000AA"}},
+		{name: "last line", content: file, prefix: "FFFFF",
+			want: []string{"00000000000000000000000000000000000", "FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF"}},
+		{name: "missing before first", content: "21BD10018A45C4D1DEF81644B54AB7F969B88D65:10\n", prefix: "00000"},
+		{name: "missing between", content: file, prefix: "21BD0"},
+		{name: "missing after last", content: strings.TrimSuffix(file, "FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF:9\n"), prefix: "FFFFE"},
+		{name: "no trailing newline", content: strings.TrimSuffix(file, "\n"), prefix: "FFFFF",
+			want: []string{"00000000000000000000000000000000000", "FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF"}},
+		{name: "crlf line endings", content: strings.ReplaceAll(file, "\n", "\r\n"), prefix: "21BD1",
+			want: []string{"0018A45C4D1DEF81644B54AB7F969B88D65", "001C2A8C5C9A0E9BD38B9D1F5B1B8E84FE2"}},
+		{name: "lower case and no count", content: "21bd10018a45c4d1def81644b54ab7f969b88d65\n", prefix: "21BD1",
+			want: []string{"0018A45C4D1DEF81644B54AB7F969B88D65"}},
+		{name: "blank lines", content: "\n\n" + file, prefix: "00000",
+			want: []string{"00000000000000000000000000000000001", "00000000000000000000000000000000002"}},
+		{name: "empty file", content: "", prefix: "00000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewPwnedPasswords(writePwnedFile(t, tt.content))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := p.Range(tt.prefix)
+			if err != nil {
+				t.Fatalf("Range(%q): %v", tt.prefix, err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Range(%q) = %q, want %q", tt.prefix, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPwnedPasswordsRangeFromFileEveryLine(t *testing.T) {
+	// every line of a file long enough to need many probes is found
+	var lines []string
+	for i := 0; i < 500; i++ {
+		lines = append(lines, sha1Hex(strings.Repeat("x", i+1))+":1")
+	}
+	slices.Sort(lines)
+	p, err := NewPwnedPasswords(writePwnedFile(t, strings.Join(lines, "\n")+"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range lines {
+		h := line[:40]
+		got, err := p.Range(h[:5])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Contains(got, h[5:]) {
+			t.Fatalf("Range(%q) = %q, missing %s", h[:5], got, h[5:])
+		}
+	}
+}
+
+func TestPwnedPasswordsRangeFromDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "21BD1.txt"), []byte("0018A45C4D1DEF81644B54AB7F969B88D65:10\r\n001C2A8C5C9A0E9BD38B9D1F5B1B8E84FE2:2\r\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewPwnedPasswords(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := p.Range("21bd1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"0018A45C4D1DEF81644B54AB7F969B88D65", "001C2A8C5C9A0E9BD38B9D1F5B1B8E84FE2"}; !slices.Equal(got, want) {
+		t.Errorf("Range = %q, want %q", got, want)
+	}
+	if got, err := p.Range("00000"); err != nil || got != nil {
+		t.Errorf("Range of a missing range file = %q, %v, want empty", got, err)
+	}
+}
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	breached := "correct horse battery staple"
+	pwned, err := NewPwnedPasswords(writePwnedFile(t, sha1Hex(breached)+":42\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := DefaultPasswordPolicy()
+	policy.Breaches = pwned
+
+	tests := []struct {
+		name     string
+		password string
+		want     error
+	}{
+		{name: "too short", password: "abc1234", want: ErrUserPasswordTooShort},
+		{name: "min length", password: "w9#kT2vq"},
+		{name: "min length in runes", password: "äöüäöüäö"},
+		{name: "multi-byte too short", password: "äöüäöüä", want: ErrUserPasswordTooShort},
+		{name: "max length", password: strings.Repeat("z", defaultPasswordMaxRunes)},
+		{name: "too long", password: strings.Repeat("z", defaultPasswordMaxRunes+1), want: ErrUserPasswordTooLong},
+		{name: "common", password: "00000000", want: ErrUserPasswordTooCommon},
+		{name: "breached", password: breached, want: ErrUserPasswordBreached},
+		{name: "not breached", password: breached + "!"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Validate(context.Background(), tt.password)
+			if !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+				t.Errorf("Validate(%q) = %v, want %v", tt.password, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateUserPasswordPolicy(t *testing.T) {
+	ctx := context.Background()
+	pwned, err := NewPwnedPasswords(writePwnedFile(t, sha1Hex("breached password 1")+":3\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := DefaultPasswordPolicy()
+	policy.Breaches = pwned
+	s := newTestService(t, WithPasswordPolicy(policy))
+
+	if _, err := s.CreateUser(ctx, "alice@example.com", "short"); !errors.Is(err, ErrUserPasswordTooShort) {
+		t.Errorf("CreateUser with a short password error = %v, want %v", err, ErrUserPasswordTooShort)
+	}
+	if _, err := s.CreateUser(ctx, "alice@example.com", "breached password 1"); !errors.Is(err, ErrUserPasswordBreached) {
+		t.Errorf("CreateUser with a breached password error = %v, want %v", err, ErrUserPasswordBreached)
+	}
+}
@@ -0,0 +1,373 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/andyfusniak/base58"
+	"github.com/andyfusniak/monolith/internal/store"
+	"github.com/andyfusniak/monolith/internal/tracing"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const userExportTTL = 7 * 24 * time.Hour
+
+var (
+	ErrUserExportNotFound = errors.New("user export not found")
+	ErrUserExportNotReady = errors.New("user export not ready")
+	ErrUserExportsStopped = errors.New("user exports stopped")
+	ErrUserSoleOwner      = errors.New("user is the only owner of an organization with other members")
+)
+
+// UserExport is a request for an archive of a user's data. The archive is
+// built in the background; Status is "pending" until it is "ready" to
+// download or has "failed".
+type UserExport struct {
+	ID          string   `json:"export_id"`
+	UserID      string   `json:"user_id"`
+	Status      string   `json:"status"`
+	CreatedAt   ISOTime  `json:"created_at"`
+	CompletedAt *ISOTime `json:"completed_at"`
+	ExpiresAt   ISOTime  `json:"expires_at"`
+}
+
+// UserArchive is all data held about a user. Secrets such as password
+// hashes, token hashes and TOTP secrets are not included.
+type UserArchive struct {
+	ExportedAt          ISOTime              `json:"exported_at"`
+	User                User                 `json:"user"`
+	Roles               []string             `json:"roles"`
+	TOTPEnabled         bool                 `json:"totp_enabled"`
+	Sessions            []Session            `json:"sessions"`
+	APIKeys             []APIKey             `json:"api_keys"`
+	Identities          []UserIdentity       `json:"identities"`
+	WebAuthnCredentials []WebAuthnCredential `json:"webauthn_credentials"`
+	Organizations       []Organization       `json:"organizations"`
+	AuditEvents         []AuditEvent         `json:"audit_events"`
+}
+
+// RequestUserExport starts building an archive of the user's data in the
+// background. Poll GetUserExport until it is ready, then download it
+// using UserExportArchive. Archives expire after seven days.
+// ErrUserExportsStopped is returned once StopUserExports has been called.
+func (s *Service) RequestUserExport(ctx context.Context, userID string) (UserExport, error) {
+	ctx, span := tracing.Start(ctx, "service.RequestUserExport")
+	defer span.End()
+
+	if _, err := s.GetUser(ctx, userID); err != nil {
+		return UserExport{}, err
+	}
+
+	// reserve the build before the row is inserted so StopUserExports
+	// waits for it
+	s.exportsMu.Lock()
+	stopped := s.exportsStopped
+	if !stopped {
+		s.exports.Add(1)
+	}
+	s.exportsMu.Unlock()
+	if stopped {
+		return UserExport{}, ErrUserExportsStopped
+	}
+	building := false
+	defer func() {
+		if !building {
+			s.exports.Done()
+		}
+	}()
+
+	if _, err := s.repo.DeleteExpiredUserExports(ctx, store.Datetime(time.Now().UTC())); err != nil {
+		return UserExport{}, errors.Wrap(err, "[service] s.repo.DeleteExpiredUserExports failed")
+	}
+
+	exportID, err := base58.RandString(22)
+	if err != nil {
+		return UserExport{}, errors.Wrap(err, "[service] failed to generate random base58 string")
+	}
+	row, err := s.repo.InsertUserExport(ctx, store.AddUserExport{
+		ExportID:  exportID,
+		UserID:    userID,
+		ExpiresAt: store.Datetime(time.Now().UTC().Add(userExportTTL)),
+	})
+	if err != nil {
+		return UserExport{}, errors.Wrap(err, "[service] s.repo.InsertUserExport failed")
+	}
+
+	if err := s.recordAudit(ctx, AuditUserExport, userID, map[string]any{
+		"export_id": exportID,
+	}); err != nil {
+		return UserExport{}, err
+	}
+
+	// the build outlives the request, so it holds its own reference to
+	// the tenant database
+	bctx, release := context.WithoutCancel(ctx), func() {}
+	if tenantID := store.TenantFromContext(ctx); tenantID != "" {
+		bctx, release, err = s.EnterTenant(bctx, tenantID)
+		if err != nil {
+			return UserExport{}, err
+		}
+	}
+	bctx, cancel := context.WithCancel(bctx)
+	stop := context.AfterFunc(s.exportsAbort, cancel)
+	building = true
+	go func() {
+		defer s.exports.Done()
+		defer release()
+		defer cancel()
+		defer stop()
+		s.buildUserExport(bctx, exportID, userID)
+	}()
+
+	return userExportFromRow(row), nil
+}
+
+// FailStaleUserExports marks exports still pending from before the
+// service started as failed, in every tenant's database in tenant mode.
+// Their builds were lost when the previous process stopped. It returns the
+// number of exports marked failed.
+func (s *Service) FailStaleUserExports(ctx context.Context, startedAt time.Time) (int64, error) {
+	ctx, span := tracing.Start(ctx, "service.FailStaleUserExports")
+	defer span.End()
+
+	var total int64
+	err := s.forEachTenant(ctx, func(ctx context.Context) error {
+		n, err := s.repo.FailPendingUserExports(ctx, store.Datetime(startedAt.UTC()))
+		if err != nil {
+			return errors.Wrap(err, "[service] s.repo.FailPendingUserExports failed")
+		}
+		total += n
+		return nil
+	})
+	return total, err
+}
+
+// StopUserExports refuses new exports and waits for the builds in
+// progress to finish. If ctx is done first the builds are cancelled and
+// left pending for FailStaleUserExports.
+func (s *Service) StopUserExports(ctx context.Context) error {
+	s.exportsMu.Lock()
+	s.exportsStopped = true
+	s.exportsMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.exports.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.abortExports()
+		return ctx.Err()
+	}
+}
+
+func (s *Service) buildUserExport(ctx context.Context, exportID, userID string) {
+	cl := log.WithContext(ctx)
+
+	status, archive := store.UserExportReady, (*string)(nil)
+	a, err := s.ExportUserData(ctx, userID)
+	if err == nil {
+		var b []byte
+		b, err = json.Marshal(a)
+		if err == nil {
+			v := string(b)
+			archive = &v
+		}
+	}
+	if err != nil {
+		cl.Errorf("[service] build export_id=%s for user_id=%s failed: %+v", exportID, userID, err)
+		status = store.UserExportFailed
+	}
+
+	if err := s.repo.CompleteUserExport(ctx, exportID, status, archive); err != nil {
+		cl.Errorf("[service] s.repo.CompleteUserExport(ctx, exportID=%q) failed: %+v", exportID, err)
+		return
+	}
+	cl.Infof("[service] export_id=%s for user_id=%s %s", exportID, userID, status)
+}
+
+// GetUserExport returns one of the user's exports. ErrUserExportNotFound
+// is returned if it does not exist or has expired.
+func (s *Service) GetUserExport(ctx context.Context, userID, exportID string) (UserExport, error) {
+	ctx, span := tracing.Start(ctx, "service.GetUserExport")
+	defer span.End()
+
+	row, err := s.getUserExport(ctx, userID, exportID)
+	if err != nil {
+		return UserExport{}, err
+	}
+	return userExportFromRow(row), nil
+}
+
+// UserExportArchive returns the JSON archive of a ready export.
+// ErrUserExportNotReady is returned if it is still being built or the
+// build failed.
+func (s *Service) UserExportArchive(ctx context.Context, userID, exportID string) ([]byte, error) {
+	ctx, span := tracing.Start(ctx, "service.UserExportArchive")
+	defer span.End()
+
+	row, err := s.getUserExport(ctx, userID, exportID)
+	if err != nil {
+		return nil, err
+	}
+	if row.Status != store.UserExportReady || row.Archive == nil {
+		return nil, ErrUserExportNotReady
+	}
+	return []byte(*row.Archive), nil
+}
+
+func (s *Service) getUserExport(ctx context.Context, userID, exportID string) (store.UserExport, error) {
+	row, err := s.repo.GetUserExport(ctx, userID, exportID)
+	if err != nil {
+		if errors.Is(err, store.ErrUserExportNotFound) {
+			return store.UserExport{}, ErrUserExportNotFound
+		}
+		return store.UserExport{}, errors.Wrapf(err, "[service] s.repo.GetUserExport(ctx, exportID=%q) failed", exportID)
+	}
+	if time.Now().After(time.Time(row.ExpiresAt)) {
+		return store.UserExport{}, ErrUserExportNotFound
+	}
+	return row, nil
+}
+
+// ExportUserData assembles the archive of a user's data.
+func (s *Service) ExportUserData(ctx context.Context, userID string) (UserArchive, error) {
+	ctx, span := tracing.Start(ctx, "service.ExportUserData")
+	defer span.End()
+
+	user, err := s.GetUser(ctx, userID)
+	if err != nil {
+		return UserArchive{}, err
+	}
+	a := UserArchive{
+		ExportedAt: ISOTime(time.Now()),
+		User:       user,
+	}
+
+	if a.Roles, err = s.UserRoles(ctx, userID); err != nil {
+		return UserArchive{}, err
+	}
+	if a.Roles == nil {
+		a.Roles = []string{}
+	}
+	if a.TOTPEnabled, err = s.HasTOTP(ctx, userID); err != nil {
+		return UserArchive{}, err
+	}
+
+	sessions, err := s.repo.ListUserSessions(ctx, userID)
+	if err != nil {
+		return UserArchive{}, errors.Wrapf(err, "[service] s.repo.ListUserSessions(ctx, userID=%q) failed", userID)
+	}
+	a.Sessions = make([]Session, 0, len(sessions))
+	for _, row := range sessions {
+		a.Sessions = append(a.Sessions, sessionFromRow(row))
+	}
+
+	if a.APIKeys, err = s.ListAPIKeys(ctx, userID); err != nil {
+		return UserArchive{}, err
+	}
+	if a.Identities, err = s.ListUserIdentities(ctx, userID); err != nil {
+		return UserArchive{}, err
+	}
+
+	creds, err := s.repo.ListWebAuthnCredentialsByUser(ctx, userID)
+	if err != nil {
+		return UserArchive{}, errors.Wrapf(err, "[service] s.repo.ListWebAuthnCredentialsByUser(ctx, userID=%q) failed", userID)
+	}
+	a.WebAuthnCredentials = make([]WebAuthnCredential, 0, len(creds))
+	for _, row := range creds {
+		a.WebAuthnCredentials = append(a.WebAuthnCredentials, webauthnCredentialFromRow(row))
+	}
+
+	orgs, err := s.repo.ListUserOrganizations(ctx, userID)
+	if err != nil {
+		return UserArchive{}, errors.Wrapf(err, "[service] s.repo.ListUserOrganizations(ctx, userID=%q) failed", userID)
+	}
+	a.Organizations = make([]Organization, 0, len(orgs))
+	for _, row := range orgs {
+		a.Organizations = append(a.Organizations, Organization{
+			ID:        row.OrgID,
+			Name:      row.Name,
+			Role:      row.Role,
+			CreatedAt: ISOTime(row.CreatedAt),
+		})
+	}
+
+	a.AuditEvents = []AuditEvent{}
+	filter := store.AuditEventFilter{UserID: userID, Limit: auditVerifyBatch}
+	for {
+		rows, err := s.repo.ListAuditEvents(ctx, filter)
+		if err != nil {
+			return UserArchive{}, errors.Wrap(err, "[service] s.repo.ListAuditEvents failed")
+		}
+		for _, row := range rows {
+			a.AuditEvents = append(a.AuditEvents, auditEventFromRow(row))
+		}
+		if len(rows) < filter.Limit {
+			break
+		}
+		filter.BeforeID = rows[len(rows)-1].EventID
+	}
+
+	return a, nil
+}
+
+// EraseUser deletes the user and all data tied to them in a single
+// transaction. Organizations the user is the only member of are deleted
+// with them; if the user is the only owner of an organization with other
+// members ErrUserSoleOwner is returned and ownership must be transferred
+// first.
+//
+// Audit events are kept as a record of security events but refer to the
+// user by user_id only, and the user_id is never issued again.
+//
+// When ctx carries a principal it must be the user, signed in with a
+// session, or an admin.
+func (s *Service) EraseUser(ctx context.Context, userID string) error {
+	ctx, span := tracing.Start(ctx, "service.EraseUser")
+	defer span.End()
+
+	if p, ok := PrincipalFromContext(ctx); ok {
+		if p.UserID != userID || p.APIKeyID != "" || p.ImpersonatorID != "" {
+			if _, err := s.requireAdmin(ctx); err != nil {
+				return err
+			}
+		}
+	}
+
+	event, err := s.newAuditEvent(ctx, AuditUserErase, userID, nil)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.EraseUser(ctx, userID, event); err != nil {
+		switch {
+		case errors.Is(err, store.ErrUserNotFound):
+			return ErrUserNotFound
+		case errors.Is(err, store.ErrUserSoleOwner):
+			return ErrUserSoleOwner
+		}
+		return errors.Wrapf(err, "[service] s.repo.EraseUser(ctx, userID=%q) failed", userID)
+	}
+	return nil
+}
+
+func userExportFromRow(row store.UserExport) UserExport {
+	e := UserExport{
+		ID:        row.ExportID,
+		UserID:    row.UserID,
+		Status:    row.Status,
+		CreatedAt: ISOTime(row.CreatedAt),
+		ExpiresAt: ISOTime(row.ExpiresAt),
+	}
+	if row.CompletedAt != nil {
+		t := ISOTime(*row.CompletedAt)
+		e.CompletedAt = &t
+	}
+	return e
+}
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/andyfusniak/monolith/internal/store"
+)
+
+func TestStopUserExportsDrainsBuilds(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+	user := newTestUser(t, s, "alice@example.com")
+
+	export, err := s.RequestUserExport(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("RequestUserExport: %v", err)
+	}
+
+	stopCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := s.StopUserExports(stopCtx); err != nil {
+		t.Fatalf("StopUserExports: %v", err)
+	}
+
+	got, err := s.GetUserExport(ctx, user.ID, export.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != store.UserExportReady {
+		t.Errorf("export status after StopUserExports = %q, want %q", got.Status, store.UserExportReady)
+	}
+
+	if _, err := s.RequestUserExport(ctx, user.ID); !errors.Is(err, ErrUserExportsStopped) {
+		t.Errorf("RequestUserExport after StopUserExports error = %v, want %v", err, ErrUserExportsStopped)
+	}
+}
+
+func TestFailStaleUserExports(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+	user := newTestUser(t, s, "alice@example.com")
+
+	// left pending by a previous process
+	row, err := s.repo.InsertUserExport(ctx, store.AddUserExport{
+		ExportID:  "stale",
+		UserID:    user.ID,
+		ExpiresAt: store.Datetime(time.Now().UTC().Add(userExportTTL)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := s.FailStaleUserExports(ctx, time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatalf("FailStaleUserExports: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("FailStaleUserExports = %d, want 1", n)
+	}
+	got, err := s.GetUserExport(ctx, user.ID, row.ExportID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != store.UserExportFailed || got.CompletedAt == nil {
+		t.Errorf("stale export = %+v, want failed and completed", got)
+	}
+}
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/andyfusniak/monolith/internal/store"
+	"github.com/andyfusniak/monolith/internal/tracing"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// rateLimitPurgeInterval is how often full buckets are deleted.
+const rateLimitPurgeInterval = time.Minute
+
+// RateLimit is a token bucket holding up to Burst tokens, refilled at
+// Limit tokens per Period. Each request takes a token and requests are
+// refused while the bucket is empty, so a client may make Burst requests
+// at once and Limit per Period after that.
+type RateLimit struct {
+	Limit  int
+	Period time.Duration
+	Burst  int
+}
+
+// rate returns the tokens added to the bucket per second.
+func (l RateLimit) rate() float64 {
+	return float64(l.Limit) / l.Period.Seconds()
+}
+
+// RateLimitStatus is the state of a client's token bucket after a
+// request. Limit is the bucket size and Window the time to refill it
+// from empty. Reset is the time until the bucket is full and RetryAfter,
+// set if the request is not Allowed, the time until it holds a token.
+type RateLimitStatus struct {
+	Allowed    bool
+	Limit      int
+	Window     time.Duration
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// WithRateLimitStore sets where rate limit token buckets are kept. By
+// default they are kept in memory and are lost on restart.
+func WithRateLimitStore(r store.RateLimitsRepository) Option {
+	return func(s *Service) {
+		s.rateLimits = r
+	}
+}
+
+// TakeRateLimitToken takes a token from the bucket for key, which
+// identifies both the client and what it is limited on, reporting
+// whether the request is allowed.
+func (s *Service) TakeRateLimitToken(ctx context.Context, key string, l RateLimit) (RateLimitStatus, error) {
+	ctx, span := tracing.Start(ctx, "service.TakeRateLimitToken")
+	defer span.End()
+
+	s.purgeRateLimitBuckets(ctx)
+
+	rate := l.rate()
+	window := secondsDuration(float64(l.Burst) / rate)
+	b, err := s.rateLimits.TakeRateLimitToken(ctx, store.TakeRateLimitToken{
+		Key:       key,
+		Rate:      rate,
+		Burst:     l.Burst,
+		ExpiresAt: store.Datetime(time.Now().UTC().Add(window)),
+	})
+	if err != nil {
+		return RateLimitStatus{}, errors.Wrap(err, "[service] s.rateLimits.TakeRateLimitToken failed")
+	}
+
+	status := RateLimitStatus{
+		Allowed:   b.Taken,
+		Limit:     l.Burst,
+		Window:    window,
+		Remaining: int(b.Tokens),
+		Reset:     secondsDuration((float64(l.Burst) - b.Tokens) / rate),
+	}
+	if !b.Taken {
+		status.RetryAfter = secondsDuration((1 - b.Tokens) / rate)
+	}
+	return status, nil
+}
+
+// purgeRateLimitBuckets deletes full buckets, at most once per
+// rateLimitPurgeInterval for each tenant. Failures are logged, as they do
+// not affect the limits.
+func (s *Service) purgeRateLimitBuckets(ctx context.Context) {
+	tenantID := store.TenantFromContext(ctx)
+	now := time.Now().UTC()
+
+	s.rateLimitsMu.Lock()
+	if now.Sub(s.rateLimitsPurged[tenantID]) < rateLimitPurgeInterval {
+		s.rateLimitsMu.Unlock()
+		return
+	}
+	s.rateLimitsPurged[tenantID] = now
+	s.rateLimitsMu.Unlock()
+
+	n, err := s.rateLimits.DeleteExpiredRateLimitBuckets(ctx, store.Datetime(now))
+	if err != nil {
+		log.WithContext(ctx).Errorf("[service] s.rateLimits.DeleteExpiredRateLimitBuckets failed: %+v", err)
+		return
+	}
+	if n > 0 {
+		log.WithContext(ctx).Debugf("[service] deleted %d full rate limit buckets", n)
+	}
+}
+
+// secondsDuration converts a number of seconds to a Duration, rounding up
+// to the microsecond.
+func secondsDuration(v float64) time.Duration {
+	return time.Duration(math.Ceil(v*1e6)) * time.Microsecond
+}
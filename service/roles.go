@@ -0,0 +1,101 @@
+package service
+
+import (
+	"context"
+
+	"github.com/andyfusniak/monolith/internal/store"
+	"github.com/andyfusniak/monolith/internal/tracing"
+	"github.com/pkg/errors"
+)
+
+// RoleAdmin is the staff role allowed to use the /v1/admin endpoints.
+const RoleAdmin = "admin"
+
+var (
+	ErrRoleInvalid = errors.New("role invalid")
+	ErrRoleNotHeld = errors.New("role not held")
+	ErrNotAdmin    = errors.New("admin role required")
+)
+
+func isValidRole(role string) bool {
+	return role == RoleAdmin
+}
+
+// UserRoles returns the staff roles held by a user.
+func (s *Service) UserRoles(ctx context.Context, userID string) ([]string, error) {
+	ctx, span := tracing.Start(ctx, "service.UserRoles")
+	defer span.End()
+
+	roles, err := s.repo.ListUserRoles(ctx, userID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[service] s.repo.ListUserRoles(ctx, userID=%q) failed", userID)
+	}
+	return roles, nil
+}
+
+// GrantRole grants a staff role to a user.
+func (s *Service) GrantRole(ctx context.Context, userID, role string) error {
+	ctx, span := tracing.Start(ctx, "service.GrantRole")
+	defer span.End()
+
+	if !isValidRole(role) {
+		return ErrRoleInvalid
+	}
+	if _, err := s.GetUser(ctx, userID); err != nil {
+		return err
+	}
+
+	if err := s.repo.InsertUserRole(ctx, userID, role); err != nil {
+		return errors.Wrapf(err, "[service] s.repo.InsertUserRole(ctx, userID=%q) failed", userID)
+	}
+	return s.recordAudit(ctx, AuditRoleGrant, userID, map[string]any{"role": role})
+}
+
+// RevokeRole revokes a staff role from a user.
+func (s *Service) RevokeRole(ctx context.Context, userID, role string) error {
+	ctx, span := tracing.Start(ctx, "service.RevokeRole")
+	defer span.End()
+
+	if !isValidRole(role) {
+		return ErrRoleInvalid
+	}
+
+	if err := s.repo.DeleteUserRole(ctx, userID, role); err != nil {
+		if errors.Is(err, store.ErrUserRoleNotFound) {
+			return ErrRoleNotHeld
+		}
+		return errors.Wrapf(err, "[service] s.repo.DeleteUserRole(ctx, userID=%q) failed", userID)
+	}
+	return s.recordAudit(ctx, AuditRoleRevoke, userID, map[string]any{"role": role})
+}
+
+// hasRole returns true if the user holds role.
+func (s *Service) hasRole(ctx context.Context, userID, role string) (bool, error) {
+	roles, err := s.UserRoles(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return containsString(roles, role), nil
+}
+
+// requireAdmin returns the principal in ctx if it is an admin signed in
+// with a session of their own. API keys and impersonation sessions never
+// carry the admin role.
+func (s *Service) requireAdmin(ctx context.Context) (Principal, error) {
+	p, ok := PrincipalFromContext(ctx)
+	if !ok || p.UserID == "" {
+		return Principal{}, ErrNoPrincipal
+	}
+	if p.APIKeyID != "" || p.ImpersonatorID != "" {
+		return Principal{}, ErrNotAdmin
+	}
+
+	admin, err := s.hasRole(ctx, p.UserID, RoleAdmin)
+	if err != nil {
+		return Principal{}, err
+	}
+	if !admin {
+		return Principal{}, ErrNotAdmin
+	}
+	return p, nil
+}
@@ -1,15 +1,55 @@
 package service
 
 import (
+	"context"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/alexedwards/argon2id"
+
+	"github.com/andyfusniak/monolith/internal/mail"
+	"github.com/andyfusniak/monolith/internal/metrics"
+	"github.com/andyfusniak/monolith/internal/oidc"
 	"github.com/andyfusniak/monolith/internal/store"
+	"github.com/andyfusniak/monolith/internal/store/memory"
+	"github.com/andyfusniak/monolith/internal/webauthn"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
 type Service struct {
-	repo store.Repository
+	repo           store.Repository
+	passwordPolicy PasswordPolicy
+	hashParams     argon2id.Params
+	signInThrottle SignInThrottle
+	sessionTTL     time.Duration
+	totpIssuer     string
+	webauthn       *webauthn.Config
+	mailer         mail.Mailer
+	baseURL        string
+	tenantDomain   string
+	oidcProviders  map[string]*oidc.Provider
+	auditHashKey   []byte
+	hashDuration   *metrics.Histogram
+	schemaVersion  uint
+	draining       atomic.Bool
+	maintenance    atomic.Bool
+
+	dummyHashOnce  sync.Once
+	dummyHashValue string
+	oauthKeysMu    sync.Mutex
+	oauthKeys      map[string]*oauthKeyCache // by tenant
+
+	rateLimits       store.RateLimitsRepository
+	rateLimitsMu     sync.Mutex
+	rateLimitsPurged map[string]time.Time // by tenant
+
+	exportsMu      sync.Mutex
+	exportsStopped bool
+	exports        sync.WaitGroup
+	exportsAbort   context.Context
+	abortExports   context.CancelFunc
 }
 
 type Option func(*Service)
@@ -18,7 +58,19 @@ type Option func(*Service)
 // must be called using the WithSqlite3 configurator since the service
 // requires a functional store to persist state.
 func New(opts ...Option) *Service {
-	service := &Service{}
+	service := &Service{
+		passwordPolicy: DefaultPasswordPolicy(),
+		hashParams:     DefaultHashParams(),
+		signInThrottle: DefaultSignInThrottle(),
+		sessionTTL:     defaultSessionTTL,
+		totpIssuer:     defaultTOTPIssuer,
+		mailer:         mail.LogMailer{},
+		oauthKeys:      make(map[string]*oauthKeyCache),
+
+		rateLimits:       memory.NewRateLimits(),
+		rateLimitsPurged: make(map[string]time.Time),
+	}
+	service.exportsAbort, service.abortExports = context.WithCancel(context.Background())
 	for _, o := range opts {
 		o(service)
 	}
@@ -32,6 +84,23 @@ func WithRepository(r store.Repository) Option {
 	}
 }
 
+// WithMetrics registers the service metrics, such as argon2id hash
+// timings, with reg.
+func WithMetrics(reg *metrics.Registry) Option {
+	return func(s *Service) {
+		s.hashDuration = reg.NewHistogram("monolith_password_hash_duration_seconds",
+			"Time taken to create or compare argon2id password hashes.",
+			[]float64{.01, .025, .05, .1, .25, .5, 1, 2.5, 5}, "op")
+	}
+}
+
+// WithPasswordPolicy configures the policy new passwords must satisfy.
+func WithPasswordPolicy(p PasswordPolicy) Option {
+	return func(s *Service) {
+		s.passwordPolicy = p
+	}
+}
+
 const jsonTime = "2006-01-02T15:04:05.000Z07:00" // .000Z = keep trailing zeros
 
 // ISOTime custom type to allow for JSON microsecond formating.
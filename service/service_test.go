@@ -0,0 +1,62 @@
+package service
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/alexedwards/argon2id"
+	"github.com/andyfusniak/monolith/internal/store/sqlite3"
+	"github.com/andyfusniak/monolith/internal/store/sqlite3/schema"
+)
+
+// newTestService returns a service backed by a new, migrated SQLite
+// database in a temporary directory. Passwords are hashed with cheap
+// parameters.
+func newTestService(t *testing.T, opts ...Option) *Service {
+	t.Helper()
+
+	db, err := sqlite3.OpenDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	// one connection, like the read-write pool of the server, so
+	// transactions are serialized
+	db.SetMaxOpenConns(1)
+
+	migrations, err := fs.Glob(schema.Migrations, "migrations/*.up.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(migrations)
+	for _, name := range migrations {
+		b, err := fs.ReadFile(schema.Migrations, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(string(b)); err != nil {
+			t.Fatalf("migration %s: %v", name, err)
+		}
+	}
+
+	opts = append([]Option{
+		WithRepository(sqlite3.NewStore(db, db)),
+		WithHashParams(argon2id.Params{
+			Memory: 8 * 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32,
+		}),
+	}, opts...)
+	return New(opts...)
+}
+
+// newTestUser creates a user with email.
+func newTestUser(t *testing.T, s *Service, email string) User {
+	t.Helper()
+	u, err := s.CreateUser(context.Background(), email, "correct horse battery staple")
+	if err != nil {
+		t.Fatalf("CreateUser(%q): %v", email, err)
+	}
+	return u
+}
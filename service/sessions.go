@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/andyfusniak/base58"
+	"github.com/andyfusniak/monolith/internal/store"
+	"github.com/andyfusniak/monolith/internal/tracing"
+	"github.com/pkg/errors"
+)
+
+const defaultSessionTTL = 7 * 24 * time.Hour
+
+var (
+	ErrSessionNotFound = errors.New("session not found")
+)
+
+// Session is an authenticated session for a user. The Token is only set
+// when the session is created; the store holds a hash of it.
+type Session struct {
+	ID             string  `json:"session_id"`
+	Token          string  `json:"-"`
+	UserID         string  `json:"user_id"`
+	ImpersonatorID string  `json:"impersonator_id,omitempty"`
+	ExpiresAt      ISOTime `json:"expires_at"`
+	CreatedAt      ISOTime `json:"created_at"`
+}
+
+// WithSessionTTL configures how long new sessions remain valid.
+func WithSessionTTL(d time.Duration) Option {
+	return func(s *Service) {
+		s.sessionTTL = d
+	}
+}
+
+// newToken returns a random base58 token and its hash.
+func newToken() (token, hash string, err error) {
+	token, err = base58.RandString(32) // 58**32 > 2**187
+	if err != nil {
+		return "", "", errors.Wrap(err, "[service] failed to generate random base58 string")
+	}
+	return token, hashToken(token), nil
+}
+
+// hashToken returns the hex encoded SHA-256 hash of a token. Tokens are
+// high entropy so a fast hash is sufficient.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateSession creates a new session for the user with the given userID.
+func (s *Service) CreateSession(ctx context.Context, userID string) (Session, error) {
+	ctx, span := tracing.Start(ctx, "service.CreateSession")
+	defer span.End()
+
+	return s.newSession(ctx, userID, nil, s.sessionTTL)
+}
+
+// newSession creates a session for userID valid for ttl. impersonatorID
+// is set for impersonation sessions.
+func (s *Service) newSession(ctx context.Context, userID string, impersonatorID *string, ttl time.Duration) (Session, error) {
+	token, hash, err := newToken()
+	if err != nil {
+		return Session{}, err
+	}
+	sessionID, err := base58.RandString(22)
+	if err != nil {
+		return Session{}, errors.Wrap(err, "[service] failed to generate random base58 string")
+	}
+
+	row, err := s.repo.InsertSession(ctx, store.AddSession{
+		SessionID:      sessionID,
+		TokenHash:      hash,
+		UserID:         userID,
+		ImpersonatorID: impersonatorID,
+		ExpiresAt:      store.Datetime(time.Now().UTC().Add(ttl)),
+	})
+	if err != nil {
+		return Session{}, errors.Wrap(err, "[service] s.repo.InsertSession failed")
+	}
+
+	session := sessionFromRow(row)
+	session.Token = token
+	return session, nil
+}
+
+// AuthenticateSession returns the session for the given token. If the
+// token is unknown or the session has expired ErrSessionNotFound is
+// returned.
+func (s *Service) AuthenticateSession(ctx context.Context, token string) (Session, error) {
+	ctx, span := tracing.Start(ctx, "service.AuthenticateSession")
+	defer span.End()
+
+	row, err := s.repo.GetSessionByTokenHash(ctx, hashToken(token))
+	if err != nil {
+		if errors.Is(err, store.ErrSessionNotFound) {
+			return Session{}, ErrSessionNotFound
+		}
+		return Session{}, errors.Wrap(err, "[service] s.repo.GetSessionByTokenHash failed")
+	}
+
+	if time.Now().After(time.Time(row.ExpiresAt)) {
+		if err := s.repo.DeleteSession(ctx, row.SessionID); err != nil {
+			return Session{}, errors.Wrap(err, "[service] s.repo.DeleteSession failed")
+		}
+		return Session{}, ErrSessionNotFound
+	}
+
+	return sessionFromRow(row), nil
+}
+
+// DeleteSession ends the session with the given sessionID.
+func (s *Service) DeleteSession(ctx context.Context, sessionID string) error {
+	ctx, span := tracing.Start(ctx, "service.DeleteSession")
+	defer span.End()
+
+	if err := s.repo.DeleteSession(ctx, sessionID); err != nil {
+		return errors.Wrapf(err, "[service] s.repo.DeleteSession(ctx, sessionID=%q) failed", sessionID)
+	}
+	return nil
+}
+
+func sessionFromRow(row store.Session) Session {
+	session := Session{
+		ID:        row.SessionID,
+		UserID:    row.UserID,
+		ExpiresAt: ISOTime(row.ExpiresAt),
+		CreatedAt: ISOTime(row.CreatedAt),
+	}
+	if row.ImpersonatorID != nil {
+		session.ImpersonatorID = *row.ImpersonatorID
+	}
+	return session
+}
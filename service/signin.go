@@ -0,0 +1,274 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/andyfusniak/monolith/internal/store"
+	"github.com/andyfusniak/monolith/internal/tracing"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	ErrSignInAccountLocked   = errors.New("account temporarily locked")
+	ErrSignInTooManyAttempts = errors.New("too many sign in attempts")
+)
+
+// SignInThrottledError is returned by SignInWithPassword when an attempt
+// is refused because of too many previous failures. It wraps either
+// ErrSignInAccountLocked or ErrSignInTooManyAttempts.
+type SignInThrottledError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *SignInThrottledError) Error() string {
+	return fmt.Sprintf("%v: retry after %s", e.Err, e.RetryAfter)
+}
+
+func (e *SignInThrottledError) Unwrap() error {
+	return e.Err
+}
+
+// SignInThrottle configures brute-force protection for password sign in.
+//
+// Failures are counted per account and per client IP address. Once a count
+// reaches its maximum within FailureWindow, further attempts are refused
+// for LockoutDuration. Each additional failure doubles the lockout, up to
+// MaxLockoutDuration.
+//
+// Magic link requests are limited to MaxMagicLinksPerEmail and
+// MaxMagicLinksPerIP within FailureWindow.
+type SignInThrottle struct {
+	MaxAccountFailures    int
+	MaxIPFailures         int
+	FailureWindow         time.Duration
+	LockoutDuration       time.Duration
+	MaxLockoutDuration    time.Duration
+	MaxMagicLinksPerEmail int
+	MaxMagicLinksPerIP    int
+}
+
+// DefaultSignInThrottle returns the throttle used if the service is not
+// configured using WithSignInThrottle.
+func DefaultSignInThrottle() SignInThrottle {
+	return SignInThrottle{
+		MaxAccountFailures:    5,
+		MaxIPFailures:         50,
+		FailureWindow:         15 * time.Minute,
+		LockoutDuration:       time.Minute,
+		MaxLockoutDuration:    time.Hour,
+		MaxMagicLinksPerEmail: 3,
+		MaxMagicLinksPerIP:    10,
+	}
+}
+
+// WithSignInThrottle configures sign in brute-force protection.
+func WithSignInThrottle(t SignInThrottle) Option {
+	return func(s *Service) {
+		s.signInThrottle = t
+	}
+}
+
+// lockoutDuration returns the lockout for the given number of failures.
+func (t SignInThrottle) lockoutDuration(failures, max int) time.Duration {
+	d := t.LockoutDuration
+	for i := max; i < failures && d < t.MaxLockoutDuration; i++ {
+		d *= 2
+	}
+	if d > t.MaxLockoutDuration {
+		d = t.MaxLockoutDuration
+	}
+	return d
+}
+
+// SignInWithPassword verifies the email and password as VerifyUserPassword
+// does, but refuses attempts for accounts or client IP addresses with too
+// many recent failures.
+//
+// On success the result holds a new Session or TokenPair, according to
+// grant, or if the user has TOTP enabled an MFAChallenge to be completed
+// using VerifyMFAChallenge.
+//
+// Accounts are tracked by email whether or not a user exists with that
+// email, so a locked response does not reveal that an account exists.
+// Each attempt is reserved before the password is verified, so attempts
+// in progress count towards the limits. A successful sign in clears the
+// account failures but not those of the IP. Attempts refused by the
+// throttle are not audited.
+func (s *Service) SignInWithPassword(ctx context.Context, email, password, ip string, grant SignInGrant) (SignInResult, error) {
+	ctx, span := tracing.Start(ctx, "service.SignInWithPassword")
+	defer span.End()
+
+	accountKey := normalizeEmail(email)
+
+	if err := s.reserveSignInAttempt(ctx, store.SignInScopeIP, ip,
+		s.signInThrottle.MaxIPFailures, ErrSignInTooManyAttempts); err != nil {
+		return SignInResult{}, err
+	}
+	if err := s.reserveSignInAttempt(ctx, store.SignInScopeAccount, accountKey,
+		s.signInThrottle.MaxAccountFailures, ErrSignInAccountLocked); err != nil {
+		s.releaseSignInAttempt(ctx, store.SignInScopeIP, ip)
+		return SignInResult{}, err
+	}
+
+	row, err := s.verifyUserPassword(ctx, email, password)
+	if err != nil {
+		if !errors.Is(err, ErrUserNotFound) && !errors.Is(err, ErrUserWrongPassword) {
+			s.releaseSignInAttempt(ctx, store.SignInScopeAccount, accountKey)
+			s.releaseSignInAttempt(ctx, store.SignInScopeIP, ip)
+			return SignInResult{}, err
+		}
+
+		if rerr := s.recordSignInFailure(ctx, store.SignInScopeAccount, accountKey,
+			s.signInThrottle.MaxAccountFailures); rerr != nil {
+			return SignInResult{}, rerr
+		}
+		if rerr := s.recordSignInFailure(ctx, store.SignInScopeIP, ip,
+			s.signInThrottle.MaxIPFailures); rerr != nil {
+			return SignInResult{}, rerr
+		}
+
+		reason := "unknown-email"
+		if errors.Is(err, ErrUserWrongPassword) {
+			reason = "wrong-password"
+		}
+		return SignInResult{}, s.auditSignInFailure(ctx, row.UserID, email, reason, err)
+	}
+
+	if err := s.repo.DeleteSignInFailure(ctx, store.SignInScopeAccount, accountKey); err != nil {
+		s.releaseSignInAttempt(ctx, store.SignInScopeIP, ip)
+		return SignInResult{}, errors.Wrap(err, "[service] s.repo.DeleteSignInFailure failed")
+	}
+	s.releaseSignInAttempt(ctx, store.SignInScopeIP, ip)
+
+	return s.newSignIn(ctx, userFromRow(row), grant)
+}
+
+// auditSignInFailure records a failed sign in and returns err, or the
+// error recording it. userID is "" when no user has the email. The email
+// is recorded as a keyed hash, if there is an audit hash key.
+func (s *Service) auditSignInFailure(ctx context.Context, userID, email, reason string, err error) error {
+	details := map[string]any{"reason": reason}
+	if h := s.auditEmailHash(email); email != "" && h != "" {
+		details["email_hash"] = h
+	}
+	if aerr := s.recordAudit(ctx, AuditSignInFailure, userID, details); aerr != nil {
+		return aerr
+	}
+	return err
+}
+
+// reserveSignInAttempt reserves an attempt for key, returning a
+// SignInThrottledError wrapping lockErr if it is locked or has too many
+// failures and attempts in progress. The attempt must be either recorded
+// as a failure or released.
+func (s *Service) reserveSignInAttempt(ctx context.Context, scope, key string, max int, lockErr error) error {
+	if key == "" || max <= 0 {
+		return nil
+	}
+
+	_, err := s.repo.ReserveSignInAttempt(ctx, store.ReserveSignInAttempt{
+		Scope:       scope,
+		Key:         key,
+		Max:         max,
+		WindowStart: store.Datetime(time.Now().UTC().Add(-s.signInThrottle.FailureWindow)),
+	})
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, store.ErrSignInAttemptRefused) {
+		return errors.Wrapf(err, "[service] s.repo.ReserveSignInAttempt(ctx, scope=%q) failed", scope)
+	}
+
+	// refused while locked, or while the attempts in progress might
+	// reach the limit and lock it
+	retryAfter := s.signInThrottle.LockoutDuration
+	row, err := s.repo.GetSignInFailure(ctx, scope, key)
+	if err != nil && !errors.Is(err, store.ErrSignInFailureNotFound) {
+		return errors.Wrapf(err, "[service] s.repo.GetSignInFailure(ctx, scope=%q) failed", scope)
+	}
+	if err == nil && row.LockedUntil != nil {
+		if remaining := time.Until(time.Time(*row.LockedUntil)); remaining > 0 {
+			retryAfter = remaining
+		}
+	}
+	return &SignInThrottledError{
+		Err:        lockErr,
+		RetryAfter: retryAfter,
+	}
+}
+
+// releaseSignInAttempt releases an attempt reserved by
+// reserveSignInAttempt that did not fail. Errors are logged as the
+// attempt is released when its failure window passes.
+func (s *Service) releaseSignInAttempt(ctx context.Context, scope, key string) {
+	if key == "" {
+		return
+	}
+	if err := s.repo.ReleaseSignInAttempt(ctx, scope, key); err != nil {
+		log.WithContext(ctx).Errorf("[service] s.repo.ReleaseSignInAttempt(ctx, scope=%q) failed: %+v", scope, err)
+	}
+}
+
+func (s *Service) recordSignInFailure(ctx context.Context, scope, key string, max int) error {
+	if key == "" || max <= 0 {
+		return nil
+	}
+
+	row, err := s.repo.IncrSignInFailure(ctx, store.IncrSignInFailure{
+		Scope:       scope,
+		Key:         key,
+		WindowStart: store.Datetime(time.Now().UTC().Add(-s.signInThrottle.FailureWindow)),
+	})
+	if err != nil {
+		return errors.Wrapf(err, "[service] s.repo.IncrSignInFailure(ctx, scope=%q) failed", scope)
+	}
+	if row.Failures < max {
+		return nil
+	}
+
+	d := s.signInThrottle.lockoutDuration(row.Failures, max)
+	until := store.Datetime(time.Now().UTC().Add(d))
+	if err := s.repo.LockSignInFailure(ctx, scope, key, until); err != nil {
+		return errors.Wrapf(err, "[service] s.repo.LockSignInFailure(ctx, scope=%q) failed", scope)
+	}
+	log.WithContext(ctx).Warnf("[service] sign in locked for %s after %d failures scope=%s key=%s",
+		d, row.Failures, scope, key)
+
+	return nil
+}
+
+// UnlockUser clears failed sign in attempts and any lock for the user with
+// the given userID.
+func (s *Service) UnlockUser(ctx context.Context, userID string) error {
+	ctx, span := tracing.Start(ctx, "service.UnlockUser")
+	defer span.End()
+
+	row, err := s.repo.GetUser(ctx, userID)
+	if err != nil {
+		if errors.Is(err, store.ErrUserNotFound) {
+			return ErrUserNotFound
+		}
+		return errors.Wrapf(err, "[service] s.repo.GetUser(ctx, userID=%q) failed", userID)
+	}
+
+	if err := s.repo.DeleteSignInFailure(ctx, store.SignInScopeAccount, normalizeEmail(row.Email)); err != nil {
+		return errors.Wrap(err, "[service] s.repo.DeleteSignInFailure failed")
+	}
+	return nil
+}
+
+// UnlockIP clears failed sign in attempts and any lock for a client IP
+// address.
+func (s *Service) UnlockIP(ctx context.Context, ip string) error {
+	ctx, span := tracing.Start(ctx, "service.UnlockIP")
+	defer span.End()
+
+	if err := s.repo.DeleteSignInFailure(ctx, store.SignInScopeIP, ip); err != nil {
+		return errors.Wrap(err, "[service] s.repo.DeleteSignInFailure failed")
+	}
+	return nil
+}
@@ -0,0 +1,290 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andyfusniak/monolith/internal/store"
+)
+
+// auditEvents returns the events recorded with action, oldest first.
+func auditEvents(t *testing.T, s *Service, action string) []store.AuditEvent {
+	t.Helper()
+	events, err := s.repo.ListAuditEvents(context.Background(), store.AuditEventFilter{Action: action, Limit: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Reverse(events)
+	return events
+}
+
+func TestSignInFailureAudit(t *testing.T) {
+	ctx := context.Background()
+	key := []byte(strings.Repeat("k", 32))
+	s := newTestService(t, WithAuditHashKey(key), WithSignInThrottle(SignInThrottle{
+		MaxAccountFailures: 2,
+		MaxIPFailures:      100,
+		FailureWindow:      DefaultSignInThrottle().FailureWindow,
+		LockoutDuration:    DefaultSignInThrottle().LockoutDuration,
+		MaxLockoutDuration: DefaultSignInThrottle().MaxLockoutDuration,
+	}))
+	user := newTestUser(t, s, "alice@example.com")
+
+	if _, err := s.SignInWithPassword(ctx, "nobody@example.com", "password", "192.0.2.1", GrantSession); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("SignInWithPassword unknown email error = %v, want %v", err, ErrUserNotFound)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := s.SignInWithPassword(ctx, "Alice@Example.com", "wrong", "192.0.2.1", GrantSession); !errors.Is(err, ErrUserWrongPassword) {
+			t.Fatalf("SignInWithPassword wrong password error = %v, want %v", err, ErrUserWrongPassword)
+		}
+	}
+	// the account is now locked
+	if _, err := s.SignInWithPassword(ctx, "alice@example.com", "wrong", "192.0.2.1", GrantSession); !errors.Is(err, ErrSignInAccountLocked) {
+		t.Fatalf("SignInWithPassword locked error = %v, want %v", err, ErrSignInAccountLocked)
+	}
+
+	events := auditEvents(t, s, AuditSignInFailure)
+	if len(events) != 3 {
+		t.Fatalf("got %d %s events, want 3; throttled attempts are not audited", len(events), AuditSignInFailure)
+	}
+
+	wantSubjects := []string{"", user.ID, user.ID}
+	wantHashes := []string{s.auditEmailHash("nobody@example.com"), s.auditEmailHash(user.Email), s.auditEmailHash(user.Email)}
+	for i, e := range events {
+		var subject string
+		if e.SubjectID != nil {
+			subject = *e.SubjectID
+		}
+		if subject != wantSubjects[i] {
+			t.Errorf("event %d subject_id = %q, want %q", i, subject, wantSubjects[i])
+		}
+
+		var details map[string]any
+		if err := json.Unmarshal([]byte(e.Details), &details); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := details["email"]; ok || strings.Contains(e.Details, "example.com") {
+			t.Errorf("event %d details = %s, want no email", i, e.Details)
+		}
+		if details["email_hash"] != wantHashes[i] {
+			t.Errorf("event %d email_hash = %v, want %s", i, details["email_hash"], wantHashes[i])
+		}
+	}
+}
+
+func TestSignInFailureAuditWithoutHashKey(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+	newTestUser(t, s, "alice@example.com")
+
+	if _, err := s.SignInWithPassword(ctx, "alice@example.com", "wrong", "192.0.2.1", GrantSession); !errors.Is(err, ErrUserWrongPassword) {
+		t.Fatalf("SignInWithPassword error = %v, want %v", err, ErrUserWrongPassword)
+	}
+	events := auditEvents(t, s, AuditSignInFailure)
+	if len(events) != 1 || events[0].Details != `{"reason":"wrong-password"}` {
+		t.Fatalf("events = %+v, want one with only the reason", events)
+	}
+}
+
+// testSignInThrottle returns the default throttle with the given maximums.
+func testSignInThrottle(maxAccount, maxIP int) SignInThrottle {
+	t := DefaultSignInThrottle()
+	t.MaxAccountFailures = maxAccount
+	t.MaxIPFailures = maxIP
+	return t
+}
+
+// signInError signs in to email with password from ip, returning the error.
+func signInError(s *Service, email, password, ip string) error {
+	_, err := s.SignInWithPassword(context.Background(), email, password, ip, GrantSession)
+	return err
+}
+
+func TestSignInAccountLockout(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t, WithSignInThrottle(testSignInThrottle(3, 100)))
+	user := newTestUser(t, s, "alice@example.com")
+
+	for i := 0; i < 3; i++ {
+		if err := signInError(s, "alice@example.com", "wrong", "192.0.2.1"); !errors.Is(err, ErrUserWrongPassword) {
+			t.Fatalf("attempt %d error = %v, want %v", i, err, ErrUserWrongPassword)
+		}
+	}
+
+	// locked for the right password too, and from another IP
+	for _, ip := range []string{"192.0.2.1", "192.0.2.2"} {
+		err := signInError(s, "alice@example.com", "correct horse battery staple", ip)
+		var terr *SignInThrottledError
+		if !errors.As(err, &terr) || !errors.Is(err, ErrSignInAccountLocked) {
+			t.Fatalf("locked sign in from %s error = %v, want %v", ip, err, ErrSignInAccountLocked)
+		}
+		if terr.RetryAfter <= 0 || terr.RetryAfter > DefaultSignInThrottle().LockoutDuration {
+			t.Errorf("RetryAfter = %s, want at most %s", terr.RetryAfter, DefaultSignInThrottle().LockoutDuration)
+		}
+	}
+
+	// other accounts are not locked
+	if err := signInError(s, "bob@example.com", "wrong", "192.0.2.1"); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("other account error = %v, want %v", err, ErrUserNotFound)
+	}
+
+	if err := s.UnlockUser(ctx, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := signInError(s, "alice@example.com", "correct horse battery staple", "192.0.2.1"); err != nil {
+		t.Fatalf("sign in after unlock: %v", err)
+	}
+}
+
+func TestSignInIPLockout(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t, WithSignInThrottle(testSignInThrottle(100, 3)))
+	newTestUser(t, s, "alice@example.com")
+
+	for i, email := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		if err := signInError(s, email, "wrong", "192.0.2.1"); !errors.Is(err, ErrUserNotFound) {
+			t.Fatalf("attempt %d error = %v, want %v", i, err, ErrUserNotFound)
+		}
+	}
+
+	if err := signInError(s, "alice@example.com", "correct horse battery staple", "192.0.2.1"); !errors.Is(err, ErrSignInTooManyAttempts) {
+		t.Fatalf("locked IP error = %v, want %v", err, ErrSignInTooManyAttempts)
+	}
+	// the refused attempt is not held against the account
+	row, err := s.repo.GetSignInFailure(ctx, store.SignInScopeAccount, "alice@example.com")
+	if err == nil && (row.Failures != 0 || row.Pending != 0) {
+		t.Errorf("account failures = %d pending = %d, want none", row.Failures, row.Pending)
+	}
+
+	if err := signInError(s, "alice@example.com", "correct horse battery staple", "192.0.2.2"); err != nil {
+		t.Fatalf("sign in from another IP: %v", err)
+	}
+	// a successful sign in releases its IP attempt but keeps the failures
+	row, err = s.repo.GetSignInFailure(ctx, store.SignInScopeIP, "192.0.2.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row.Failures != 0 || row.Pending != 0 {
+		t.Errorf("IP failures = %d pending = %d, want 0 0", row.Failures, row.Pending)
+	}
+}
+
+func TestSignInLockExpires(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t, WithSignInThrottle(testSignInThrottle(2, 100)))
+	newTestUser(t, s, "alice@example.com")
+
+	for i := 0; i < 2; i++ {
+		if err := signInError(s, "alice@example.com", "wrong", "192.0.2.1"); !errors.Is(err, ErrUserWrongPassword) {
+			t.Fatalf("attempt %d error = %v, want %v", i, err, ErrUserWrongPassword)
+		}
+	}
+	expireSignInLock(t, s, "alice@example.com")
+
+	// one more failure after the lock expires locks again for twice as long
+	if err := signInError(s, "alice@example.com", "wrong", "192.0.2.1"); !errors.Is(err, ErrUserWrongPassword) {
+		t.Fatalf("attempt after lock expired error = %v, want %v", err, ErrUserWrongPassword)
+	}
+	var terr *SignInThrottledError
+	if err := signInError(s, "alice@example.com", "wrong", "192.0.2.1"); !errors.As(err, &terr) {
+		t.Fatalf("relocked error = %v, want %v", err, ErrSignInAccountLocked)
+	}
+	if want := 2 * DefaultSignInThrottle().LockoutDuration; terr.RetryAfter <= want-time.Second || terr.RetryAfter > want {
+		t.Errorf("RetryAfter = %s, want about %s", terr.RetryAfter, want)
+	}
+
+	expireSignInLock(t, s, "alice@example.com")
+	if err := signInError(s, "alice@example.com", "correct horse battery staple", "192.0.2.1"); err != nil {
+		t.Fatalf("sign in after lock expired: %v", err)
+	}
+	if _, err := s.repo.GetSignInFailure(ctx, store.SignInScopeAccount, "alice@example.com"); !errors.Is(err, store.ErrSignInFailureNotFound) {
+		t.Errorf("GetSignInFailure after sign in error = %v, want %v", err, store.ErrSignInFailureNotFound)
+	}
+}
+
+func TestSignInFailureWindowExpires(t *testing.T) {
+	ctx := context.Background()
+	throttle := testSignInThrottle(3, 100)
+	throttle.FailureWindow = 200 * time.Millisecond
+	s := newTestService(t, WithSignInThrottle(throttle))
+	newTestUser(t, s, "alice@example.com")
+
+	for i := 0; i < 2; i++ {
+		if err := signInError(s, "alice@example.com", "wrong", "192.0.2.1"); !errors.Is(err, ErrUserWrongPassword) {
+			t.Fatalf("attempt %d error = %v, want %v", i, err, ErrUserWrongPassword)
+		}
+	}
+	time.Sleep(throttle.FailureWindow + 50*time.Millisecond)
+
+	// the earlier failures are forgotten, so two more do not lock
+	for i := 0; i < 2; i++ {
+		if err := signInError(s, "alice@example.com", "wrong", "192.0.2.1"); !errors.Is(err, ErrUserWrongPassword) {
+			t.Fatalf("attempt %d after the window error = %v, want %v", i, err, ErrUserWrongPassword)
+		}
+	}
+	row, err := s.repo.GetSignInFailure(ctx, store.SignInScopeAccount, "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row.Failures != 2 || row.LockedUntil != nil {
+		t.Errorf("failures = %d locked_until = %v, want 2 and not locked", row.Failures, row.LockedUntil)
+	}
+}
+
+func TestSignInConcurrentAttempts(t *testing.T) {
+	const maxFailures = 3
+	s := newTestService(t, WithSignInThrottle(testSignInThrottle(maxFailures, 100)))
+	newTestUser(t, s, "alice@example.com")
+
+	var wg sync.WaitGroup
+	errs := make([]error, 20)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = signInError(s, "alice@example.com", "wrong", "192.0.2.1")
+		}()
+	}
+	wg.Wait()
+
+	var verified, throttled int
+	for _, err := range errs {
+		switch {
+		case errors.Is(err, ErrUserWrongPassword):
+			verified++
+		case errors.Is(err, ErrSignInAccountLocked):
+			throttled++
+		default:
+			t.Errorf("error = %v, want %v or %v", err, ErrUserWrongPassword, ErrSignInAccountLocked)
+		}
+	}
+	if verified < 1 || verified > maxFailures {
+		t.Errorf("%d passwords verified, want 1 to %d", verified, maxFailures)
+	}
+	if verified+throttled != len(errs) {
+		t.Errorf("%d verified and %d throttled, want %d in total", verified, throttled, len(errs))
+	}
+
+	row, err := s.repo.GetSignInFailure(context.Background(), store.SignInScopeAccount, "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row.Pending != 0 || row.Failures != verified {
+		t.Errorf("failures = %d pending = %d, want %d and 0", row.Failures, row.Pending, verified)
+	}
+}
+
+// expireSignInLock moves the lock of the account with email into the past.
+func expireSignInLock(t *testing.T, s *Service, email string) {
+	t.Helper()
+	past := store.Datetime(time.Now().UTC().Add(-time.Second))
+	if err := s.repo.LockSignInFailure(context.Background(), store.SignInScopeAccount, email, past); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"net"
+	"net/url"
+
+	"github.com/andyfusniak/monolith/internal/store"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	ErrTenantNotFound = errors.New("tenant not found")
+)
+
+// WithTenantDomain configures the domain whose subdomains name tenants in
+// tenant mode. Links sent to users of a tenant are built on its subdomain.
+func WithTenantDomain(domain string) Option {
+	return func(s *Service) {
+		s.tenantDomain = domain
+	}
+}
+
+// IsMultiTenant reports whether the service keeps each tenant in its own
+// database. If so every request must first call EnterTenant.
+func (s *Service) IsMultiTenant() bool {
+	_, ok := s.repo.(store.TenantRepository)
+	return ok
+}
+
+// EnterTenant returns a copy of ctx for serving a request to tenantID.
+// release must be called once the request is complete. ErrTenantNotFound
+// is returned for unknown tenants.
+func (s *Service) EnterTenant(ctx context.Context, tenantID string) (context.Context, func(), error) {
+	tr, ok := s.repo.(store.TenantRepository)
+	if !ok {
+		return nil, nil, errors.New("[service] repository is not multi-tenant")
+	}
+
+	tctx, release, err := tr.AcquireTenant(ctx, tenantID)
+	if err != nil {
+		if errors.Is(err, store.ErrTenantNotFound) {
+			return nil, nil, ErrTenantNotFound
+		}
+		return nil, nil, errors.Wrapf(err, "[service] s.repo.AcquireTenant(ctx, tenantID=%q) failed", tenantID)
+	}
+	return tctx, release, nil
+}
+
+// forEachTenant calls fn with a context for every tenant in tenant mode,
+// or once with ctx otherwise. An error from one tenant is logged and the
+// remaining tenants are still visited.
+func (s *Service) forEachTenant(ctx context.Context, fn func(ctx context.Context) error) error {
+	tr, ok := s.repo.(store.TenantRepository)
+	if !ok {
+		return fn(ctx)
+	}
+
+	tenants, err := tr.ListTenants(ctx)
+	if err != nil {
+		return errors.Wrap(err, "[service] s.repo.ListTenants failed")
+	}
+	for _, tenantID := range tenants {
+		if err := s.inTenant(ctx, tenantID, fn); err != nil {
+			log.WithContext(ctx).Errorf("[service] tenant=%q failed: %+v", tenantID, err)
+		}
+	}
+	return nil
+}
+
+func (s *Service) inTenant(ctx context.Context, tenantID string, fn func(ctx context.Context) error) error {
+	tctx, release, err := s.EnterTenant(ctx, tenantID)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return fn(tctx)
+}
+
+// publicURL returns the base URL of links sent to users of the tenant of
+// ctx and of the OpenID Connect callback. In tenant mode it is the base URL
+// with its host replaced by the tenant subdomain of the tenant domain, so
+// the tenant is resolved when the link is followed.
+func (s *Service) publicURL(ctx context.Context) string {
+	tenantID := store.TenantFromContext(ctx)
+	if tenantID == "" || s.tenantDomain == "" {
+		return s.baseURL
+	}
+
+	u, err := url.Parse(s.baseURL)
+	if err != nil {
+		return s.baseURL
+	}
+	host := tenantID + "." + s.tenantDomain
+	if port := u.Port(); port != "" {
+		host = net.JoinHostPort(host, port)
+	}
+	u.Host = host
+	return u.String()
+}
@@ -0,0 +1,40 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/andyfusniak/monolith/internal/store"
+)
+
+func TestPublicURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		baseURL string
+		domain  string
+		tenant  string
+		want    string
+	}{
+		{name: "single database", baseURL: "https://example.com", want: "https://example.com"},
+		{name: "tenant", baseURL: "https://example.com", domain: "example.com", tenant: "acme", want: "https://acme.example.com"},
+		{name: "tenant keeps port and path", baseURL: "http://localhost:8080/auth", domain: "localtest.me", tenant: "acme",
+			want: "http://acme.localtest.me:8080/auth"},
+		{name: "tenant without domain", baseURL: "https://example.com", tenant: "acme", want: "https://example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t, WithBaseURL(tt.baseURL), WithTenantDomain(tt.domain))
+			ctx := context.Background()
+			if tt.tenant != "" {
+				ctx = store.WithTenant(ctx, tt.tenant)
+			}
+			if got := s.publicURL(ctx); got != tt.want {
+				t.Errorf("publicURL = %s, want %s", got, tt.want)
+			}
+			if got, want := s.oidcRedirectURI(ctx, "google"), tt.want+"/v1/auth/oidc/google/callback"; got != want {
+				t.Errorf("oidcRedirectURI = %s, want %s", got, want)
+			}
+		})
+	}
+}
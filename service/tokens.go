@@ -0,0 +1,227 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/andyfusniak/base58"
+	"github.com/andyfusniak/monolith/internal/jwt"
+	"github.com/andyfusniak/monolith/internal/store"
+	"github.com/andyfusniak/monolith/internal/tracing"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 30 * 24 * time.Hour
+)
+
+var (
+	ErrAccessTokenInvalid  = errors.New("access token invalid or expired")
+	ErrRefreshTokenInvalid = errors.New("refresh token invalid or expired")
+	ErrRefreshTokenReused  = errors.New("refresh token reused")
+)
+
+// SignInGrant selects the credentials issued by a successful sign in.
+type SignInGrant int
+
+const (
+	// GrantSession issues a cookie session.
+	GrantSession SignInGrant = iota
+
+	// GrantTokens issues a short lived access token and a refresh token,
+	// for clients such as mobile apps that cannot use cookies.
+	GrantTokens
+)
+
+func (g SignInGrant) String() string {
+	if g == GrantTokens {
+		return "tokens"
+	}
+	return "session"
+}
+
+// TokenPair is an access token with the refresh token used to replace it.
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// accessTokenClaims are the claims of a first party access token. The
+// audience is the service base URL, which distinguishes these tokens from
+// those issued to OAuth clients.
+type accessTokenClaims struct {
+	jwt.Claims
+	FamilyID string `json:"sid"`
+}
+
+// issueSignIn creates the credentials for grant once a user has fully
+// signed in.
+func (s *Service) issueSignIn(ctx context.Context, user User, grant SignInGrant) (SignInResult, error) {
+	if err := s.recordAudit(ctx, AuditSignInSuccess, user.ID, map[string]any{
+		"grant": grant.String(),
+	}); err != nil {
+		return SignInResult{}, err
+	}
+
+	if grant == GrantTokens {
+		tokens, err := s.IssueTokens(ctx, user.ID)
+		if err != nil {
+			return SignInResult{}, err
+		}
+		return SignInResult{User: user, Tokens: &tokens}, nil
+	}
+
+	session, err := s.CreateSession(ctx, user.ID)
+	if err != nil {
+		return SignInResult{}, err
+	}
+	return SignInResult{User: user, Session: &session}, nil
+}
+
+// IssueTokens starts a new refresh token family for the user and returns
+// its first refresh token with an access token.
+func (s *Service) IssueTokens(ctx context.Context, userID string) (TokenPair, error) {
+	ctx, span := tracing.Start(ctx, "service.IssueTokens")
+	defer span.End()
+
+	familyID, err := base58.RandString(22)
+	if err != nil {
+		return TokenPair{}, errors.Wrap(err, "[service] failed to generate random base58 string")
+	}
+	refresh, hash, err := newToken()
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	if err := s.repo.InsertRefreshTokenFamily(ctx, store.AddRefreshTokenFamily{
+		FamilyID:  familyID,
+		UserID:    userID,
+		TokenHash: hash,
+		ExpiresAt: store.Datetime(time.Now().UTC().Add(refreshTokenTTL)),
+	}); err != nil {
+		return TokenPair{}, errors.Wrap(err, "[service] s.repo.InsertRefreshTokenFamily failed")
+	}
+
+	return s.newTokenPair(ctx, userID, familyID, refresh)
+}
+
+// RefreshTokens exchanges a refresh token for a new access token and
+// refresh token. Each refresh token can be used once. Presenting a used
+// refresh token revokes its whole family, signing out both the attacker
+// and the legitimate client, and returns ErrRefreshTokenReused. Unknown,
+// expired and revoked tokens return ErrRefreshTokenInvalid.
+func (s *Service) RefreshTokens(ctx context.Context, refreshToken string) (TokenPair, error) {
+	ctx, span := tracing.Start(ctx, "service.RefreshTokens")
+	defer span.End()
+
+	hash := hashToken(refreshToken)
+	row, err := s.repo.GetRefreshToken(ctx, hash)
+	if err != nil {
+		if errors.Is(err, store.ErrRefreshTokenNotFound) {
+			return TokenPair{}, ErrRefreshTokenInvalid
+		}
+		return TokenPair{}, errors.Wrap(err, "[service] s.repo.GetRefreshToken failed")
+	}
+	if row.FamilyRevokedAt != nil {
+		return TokenPair{}, ErrRefreshTokenInvalid
+	}
+	if row.UsedAt != nil {
+		return TokenPair{}, s.revokeReusedFamily(ctx, row)
+	}
+	if time.Now().After(time.Time(row.ExpiresAt)) {
+		return TokenPair{}, ErrRefreshTokenInvalid
+	}
+
+	refresh, newHash, err := newToken()
+	if err != nil {
+		return TokenPair{}, err
+	}
+	if err := s.repo.RotateRefreshToken(ctx, store.RotateRefreshToken{
+		OldTokenHash: hash,
+		NewTokenHash: newHash,
+		FamilyID:     row.FamilyID,
+		ExpiresAt:    store.Datetime(time.Now().UTC().Add(refreshTokenTTL)),
+	}); err != nil {
+		if errors.Is(err, store.ErrRefreshTokenUsed) {
+			return TokenPair{}, s.revokeReusedFamily(ctx, row)
+		}
+		return TokenPair{}, errors.Wrap(err, "[service] s.repo.RotateRefreshToken failed")
+	}
+
+	return s.newTokenPair(ctx, row.UserID, row.FamilyID, refresh)
+}
+
+func (s *Service) revokeReusedFamily(ctx context.Context, row store.RefreshToken) error {
+	if err := s.repo.RevokeRefreshTokenFamily(ctx, row.FamilyID); err != nil {
+		return errors.Wrapf(err, "[service] s.repo.RevokeRefreshTokenFamily(ctx, familyID=%q) failed", row.FamilyID)
+	}
+	log.WithContext(ctx).Warnf("[service] refresh token reuse detected; revoked token family family_id=%s user_id=%s",
+		row.FamilyID, row.UserID)
+	return ErrRefreshTokenReused
+}
+
+func (s *Service) newTokenPair(ctx context.Context, userID, familyID, refreshToken string) (TokenPair, error) {
+	jti, err := base58.RandString(22)
+	if err != nil {
+		return TokenPair{}, errors.Wrap(err, "[service] failed to generate random base58 string")
+	}
+
+	now := time.Now()
+	access, err := s.signOAuthToken(ctx, oauthAccessTokenType, accessTokenClaims{
+		Claims: jwt.Claims{
+			Issuer:    s.baseURL,
+			Subject:   userID,
+			Audience:  jwt.Audience{s.baseURL},
+			ExpiresAt: now.Add(accessTokenTTL).Unix(),
+			IssuedAt:  now.Unix(),
+			ID:        jti,
+		},
+		FamilyID: familyID,
+	})
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	return TokenPair{
+		AccessToken:  access,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(accessTokenTTL.Seconds()),
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+// AuthenticateAccessToken verifies an access token issued by IssueTokens
+// or RefreshTokens and returns the principal it was issued to. Signing
+// keys are cached so no database query is made per request; as a result
+// an access token remains valid until it expires even if its token family
+// is revoked. ErrAccessTokenInvalid is returned for any invalid token.
+func (s *Service) AuthenticateAccessToken(ctx context.Context, raw string) (Principal, error) {
+	ctx, span := tracing.Start(ctx, "service.AuthenticateAccessToken")
+	defer span.End()
+
+	tok, err := jwt.Parse(raw)
+	if err != nil || tok.Header.Typ != oauthAccessTokenType {
+		return Principal{}, ErrAccessTokenInvalid
+	}
+	pub, err := s.oauthVerificationKey(ctx, tok.Header)
+	if err != nil {
+		return Principal{}, err
+	}
+	if pub == nil || tok.Verify(pub) != nil {
+		return Principal{}, ErrAccessTokenInvalid
+	}
+
+	var claims accessTokenClaims
+	if err := tok.DecodeClaims(&claims); err != nil {
+		return Principal{}, ErrAccessTokenInvalid
+	}
+	if err := claims.Validate(time.Now(), s.baseURL, s.baseURL, 0); err != nil || claims.Subject == "" {
+		return Principal{}, ErrAccessTokenInvalid
+	}
+
+	return Principal{UserID: claims.Subject}, nil
+}
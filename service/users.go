@@ -2,11 +2,13 @@ package service
 
 import (
 	"context"
+	"strings"
 
-	"github.com/alexedwards/argon2id"
 	"github.com/andyfusniak/base58"
 	"github.com/andyfusniak/monolith/internal/store"
+	"github.com/andyfusniak/monolith/internal/tracing"
 	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
 )
 
 var (
@@ -22,14 +24,21 @@ type User struct {
 }
 
 // CreateUser params.URole should be set to RoleUser or RoleAdmin.
+//
+// The password is checked against the service PasswordPolicy and one of
+// ErrUserPasswordTooShort, ErrUserPasswordTooLong, ErrUserPasswordTooCommon
+// or ErrUserPasswordBreached is returned if it is rejected.
 func (s *Service) CreateUser(ctx context.Context, email, password string) (User, error) {
-	if len(password) < 8 {
-		return User{}, ErrUserPasswordTooShort
+	ctx, span := tracing.Start(ctx, "service.CreateUser")
+	defer span.End()
+
+	if err := s.passwordPolicy.Validate(ctx, password); err != nil {
+		return User{}, err
 	}
 
-	hash, err := argon2id.CreateHash(password, argon2id.DefaultParams)
+	hash, err := s.createHash(password)
 	if err != nil {
-		return User{}, errors.Wrap(err, "[service] failed to create argon2id hash")
+		return User{}, err
 	}
 
 	userID, err := base58.RandString(22) // 58**22 > 2**128
@@ -38,18 +47,33 @@ func (s *Service) CreateUser(ctx context.Context, email, password string) (User,
 	}
 	row, err := s.repo.InsertUser(ctx, store.AddUser{
 		UserID:       userID,
-		Email:        email,
+		Email:        normalizeEmail(email),
 		PasswordHash: hash,
 	})
 	if err != nil {
 		return User{}, errors.Wrap(err, "[service] s.store.InsertUser failed")
 	}
 
+	if err := s.recordAudit(ctx, AuditUserCreate, userID, map[string]any{
+		"method": "password",
+	}); err != nil {
+		return User{}, err
+	}
+
 	return userFromRow(row), nil
 }
 
+// normalizeEmail returns email in the form it is stored and looked up in,
+// so addresses differing only in case refer to the same user.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
 // GetUser returns a single User with the given userID.
 func (s *Service) GetUser(ctx context.Context, userID string) (User, error) {
+	ctx, span := tracing.Start(ctx, "service.GetUser")
+	defer span.End()
+
 	row, err := s.repo.GetUser(ctx, userID)
 	if err != nil {
 		if errors.Is(err, store.ErrUserNotFound) {
@@ -72,28 +96,113 @@ func (s *Service) GetUser(ctx context.Context, userID string) (User, error) {
 // tell clients that their account could not be found otherwise
 // VerifyUserPassword can be used to find valid emails. Instead return an
 // authorized response to clients.
+//
+// If the password matches but the stored hash was created with argon2id
+// parameters weaker than those configured for the service, the password is
+// rehashed and the stored hash replaced.
 func (s *Service) VerifyUserPassword(ctx context.Context, email, password string) (User, error) {
-	row, err := s.repo.GetUserByEmail(ctx, email)
+	ctx, span := tracing.Start(ctx, "service.VerifyUserPassword")
+	defer span.End()
+
+	row, err := s.verifyUserPassword(ctx, email, password)
+	if err != nil {
+		return User{}, err
+	}
+	return userFromRow(row), nil
+}
+
+// verifyUserPassword is VerifyUserPassword returning the users row, which
+// is also returned with ErrUserWrongPassword.
+func (s *Service) verifyUserPassword(ctx context.Context, email, password string) (store.User, error) {
+	row, err := s.repo.GetUserByEmail(ctx, normalizeEmail(email))
 	if err != nil {
 		if errors.Is(err, store.ErrUserNotFound) {
-			_, _ = argon2id.ComparePasswordAndHash(password, "dummyhash")
-			return User{}, ErrUserNotFound
+			_, _ = s.comparePasswordAndHash(password, s.dummyHash())
+			return store.User{}, ErrUserNotFound
 		}
 
-		return User{}, errors.Wrap(err,
+		return store.User{}, errors.Wrap(err,
 			"[service] s.store.VerifyUserPassword failed")
 	}
 
-	match, err := argon2id.ComparePasswordAndHash(password, row.PasswordHash)
+	// users created by an external identity provider have no password
+	if row.PasswordHash == "" {
+		_, _ = s.comparePasswordAndHash(password, s.dummyHash())
+		return row, ErrUserWrongPassword
+	}
+
+	match, err := s.comparePasswordAndHash(password, row.PasswordHash)
 	if err != nil {
-		return User{}, errors.Wrap(err,
+		return store.User{}, errors.Wrap(err,
 			"[service] failed to compare password and hash using argon2id")
 	}
-	if match {
-		return userFromRow(row), nil
+	if !match {
+		return row, ErrUserWrongPassword
+	}
+
+	if s.NeedsRehash(row.PasswordHash) {
+		if err := s.rehashPassword(ctx, row.UserID, password); err != nil {
+			// the user has signed in successfully so do not fail the request
+			log.WithContext(ctx).Warnf("[service] rehash password for user_id=%s failed: %+v", row.UserID, err)
+		}
+	}
+
+	return row, nil
+}
+
+// ChangePassword replaces the password of the user with userID. The
+// current password must match or ErrUserWrongPassword is returned; users
+// created by an external identity provider have no password to change.
+// The new password is checked against the service PasswordPolicy.
+func (s *Service) ChangePassword(ctx context.Context, userID, current, password string) error {
+	ctx, span := tracing.Start(ctx, "service.ChangePassword")
+	defer span.End()
+
+	row, err := s.repo.GetUser(ctx, userID)
+	if err != nil {
+		if errors.Is(err, store.ErrUserNotFound) {
+			return ErrUserNotFound
+		}
+		return errors.Wrapf(err, "[service] s.repo.GetUser(ctx, userID=%q) failed", userID)
+	}
+	if row.PasswordHash == "" {
+		return ErrUserWrongPassword
+	}
+
+	match, err := s.comparePasswordAndHash(current, row.PasswordHash)
+	if err != nil {
+		return errors.Wrap(err, "[service] failed to compare password and hash using argon2id")
+	}
+	if !match {
+		return ErrUserWrongPassword
+	}
+
+	if err := s.passwordPolicy.Validate(ctx, password); err != nil {
+		return err
+	}
+	hash, err := s.createHash(password)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.UpdateUserPasswordHash(ctx, userID, hash); err != nil {
+		return errors.Wrapf(err, "[service] s.repo.UpdateUserPasswordHash(ctx, userID=%q) failed", userID)
 	}
 
-	return User{}, ErrUserWrongPassword
+	return s.recordAudit(ctx, AuditPasswordChange, userID, nil)
+}
+
+// rehashPassword replaces the stored hash for userID with a hash created
+// using the service parameters.
+func (s *Service) rehashPassword(ctx context.Context, userID, password string) error {
+	hash, err := s.createHash(password)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.UpdateUserPasswordHash(ctx, userID, hash); err != nil {
+		return errors.Wrapf(err, "[service] s.repo.UpdateUserPasswordHash(ctx, userID=%q) failed", userID)
+	}
+	log.WithContext(ctx).Infof("[service] upgraded password hash parameters for user_id=%s", userID)
+	return nil
 }
 
 func userFromRow(row store.User) User {
@@ -0,0 +1,274 @@
+package service
+
+import (
+	"context"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/andyfusniak/monolith/internal/store"
+	"github.com/andyfusniak/monolith/internal/tracing"
+	"github.com/andyfusniak/monolith/internal/webauthn"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const webauthnTimeout = 5 * time.Minute
+
+var (
+	ErrWebAuthnNotConfigured      = errors.New("webauthn not configured")
+	ErrWebAuthnChallengeInvalid   = errors.New("webauthn challenge invalid or expired")
+	ErrWebAuthnVerificationFailed = errors.New("webauthn verification failed")
+	ErrWebAuthnCredentialExists   = errors.New("webauthn credential already registered")
+)
+
+// WithWebAuthn configures the WebAuthn relying party. Without it the
+// WebAuthn methods return ErrWebAuthnNotConfigured.
+func WithWebAuthn(cfg webauthn.Config) Option {
+	return func(s *Service) {
+		s.webauthn = &cfg
+	}
+}
+
+// WebAuthnCredential is a registered passkey or security key.
+type WebAuthnCredential struct {
+	ID         string   `json:"credential_id"`
+	UserID     string   `json:"user_id"`
+	AAGUID     string   `json:"aaguid"`
+	Transports []string `json:"transports"`
+	LastUsedAt *ISOTime `json:"last_used_at"`
+	CreatedAt  ISOTime  `json:"created_at"`
+}
+
+// BeginWebAuthnRegistration starts a registration ceremony for the user,
+// returning options for navigator.credentials.create().
+func (s *Service) BeginWebAuthnRegistration(ctx context.Context, userID string) (webauthn.CreationOptions, error) {
+	ctx, span := tracing.Start(ctx, "service.BeginWebAuthnRegistration")
+	defer span.End()
+
+	if s.webauthn == nil {
+		return webauthn.CreationOptions{}, ErrWebAuthnNotConfigured
+	}
+
+	user, err := s.GetUser(ctx, userID)
+	if err != nil {
+		return webauthn.CreationOptions{}, err
+	}
+
+	rows, err := s.repo.ListWebAuthnCredentialsByUser(ctx, userID)
+	if err != nil {
+		return webauthn.CreationOptions{}, errors.Wrap(err, "[service] s.repo.ListWebAuthnCredentialsByUser failed")
+	}
+	exclude := make([]webauthn.CredentialDescriptor, 0, len(rows))
+	for _, row := range rows {
+		exclude = append(exclude, credentialDescriptor(row))
+	}
+
+	challenge, err := s.newWebAuthnChallenge(ctx, store.WebAuthnCeremonyRegistration, &userID)
+	if err != nil {
+		return webauthn.CreationOptions{}, err
+	}
+
+	return s.webauthn.CreationOptions(challenge, user.ID, user.Email,
+		int(webauthnTimeout.Milliseconds()), exclude), nil
+}
+
+// FinishWebAuthnRegistration verifies the authenticator response and
+// stores the new credential for the user.
+func (s *Service) FinishWebAuthnRegistration(ctx context.Context, userID string, resp webauthn.AttestationResponse) (WebAuthnCredential, error) {
+	ctx, span := tracing.Start(ctx, "service.FinishWebAuthnRegistration")
+	defer span.End()
+
+	if s.webauthn == nil {
+		return WebAuthnCredential{}, ErrWebAuthnNotConfigured
+	}
+
+	challenge, err := s.takeWebAuthnChallenge(ctx, resp.Response.ClientDataJSON, store.WebAuthnCeremonyRegistration)
+	if err != nil {
+		return WebAuthnCredential{}, err
+	}
+	if challenge.UserID == nil || *challenge.UserID != userID {
+		return WebAuthnCredential{}, ErrWebAuthnChallengeInvalid
+	}
+
+	cred, err := s.webauthn.VerifyRegistration(resp, challenge.Challenge)
+	if err != nil {
+		log.WithContext(ctx).Infof("[service] webauthn registration for user_id=%s failed: %v", userID, err)
+		return WebAuthnCredential{}, ErrWebAuthnVerificationFailed
+	}
+
+	credentialID := webauthn.Encoding.EncodeToString(cred.ID)
+	if _, err := s.repo.GetWebAuthnCredential(ctx, credentialID); err == nil {
+		return WebAuthnCredential{}, ErrWebAuthnCredentialExists
+	} else if !errors.Is(err, store.ErrWebAuthnCredentialNotFound) {
+		return WebAuthnCredential{}, errors.Wrap(err, "[service] s.repo.GetWebAuthnCredential failed")
+	}
+
+	row, err := s.repo.InsertWebAuthnCredential(ctx, store.AddWebAuthnCredential{
+		CredentialID: credentialID,
+		UserID:       userID,
+		PublicKey:    cred.PublicKey,
+		SignCount:    int64(cred.SignCount),
+		AAGUID:       hex.EncodeToString(cred.AAGUID),
+		Transports:   strings.Join(cred.Transports, ","),
+	})
+	if err != nil {
+		return WebAuthnCredential{}, errors.Wrap(err, "[service] s.repo.InsertWebAuthnCredential failed")
+	}
+
+	return webauthnCredentialFromRow(row), nil
+}
+
+// BeginWebAuthnLogin starts an authentication ceremony for discoverable
+// credentials, returning options for navigator.credentials.get(). No email
+// is taken so the response cannot reveal whether an account exists.
+func (s *Service) BeginWebAuthnLogin(ctx context.Context) (webauthn.RequestOptions, error) {
+	ctx, span := tracing.Start(ctx, "service.BeginWebAuthnLogin")
+	defer span.End()
+
+	if s.webauthn == nil {
+		return webauthn.RequestOptions{}, ErrWebAuthnNotConfigured
+	}
+
+	challenge, err := s.newWebAuthnChallenge(ctx, store.WebAuthnCeremonyLogin, nil)
+	if err != nil {
+		return webauthn.RequestOptions{}, err
+	}
+
+	return s.webauthn.RequestOptions(challenge, int(webauthnTimeout.Milliseconds()), nil), nil
+}
+
+// FinishWebAuthnLogin verifies an assertion and signs the user in.
+//
+// If the authenticator verified the user (for example with a PIN or
+// biometric) the passkey satisfies both factors and a Session is returned.
+// Otherwise the result is the same as a password sign in, so users with
+// TOTP enabled receive an MFAChallenge.
+func (s *Service) FinishWebAuthnLogin(ctx context.Context, resp webauthn.AssertionResponse) (SignInResult, error) {
+	ctx, span := tracing.Start(ctx, "service.FinishWebAuthnLogin")
+	defer span.End()
+
+	if s.webauthn == nil {
+		return SignInResult{}, ErrWebAuthnNotConfigured
+	}
+	cl := log.WithContext(ctx)
+
+	challenge, err := s.takeWebAuthnChallenge(ctx, resp.Response.ClientDataJSON, store.WebAuthnCeremonyLogin)
+	if err != nil {
+		return SignInResult{}, err
+	}
+
+	rawID, err := webauthn.Encoding.DecodeString(resp.RawID)
+	if err != nil {
+		return SignInResult{}, ErrWebAuthnVerificationFailed
+	}
+	row, err := s.repo.GetWebAuthnCredential(ctx, webauthn.Encoding.EncodeToString(rawID))
+	if err != nil {
+		if errors.Is(err, store.ErrWebAuthnCredentialNotFound) {
+			cl.Infof("[service] webauthn login with unknown credential")
+			return SignInResult{}, ErrWebAuthnVerificationFailed
+		}
+		return SignInResult{}, errors.Wrap(err, "[service] s.repo.GetWebAuthnCredential failed")
+	}
+	if resp.Response.UserHandle != "" {
+		handle, err := webauthn.Encoding.DecodeString(resp.Response.UserHandle)
+		if err != nil || string(handle) != row.UserID {
+			cl.Warnf("[service] webauthn login user handle mismatch for credential_id=%s", row.CredentialID)
+			return SignInResult{}, ErrWebAuthnVerificationFailed
+		}
+	}
+
+	assertion, err := s.webauthn.VerifyAssertion(resp, challenge.Challenge, row.PublicKey, uint32(row.SignCount))
+	if err != nil {
+		if errors.Is(err, webauthn.ErrSignCountInvalid) {
+			cl.Warnf("[service] webauthn sign count did not increase for credential_id=%s; possible cloned authenticator",
+				row.CredentialID)
+		} else {
+			cl.Infof("[service] webauthn login for credential_id=%s failed: %v", row.CredentialID, err)
+		}
+		return SignInResult{}, ErrWebAuthnVerificationFailed
+	}
+
+	if err := s.repo.UpdateWebAuthnCredentialSignCount(ctx, row.CredentialID, int64(assertion.SignCount)); err != nil {
+		return SignInResult{}, errors.Wrap(err, "[service] s.repo.UpdateWebAuthnCredentialSignCount failed")
+	}
+
+	user, err := s.GetUser(ctx, row.UserID)
+	if err != nil {
+		return SignInResult{}, err
+	}
+
+	if assertion.UserVerified {
+		session, err := s.CreateSession(ctx, user.ID)
+		if err != nil {
+			return SignInResult{}, err
+		}
+		return SignInResult{User: user, Session: &session}, nil
+	}
+	return s.newSignIn(ctx, user, GrantSession)
+}
+
+func (s *Service) newWebAuthnChallenge(ctx context.Context, ceremony string, userID *string) (string, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return "", err
+	}
+	if err := s.repo.InsertWebAuthnChallenge(ctx, store.AddWebAuthnChallenge{
+		Challenge: challenge,
+		Ceremony:  ceremony,
+		UserID:    userID,
+		ExpiresAt: store.Datetime(time.Now().UTC().Add(webauthnTimeout)),
+	}); err != nil {
+		return "", errors.Wrap(err, "[service] s.repo.InsertWebAuthnChallenge failed")
+	}
+	return challenge, nil
+}
+
+// takeWebAuthnChallenge consumes the challenge named in clientDataJSON.
+func (s *Service) takeWebAuthnChallenge(ctx context.Context, clientDataJSON, ceremony string) (store.WebAuthnChallenge, error) {
+	cd, _, err := webauthn.ParseClientData(clientDataJSON)
+	if err != nil {
+		return store.WebAuthnChallenge{}, ErrWebAuthnVerificationFailed
+	}
+
+	row, err := s.repo.TakeWebAuthnChallenge(ctx, cd.Challenge)
+	if err != nil {
+		if errors.Is(err, store.ErrWebAuthnChallengeNotFound) {
+			return store.WebAuthnChallenge{}, ErrWebAuthnChallengeInvalid
+		}
+		return store.WebAuthnChallenge{}, errors.Wrap(err, "[service] s.repo.TakeWebAuthnChallenge failed")
+	}
+	if row.Ceremony != ceremony || time.Now().After(time.Time(row.ExpiresAt)) {
+		return store.WebAuthnChallenge{}, ErrWebAuthnChallengeInvalid
+	}
+	return row, nil
+}
+
+func credentialDescriptor(row store.WebAuthnCredential) webauthn.CredentialDescriptor {
+	d := webauthn.CredentialDescriptor{
+		Type: "public-key",
+		ID:   row.CredentialID,
+	}
+	if row.Transports != "" {
+		d.Transports = strings.Split(row.Transports, ",")
+	}
+	return d
+}
+
+func webauthnCredentialFromRow(row store.WebAuthnCredential) WebAuthnCredential {
+	c := WebAuthnCredential{
+		ID:         row.CredentialID,
+		UserID:     row.UserID,
+		AAGUID:     row.AAGUID,
+		Transports: []string{},
+		CreatedAt:  ISOTime(row.CreatedAt),
+	}
+	if row.Transports != "" {
+		c.Transports = strings.Split(row.Transports, ",")
+	}
+	if row.LastUsedAt != nil {
+		t := ISOTime(*row.LastUsedAt)
+		c.LastUsedAt = &t
+	}
+	return c
+}
@@ -0,0 +1,207 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/andyfusniak/monolith/internal/store"
+	"github.com/andyfusniak/monolith/internal/webauthn"
+	"github.com/andyfusniak/monolith/internal/webauthn/webauthntest"
+)
+
+func newWebAuthnTestService(t *testing.T) (*Service, *webauthntest.Authenticator) {
+	t.Helper()
+	s := newTestService(t, WithWebAuthn(webauthn.Config{
+		RPID:   "example.com",
+		RPName: "Example",
+		Origin: "https://example.com",
+	}))
+	a, err := webauthntest.NewAuthenticator("example.com", "https://example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, a
+}
+
+// registerPasskey registers the credential of a for user.
+func registerPasskey(t *testing.T, s *Service, a *webauthntest.Authenticator, user User) {
+	t.Helper()
+	ctx := context.Background()
+
+	opts, err := s.BeginWebAuthnRegistration(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("BeginWebAuthnRegistration: %v", err)
+	}
+	cred, err := s.FinishWebAuthnRegistration(ctx, user.ID, a.Create(opts.Challenge))
+	if err != nil {
+		t.Fatalf("FinishWebAuthnRegistration: %v", err)
+	}
+	if cred.ID != a.CredentialID() {
+		t.Fatalf("credential id = %q, want %q", cred.ID, a.CredentialID())
+	}
+}
+
+func TestWebAuthnRegisterAndLogin(t *testing.T) {
+	ctx := context.Background()
+	s, a := newWebAuthnTestService(t)
+	user := newTestUser(t, s, "alice@example.com")
+	registerPasskey(t, s, a, user)
+
+	opts, err := s.BeginWebAuthnLogin(ctx)
+	if err != nil {
+		t.Fatalf("BeginWebAuthnLogin: %v", err)
+	}
+	resp := a.Get(opts.Challenge, []byte(user.ID))
+	result, err := s.FinishWebAuthnLogin(ctx, resp)
+	if err != nil {
+		t.Fatalf("FinishWebAuthnLogin: %v", err)
+	}
+	if result.User.ID != user.ID || result.Session == nil {
+		t.Fatalf("FinishWebAuthnLogin = %+v, want a session for %s", result, user.ID)
+	}
+
+	// challenges are single use
+	if _, err := s.FinishWebAuthnLogin(ctx, resp); !errors.Is(err, ErrWebAuthnChallengeInvalid) {
+		t.Errorf("replayed FinishWebAuthnLogin error = %v, want %v", err, ErrWebAuthnChallengeInvalid)
+	}
+}
+
+func TestFinishWebAuthnRegistrationRejected(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(a *webauthntest.Authenticator, challenge string) webauthn.AttestationResponse
+		wantErr error
+	}{
+		{
+			name: "bad origin",
+			modify: func(a *webauthntest.Authenticator, challenge string) webauthn.AttestationResponse {
+				a.Origin = "https://evil.example"
+				return a.Create(challenge)
+			},
+			wantErr: ErrWebAuthnVerificationFailed,
+		},
+		{
+			name: "unknown challenge",
+			modify: func(a *webauthntest.Authenticator, challenge string) webauthn.AttestationResponse {
+				return a.Create("AAAA")
+			},
+			wantErr: ErrWebAuthnChallengeInvalid,
+		},
+		{
+			name: "malformed cbor",
+			modify: func(a *webauthntest.Authenticator, challenge string) webauthn.AttestationResponse {
+				resp := a.Create(challenge)
+				resp.Response.AttestationObject = webauthn.Encoding.EncodeToString([]byte{0xa1, 0x63, 'f', 'm'})
+				return resp
+			},
+			wantErr: ErrWebAuthnVerificationFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s, a := newWebAuthnTestService(t)
+			user := newTestUser(t, s, "alice@example.com")
+
+			opts, err := s.BeginWebAuthnRegistration(ctx, user.ID)
+			if err != nil {
+				t.Fatalf("BeginWebAuthnRegistration: %v", err)
+			}
+			_, err = s.FinishWebAuthnRegistration(ctx, user.ID, tt.modify(a, opts.Challenge))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("FinishWebAuthnRegistration error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFinishWebAuthnLoginRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(a *webauthntest.Authenticator, challenge string) webauthn.AssertionResponse
+	}{
+		{
+			name: "bad origin",
+			modify: func(a *webauthntest.Authenticator, challenge string) webauthn.AssertionResponse {
+				a.Origin = "https://evil.example"
+				return a.Get(challenge, nil)
+			},
+		},
+		{
+			name: "counter regression",
+			modify: func(a *webauthntest.Authenticator, challenge string) webauthn.AssertionResponse {
+				a.SignCount = 0 // a clone still at the registered count
+				return a.Get(challenge, nil)
+			},
+		},
+		{
+			name: "user handle mismatch",
+			modify: func(a *webauthntest.Authenticator, challenge string) webauthn.AssertionResponse {
+				return a.Get(challenge, []byte("someone-else"))
+			},
+		},
+		{
+			name: "malformed authenticator data",
+			modify: func(a *webauthntest.Authenticator, challenge string) webauthn.AssertionResponse {
+				resp := a.Get(challenge, nil)
+				raw, _ := webauthn.Encoding.DecodeString(resp.Response.AuthenticatorData)
+				raw[32] |= 0x80 // extensions flag without extensions
+				resp.Response.AuthenticatorData = webauthn.Encoding.EncodeToString(raw)
+				return resp
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s, a := newWebAuthnTestService(t)
+			user := newTestUser(t, s, "alice@example.com")
+			registerPasskey(t, s, a, user)
+
+			// a first login moves the stored counter to 1
+			opts, err := s.BeginWebAuthnLogin(ctx)
+			if err != nil {
+				t.Fatalf("BeginWebAuthnLogin: %v", err)
+			}
+			if _, err := s.FinishWebAuthnLogin(ctx, a.Get(opts.Challenge, nil)); err != nil {
+				t.Fatalf("FinishWebAuthnLogin: %v", err)
+			}
+
+			opts, err = s.BeginWebAuthnLogin(ctx)
+			if err != nil {
+				t.Fatalf("BeginWebAuthnLogin: %v", err)
+			}
+			_, err = s.FinishWebAuthnLogin(ctx, tt.modify(a, opts.Challenge))
+			if !errors.Is(err, ErrWebAuthnVerificationFailed) {
+				t.Fatalf("FinishWebAuthnLogin error = %v, want %v", err, ErrWebAuthnVerificationFailed)
+			}
+		})
+	}
+}
+
+func TestDeleteExpiredWebAuthnChallenges(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newWebAuthnTestService(t)
+
+	if _, err := s.BeginWebAuthnLogin(ctx); err != nil {
+		t.Fatalf("BeginWebAuthnLogin: %v", err)
+	}
+	if err := s.repo.InsertWebAuthnChallenge(ctx, store.AddWebAuthnChallenge{
+		Challenge: "expired",
+		Ceremony:  store.WebAuthnCeremonyLogin,
+		ExpiresAt: store.Datetime(time.Now().UTC().Add(-time.Second)),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := s.DeleteExpired(ctx); err != nil || n != 1 {
+		t.Fatalf("DeleteExpired = %d, %v, want 1", n, err)
+	}
+	if _, err := s.repo.TakeWebAuthnChallenge(ctx, "expired"); !errors.Is(err, store.ErrWebAuthnChallengeNotFound) {
+		t.Errorf("expired challenge not deleted: %v", err)
+	}
+}